@@ -0,0 +1,138 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/wojnosystems/vsql"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vresult"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql/vstmt"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/engine_ware"
+	"testing"
+)
+
+// attachEngine returns an engine whose Exec chain is a handler named "audit" followed by a driver named "driver".
+// Every handler appends its name to the returned log.
+func attachEngine() (MultiTXer, *[]string) {
+	var log []string
+	e := NewMulti()
+	named := e.ExecQueryMW().(engine_ware.NamedAdder[engine_context.Execer])
+	named.AppendNamed("audit", execLogger(&log, "audit"))
+	named.AppendNamed("driver", func(ctx context.Context, c engine_context.Execer) {
+		log = append(log, "driver")
+		c.SetResult(&vresult.ResulterMock{})
+		c.Next(ctx)
+	})
+	e.BeginNestedMW().Append(func(ctx context.Context, c engine_context.NestedBeginner) {
+		c.SetQueryExecNestedTransactioner(&vsql.QueryExecNestedTransactionerMock{})
+		c.Next(ctx)
+	})
+	e.StatementPrepareMW().Append(func(ctx context.Context, c engine_context.Preparer) {
+		c.SetStatement(&vstmt.StatementerMock{})
+		c.Next(ctx)
+	})
+	e.StatementExecQueryMW().Append(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		log = append(log, "statement")
+		c.Next(ctx)
+	})
+	return e, &log
+}
+
+func execLogger(log *[]string, name string) engine_ware.ExecHandler {
+	return func(ctx context.Context, c engine_context.Execer) {
+		*log = append(*log, name)
+		c.Next(ctx)
+	}
+}
+
+func TestAttach_Placement(t *testing.T) {
+	e, log := attachEngine()
+	ctx := engine_ware.Attach(context.Background(), engine_ware.ExecChain, engine_ware.Back, execLogger(log, "back"))
+	ctx = engine_ware.Attach(ctx, engine_ware.ExecChain, engine_ware.Before("driver"), execLogger(log, "before driver"))
+	ctx = engine_ware.Attach(ctx, engine_ware.ExecChain, engine_ware.Front, execLogger(log, "front 1"))
+	ctx = engine_ware.Attach(ctx, engine_ware.ExecChain, engine_ware.Front, execLogger(log, "front 2"))
+	ctx = engine_ware.Attach(ctx, engine_ware.ExecChain, engine_ware.Before("missing"), execLogger(log, "missing"))
+	_, _ = e.Exec(ctx, vparam.New("DELETE FROM puppies"))
+	assert.Equal(t, []string{"front 1", "front 2", "audit", "before driver", "driver", "back", "missing"}, *log)
+
+	*log = nil
+	_, _ = e.Exec(context.Background(), vparam.New("DELETE FROM puppies"))
+	assert.Equal(t, []string{"audit", "driver"}, *log, "expected the handlers to only apply to the call")
+}
+
+func TestAttach_OtherChainsAreUnchanged(t *testing.T) {
+	e, log := attachEngine()
+	ctx := engine_ware.Attach(context.Background(), engine_ware.QueryChain, engine_ware.Front, func(ctx context.Context, c engine_context.Queryer) {
+		*log = append(*log, "query")
+		c.Next(ctx)
+	})
+	_, _ = e.Exec(ctx, vparam.New("DELETE FROM puppies"))
+	assert.Equal(t, []string{"audit", "driver"}, *log)
+}
+
+func TestAttach_Transaction(t *testing.T) {
+	e, log := attachEngine()
+	beginCtx := engine_ware.Attach(context.Background(), engine_ware.ExecChain, engine_ware.Front, execLogger(log, "tx"))
+	tx, err := e.Begin(beginCtx, nil)
+	assert.NoError(t, err)
+
+	callCtx := engine_ware.Attach(context.Background(), engine_ware.ExecChain, engine_ware.Front, execLogger(log, "call"))
+	_, _ = tx.Exec(callCtx, vparam.New("DELETE FROM puppies"))
+	assert.Equal(t, []string{"tx", "call", "audit", "driver"}, *log)
+
+	*log = nil
+	_, _ = tx.Exec(beginCtx, vparam.New("DELETE FROM puppies"))
+	assert.Equal(t, []string{"tx", "audit", "driver"}, *log, "expected a context derived from Begin's to not repeat its handlers")
+
+	*log = nil
+	nested, _ := tx.Begin(context.Background(), nil)
+	_, _ = nested.Exec(context.Background(), vparam.New("DELETE FROM puppies"))
+	assert.Equal(t, []string{"tx", "audit", "driver"}, *log, "expected nested transactions to inherit the handlers")
+}
+
+func TestAttach_TransactionStatement(t *testing.T) {
+	e, log := attachEngine()
+	beginCtx := engine_ware.Attach(context.Background(), engine_ware.StatementExecQueryChain, engine_ware.Front,
+		func(ctx context.Context, c engine_context.StatementExecQueryer) {
+			*log = append(*log, "tx")
+			c.Next(ctx)
+		})
+	tx, _ := e.Begin(beginCtx, nil)
+	stmt, err := tx.Prepare(context.Background(), vparam.New("DELETE FROM puppies WHERE id = ?"))
+	assert.NoError(t, err)
+	_, _ = stmt.Exec(context.Background(), vparam.NewAppendData(1))
+	assert.Equal(t, []string{"tx", "statement"}, *log)
+}
+
+func TestAttach_Rows(t *testing.T) {
+	e := NewSingle()
+	e.QueryMW().Append(func(ctx context.Context, c engine_context.Queryer) {
+		c.SetRows(&vrows.RowserMock{})
+		c.Next(ctx)
+	})
+	var ran bool
+	ctx := engine_ware.Attach(context.Background(), engine_ware.RowsNextChain, engine_ware.Front, func(ctx context.Context, c engine_context.RowsNexter) {
+		ran = true
+		c.Next(ctx)
+	})
+	rows, _ := e.Query(ctx, vparam.New("SELECT * FROM puppies"))
+	rows.Next()
+	assert.True(t, ran, "expected handlers attached to the query to apply to its rows")
+}
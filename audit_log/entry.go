@@ -0,0 +1,168 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package audit_log
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Operation is the kind of call an Entry records
+type Operation string
+
+const (
+	Exec            Operation = "exec"
+	Insert          Operation = "insert"
+	StatementExec   Operation = "statement_exec"
+	StatementInsert Operation = "statement_insert"
+	Begin           Operation = "begin"
+	Commit          Operation = "commit"
+	Rollback        Operation = "rollback"
+)
+
+// Outcome is whether the call succeeded
+type Outcome string
+
+const (
+	Succeeded Outcome = "ok"
+	Failed    Outcome = "error"
+	// Pending is the outcome of the entry written before a write is made. The entry with its outcome follows it.
+	Pending Outcome = "pending"
+)
+
+// Entry is one record of the audit log. Each entry is written as a line of JSON.
+type Entry struct {
+	// Seq is the position of the entry in the log, starting at 1
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	// Actor is who made the call, from WithActor
+	Actor     string    `json:"actor,omitempty"`
+	Operation Operation `json:"op"`
+	// Fingerprint is the ID of the query's fingerprint and Query is the normalized query. Neither contains values.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Query       string `json:"query,omitempty"`
+	// Params are the query's values as redacted by the Logger's Redact function
+	Params       []string `json:"params,omitempty"`
+	RowsAffected *uint64  `json:"rows_affected,omitempty"`
+	Outcome      Outcome  `json:"outcome"`
+	Error        string   `json:"error,omitempty"`
+	// Intent is the Seq of the Pending entry written before the write, on the entry with its outcome
+	Intent uint64 `json:"intent,omitempty"`
+	// Transaction is the Seq of the Begin entry of the transaction the call was made in, if any. Parent is the
+	// Transaction of the transaction it is nested in, if any.
+	Transaction uint64 `json:"tx,omitempty"`
+	Parent      uint64 `json:"parent_tx,omitempty"`
+	// Final is true if the entry's effect is permanent when it is written: successful writes outside of a transaction and
+	// successful commits of outermost transactions. Writes in a transaction become final when it is committed;
+	// Verify works this out.
+	Final bool `json:"final"`
+	// PrevHash is the Hash of the previous entry, empty for the first
+	PrevHash string `json:"prev_hash"`
+	// Hash is the hex SHA-256 of the entry's JSON without Hash. Changing any field breaks the chain.
+	Hash string `json:"hash"`
+
+	// Committed is set by Verify: true if the entry is Final or is a write of a transaction that was committed,
+	// along with every transaction it is nested in
+	Committed bool `json:"-"`
+}
+
+// computeHash returns the hash of e, which covers every field except Hash
+func (e Entry) computeHash() (string, error) {
+	e.Hash = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ErrTampered is returned by Verify when the log has been modified, or entries have been removed
+type ErrTampered struct {
+	// Line is the line of the log at which the chain breaks
+	Line   int
+	Reason string
+}
+
+func (e ErrTampered) Error() string {
+	return fmt.Sprintf("audit_log: the log has been tampered with at line %d: %s", e.Line, e.Reason)
+}
+
+// Verify reads a log and checks that every entry is unchanged and in sequence. Removing or changing any entry other
+// than the last ones is detected; to detect the removal of the last entries, compare the Hash of the last entry
+// with one recorded elsewhere, such as Logger.Head. The entries are returned with Committed set.
+func Verify(r io.Reader) (entries []Entry, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	prev := ""
+	for line := 1; scanner.Scan(); line++ {
+		var e Entry
+		if err = json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, &ErrTampered{Line: line, Reason: err.Error()}
+		}
+		if e.Seq != uint64(line) {
+			return nil, &ErrTampered{Line: line, Reason: fmt.Sprintf("expected entry %d, found entry %d", line, e.Seq)}
+		}
+		if e.PrevHash != prev {
+			return nil, &ErrTampered{Line: line, Reason: "the previous entry's hash does not match"}
+		}
+		hash, err := e.computeHash()
+		if err != nil {
+			return nil, err
+		}
+		if hash != e.Hash {
+			return nil, &ErrTampered{Line: line, Reason: "the entry does not match its hash"}
+		}
+		prev = e.Hash
+		entries = append(entries, e)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	markCommitted(entries)
+	return entries, nil
+}
+
+// markCommitted sets Committed on each entry
+func markCommitted(entries []Entry) {
+	committed := make(map[uint64]bool)
+	parents := make(map[uint64]uint64)
+	for _, e := range entries {
+		if e.Operation == Begin && e.Outcome == Succeeded {
+			parents[e.Seq] = e.Parent
+		}
+		if e.Operation == Commit && e.Outcome == Succeeded {
+			committed[e.Transaction] = true
+		}
+	}
+	isCommitted := func(tx uint64) bool {
+		for ; tx != 0; tx = parents[tx] {
+			if !committed[tx] {
+				return false
+			}
+		}
+		return true
+	}
+	for i := range entries {
+		e := &entries[i]
+		e.Committed = e.Final || (e.Transaction != 0 && e.Outcome == Succeeded && isCommitted(e.Transaction))
+	}
+}
@@ -0,0 +1,352 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package audit_log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/wojnosystems/vsql"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vresult"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/engine_ware"
+	"github.com/wojnosystems/vsql_engine/fingerprint"
+	"github.com/wojnosystems/vsql_engine/query_rewrite"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// audit_log records every data-modifying call in an append-only log in which each entry carries the hash of the one
+// before it, so that any change to, or removal of, an entry is detected by Verify.
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx for calls made on behalf of actor, such as a user or service name
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor set by WithActor, if any
+func ActorFrom(ctx context.Context) (actor string, ok bool) {
+	actor, ok = ctx.Value(actorKey{}).(string)
+	return
+}
+
+// ErrUnavailable is returned for calls made after the log could not be written. Data-modifying calls are refused
+// rather than made without an audit trail. Rollback is always allowed.
+type ErrUnavailable struct {
+	Err error
+}
+
+func (e ErrUnavailable) Error() string {
+	return fmt.Sprintf("audit_log: the audit log cannot be written: %s", e.Err)
+}
+
+// RedactType replaces each value with its Go type, so the log shows the shape of the values without their content.
+// It is the default Logger.Redact.
+func RedactType(value interface{}) string {
+	if value == nil {
+		return "nil"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// Logger writes audit entries. Configure the exported fields before calling Install.
+type Logger struct {
+	// Redact converts each query value into what is logged. If nil, RedactType is used.
+	Redact func(value interface{}) string
+
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+	seq    uint64
+	head   string
+	// err is the first error writing the log. Once set, data-modifying calls are refused.
+	err error
+	// transactions maps the transactions created by the driver to their transaction in the log
+	transactions engine_context.Tracker[transaction]
+}
+
+// transaction identifies a transaction in the log
+type transaction struct {
+	seq    uint64
+	parent uint64
+}
+
+// NewLogger creates a Logger that starts a new log on w
+func NewLogger(w io.Writer) *Logger {
+	return &Logger{w: w}
+}
+
+// Open creates a Logger that appends to the log file at path, creating it if needed. An existing log is verified
+// first, so that new entries are chained to it.
+func Open(path string) (*Logger, error) {
+	l := &Logger{}
+	if f, err := os.Open(path); err == nil {
+		entries, err := Verify(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) != 0 {
+			last := entries[len(entries)-1]
+			l.seq, l.head = last.Seq, last.Hash
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l.w, l.closer = f, f
+	return l, nil
+}
+
+// Head is the Hash of the last entry written. Record it outside of the log to detect the removal of the last entries.
+func (l *Logger) Head() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.head
+}
+
+// Close closes the log file, if the Logger opened it
+func (l *Logger) Close() error {
+	if l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
+
+// write chains e to the log and appends it. It returns the entry's Seq.
+func (l *Logger) write(e Entry) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return 0, l.err
+	}
+	e.Seq = l.seq + 1
+	if e.Operation == Begin {
+		e.Transaction = e.Seq
+	}
+	e.PrevHash = l.head
+	hash, err := e.computeHash()
+	if err == nil {
+		e.Hash = hash
+		var b []byte
+		b, err = json.Marshal(e)
+		if err == nil {
+			_, err = l.w.Write(append(b, '\n'))
+		}
+	}
+	if err != nil {
+		l.err = &ErrUnavailable{Err: err}
+		return 0, l.err
+	}
+	l.seq, l.head = e.Seq, e.Hash
+	return e.Seq, nil
+}
+
+// available returns the error that stops calls from being made, if any
+func (l *Logger) available() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+func (l *Logger) redact(params []interface{}) []string {
+	redact := l.Redact
+	if redact == nil {
+		redact = RedactType
+	}
+	redacted := make([]string, len(params))
+	for i, p := range params {
+		redacted[i] = redact(p)
+	}
+	return redacted
+}
+
+// newEntry starts the entry for a call made with ctx
+func (l *Logger) newEntry(ctx context.Context, op Operation, fp fingerprint.Fingerprinter, tx transaction, err error) Entry {
+	e := Entry{
+		Time:        time.Now().UTC(),
+		Operation:   op,
+		Transaction: tx.seq,
+		Parent:      tx.parent,
+		Outcome:     Succeeded,
+	}
+	e.Actor, _ = ActorFrom(ctx)
+	if fp != nil {
+		e.Fingerprint, e.Query = fp.ID(), fp.Normalized()
+	}
+	if err != nil {
+		e.Outcome, e.Error = Failed, err.Error()
+	}
+	return e
+}
+
+// Install prepends the logger to the data-modifying chains of the engine: Exec, Insert, the statement Exec and
+// Insert, Begin, Commit and Rollback. Begin is covered for both SingleTXer and MultiTXer engines.
+func (l *Logger) Install(e vsql_engine.SQLQueryer) {
+	e.ExecQueryMW().Prepend(func(ctx context.Context, c engine_context.Execer) {
+		l.logWrite(ctx, c, Exec, c.Fingerprint(), func() []interface{} {
+			_, values, _ := query_rewrite.Positional(c.Query())
+			return values
+		}, func() vresult.Resulter {
+			return c.Result()
+		})
+	})
+	e.InsertQueryMW().Prepend(func(ctx context.Context, c engine_context.Inserter) {
+		l.logWrite(ctx, c, Insert, c.Fingerprint(), func() []interface{} {
+			_, values, _ := query_rewrite.Positional(c.Query())
+			return values
+		}, func() vresult.Resulter {
+			return c.InsertResult()
+		})
+	})
+	e.StatementExecQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		l.logWrite(ctx, c, StatementExec, c.Fingerprint(), func() []interface{} {
+			return statementValues(c.Query(), c.Parameterer())
+		}, func() vresult.Resulter {
+			return c.Result()
+		})
+	})
+	e.StatementInsertQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementInsertQueryer) {
+		l.logWrite(ctx, c, StatementInsert, c.Fingerprint(), func() []interface{} {
+			return statementValues(c.Query(), c.Parameterer())
+		}, func() vresult.Resulter {
+			return c.InsertResult()
+		})
+	})
+	e.CommitMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+		l.logEnd(ctx, c, Commit, c.QueryExecTransactioner())
+	})
+	e.RollbackMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+		l.logEnd(ctx, c, Rollback, c.QueryExecTransactioner())
+	})
+	if b, ok := e.(engine_ware.BeginWare); ok {
+		b.BeginMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+			if err := l.available(); err != nil {
+				c.SetError(err)
+				return
+			}
+			c.Next(ctx)
+			l.logBegin(ctx, c, nil, c.QueryExecTransactioner())
+		})
+	}
+	if b, ok := e.(engine_ware.BeginNestedWare); ok {
+		b.BeginNestedMW().Prepend(func(ctx context.Context, c engine_context.NestedBeginner) {
+			if err := l.available(); err != nil {
+				c.SetError(err)
+				return
+			}
+			parent := engine_context.TransactionOf(c)
+			c.Next(ctx)
+			var tx vsql.QueryExecTransactioner
+			if c.Error() == nil && c.QueryExecNestedTransactioner() != nil {
+				tx = c.QueryExecNestedTransactioner()
+			}
+			l.logBegin(ctx, c, parent, tx)
+		})
+	}
+}
+
+// lookup returns the transaction the driver's tx is in the log, if any
+func (l *Logger) lookup(tx vsql.QueryExecTransactioner) transaction {
+	t, _ := l.transactions.Lookup(tx)
+	return t
+}
+
+// statementValues returns the values a statement is run with
+func statementValues(query vparam.Queryer, params vparam.Parameterer) []interface{} {
+	if query == nil {
+		return nil
+	}
+	values, _ := query_rewrite.PositionalParameters(query.SQLQueryUnInterpolated(), params)
+	return values
+}
+
+// logWrite logs a data-modifying call and runs it. A Pending entry with the call's query and values is written
+// before the call is made, and refused if it cannot be written, so that no write is made without being logged. The
+// entry with its outcome is written after it. If that one cannot be written, the log still holds the Pending entry,
+// which is the only record of the write; it is not Committed, as its outcome is unknown.
+func (l *Logger) logWrite(ctx context.Context, c engine_context.Er, op Operation, fp fingerprint.Fingerprinter, values func() []interface{}, result func() vresult.Resulter) {
+	t := l.lookup(engine_context.TransactionOf(c))
+	pending := l.newEntry(ctx, op, fp, t, nil)
+	pending.Outcome = Pending
+	pending.Params = l.redact(values())
+	intent, err := l.write(pending)
+	if err != nil {
+		c.SetError(err)
+		return
+	}
+	c.Next(ctx)
+	e := l.newEntry(ctx, op, fp, t, c.Error())
+	e.Intent = intent
+	e.Final = t.seq == 0 && c.Error() == nil
+	if c.Error() == nil {
+		if r := result(); r != nil {
+			if n, err := r.RowsAffected(); err == nil {
+				rows := uint64(n)
+				e.RowsAffected = &rows
+			}
+		}
+	}
+	if _, err := l.write(e); err != nil && c.Error() == nil {
+		c.SetError(err)
+	}
+}
+
+func (l *Logger) logBegin(ctx context.Context, c engine_context.Er, parent vsql.QueryExecTransactioner, tx vsql.QueryExecTransactioner) {
+	p := l.lookup(parent)
+	e := l.newEntry(ctx, Begin, nil, transaction{}, c.Error())
+	e.Parent = p.seq
+	seq, err := l.write(e)
+	if err != nil {
+		if tx != nil {
+			// the transaction cannot be used without an audit trail
+			_ = tx.Rollback()
+		}
+		c.SetError(err)
+		return
+	}
+	if c.Error() == nil {
+		l.transactions.Track(tx, transaction{seq: seq, parent: p.seq})
+	}
+}
+
+func (l *Logger) logEnd(ctx context.Context, c engine_context.Er, op Operation, tx vsql.QueryExecTransactioner) {
+	if op == Commit {
+		if err := l.available(); err != nil {
+			c.SetError(err)
+			return
+		}
+	}
+	c.Next(ctx)
+	t := l.lookup(tx)
+	e := l.newEntry(ctx, op, nil, t, c.Error())
+	e.Final = op == Commit && c.Error() == nil && t.parent == 0
+	if c.Error() == nil {
+		l.transactions.Forget(tx)
+	}
+	if _, err := l.write(e); err != nil && c.Error() == nil {
+		c.SetError(err)
+	}
+}
@@ -0,0 +1,226 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package audit_log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/internal/testdriver"
+	"github.com/wojnosystems/vsql_engine/query_rewrite"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newEngine creates an engine with a fake driver whose writes affect rows rows. Queries starting with BAD fail.
+func newEngine(rows uint64) (vsql_engine.SingleTXer, *testdriver.Driver) {
+	e := vsql_engine.NewSingle()
+	d := testdriver.Install(e)
+	d.RowsAffected = rows
+	d.Fail = func(call testdriver.Call) error {
+		if strings.HasPrefix(call.SQL, "BAD") {
+			return errors.New("syntax error")
+		}
+		return nil
+	}
+	return e, d
+}
+
+func TestLogger_Entries(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewLogger(buf)
+	e, _ := newEngine(2)
+	l.Install(e)
+	ctx := WithActor(context.Background(), "alice")
+
+	_, err := e.Exec(ctx, vparam.NewAppendWithData("UPDATE users SET name = ? WHERE id = ?", "Bob", 7))
+	assert.NoError(t, err)
+	_, err = e.Exec(ctx, vparam.New("BAD QUERY"))
+	assert.Error(t, err)
+
+	entries, err := Verify(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 4) {
+		pending, done := entries[0], entries[1]
+		assert.Equal(t, "alice", pending.Actor)
+		assert.Equal(t, Exec, pending.Operation)
+		assert.Equal(t, "update users set name = ? where id = ?", pending.Query)
+		assert.Equal(t, []string{"string", "int"}, pending.Params)
+		assert.Equal(t, Pending, pending.Outcome)
+		assert.False(t, pending.Committed, "expected the intent to not be committed")
+		assert.Equal(t, pending.Seq, done.Intent)
+		if assert.NotNil(t, done.RowsAffected) {
+			assert.Equal(t, uint64(2), *done.RowsAffected)
+		}
+		assert.Equal(t, Succeeded, done.Outcome)
+		assert.True(t, done.Final)
+		assert.True(t, done.Committed)
+		assert.Equal(t, Failed, entries[3].Outcome)
+		assert.False(t, entries[3].Final, "expected a failed write to not be final")
+		assert.False(t, entries[3].Committed)
+		assert.Equal(t, "syntax error", entries[3].Error)
+		assert.Nil(t, entries[3].RowsAffected)
+		assert.Equal(t, entries[2].Hash, entries[3].PrevHash)
+		assert.Equal(t, entries[3].Hash, l.Head())
+	}
+}
+
+func TestLogger_Redact(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewLogger(buf)
+	l.Redact = func(value interface{}) string {
+		if s, ok := value.(string); ok && len(s) > 1 {
+			return s[:1] + "***"
+		}
+		return RedactType(value)
+	}
+	e, _ := newEngine(1)
+	l.Install(e)
+	_, err := e.Exec(context.Background(), vparam.NewAppendWithData("UPDATE users SET email = ? WHERE id = ?", "bob@example.com", 7))
+	assert.NoError(t, err)
+	entries, err := Verify(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b***", "int"}, entries[0].Params)
+}
+
+func TestLogger_Transactions(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewLogger(buf)
+	e, _ := newEngine(1)
+	l.Install(e)
+	ctx := context.Background()
+
+	committed, err := e.Begin(ctx, nil)
+	assert.NoError(t, err)
+	stmt, err := committed.Prepare(ctx, vparam.New("DELETE FROM carts WHERE id = ?"))
+	assert.NoError(t, err)
+	_, err = stmt.Exec(ctx, query_rewrite.Parameters([]interface{}{4}))
+	assert.NoError(t, err)
+	rolledBack, err := e.Begin(ctx, nil)
+	assert.NoError(t, err)
+	_, err = rolledBack.Exec(ctx, vparam.New("DELETE FROM orders"))
+	assert.NoError(t, err)
+	assert.NoError(t, rolledBack.Rollback())
+	assert.NoError(t, committed.Commit())
+
+	entries, err := Verify(buf)
+	assert.NoError(t, err)
+	var ops []Operation
+	for _, entry := range entries {
+		ops = append(ops, entry.Operation)
+	}
+	assert.Equal(t, []Operation{Begin, StatementExec, StatementExec, Begin, Exec, Exec, Rollback, Commit}, ops)
+	assert.Equal(t, uint64(1), entries[2].Transaction, "expected statements to be in the transaction they were prepared in")
+	assert.Equal(t, []string{"int"}, entries[1].Params)
+	assert.False(t, entries[2].Final)
+	assert.True(t, entries[2].Committed, "writes of committed transactions are committed")
+	assert.Equal(t, uint64(4), entries[5].Transaction)
+	assert.False(t, entries[5].Committed, "writes of rolled back transactions are not committed")
+	assert.Equal(t, uint64(4), entries[6].Transaction)
+	assert.True(t, entries[7].Final)
+}
+
+func TestVerify_Tampered(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewLogger(buf)
+	e, _ := newEngine(1)
+	l.Install(e)
+	for i := 0; i < 2; i++ {
+		_, err := e.Exec(context.Background(), vparam.New("DELETE FROM sessions"))
+		assert.NoError(t, err)
+	}
+	lines := strings.SplitAfter(buf.String(), "\n")
+
+	modified := strings.Replace(buf.String(), `"rows_affected":1`, `"rows_affected":0`, 1)
+	_, err := Verify(strings.NewReader(modified))
+	assert.Equal(t, &ErrTampered{Line: 2, Reason: "the entry does not match its hash"}, err)
+
+	deleted := lines[0] + lines[2]
+	_, err = Verify(strings.NewReader(deleted))
+	assert.Equal(t, &ErrTampered{Line: 2, Reason: "expected entry 2, found entry 3"}, err)
+}
+
+func TestOpen_Appends(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit_log")
+	assert.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "audit.log")
+
+	for i := 0; i < 2; i++ {
+		l, err := Open(path)
+		assert.NoError(t, err)
+		e, _ := newEngine(1)
+		l.Install(e)
+		_, err = e.Exec(context.Background(), vparam.New("DELETE FROM sessions"))
+		assert.NoError(t, err)
+		assert.NoError(t, l.Close())
+	}
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer func() { _ = f.Close() }()
+	entries, err := Verify(f)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 4)
+}
+
+// failingWriter is a log that can be written writes times, and then fails
+type failingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *failingWriter) Write(b []byte) (int, error) {
+	if w.writes == 0 {
+		return 0, errors.New("disk full")
+	}
+	w.writes--
+	return w.Buffer.Write(b)
+}
+
+func TestLogger_Unavailable(t *testing.T) {
+	l := NewLogger(&failingWriter{})
+	e, d := newEngine(1)
+	l.Install(e)
+	_, err := e.Exec(context.Background(), vparam.New("DELETE FROM sessions"))
+	assert.IsType(t, &ErrUnavailable{}, err)
+	assert.Empty(t, d.Calls(testdriver.Exec), "expected the write to be refused before it is made")
+	_, err = e.Exec(context.Background(), vparam.New("DELETE FROM sessions"))
+	assert.IsType(t, &ErrUnavailable{}, err)
+	_, err = e.Begin(context.Background(), nil)
+	assert.IsType(t, &ErrUnavailable{}, err)
+}
+
+func TestLogger_OutcomeUnavailable(t *testing.T) {
+	w := &failingWriter{writes: 1}
+	l := NewLogger(w)
+	e, d := newEngine(1)
+	l.Install(e)
+	_, err := e.Exec(context.Background(), vparam.New("DELETE FROM sessions"))
+	assert.IsType(t, &ErrUnavailable{}, err)
+	assert.Len(t, d.Calls(testdriver.Exec), 1)
+	entries, err := Verify(&w.Buffer)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1, "expected the intent to record the write whose outcome could not be logged") {
+		assert.Equal(t, Pending, entries[0].Outcome)
+		assert.Equal(t, "delete from sessions", entries[0].Query)
+	}
+}
@@ -55,8 +55,10 @@ func (m *engineNoNest) Begin(ctx context.Context, txOp vtxn.TxOptioner) (n vsql.
 	c.SetTxOptions(txOp)
 	m.beginMW.PerformMiddleware(ctx, c)
 	s := &nonNestedTx{
+		ctx:                ctx,
 		beginnerContext:    c,
 		queryEngineFactory: m.engineQuery,
+		middlewareContext:  engine_context.ForTransaction(m.engineQuery.middlewareContext, 1, c.QueryExecTransactioner()),
 	}
 	return s, c.Error()
 }
@@ -68,3 +70,9 @@ func (m *engineNoNest) Group() SingleTXer {
 	}
 	return r
 }
+
+// Seal stops middleware from being added to the engine, see Sealer
+func (m *engineNoNest) Seal() {
+	m.engineQuery.Seal()
+	m.beginMW.Seal()
+}
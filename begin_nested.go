@@ -52,9 +52,11 @@ func (m *engineNest) Begin(ctx context.Context, txOp vtxn.TxOptioner) (n vsql.Qu
 	c.SetTxOptions(txOp)
 	m.beginMW.PerformMiddleware(ctx, c)
 	s := &nestedTx{
+		ctx:                   ctx,
 		beginNestedMW:         m.beginMW,
 		beginnerNestedContext: c,
 		queryEngineFactory:    m,
+		middlewareContext:     engine_context.ForTransaction(m.engineQuery.middlewareContext, 1, c.QueryExecNestedTransactioner()),
 	}
 	return s, c.Error()
 }
@@ -66,3 +68,9 @@ func (m *engineNest) Group() MultiTXer {
 	}
 	return r
 }
+
+// Seal stops middleware from being added to the engine, see Sealer
+func (m *engineNest) Seal() {
+	m.engineQuery.Seal()
+	m.beginMW.Seal()
+}
@@ -19,6 +19,9 @@ import (
 	"context"
 	"errors"
 	"github.com/wojnosystems/vsql"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vstmt"
+	"github.com/wojnosystems/vsql/vtxn"
 	"github.com/wojnosystems/vsql_engine/engine_context"
 	"testing"
 )
@@ -89,3 +92,113 @@ func TestEngineNest_Begin_QETPassedRollback(t *testing.T) {
 		t.Error("expected a query Exec transactioner to be returned")
 	}
 }
+
+type beginNestedTestKey struct{}
+
+func TestNest_Begin_ContextPassedCommit(t *testing.T) {
+	ctx := context.WithValue(context.Background(), beginNestedTestKey{}, "kitten")
+	engine := NewMulti()
+	var commitValue interface{}
+	engine.CommitMW().Append(func(ctx context.Context, c engine_context.Beginner) {
+		commitValue = ctx.Value(beginNestedTestKey{})
+		c.Next(ctx)
+	})
+	r, _ := engine.Begin(context.Background(), nil)
+	nested, _ := r.Begin(ctx, nil)
+	_ = nested.Commit()
+	if commitValue != "kitten" {
+		t.Error("expected Commit to receive the nested Begin context")
+	}
+}
+
+func TestNest_Begin_ParentTransactionPassed(t *testing.T) {
+	engine := NewMulti()
+	rootQET := &vsql.QueryExecNestedTransactionerMock{}
+	nestedQET := &vsql.QueryExecNestedTransactionerMock{}
+	var actualParent vsql.QueryExecNestedTransactioner
+	engine.BeginNestedMW().Append(func(ctx context.Context, c engine_context.NestedBeginner) {
+		if c.QueryExecNestedTransactioner() == nil {
+			c.SetQueryExecNestedTransactioner(rootQET)
+		} else {
+			actualParent = c.QueryExecNestedTransactioner()
+			c.SetQueryExecNestedTransactioner(nestedQET)
+		}
+		c.Next(ctx)
+	})
+	var committed vsql.QueryExecTransactioner
+	engine.CommitMW().Append(func(ctx context.Context, c engine_context.Beginner) {
+		committed = c.QueryExecTransactioner()
+		c.Next(ctx)
+	})
+	r, _ := engine.Begin(context.Background(), nil)
+	nested, _ := r.Begin(context.Background(), nil)
+	if actualParent != rootQET {
+		t.Error("expected the nested begin to receive the parent's driver transaction")
+	}
+	_ = nested.Commit()
+	if committed != nestedQET {
+		t.Error("expected the nested transaction to be committed")
+	}
+}
+
+func TestNest_Begin_NilTxOptionDefaulted(t *testing.T) {
+	engine := NewMulti()
+	var actualOp vtxn.TxOptioner
+	calls := 0
+	engine.BeginNestedMW().Append(func(ctx context.Context, c engine_context.NestedBeginner) {
+		calls++
+		if calls == 2 {
+			actualOp = c.TxOptions()
+		}
+		c.SetQueryExecNestedTransactioner(&vsql.QueryExecNestedTransactionerMock{})
+		c.Next(ctx)
+	})
+	r, _ := engine.Begin(context.Background(), nil)
+	_, _ = r.Begin(context.Background(), nil)
+	if actualOp == nil {
+		t.Error("expected the nested begin to receive default transaction options")
+	}
+}
+
+func TestNest_TransactionOfCalls(t *testing.T) {
+	engine := NewMulti()
+	rootQET := &vsql.QueryExecNestedTransactionerMock{}
+	nestedQET := &vsql.QueryExecNestedTransactionerMock{}
+	var beganIn []vsql.QueryExecTransactioner
+	engine.BeginNestedMW().Append(func(ctx context.Context, c engine_context.NestedBeginner) {
+		beganIn = append(beganIn, engine_context.TransactionOf(c))
+		if c.QueryExecNestedTransactioner() == nil {
+			c.SetQueryExecNestedTransactioner(rootQET)
+		} else {
+			c.SetQueryExecNestedTransactioner(nestedQET)
+		}
+		c.Next(ctx)
+	})
+	engine.StatementPrepareMW().Append(func(ctx context.Context, c engine_context.Preparer) {
+		c.SetStatement(&vstmt.StatementerMock{})
+		c.Next(ctx)
+	})
+	var execIn, committedIn vsql.QueryExecTransactioner
+	engine.StatementExecQueryMW().Append(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		execIn = engine_context.TransactionOf(c)
+		c.Next(ctx)
+	})
+	engine.CommitMW().Append(func(ctx context.Context, c engine_context.Beginner) {
+		committedIn = engine_context.TransactionOf(c)
+		c.Next(ctx)
+	})
+	r, _ := engine.Begin(context.Background(), nil)
+	nested, _ := r.Begin(context.Background(), nil)
+	stmt, _ := nested.Prepare(context.Background(), vparam.New("DELETE FROM puppies"))
+	_, _ = stmt.Exec(context.Background(), vparam.New(""))
+	_ = nested.Commit()
+	if len(beganIn) != 2 || beganIn[0] != nil || beganIn[1] != rootQET {
+		t.Error("expected Begin to run in the transaction it is started from")
+	}
+	if execIn != nestedQET {
+		t.Error("expected a statement to run in the transaction it was prepared in")
+	}
+	if committedIn != nestedQET {
+		t.Error("expected Commit to run in the transaction it ends")
+	}
+}
@@ -89,3 +89,31 @@ func TestNoNest_Begin_QETPassedRollback(t *testing.T) {
 		t.Error("expected a query Exec transactioner to be returned")
 	}
 }
+
+type beginTestKey struct{}
+
+func TestNoNest_Begin_ContextPassedCommitRollback(t *testing.T) {
+	ctx := context.WithValue(context.Background(), beginTestKey{}, "kitten")
+	engine := NewSingle()
+	var commitValue, rollbackValue interface{}
+	engine.CommitMW().Append(func(ctx context.Context, c engine_context.Beginner) {
+		commitValue = ctx.Value(beginTestKey{})
+		if c.KeyValues() == nil {
+			t.Error("expected the commit context to have the engine's key values")
+		}
+		c.Next(ctx)
+	})
+	engine.RollbackMW().Append(func(ctx context.Context, c engine_context.Beginner) {
+		rollbackValue = ctx.Value(beginTestKey{})
+		c.Next(ctx)
+	})
+	r, _ := engine.Begin(ctx, nil)
+	_ = r.Commit()
+	_ = r.Rollback()
+	if commitValue != "kitten" {
+		t.Error("expected Commit to receive the Begin context")
+	}
+	if rollbackValue != "kitten" {
+		t.Error("expected Rollback to receive the Begin context")
+	}
+}
@@ -0,0 +1,133 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vresult"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"testing"
+)
+
+// benchmarkDepth is the number of pass-through handlers placed in front of the driver in each benchmarked chain
+const benchmarkDepth = 5
+
+// benchmarkEngine returns an engine whose Query, Exec and RowsNext chains hold benchmarkDepth pass-through handlers
+// followed by a driver that hands back the same results every time, so that only the engine's own work is measured.
+func benchmarkEngine() SingleTXer {
+	engine := NewSingle()
+	rowser := &vrows.RowserMock{}
+	rower := &vrows.RowerMock{}
+	result := &vresult.ResulterMock{}
+	for i := 0; i < benchmarkDepth; i++ {
+		engine.QueryMW().Append(func(ctx context.Context, c engine_context.Queryer) {
+			c.Next(ctx)
+		})
+		engine.ExecQueryMW().Append(func(ctx context.Context, c engine_context.Execer) {
+			c.Next(ctx)
+		})
+		engine.RowsNextMW().Append(func(ctx context.Context, c engine_context.RowsNexter) {
+			c.Next(ctx)
+		})
+	}
+	engine.QueryMW().Append(func(ctx context.Context, c engine_context.Queryer) {
+		c.SetRows(rowser)
+		c.Next(ctx)
+	})
+	engine.ExecQueryMW().Append(func(ctx context.Context, c engine_context.Execer) {
+		c.SetResult(result)
+		c.Next(ctx)
+	})
+	engine.RowsNextMW().Append(func(ctx context.Context, c engine_context.RowsNexter) {
+		c.SetRow(rower)
+		c.Next(ctx)
+	})
+	return engine
+}
+
+// BenchmarkEngine_Query allocates only the Rowser it returns
+func BenchmarkEngine_Query(b *testing.B) {
+	engine := benchmarkEngine()
+	ctx := context.Background()
+	q := vparam.New("SELECT * FROM puppies")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = engine.Query(ctx, q)
+	}
+}
+
+func BenchmarkEngine_Exec(b *testing.B) {
+	engine := benchmarkEngine()
+	ctx := context.Background()
+	q := vparam.New("UPDATE puppies SET name = 'fido'")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = engine.Exec(ctx, q)
+	}
+}
+
+func BenchmarkRows_Next(b *testing.B) {
+	engine := benchmarkEngine()
+	rows, _ := engine.Query(context.Background(), vparam.New("SELECT * FROM puppies"))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = rows.Next()
+	}
+}
+
+func BenchmarkEngine_QueryParallel(b *testing.B) {
+	engine := benchmarkEngine()
+	q := vparam.New("SELECT * FROM puppies")
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		ctx := context.Background()
+		for pb.Next() {
+			_, _ = engine.Query(ctx, q)
+		}
+	})
+}
+
+// TestEngine_Allocations keeps the engine's own allocations from creeping up: a Query allocates only the Rowser it
+// returns, and Exec and Rows.Next allocate nothing
+func TestEngine_Allocations(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector allocates")
+	}
+	engine := benchmarkEngine()
+	ctx := context.Background()
+	query := vparam.New("SELECT * FROM puppies")
+	exec := vparam.New("UPDATE puppies SET name = 'fido'")
+	rows, _ := engine.Query(ctx, query)
+	cases := []struct {
+		name     string
+		f        func()
+		expected float64
+	}{
+		{name: "Query", expected: 1, f: func() { _, _ = engine.Query(ctx, query) }},
+		{name: "Exec", expected: 0, f: func() { _, _ = engine.Exec(ctx, exec) }},
+		{name: "Rows.Next", expected: 0, f: func() { _ = rows.Next() }},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, testing.AllocsPerRun(100, c.f), c.name)
+	}
+}
@@ -0,0 +1,247 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cdc
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vresult"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/engine_ware"
+	"github.com/wojnosystems/vsql_engine/fingerprint"
+	"github.com/wojnosystems/vsql_engine/query_rewrite"
+	"log"
+	"sync"
+	"time"
+)
+
+// cdc captures the rows written in transactions, the change data, and publishes them once the outermost transaction
+// commits. Writes made outside of a transaction, and DDL, are not captured.
+
+// Capturer captures the writes. Create one with New or NewOutbox and install it into an engine with Install.
+type Capturer struct {
+	// OnEmitError receives the changes of a committed transaction that the Sink failed to emit, so that they can be
+	// emitted again. Commit still succeeds, as the transaction was committed. If nil, the failures are written to the
+	// standard logger and the changes are lost.
+	OnEmitError func(ctx context.Context, err *ErrEmit)
+
+	sink   Sink
+	outbox *Outbox
+
+	// transactions maps the transactions created by the driver to their buffer. Entries are removed when the
+	// transaction ends.
+	transactions engine_context.Tracker[*buffer]
+
+	// now is replaceable for tests
+	now func() time.Time
+}
+
+// buffer holds the changes of one transaction until it ends
+type buffer struct {
+	// parent is the buffer of the transaction this one is nested in. It is nil for outermost transactions.
+	parent  *buffer
+	mu      sync.Mutex
+	changes []Change
+}
+
+func (b *buffer) add(changes ...Change) {
+	b.mu.Lock()
+	b.changes = append(b.changes, changes...)
+	b.mu.Unlock()
+}
+
+// take returns the changes and empties the buffer
+func (b *buffer) take() (changes []Change) {
+	b.mu.Lock()
+	changes, b.changes = b.changes, nil
+	b.mu.Unlock()
+	return
+}
+
+// New creates a Capturer that emits the changes of each outermost transaction to sink after it commits
+func New(sink Sink) *Capturer {
+	return &Capturer{sink: sink, now: time.Now}
+}
+
+// NewOutbox creates a Capturer that writes the changes of each outermost transaction to outbox before it commits
+func NewOutbox(outbox Outbox) *Capturer {
+	return &Capturer{outbox: &outbox, now: time.Now}
+}
+
+// Install prepends the Capturer to the write chains of the engine: Exec, Insert, the statement Exec and Insert,
+// Begin, Commit and Rollback. Begin is covered for both SingleTXer and MultiTXer engines.
+// Because it is prepended, it captures writes as the driver made them.
+func (m *Capturer) Install(e vsql_engine.SQLQueryer) {
+	e.ExecQueryMW().Prepend(func(ctx context.Context, c engine_context.Execer) {
+		c.Next(ctx)
+		m.capture(c, c.QueryExecTransactioner(), c.Query(), nil, nil)
+	})
+	e.InsertQueryMW().Prepend(func(ctx context.Context, c engine_context.Inserter) {
+		c.Next(ctx)
+		m.capture(c, c.QueryExecTransactioner(), c.Query(), nil, c.InsertResult())
+	})
+	e.StatementExecQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		c.Next(ctx)
+		m.capture(c, engine_context.TransactionOf(c), c.Query(), c.Parameterer(), nil)
+	})
+	e.StatementInsertQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementInsertQueryer) {
+		c.Next(ctx)
+		m.capture(c, engine_context.TransactionOf(c), c.Query(), c.Parameterer(), c.InsertResult())
+	})
+	e.CommitMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+		m.commit(ctx, c, c.QueryExecTransactioner())
+	})
+	e.RollbackMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+		c.Next(ctx)
+		if c.Error() == nil {
+			// the changes of the transaction, and of the transactions committed into it, are discarded
+			m.transactions.Forget(c.QueryExecTransactioner())
+		}
+	})
+	if b, ok := e.(engine_ware.BeginWare); ok {
+		b.BeginMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+			c.Next(ctx)
+			if c.Error() == nil {
+				m.transactions.Track(c.QueryExecTransactioner(), &buffer{})
+			}
+		})
+	}
+	if b, ok := e.(engine_ware.BeginNestedWare); ok {
+		b.BeginNestedMW().Prepend(func(ctx context.Context, c engine_context.NestedBeginner) {
+			// the context holds the parent's transaction until the driver replaces it with the new one
+			var parent *buffer
+			if p := c.QueryExecNestedTransactioner(); p != nil {
+				if parent = m.lookup(p); parent == nil {
+					// the parent began before the Capturer was installed: its changes are not captured
+					c.Next(ctx)
+					return
+				}
+			}
+			c.Next(ctx)
+			if tx := c.QueryExecNestedTransactioner(); c.Error() == nil && tx != nil {
+				m.transactions.Track(tx, &buffer{parent: parent})
+			}
+		})
+	}
+}
+
+// lookup returns the buffer of the driver's tx, if it has one
+func (m *Capturer) lookup(tx interface{}) *buffer {
+	buf, _ := m.transactions.Lookup(tx)
+	return buf
+}
+
+// capture records a write that was made in tx. params are the statement's values, for statements.
+func (m *Capturer) capture(c engine_context.Er, tx interface{}, query vparam.Queryer, params vparam.Parameterer, inserted vresult.InsertResulter) {
+	buf := m.lookup(tx)
+	if buf == nil || c.Error() != nil || query == nil {
+		return
+	}
+	f := fingerprint.New(query.SQLQueryUnInterpolated())
+	op, ok := operationOf(f.StatementType())
+	if !ok {
+		return
+	}
+	change := Change{
+		Operation: op,
+		Time:      m.now(),
+	}
+	if tables := f.Tables(); len(tables) != 0 {
+		change.Table = tables[0]
+	}
+	var err error
+	if params == nil {
+		change.Query, change.Params, err = query_rewrite.Positional(query)
+	} else {
+		change.Query = query_rewrite.PositionalSQL(query)
+		change.Params, err = query_rewrite.PositionalParameters(query.SQLQueryUnInterpolated(), params)
+	}
+	if err != nil || len(change.Params) == 0 {
+		// the driver accepted the values, so err is unexpected; the query is kept without them
+		change.Params = nil
+	}
+	if inserted != nil {
+		if id, err := inserted.LastInsertId(); err == nil {
+			v := uint64(id)
+			change.LastInsertID = &v
+		}
+	}
+	buf.add(change)
+}
+
+// commit commits tx. The changes of a nested transaction are added to its parent's; those of an outermost
+// transaction are written to the outbox before it commits, or emitted to the sink after. Sink failures are passed to
+// OnEmitError rather than failing the commit, which succeeded.
+func (m *Capturer) commit(ctx context.Context, c engine_context.Beginner, tx vsql.QueryExecTransactioner) {
+	buf := m.lookup(tx)
+	if buf == nil {
+		c.Next(ctx)
+		return
+	}
+	if buf.parent != nil {
+		c.Next(ctx)
+		if c.Error() == nil {
+			m.transactions.Forget(tx)
+			buf.parent.add(buf.take()...)
+		}
+		return
+	}
+	changes := buf.take()
+	for i := range changes {
+		changes[i].Seq = i + 1
+	}
+	if m.outbox != nil && len(changes) != 0 {
+		if err := m.writeOutbox(ctx, tx, changes); err != nil {
+			// the changes are kept, so that the commit can be retried
+			buf.add(changes...)
+			c.SetError(err)
+			return
+		}
+	}
+	c.Next(ctx)
+	if c.Error() != nil {
+		buf.add(changes...)
+		return
+	}
+	m.transactions.Forget(tx)
+	if m.sink != nil && len(changes) != 0 {
+		if err := m.sink.Emit(ctx, changes); err != nil {
+			m.emitFailed(ctx, &ErrEmit{Err: err, Changes: changes})
+		}
+	}
+}
+
+func (m *Capturer) emitFailed(ctx context.Context, err *ErrEmit) {
+	if m.OnEmitError != nil {
+		m.OnEmitError(ctx, err)
+		return
+	}
+	log.Println(err.Error())
+}
+
+// writeOutbox writes changes to the outbox in the driver's transaction tx. They are written with one INSERT, so
+// either every row is written or none is, and a failed commit can be retried without duplicating rows. It is not
+// made through the engine, so the rows are not captured themselves.
+func (m *Capturer) writeOutbox(ctx context.Context, tx vsql.QueryExecTransactioner, changes []Change) error {
+	q, err := m.outbox.query(changes)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, q)
+	return err
+}
@@ -0,0 +1,197 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cdc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/internal/testdriver"
+	"github.com/wojnosystems/vsql_engine/query_rewrite"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestEngine(c *Capturer) (vsql_engine.MultiTXer, *testdriver.Driver) {
+	e := vsql_engine.NewMulti()
+	d := testdriver.Install(e)
+	c.now = func() time.Time {
+		return time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	}
+	c.Install(e)
+	return e, d
+}
+
+// outboxWrites returns the writes to the outbox table that reached the driver
+func outboxWrites(d *testdriver.Driver) (writes []testdriver.Call) {
+	for _, call := range d.Calls(testdriver.Exec) {
+		if strings.HasPrefix(call.SQL, "INSERT INTO outbox") {
+			writes = append(writes, call)
+		}
+	}
+	return
+}
+
+// failOn makes the calls through chain whose SQL starts with prefix fail with err
+func failOn(d *testdriver.Driver, chain, prefix string, err error) {
+	d.Fail = func(call testdriver.Call) error {
+		if call.Chain == chain && strings.HasPrefix(call.SQL, prefix) {
+			return err
+		}
+		return nil
+	}
+}
+
+// sinkRecorder is a Sink that records the transactions it is given
+type sinkRecorder struct {
+	emitted [][]Change
+	err     error
+}
+
+func (s *sinkRecorder) Emit(ctx context.Context, changes []Change) error {
+	s.emitted = append(s.emitted, changes)
+	return s.err
+}
+
+func TestCapturer_Commit(t *testing.T) {
+	sink := &sinkRecorder{}
+	e, _ := newTestEngine(New(sink))
+	ctx := context.Background()
+
+	_, _ = e.Exec(ctx, vparam.New("DELETE FROM sessions"))
+	tx, _ := e.Begin(ctx, nil)
+	_, _ = tx.Exec(ctx, vparam.NewAppendWithData("UPDATE accounts SET balance = ? WHERE id = ?", 10, 1))
+	_, _ = tx.Exec(ctx, vparam.New("SELECT 1"))
+	_, _ = tx.Insert(ctx, vparam.NewNamedWithData("INSERT INTO `accounts` (name) VALUES (:name)", map[string]interface{}{"name": "chris"}))
+	committed, _ := tx.Begin(ctx, nil)
+	stmt, _ := committed.Prepare(ctx, vparam.New("DELETE FROM ledger WHERE id = ?"))
+	_, err := stmt.Exec(ctx, query_rewrite.Parameters([]interface{}{2}))
+	assert.NoError(t, err)
+	grandchild, _ := committed.Begin(ctx, nil)
+	_, _ = grandchild.Exec(ctx, vparam.New("DELETE FROM audit.events"))
+	assert.NoError(t, grandchild.Commit())
+	assert.NoError(t, committed.Commit())
+	rolledBack, _ := tx.Begin(ctx, nil)
+	_, _ = rolledBack.Exec(ctx, vparam.New("DELETE FROM accounts"))
+	assert.NoError(t, rolledBack.Rollback())
+	assert.Empty(t, sink.emitted, "expected nothing to be emitted before the outermost transaction commits")
+	assert.NoError(t, tx.Commit())
+
+	if assert.Len(t, sink.emitted, 1) {
+		id := uint64(1)
+		at := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+		assert.Equal(t, []Change{
+			{Seq: 1, Table: "accounts", Operation: Update, Query: "UPDATE accounts SET balance = ? WHERE id = ?", Params: []interface{}{10, 1}, Time: at},
+			{Seq: 2, Table: "accounts", Operation: Insert, Query: "INSERT INTO `accounts` (name) VALUES (?)", Params: []interface{}{"chris"}, LastInsertID: &id, Time: at},
+			{Seq: 3, Table: "ledger", Operation: Delete, Query: "DELETE FROM ledger WHERE id = ?", Params: []interface{}{2}, Time: at},
+			{Seq: 4, Table: "audit.events", Operation: Delete, Query: "DELETE FROM audit.events", Time: at},
+		}, sink.emitted[0])
+	}
+}
+
+func TestCapturer_StatementPreparedOutsideTransaction(t *testing.T) {
+	sink := &sinkRecorder{}
+	e, _ := newTestEngine(New(sink))
+	ctx := context.Background()
+	stmt, _ := e.Prepare(ctx, vparam.New("DELETE FROM ledger WHERE id = ?"))
+	tx, _ := e.Begin(ctx, nil)
+	_, err := stmt.Exec(ctx, query_rewrite.Parameters([]interface{}{2}))
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+	assert.Empty(t, sink.emitted, "the statement's writes are not made in the transaction")
+}
+
+func TestCapturer_Rollback(t *testing.T) {
+	sink := &sinkRecorder{}
+	e, _ := newTestEngine(New(sink))
+	tx, _ := e.Begin(context.Background(), nil)
+	_, _ = tx.Exec(context.Background(), vparam.New("DELETE FROM sessions"))
+	assert.NoError(t, tx.Rollback())
+	assert.Empty(t, sink.emitted)
+}
+
+func TestCapturer_CommitFailed(t *testing.T) {
+	sink := &sinkRecorder{}
+	e, d := newTestEngine(New(sink))
+	deadlock := errors.New("deadlock")
+	failOn(d, testdriver.Commit, "", deadlock)
+	tx, _ := e.Begin(context.Background(), nil)
+	_, _ = tx.Exec(context.Background(), vparam.New("DELETE FROM sessions"))
+	assert.Equal(t, deadlock, tx.Commit())
+	assert.Empty(t, sink.emitted)
+}
+
+func TestCapturer_SinkFailed(t *testing.T) {
+	sink := &sinkRecorder{err: errors.New("broker unavailable")}
+	c := New(sink)
+	var failed []*ErrEmit
+	c.OnEmitError = func(ctx context.Context, err *ErrEmit) {
+		failed = append(failed, err)
+	}
+	e, _ := newTestEngine(c)
+	tx, _ := e.Begin(context.Background(), nil)
+	_, _ = tx.Exec(context.Background(), vparam.New("DELETE FROM sessions"))
+	assert.NoError(t, tx.Commit(), "the transaction was committed")
+	if assert.Len(t, failed, 1) {
+		assert.Equal(t, sink.err, errors.Unwrap(failed[0]))
+		assert.Len(t, failed[0].Changes, 1)
+	}
+}
+
+func TestCapturer_Outbox(t *testing.T) {
+	e, d := newTestEngine(NewOutbox(Outbox{Table: "outbox"}))
+	tx, _ := e.Begin(context.Background(), nil)
+	nested, _ := tx.Begin(context.Background(), nil)
+	_, _ = nested.Exec(context.Background(), vparam.NewAppendWithData("DELETE FROM sessions WHERE id = ?", 3))
+	assert.NoError(t, nested.Commit())
+	assert.Empty(t, outboxWrites(d), "expected the outbox to be written when the outermost transaction commits")
+	_, _ = tx.Exec(context.Background(), vparam.New("DELETE FROM carts"))
+	assert.NoError(t, tx.Commit())
+
+	if writes := outboxWrites(d); assert.Len(t, writes, 1, "the changes are written with one INSERT") {
+		assert.Equal(t, "INSERT INTO outbox (source_table, operation, payload) VALUES (?, ?, ?), (?, ?, ?)", writes[0].SQL)
+		params := writes[0].Params
+		assert.Equal(t, []interface{}{"sessions", "delete"}, params[0:2])
+		assert.Equal(t, []interface{}{"carts", "delete"}, params[3:5])
+		var c Change
+		assert.NoError(t, json.Unmarshal([]byte(params[2].(string)), &c))
+		assert.Equal(t, "DELETE FROM sessions WHERE id = ?", c.Query)
+		assert.Equal(t, []interface{}{float64(3)}, c.Params)
+		assert.Equal(t, d.Calls(testdriver.Begin)[0].Tx, writes[0].Tx)
+	}
+	assert.Len(t, d.Calls(testdriver.Commit), 2)
+}
+
+func TestCapturer_OutboxFailed(t *testing.T) {
+	e, d := newTestEngine(NewOutbox(Outbox{Table: "outbox"}))
+	noTable := errors.New("no such table")
+	failOn(d, testdriver.Exec, "INSERT INTO outbox", noTable)
+	tx, _ := e.Begin(context.Background(), nil)
+	_, _ = tx.Exec(context.Background(), vparam.New("DELETE FROM sessions"))
+	_, _ = tx.Exec(context.Background(), vparam.New("DELETE FROM carts"))
+	assert.Equal(t, noTable, tx.Commit())
+	assert.Empty(t, d.Calls(testdriver.Commit), "expected the transaction to not be committed without its outbox rows")
+
+	d.Fail = nil
+	assert.NoError(t, tx.Commit())
+	if writes := outboxWrites(d); assert.Len(t, writes, 1) {
+		assert.Len(t, writes[0].Params, 6, "a retried commit writes each change once")
+	}
+}
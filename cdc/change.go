@@ -0,0 +1,88 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cdc
+
+import (
+	"context"
+	"fmt"
+	"github.com/wojnosystems/vsql_engine/fingerprint"
+	"time"
+)
+
+// Operation is the kind of write a Change records
+type Operation string
+
+const (
+	Insert Operation = "insert"
+	Update Operation = "update"
+	Delete Operation = "delete"
+)
+
+// operationOf returns the Operation of a statement, or false if the statement does not write rows
+func operationOf(t fingerprint.StatementType) (Operation, bool) {
+	switch t {
+	case fingerprint.Insert:
+		return Insert, true
+	case fingerprint.Update:
+		return Update, true
+	case fingerprint.Delete:
+		return Delete, true
+	}
+	return "", false
+}
+
+// Change is one write made in a committed transaction
+type Change struct {
+	// Seq is the position of the change in its outermost transaction, starting at 1
+	Seq int `json:"seq"`
+	// Table is the first table the write references, which is the one written to by INSERT, UPDATE and DELETE
+	Table     string    `json:"table"`
+	Operation Operation `json:"op"`
+	// Query is the SQL of the write with ? placeholders, and Params are the values of the placeholders, in order
+	Query  string        `json:"query"`
+	Params []interface{} `json:"params,omitempty"`
+	// LastInsertID is the ID the driver returned for an Insert call, if it returned one
+	LastInsertID *uint64   `json:"last_insert_id,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+// Sink receives the changes of each outermost transaction that commits, in the order they were made. ctx is the
+// context the transaction was started with.
+type Sink interface {
+	Emit(ctx context.Context, changes []Change) error
+}
+
+// SinkFunc is a func that is a Sink
+type SinkFunc func(ctx context.Context, changes []Change) error
+
+func (f SinkFunc) Emit(ctx context.Context, changes []Change) error {
+	return f(ctx, changes)
+}
+
+// ErrEmit is passed to Capturer.OnEmitError when the Sink failed. The transaction was committed: Changes are the
+// changes that were not emitted, so that they can be emitted again.
+type ErrEmit struct {
+	Err     error
+	Changes []Change
+}
+
+func (e ErrEmit) Error() string {
+	return fmt.Sprintf("cdc: the transaction was committed, but %d changes could not be emitted: %s", len(e.Changes), e.Err)
+}
+
+func (e ErrEmit) Unwrap() error {
+	return e.Err
+}
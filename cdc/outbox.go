@@ -0,0 +1,53 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cdc
+
+import (
+	"encoding/json"
+	"github.com/wojnosystems/vsql/vparam"
+	"strings"
+)
+
+// Outbox writes changes to a table in the same transaction as the writes, just before it commits, so that the
+// changes are stored if and only if the transaction commits. A separate process relays the table's rows. The table
+// needs these columns:
+//
+//	source_table: the Change's Table
+//	operation:    the Change's Operation
+//	payload:      the Change as JSON
+//
+// Other columns, such as an auto-increment ID or the time the row was created, are left to their defaults.
+type Outbox struct {
+	// Table is the name of the outbox table. It is written into the SQL as given, so quote it if it needs quoting.
+	Table string
+}
+
+// query returns the INSERT that writes changes to the outbox, one row each
+func (o Outbox) query(changes []Change) (vparam.Queryer, error) {
+	rows := make([]string, len(changes))
+	params := make([]interface{}, 0, 3*len(changes))
+	for i, c := range changes {
+		payload, err := json.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+		rows[i] = "(?, ?, ?)"
+		params = append(params, c.Table, string(c.Operation), string(payload))
+	}
+	return vparam.NewAppendWithData(
+		"INSERT INTO "+o.Table+" (source_table, operation, payload) VALUES "+strings.Join(rows, ", "),
+		params...), nil
+}
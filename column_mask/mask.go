@@ -0,0 +1,54 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package column_mask
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Mask replaces the text of a column's value with what may be shown
+type Mask func(value string) string
+
+// fullMask is shown in place of fully masked values. It has a fixed length so that the length of the value is hidden.
+const fullMask = "****"
+
+// Full hides the whole value
+func Full(string) string {
+	return fullMask
+}
+
+// Partial hides all but the last 4 characters of the value, such as for card and phone numbers. Values of 4
+// characters or fewer are hidden entirely.
+func Partial(value string) string {
+	runes := []rune(value)
+	if len(runes) <= 4 {
+		return fullMask
+	}
+	return strings.Repeat("*", len(runes)-4) + string(runes[len(runes)-4:])
+}
+
+// Hashed creates a Mask that replaces the value with a keyed hash of it, so equal values can be matched without
+// being shown. The key stops values from being found by hashing guesses; keep it secret.
+func Hashed(key []byte) Mask {
+	return func(value string) string {
+		h := hmac.New(sha256.New, key)
+		_, _ = h.Write([]byte(value))
+		return hex.EncodeToString(h.Sum(nil))[:16]
+	}
+}
@@ -0,0 +1,207 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package column_mask
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/internal/columnrule"
+	"reflect"
+)
+
+// column_mask masks the values of configured columns in query results, such as for tools that must not show
+// personal data. Rows read through the engine are wrapped so that masked columns are replaced when they are scanned.
+
+type roleKey struct{}
+
+// WithRole returns a copy of ctx for calls made on behalf of role. Results are masked with the role's Policy.
+func WithRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleKey{}, role)
+}
+
+// RoleFrom returns the role set by WithRole, if any
+func RoleFrom(ctx context.Context) (role string, ok bool) {
+	role, ok = ctx.Value(roleKey{}).(string)
+	return
+}
+
+// Policy maps columns to the Mask applied to them. Keys are either a column name, such as "email", which masks the
+// column in every result, or a table and column, such as "users.email", which masks the column in the results of
+// queries that read the table. Table and column rules take precedence. Names are matched without regard to case.
+//
+// Rules match the names of the result's columns, not the columns they were read from, so a column renamed with an
+// alias, such as SELECT email AS contact, or used in an expression, such as SELECT LOWER(email), is not masked. A
+// Policy is not a substitute for database permissions when callers can write their own queries.
+type Policy map[string]Mask
+
+// ErrUnmaskable is returned by Scan when a masked column is scanned into a destination that cannot hold masked
+// text. Masked columns must be scanned into a *string, *[]byte, *sql.NullString, *sql.RawBytes or *interface{}.
+type ErrUnmaskable struct {
+	Column string
+	Type   string
+}
+
+func (e ErrUnmaskable) Error() string {
+	return fmt.Sprintf("column_mask: column \"%s\" is masked and cannot be scanned into a %s", e.Column, e.Type)
+}
+
+// Masker masks query results according to the caller's role. Configure the exported fields before calling Install.
+type Masker struct {
+	// Policies are the policies of each role
+	Policies map[string]Policy
+	// Default is used for calls whose context has no role, or a role without a Policy. If nil, their results are not
+	// masked.
+	Default Policy
+	// MaskUnknownTables applies table and column rules to the rows of queries whose tables are not known, whatever
+	// the table of the rule. Otherwise, only column rules apply to them. The tables are not known when the driver
+	// returns rows of a type that cannot be tracked, see engine_context.Trackable, or the query could not be read.
+	MaskUnknownTables bool
+
+	openRows columnrule.OpenRows
+}
+
+// New creates a Masker with no policies
+func New() *Masker {
+	return &Masker{
+		Policies: make(map[string]Policy),
+	}
+}
+
+// Install prepends the masker to the engine. The rows of queries and prepared statement queries are both masked.
+func (m *Masker) Install(e vsql_engine.SQLQueryer) {
+	m.openRows.Install(e)
+	e.RowsNextMW().Prepend(func(ctx context.Context, c engine_context.RowsNexter) {
+		c.Next(ctx)
+		if c.Row() == nil {
+			return
+		}
+		policy := m.policy(ctx)
+		if len(policy) == 0 {
+			return
+		}
+		tables, known := m.openRows.Tables(c.Rows())
+		if masks := policy.masks(c.Row().Columns(), tables, !known && m.MaskUnknownTables); masks != nil {
+			c.SetRow(&maskedRow{Rower: c.Row(), masks: masks})
+		}
+	})
+}
+
+// policy returns the Policy for the role in ctx
+func (m *Masker) policy(ctx context.Context) Policy {
+	if role, ok := RoleFrom(ctx); ok {
+		if p, ok := m.Policies[role]; ok {
+			return p
+		}
+	}
+	return m.Default
+}
+
+// masks returns the Mask of each of the columns of a result of a query that reads tables, or nil if no column is
+// masked. If anyTable, table and column rules apply whatever tables are.
+func (p Policy) masks(columns []string, tables []string, anyTable bool) (masks []Mask) {
+	for i, column := range columns {
+		mask, ok := columnrule.Lookup(p, column, tables, anyTable)
+		if !ok || mask == nil {
+			continue
+		}
+		if masks == nil {
+			masks = make([]Mask, len(columns))
+		}
+		masks[i] = mask
+	}
+	return
+}
+
+// maskedRow masks the values of a row as they are scanned
+type maskedRow struct {
+	vrows.Rower
+	// masks is the Mask of each column, nil for columns that are not masked
+	masks []Mask
+}
+
+func (r *maskedRow) Scan(destination ...interface{}) error {
+	// masked columns are scanned as text, then masked into the caller's destination
+	scanned := make([]interface{}, len(destination))
+	copy(scanned, destination)
+	texts := make([]sql.NullString, len(destination))
+	for i := range destination {
+		if i < len(r.masks) && r.masks[i] != nil {
+			scanned[i] = &texts[i]
+		}
+	}
+	if err := r.Rower.Scan(scanned...); err != nil {
+		return err
+	}
+	for i, d := range destination {
+		if i >= len(r.masks) || r.masks[i] == nil {
+			continue
+		}
+		if err := assign(d, texts[i], r.masks[i]); err != nil {
+			if e, ok := err.(*ErrUnmaskable); ok {
+				e.Column = r.column(i)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *maskedRow) column(i int) string {
+	columns := r.Columns()
+	if i < len(columns) {
+		return columns[i]
+	}
+	return ""
+}
+
+// assign stores the masked text into destination. NULL values are left as NULL.
+func assign(destination interface{}, text sql.NullString, mask Mask) error {
+	masked := text.String
+	if text.Valid {
+		masked = mask(text.String)
+	}
+	switch d := destination.(type) {
+	case *string:
+		*d = masked
+	case *[]byte:
+		if text.Valid {
+			*d = []byte(masked)
+		} else {
+			*d = nil
+		}
+	case *sql.RawBytes:
+		if text.Valid {
+			*d = sql.RawBytes(masked)
+		} else {
+			*d = nil
+		}
+	case *sql.NullString:
+		*d = sql.NullString{String: masked, Valid: text.Valid}
+	case *interface{}:
+		if text.Valid {
+			*d = masked
+		} else {
+			*d = nil
+		}
+	default:
+		return &ErrUnmaskable{Type: reflect.TypeOf(destination).String()}
+	}
+	return nil
+}
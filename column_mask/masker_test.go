@@ -0,0 +1,172 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package column_mask
+
+import (
+	"context"
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/internal/testdriver"
+	"github.com/wojnosystems/vsql_engine/query_rewrite"
+	"testing"
+)
+
+// newEngine creates an engine with a fake driver that returns one row of values for columns
+func newEngine(columns []string, values ...interface{}) vsql_engine.SingleTXer {
+	e := vsql_engine.NewSingle()
+	d := testdriver.Install(e)
+	d.Columns = columns
+	d.Rows = [][]interface{}{values}
+	return e
+}
+
+// untrackedRows are rows of a type that cannot be tracked, as some drivers return
+type untrackedRows struct {
+	vrows.Rowser
+	_ []int
+}
+
+func scanOne(t *testing.T, rows vrows.Rowser, err error, destinations ...interface{}) error {
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	defer func() { _ = rows.Close() }()
+	row := rows.Next()
+	if !assert.NotNil(t, row) {
+		return nil
+	}
+	return row.Scan(destinations...)
+}
+
+func TestMasker_Masks(t *testing.T) {
+	e := newEngine([]string{"id", "email", "card", "phone"}, "7", "bob@example.com", "4111111111111111", "5550100")
+	m := New()
+	m.Policies["support"] = Policy{
+		"email":       Full,
+		"orders.card": Partial,
+		"phone":       Hashed([]byte("secret")),
+	}
+	m.Install(e)
+	ctx := WithRole(context.Background(), "support")
+
+	var id, email, card string
+	var phone sql.NullString
+	rows, err := e.Query(ctx, vparam.New("SELECT id, email, card, phone FROM orders"))
+	assert.NoError(t, scanOne(t, rows, err, &id, &email, &card, &phone))
+	assert.Equal(t, "7", id)
+	assert.Equal(t, "****", email)
+	assert.Equal(t, "************1111", card)
+	assert.Equal(t, Hashed([]byte("secret"))("5550100"), phone.String)
+	assert.True(t, phone.Valid)
+	assert.NotContains(t, phone.String, "5550100")
+
+	rows, err = e.Query(ctx, vparam.New("SELECT id, email, card, phone FROM payments"))
+	assert.NoError(t, scanOne(t, rows, err, &id, &email, &card, &phone))
+	assert.Equal(t, "4111111111111111", card, "table rules only apply to queries of the table")
+}
+
+func TestMasker_Statements(t *testing.T) {
+	e := newEngine([]string{"email"}, "bob@example.com")
+	m := New()
+	m.Default = Policy{"users.email": Full}
+	m.Install(e)
+
+	stmt, err := e.Prepare(context.Background(), vparam.New("SELECT email FROM public.users WHERE id = ?"))
+	assert.NoError(t, err)
+	var email string
+	rows, err := stmt.Query(context.Background(), query_rewrite.Parameters([]interface{}{7}))
+	assert.NoError(t, scanOne(t, rows, err, &email))
+	assert.Equal(t, "****", email)
+}
+
+func TestMasker_Roles(t *testing.T) {
+	e := newEngine([]string{"email"}, "bob@example.com")
+	m := New()
+	m.Policies["support"] = Policy{"email": Full}
+	m.Policies["admin"] = Policy{}
+	m.Default = Policy{"email": Partial}
+	m.Install(e)
+
+	var email string
+	rows, err := e.Query(WithRole(context.Background(), "admin"), vparam.New("SELECT email FROM users"))
+	assert.NoError(t, scanOne(t, rows, err, &email))
+	assert.Equal(t, "bob@example.com", email)
+
+	rows, err = e.Query(context.Background(), vparam.New("SELECT email FROM users"))
+	assert.NoError(t, scanOne(t, rows, err, &email))
+	assert.Equal(t, "***********.com", email)
+}
+
+func TestMasker_Unmaskable(t *testing.T) {
+	e := newEngine([]string{"salary"}, "100000")
+	m := New()
+	m.Default = Policy{"salary": Full}
+	m.Install(e)
+
+	var salary int
+	rows, err := e.Query(context.Background(), vparam.New("SELECT salary FROM employees"))
+	err = scanOne(t, rows, err, &salary)
+	assert.Equal(t, &ErrUnmaskable{Column: "salary", Type: "*int"}, err)
+	assert.Equal(t, 0, salary)
+}
+
+func TestMasker_AliasesAreNotMasked(t *testing.T) {
+	e := newEngine([]string{"contact"}, "bob@example.com")
+	m := New()
+	m.Default = Policy{"email": Full}
+	m.Install(e)
+
+	var contact string
+	rows, err := e.Query(context.Background(), vparam.New("SELECT email AS contact FROM users"))
+	assert.NoError(t, scanOne(t, rows, err, &contact))
+	assert.Equal(t, "bob@example.com", contact, "rules match result column names")
+}
+
+func TestMasker_MaskUnknownTables(t *testing.T) {
+	e := vsql_engine.NewSingle()
+	e.QueryMW().Append(func(ctx context.Context, c engine_context.Queryer) {
+		c.Next(ctx)
+		c.SetRows(untrackedRows{Rowser: c.Rows()})
+	})
+	d := testdriver.Install(e)
+	d.Columns = []string{"card", "note"}
+	d.Rows = [][]interface{}{{"4111111111111111", nil}}
+	m := New()
+	m.Default = Policy{"orders.card": Partial, "note": Full}
+	m.Install(e)
+
+	var card string
+	var note sql.NullString
+	rows, err := e.Query(context.Background(), vparam.New("SELECT card, note FROM orders"))
+	assert.NoError(t, scanOne(t, rows, err, &card, &note))
+	assert.Equal(t, "4111111111111111", card, "table rules need the rows' tables")
+	assert.False(t, note.Valid, "NULL values stay NULL")
+
+	m.MaskUnknownTables = true
+	rows, err = e.Query(context.Background(), vparam.New("SELECT card, note FROM orders"))
+	assert.NoError(t, scanOne(t, rows, err, &card, &note))
+	assert.Equal(t, "************1111", card)
+}
+
+func TestPartial(t *testing.T) {
+	assert.Equal(t, "****", Partial("1234"))
+	assert.Equal(t, "*2345", Partial("12345"))
+	assert.Equal(t, "**ünï€", Partial("abünï€"))
+}
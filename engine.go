@@ -89,6 +89,24 @@ func (m *engineQuery) Group() *engineQuery {
 	return rc
 }
 
+// Seal stops middleware from being added to the engine, see Sealer
+func (m *engineQuery) Seal() {
+	m.queryMW.Seal()
+	m.insertQueryMW.Seal()
+	m.execQueryMW.Seal()
+	m.pingMW.Seal()
+	m.rowsNextMW.Seal()
+	m.rowsCloseMW.Seal()
+	m.connCloseMW.Seal()
+	m.commitMW.Seal()
+	m.rollbackMW.Seal()
+	m.statementPrepareMW.Seal()
+	m.statementCloseMW.Seal()
+	m.statementQueryMW.Seal()
+	m.statementInsertQueryMW.Seal()
+	m.statementExecQueryMW.Seal()
+}
+
 // StatementCloseMiddleware provides a way to add items to the StatementCloseWares
 func (m *engineQuery) StatementPrepareMW() engine_ware.StatementPrepareAdder {
 	return m.statementPrepareMW
@@ -168,33 +186,41 @@ func (m *engineQuery) Ping(ctx context.Context) error {
 
 // Query see github.com/wojnosystems/vsql/vquery/queryer.go#Queryer
 func (m *engineQuery) Query(ctx context.Context, query vparam.Queryer) (rRows vrows.Rowser, err error) {
-	c := engine_context.NewQuery()
+	c := engine_context.AcquireQuery()
 	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
 	c.SetQuery(query)
 	m.queryMW.PerformMiddleware(ctx, c)
 	r := &rows{
+		ctx:                ctx,
 		rows:               c.Rows(),
 		queryEngineFactory: m,
+		middlewareContext:  m.middlewareContext,
 	}
-	return r, c.Error()
+	err = c.Error()
+	engine_context.Release(c)
+	return r, err
 }
 
 // Insert see github.com/wojnosystems/vsql/vquery/queryer.go#Inserter
 func (m *engineQuery) Insert(ctx context.Context, query vparam.Queryer) (res vresult.InsertResulter, err error) {
-	c := engine_context.NewInsertQuery()
+	c := engine_context.AcquireInsertQuery()
 	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
 	c.SetQuery(query)
 	m.insertQueryMW.PerformMiddleware(ctx, c)
-	return c.InsertResult(), c.Error()
+	res, err = c.InsertResult(), c.Error()
+	engine_context.Release(c)
+	return
 }
 
 // Exec see github.com/wojnosystems/vsql/vquery/queryer.go#Execer
 func (m *engineQuery) Exec(ctx context.Context, query vparam.Queryer) (res vresult.Resulter, err error) {
-	c := engine_context.NewExecQuery()
+	c := engine_context.AcquireExecQuery()
 	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
 	c.SetQuery(query)
 	m.execQueryMW.PerformMiddleware(ctx, c)
-	return c.Result(), c.Error()
+	res, err = c.Result(), c.Error()
+	engine_context.Release(c)
+	return
 }
 
 // Prepare see github.com/wojnosystems/vsql/vstmt/statement.go#Preparer
@@ -205,7 +231,9 @@ func (m *engineQuery) Prepare(ctx context.Context, query vparam.Queryer) (stmtr
 	m.statementPrepareMW.PerformMiddleware(ctx, c)
 	s := &statement{
 		stmt:               c.Statement(),
+		query:              c.Query(),
 		queryEngineFactory: m,
+		middlewareContext:  m.middlewareContext,
 	}
 	return s, c.Error()
 }
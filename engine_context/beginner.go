@@ -16,7 +16,6 @@
 package engine_context
 
 import (
-	"context"
 	"github.com/wojnosystems/vsql"
 )
 
@@ -26,15 +25,21 @@ type Beginner interface {
 	QueryExecTransactioner() vsql.QueryExecTransactioner
 }
 
+// BeginnerHandler is a middleware of the Begin, Commit and Rollback chains
+type BeginnerHandler = Handler[Beginner]
+
 func NewBeginner() Beginner {
-	return &beginner{
+	c := &beginner{
 		commonBeginner: newCommonBeginner(),
 	}
+	c.bind(c)
+	return c
 }
 
 type beginner struct {
 	*commonBeginner
 	queryExecTransactioner vsql.QueryExecTransactioner
+	chain[Beginner]
 }
 
 func (c *beginner) SetQueryExecTransactioner(s vsql.QueryExecTransactioner) {
@@ -44,12 +49,3 @@ func (c *beginner) SetQueryExecTransactioner(s vsql.QueryExecTransactioner) {
 func (c beginner) QueryExecTransactioner() vsql.QueryExecTransactioner {
 	return c.queryExecTransactioner
 }
-
-// Next runs the middleware, if any is available, null op if not. Next is only intended to be run once each middleware layer.
-func (c *beginner) Next(ctx context.Context) {
-	if c.currentMiddleware != nil {
-		cm := c.currentMiddleware
-		c.moveToNextMiddleware()
-		cm.Value.(MiddlewareFunc)(ctx, c)
-	}
-}
@@ -0,0 +1,75 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package engine_context
+
+import "context"
+
+// Handler is a middleware of a chain whose calls are performed with contexts of type C
+type Handler[C Er] func(ctx context.Context, c C)
+
+// Perform runs handlers against c, starting with the first. The slice is not copied and must not be modified
+// afterwards.
+func Perform[C Er](ctx context.Context, c C, handlers []Handler[C]) {
+	interface{}(c).(chainer[C]).setHandlers(handlers)
+	c.Next(ctx)
+}
+
+type chainer[C Er] interface {
+	setHandlers([]Handler[C])
+}
+
+// chain is embedded by every context type to run the typed handlers it was performed with. self is the context
+// embedding it, which is what the handlers are passed, and cb is its contextBase.
+type chain[C Er] struct {
+	self     C
+	cb       *contextBase
+	handlers []Handler[C]
+}
+
+// bind is called once by the constructor of the context embedding the chain
+func (h *chain[C]) bind(self C) {
+	h.self = self
+	h.cb = self.base()
+}
+
+// Next runs the middleware, if any is available, null op if not. Next is only intended to be run once each middleware layer.
+func (h *chain[C]) Next(ctx context.Context) {
+	b := h.cb
+	if b.funcs != nil {
+		b.nextFunc(ctx, h.self)
+	} else if b.next < len(h.handlers) {
+		i := b.next
+		b.next++
+		caller := b.current
+		b.current = i
+		h.handlers[i](ctx, h.self)
+		b.current = caller
+	}
+}
+
+func (h *chain[C]) setHandlers(handlers []Handler[C]) {
+	b := h.cb
+	b.funcs = nil
+	b.next = 0
+	b.current = -1
+	h.handlers = handlers
+}
+
+// Current returns the index, in its chain, of the handler of c that is running. After a panic it is the index of the
+// handler that panicked, as the handlers it unwound through did not return. It is -1 before the chain has started.
+func Current(c Er) int {
+	return c.base().current
+}
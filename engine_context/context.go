@@ -19,6 +19,7 @@ import (
 	"container/list"
 	"context"
 	"github.com/wojnosystems/go_keyvaluer"
+	"github.com/wojnosystems/vsql"
 )
 
 type Er interface {
@@ -29,34 +30,89 @@ type Er interface {
 
 	Next(ctx context.Context)
 	Copy() Er
-	middlewares() *list.List
+	base() *contextBase
 }
 
-type MiddlewareFunc func(ctx context.Context, er Er)
+// TxDepther is implemented by every context the engine performs a chain with, see TxDepth
+type TxDepther interface {
+	// TxDepth is the depth of the transaction the call is made in: 0 outside of a transaction, 1 in a transaction and
+	// 2 or more in a nested transaction. Begin runs at the depth of the transaction it is started from.
+	TxDepth() int
+}
+
+// TxDepth returns the depth of the transaction the call c is made in, or 0 if c is not a TxDepther
+func TxDepth(c Er) int {
+	if d, ok := c.(TxDepther); ok {
+		return d.TxDepth()
+	}
+	return 0
+}
+
+// Transactioner is implemented by every context the engine performs a chain with, see TransactionOf
+type Transactioner interface {
+	// Transaction is the transaction, as created by the driver, that the call is made in, or nil outside of a
+	// transaction. Statements are in the transaction they were prepared in and rows in that of the call that returned
+	// them. Begin runs in the transaction it is started from, Commit and Rollback in the one they end.
+	Transaction() vsql.QueryExecTransactioner
+}
+
+// TransactionOf returns the transaction the call c is made in, or nil if c is not a Transactioner
+func TransactionOf(c Er) vsql.QueryExecTransactioner {
+	if t, ok := c.(Transactioner); ok {
+		return t.Transaction()
+	}
+	return nil
+}
+
+// MiddlewareFunc is a middleware of the Ping and ConnClose chains, which are performed with contexts created by New
+type MiddlewareFunc = Handler[Er]
 
 type WithMiddlewarer interface {
 	Er
+	// SetMiddlewares replaces the chain with the MiddlewareFunc values held in the list. The list is copied into a slice,
+	// so later changes to it are not seen by the context.
 	SetMiddlewares(*list.List)
 	// ShallowCopyFrom only copies the parts known to WithMiddlewarer, the rest of the configuration is up to the inheriting object
-	// This copies a reference to kvo and middlewareV from the object passed to the argument and into the receiver.
+	// This copies a reference to kvo and the MiddlewareFunc chain from the object passed to the argument and into the receiver.
 	ShallowCopyFrom(WithMiddlewarer)
 }
 
 type contextBase struct {
 	kvo go_keyvaluer.KeyValuer
 	err error
-	// middlewareV is always of type: MiddlewareFunc
-	middlewareV       *list.List
-	currentMiddleware *list.Element
+	// funcs is the chain set by SetMiddlewares or ShallowCopyFrom. When it is nil, Next runs the typed handlers the context
+	// was performed with instead.
+	funcs []MiddlewareFunc
+	// next is the index of the handler the next call to Next runs
+	next int
+	// current is the index of the handler that is running
+	current int
+	txDepth int
+	tx      vsql.QueryExecTransactioner
 }
 
 func New() WithMiddlewarer {
-	return newContextBase()
+	return newPlain(newContextBase())
+}
+
+// plain is the context of chains that have nothing to pass but the key values and error
+type plain struct {
+	*contextBase
+	chain[Er]
+}
+
+func newPlain(b *contextBase) *plain {
+	c := &plain{
+		contextBase: b,
+	}
+	c.bind(c)
+	return c
 }
 
 func newContextBase() *contextBase {
 	return &contextBase{
-		kvo: go_keyvaluer.New(),
+		kvo:     go_keyvaluer.New(),
+		current: -1,
 	}
 }
 
@@ -65,25 +121,24 @@ func (c *contextBase) KeyValues() go_keyvaluer.KeyValuer {
 }
 
 func (c *contextBase) Copy() Er {
-	rc := &contextBase{}
+	rc := newPlain(&contextBase{current: -1})
 	// kvo is thread-safe. This copy is just a reference copy to ensure that the new context can reference any values in that KVO
 	rc.kvo = c.kvo
-	rc.SetMiddlewares(cloneMiddlewareList(c.middlewareV))
+	// chains are never modified once set, so the copy may share it
+	rc.funcs = c.funcs
+	rc.txDepth = c.txDepth
+	rc.tx = c.tx
 	rc.err = nil
 	return rc
 }
 
-func cloneMiddlewareList(l *list.List) (o *list.List) {
-	o = list.New()
-	if l != nil {
-		o.PushBackList(l)
-	}
-	return
-}
-
 func (c *contextBase) ShallowCopyFrom(o WithMiddlewarer) {
 	c.kvo = o.KeyValues()
-	c.middlewareV = o.middlewares()
+	c.funcs = o.base().funcs
+	c.txDepth = o.base().txDepth
+	c.tx = o.base().tx
+	c.next = 0
+	c.current = -1
 }
 
 func (c *contextBase) SetError(err error) {
@@ -94,31 +149,58 @@ func (c contextBase) Error() error {
 	return c.err
 }
 
+func (c contextBase) TxDepth() int {
+	return c.txDepth
+}
+
+func (c contextBase) Transaction() vsql.QueryExecTransactioner {
+	return c.tx
+}
+
+// ForTransaction returns a copy of parent for the calls made in the transaction tx, which is nested depth deep. The
+// engine makes one for every transaction it begins, and the calls made in the transaction copy from it.
+func ForTransaction(parent WithMiddlewarer, depth int, tx vsql.QueryExecTransactioner) WithMiddlewarer {
+	c := parent.Copy().(WithMiddlewarer)
+	c.base().txDepth = depth
+	c.base().tx = tx
+	return c
+}
+
 func (c *contextBase) SetMiddlewares(m *list.List) {
-	if m == nil {
-		c.middlewareV = list.New()
-	} else {
-		c.middlewareV = m
+	c.funcs = make([]MiddlewareFunc, 0, listLen(m))
+	if m != nil {
+		for e := m.Front(); e != nil; e = e.Next() {
+			c.funcs = append(c.funcs, e.Value.(MiddlewareFunc))
+		}
 	}
-	c.currentMiddleware = c.middlewareV.Front()
+	c.next = 0
+	c.current = -1
 }
 
-func (c *contextBase) moveToNextMiddleware() {
-	// Just starting a middleware chain, start with the first node
-	if c.currentMiddleware != nil {
-		c.currentMiddleware = c.currentMiddleware.Next()
+func listLen(m *list.List) int {
+	if m == nil {
+		return 0
 	}
+	return m.Len()
 }
 
-// Next runs the middleware, if any is available, null op if not. Next is only intended to be run once each middleware layer.
-func (c *contextBase) Next(ctx context.Context) {
-	if c.currentMiddleware != nil {
-		cm := c.currentMiddleware
-		c.moveToNextMiddleware()
-		cm.Value.(MiddlewareFunc)(ctx, c)
+// nextFunc runs the next MiddlewareFunc of the chain, passing it self, which is the context embedding this one.
+func (c *contextBase) nextFunc(ctx context.Context, self Er) {
+	if c.next < len(c.funcs) {
+		i := c.next
+		c.next++
+		caller := c.current
+		c.current = i
+		c.funcs[i](ctx, self)
+		c.current = caller
 	}
 }
 
-func (c contextBase) middlewares() *list.List {
-	return c.middlewareV
+// reset returns the context to its zero state so that it can be pooled
+func (c *contextBase) reset() {
+	*c = contextBase{current: -1}
+}
+
+func (c *contextBase) base() *contextBase {
+	return c
 }
@@ -16,13 +16,104 @@
 package engine_context
 
 import (
+	"container/list"
+	"context"
+	"github.com/wojnosystems/vsql"
+	"github.com/wojnosystems/vsql/vparam"
 	"testing"
 )
 
 func TestContextBase_SetNilMiddlewares(t *testing.T) {
-	b := newContextBase()
+	b := New()
 	b.SetMiddlewares(nil)
-	if b.middlewareV == nil {
+	if b.base().funcs == nil {
 		t.Error("expected middleware to not be nil")
 	}
+	b.Next(context.Background())
+}
+
+func TestContextBase_SetMiddlewaresRunsInOrder(t *testing.T) {
+	var order []int
+	l := list.New()
+	for i := 0; i < 3; i++ {
+		i := i
+		l.PushBack(MiddlewareFunc(func(ctx context.Context, er Er) {
+			order = append(order, i)
+			er.Next(ctx)
+		}))
+	}
+	c := NewQuery()
+	c.(WithMiddlewarer).SetMiddlewares(l)
+	c.Next(context.Background())
+	if len(order) != 3 || order[0] != 0 || order[1] != 1 || order[2] != 2 {
+		t.Errorf("expected handlers to run in order, got %v", order)
+	}
+}
+
+func TestPerform(t *testing.T) {
+	var calls int
+	h := func(ctx context.Context, c Queryer) {
+		calls++
+		c.Next(ctx)
+	}
+	c := NewQuery()
+	Perform(context.Background(), c, []QueryHandler{h, h})
+	Perform(context.Background(), c, []QueryHandler{h})
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	c := AcquireQuery()
+	c.SetQuery(vparam.New("SELECT 1"))
+	Perform(context.Background(), c, []QueryHandler{func(ctx context.Context, c Queryer) {}})
+	Release(c)
+	q := c.(*query)
+	if q.Query() != nil || q.chain.handlers != nil || q.next != 0 || q.kvo != nil {
+		t.Error("expected released context to be reset")
+	}
+}
+
+func TestCurrent(t *testing.T) {
+	c := NewQuery()
+	if Current(c) != -1 {
+		t.Error("expected no handler to be running")
+	}
+	var seen []int
+	h := func(ctx context.Context, c Queryer) {
+		seen = append(seen, Current(c))
+		c.Next(ctx)
+	}
+	func() {
+		defer func() {
+			_ = recover()
+		}()
+		Perform(context.Background(), c, []QueryHandler{h, h, func(ctx context.Context, c Queryer) {
+			panic("boom")
+		}})
+	}()
+	if len(seen) != 2 || seen[0] != 0 || seen[1] != 1 {
+		t.Errorf("expected handlers 0 and 1 to run, got %v", seen)
+	}
+	if Current(c) != 2 {
+		t.Errorf("expected the handler that panicked to be current, got %d", Current(c))
+	}
+}
+
+func TestForTransaction(t *testing.T) {
+	qet := &vsql.QueryExecTransactionerMock{}
+	tx := ForTransaction(New(), 2, qet)
+	c := AcquireExecQuery()
+	c.(WithMiddlewarer).ShallowCopyFrom(tx)
+	if TxDepth(c) != 2 || TxDepth(tx.Copy()) != 2 {
+		t.Error("expected copies to keep the transaction depth")
+	}
+	if TransactionOf(c) != qet || TransactionOf(tx.Copy()) != qet {
+		t.Error("expected copies to keep the transaction")
+	}
+	Release(c)
+	if TxDepth(c) != 0 || TransactionOf(c) != nil {
+		t.Error("expected released context to be reset")
+	}
 }
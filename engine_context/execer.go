@@ -16,7 +16,6 @@
 package engine_context
 
 import (
-	"context"
 	"github.com/wojnosystems/vsql/vresult"
 )
 
@@ -26,15 +25,21 @@ type Execer interface {
 	Result() vresult.Resulter
 }
 
+// ExecHandler is a middleware of the Exec chain
+type ExecHandler = Handler[Execer]
+
 func NewExecQuery() Execer {
-	return &execQuery{
+	c := &execQuery{
 		commonQuery: newCommonQuery(),
 	}
+	c.bind(c)
+	return c
 }
 
 type execQuery struct {
 	*commonQuery
 	result vresult.Resulter
+	chain[Execer]
 }
 
 func (c *execQuery) SetResult(s vresult.Resulter) {
@@ -44,12 +49,3 @@ func (c *execQuery) SetResult(s vresult.Resulter) {
 func (c execQuery) Result() vresult.Resulter {
 	return c.result
 }
-
-// Next runs the middleware, if any is available, null op if not. Next is only intended to be run once each middleware layer.
-func (c *execQuery) Next(ctx context.Context) {
-	if c.currentMiddleware != nil {
-		cm := c.currentMiddleware
-		c.moveToNextMiddleware()
-		cm.Value.(MiddlewareFunc)(ctx, c)
-	}
-}
@@ -16,7 +16,6 @@
 package engine_context
 
 import (
-	"context"
 	"github.com/wojnosystems/vsql/vresult"
 )
 
@@ -26,15 +25,21 @@ type Inserter interface {
 	InsertResult() vresult.InsertResulter
 }
 
+// InsertQueryHandler is a middleware of the Insert chain
+type InsertQueryHandler = Handler[Inserter]
+
 func NewInsertQuery() Inserter {
-	return &insertQuery{
+	c := &insertQuery{
 		commonQuery: newCommonQuery(),
 	}
+	c.bind(c)
+	return c
 }
 
 type insertQuery struct {
 	*commonQuery
 	result vresult.InsertResulter
+	chain[Inserter]
 }
 
 func (c *insertQuery) SetInsertResult(s vresult.InsertResulter) {
@@ -44,12 +49,3 @@ func (c *insertQuery) SetInsertResult(s vresult.InsertResulter) {
 func (c insertQuery) InsertResult() vresult.InsertResulter {
 	return c.result
 }
-
-// Next runs the middleware, if any is available, null op if not. Next is only intended to be run once each middleware layer.
-func (c *insertQuery) Next(ctx context.Context) {
-	if c.currentMiddleware != nil {
-		cm := c.currentMiddleware
-		c.moveToNextMiddleware()
-		cm.Value.(MiddlewareFunc)(ctx, c)
-	}
-}
@@ -16,25 +16,32 @@
 package engine_context
 
 import (
-	"context"
 	"github.com/wojnosystems/vsql"
 )
 
 type NestedBeginner interface {
 	beginCommoner
+	// SetQueryExecNestedTransactioner sets the transaction created by the driver. When a nested transaction is begun,
+	// QueryExecNestedTransactioner is the parent's transaction until the driver replaces it.
 	SetQueryExecNestedTransactioner(vsql.QueryExecNestedTransactioner)
 	QueryExecNestedTransactioner() vsql.QueryExecNestedTransactioner
 }
 
+// NestedBeginnerHandler is a middleware of the BeginNested chain
+type NestedBeginnerHandler = Handler[NestedBeginner]
+
 func NewNestedBeginner() NestedBeginner {
-	return &nestedBeginner{
+	c := &nestedBeginner{
 		commonBeginner: newCommonBeginner(),
 	}
+	c.bind(c)
+	return c
 }
 
 type nestedBeginner struct {
 	*commonBeginner
 	queryExecNestedTransactioner vsql.QueryExecNestedTransactioner
+	chain[NestedBeginner]
 }
 
 func (c *nestedBeginner) SetQueryExecNestedTransactioner(s vsql.QueryExecNestedTransactioner) {
@@ -44,12 +51,3 @@ func (c *nestedBeginner) SetQueryExecNestedTransactioner(s vsql.QueryExecNestedT
 func (c nestedBeginner) QueryExecNestedTransactioner() vsql.QueryExecNestedTransactioner {
 	return c.queryExecNestedTransactioner
 }
-
-// Next runs the middleware, if any is available, null op if not. Next is only intended to be run once each middleware layer.
-func (c *nestedBeginner) Next(ctx context.Context) {
-	if c.currentMiddleware != nil {
-		cm := c.currentMiddleware
-		c.moveToNextMiddleware()
-		cm.Value.(MiddlewareFunc)(ctx, c)
-	}
-}
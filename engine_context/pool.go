@@ -0,0 +1,188 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package engine_context
+
+import "sync"
+
+// The contexts of calls that do not outlive the call are pooled. The engine takes one with its Acquire function,
+// performs the chain, reads the results and hands it back with Release. Middleware must not keep a context, or call
+// its methods, after its handler has returned.
+//
+// The contexts of Begin, BeginNested and Prepare are not pooled: the transactions and statements they create keep
+// them.
+
+var (
+	queryPool                = sync.Pool{New: func() interface{} { return NewQuery() }}
+	execQueryPool            = sync.Pool{New: func() interface{} { return NewExecQuery() }}
+	insertQueryPool          = sync.Pool{New: func() interface{} { return NewInsertQuery() }}
+	rowsPool                 = sync.Pool{New: func() interface{} { return NewRows() }}
+	rowNextPool              = sync.Pool{New: func() interface{} { return NewRowNext() }}
+	statementClosePool       = sync.Pool{New: func() interface{} { return NewStatementClose() }}
+	statementExecQueryPool   = sync.Pool{New: func() interface{} { return NewStatementExecQuery() }}
+	statementInsertQueryPool = sync.Pool{New: func() interface{} { return NewStatementInsertQuery() }}
+	statementQueryPool       = sync.Pool{New: func() interface{} { return NewStatementQuery() }}
+)
+
+// AcquireQuery returns a Queryer from the pool. Give it back with Release
+func AcquireQuery() Queryer {
+	return queryPool.Get().(Queryer)
+}
+
+// AcquireExecQuery returns an Execer from the pool. Give it back with Release
+func AcquireExecQuery() Execer {
+	return execQueryPool.Get().(Execer)
+}
+
+// AcquireInsertQuery returns an Inserter from the pool. Give it back with Release
+func AcquireInsertQuery() Inserter {
+	return insertQueryPool.Get().(Inserter)
+}
+
+// AcquireRows returns a Rowser from the pool. Give it back with Release
+func AcquireRows() Rowser {
+	return rowsPool.Get().(Rowser)
+}
+
+// AcquireRowNext returns a RowsNexter from the pool. Give it back with Release
+func AcquireRowNext() RowsNexter {
+	return rowNextPool.Get().(RowsNexter)
+}
+
+// AcquireStatementClose returns a StatementCloser from the pool. Give it back with Release
+func AcquireStatementClose() StatementCloser {
+	return statementClosePool.Get().(StatementCloser)
+}
+
+// AcquireStatementExecQuery returns a StatementExecQueryer from the pool. Give it back with Release
+func AcquireStatementExecQuery() StatementExecQueryer {
+	return statementExecQueryPool.Get().(StatementExecQueryer)
+}
+
+// AcquireStatementInsertQuery returns a StatementInsertQueryer from the pool. Give it back with Release
+func AcquireStatementInsertQuery() StatementInsertQueryer {
+	return statementInsertQueryPool.Get().(StatementInsertQueryer)
+}
+
+// AcquireStatementQuery returns a StatementQueryer from the pool. Give it back with Release
+func AcquireStatementQuery() StatementQueryer {
+	return statementQueryPool.Get().(StatementQueryer)
+}
+
+// Release resets c and gives it back to its pool. Contexts of types that are not pooled are left alone. c must not be
+// used afterwards.
+func Release(c Er) {
+	switch v := c.(type) {
+	case *query:
+		v.reset()
+		queryPool.Put(v)
+	case *execQuery:
+		v.reset()
+		execQueryPool.Put(v)
+	case *insertQuery:
+		v.reset()
+		insertQueryPool.Put(v)
+	case *rowNextContext:
+		v.reset()
+		rowNextPool.Put(v)
+	case *rowsContext:
+		v.reset()
+		rowsPool.Put(v)
+	case *statementClose:
+		v.reset()
+		statementClosePool.Put(v)
+	case *StatementExecQuery:
+		v.reset()
+		statementExecQueryPool.Put(v)
+	case *StatementInsertQuery:
+		v.reset()
+		statementInsertQueryPool.Put(v)
+	case *statementQuery:
+		v.reset()
+		statementQueryPool.Put(v)
+	}
+}
+
+func (c *commonQuery) reset() {
+	c.contextBase.reset()
+	c.query = nil
+	c.queryExecTransactioner = nil
+	c.fingerprint = nil
+}
+
+func (c *query) reset() {
+	c.commonQuery.reset()
+	c.rows = nil
+	c.chain.handlers = nil
+}
+
+func (c *execQuery) reset() {
+	c.commonQuery.reset()
+	c.result = nil
+	c.chain.handlers = nil
+}
+
+func (c *insertQuery) reset() {
+	c.commonQuery.reset()
+	c.result = nil
+	c.chain.handlers = nil
+}
+
+func (c *rowsContext) reset() {
+	c.contextBase.reset()
+	c.rows = nil
+	c.chain.handlers = nil
+}
+
+func (c *rowNextContext) reset() {
+	c.rowsContext.reset()
+	c.row = nil
+	c.chain.handlers = nil
+}
+
+func (c *statementCommon) reset() {
+	c.contextBase.reset()
+	c.statement = nil
+	c.parameterer = nil
+}
+
+func (c *statementQueryCommon) reset() {
+	c.statementCommon.reset()
+	c.queryer = nil
+	c.fingerprint = nil
+}
+
+func (c *statementClose) reset() {
+	c.statementCommon.reset()
+	c.chain.handlers = nil
+}
+
+func (c *StatementExecQuery) reset() {
+	c.statementQueryCommon.reset()
+	c.result = nil
+	c.chain.handlers = nil
+}
+
+func (c *StatementInsertQuery) reset() {
+	c.statementQueryCommon.reset()
+	c.result = nil
+	c.chain.handlers = nil
+}
+
+func (c *statementQuery) reset() {
+	c.statementQueryCommon.reset()
+	c.rows = nil
+	c.chain.handlers = nil
+}
@@ -16,7 +16,6 @@
 package engine_context
 
 import (
-	"context"
 	"github.com/wojnosystems/vsql/vstmt"
 )
 
@@ -27,15 +26,21 @@ type Preparer interface {
 	Statement() vstmt.Statementer
 }
 
+// PrepareHandler is a middleware of the StatementPrepare chain
+type PrepareHandler = Handler[Preparer]
+
 func NewPreparer() Preparer {
-	return &prepare{
+	c := &prepare{
 		commonQuery: newCommonQuery(),
 	}
+	c.bind(c)
+	return c
 }
 
 type prepare struct {
 	*commonQuery
 	statement vstmt.Statementer
+	chain[Preparer]
 }
 
 func (c *prepare) SetStatement(s vstmt.Statementer) {
@@ -45,12 +50,3 @@ func (c *prepare) SetStatement(s vstmt.Statementer) {
 func (c prepare) Statement() vstmt.Statementer {
 	return c.statement
 }
-
-// Next runs the middleware, if any is available, null op if not. Next is only intended to be run once each middleware layer.
-func (c *prepare) Next(ctx context.Context) {
-	if c.currentMiddleware != nil {
-		cm := c.currentMiddleware
-		c.moveToNextMiddleware()
-		cm.Value.(MiddlewareFunc)(ctx, c)
-	}
-}
@@ -18,8 +18,15 @@ package engine_context
 import (
 	"github.com/wojnosystems/vsql"
 	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine/fingerprint"
 )
 
+// CommonQueryer is implemented by the contexts of every call that runs SQL: Query, Insert, Exec and Prepare. Use it
+// for middleware that inspects or rewrites the SQL of all of them.
+type CommonQueryer interface {
+	commonQueryer
+}
+
 type commonQueryer interface {
 	Er
 
@@ -27,11 +34,14 @@ type commonQueryer interface {
 	QueryExecTransactioner() vsql.QueryExecTransactioner
 	SetQuery(vparam.Queryer)
 	Query() vparam.Queryer
+	// Fingerprint is the shape of Query. It is computed on first use and cached until the query is replaced with SetQuery
+	Fingerprint() fingerprint.Fingerprinter
 }
 type commonQuery struct {
 	*contextBase
 	query                  vparam.Queryer
 	queryExecTransactioner vsql.QueryExecTransactioner
+	fingerprint            fingerprint.Fingerprinter
 }
 
 func newCommonQuery() *commonQuery {
@@ -42,12 +52,20 @@ func newCommonQuery() *commonQuery {
 
 func (c *commonQuery) SetQuery(s vparam.Queryer) {
 	c.query = s
+	c.fingerprint = nil
 }
 
 func (c commonQuery) Query() vparam.Queryer {
 	return c.query
 }
 
+func (c *commonQuery) Fingerprint() fingerprint.Fingerprinter {
+	if c.fingerprint == nil && c.query != nil {
+		c.fingerprint = fingerprint.New(c.query.SQLQueryUnInterpolated())
+	}
+	return c.fingerprint
+}
+
 func (c *commonQuery) SetQueryExecTransactioner(s vsql.QueryExecTransactioner) {
 	c.queryExecTransactioner = s
 }
@@ -17,6 +17,7 @@ package engine_context
 
 import (
 	"github.com/wojnosystems/vsql"
+	"github.com/wojnosystems/vsql/vparam"
 	"testing"
 )
 
@@ -28,3 +29,22 @@ func TestCommonQuery_SetQueryExecTransactioner(t *testing.T) {
 		t.Error("expected QET to be the same")
 	}
 }
+
+func TestCommonQuery_Fingerprint(t *testing.T) {
+	c := newCommonQuery()
+	if c.Fingerprint() != nil {
+		t.Error("expected no fingerprint without a query")
+	}
+	c.SetQuery(vparam.New("SELECT * FROM puppies WHERE id = 5"))
+	f := c.Fingerprint()
+	if f.Normalized() != "select * from puppies where id = ?" {
+		t.Error("expected the query to be normalized")
+	}
+	if f != c.Fingerprint() {
+		t.Error("expected the fingerprint to be cached")
+	}
+	c.SetQuery(vparam.New("SELECT * FROM kittens"))
+	if c.Fingerprint().Tables()[0] != "kittens" {
+		t.Error("expected the fingerprint to be reset when the query changes")
+	}
+}
@@ -16,7 +16,6 @@
 package engine_context
 
 import (
-	"context"
 	"github.com/wojnosystems/vsql/vrows"
 )
 
@@ -26,15 +25,21 @@ type Queryer interface {
 	Rows() vrows.Rowser
 }
 
+// QueryHandler is a middleware of the Query chain
+type QueryHandler = Handler[Queryer]
+
 func NewQuery() Queryer {
-	return &query{
+	c := &query{
 		commonQuery: newCommonQuery(),
 	}
+	c.bind(c)
+	return c
 }
 
 type query struct {
 	*commonQuery
 	rows vrows.Rowser
+	chain[Queryer]
 }
 
 func (c *query) SetRows(r vrows.Rowser) {
@@ -44,12 +49,3 @@ func (c *query) SetRows(r vrows.Rowser) {
 func (c query) Rows() vrows.Rowser {
 	return c.rows
 }
-
-// Next runs the middleware, if any is available, null op if not. Next is only intended to be run once each middleware layer.
-func (c *query) Next(ctx context.Context) {
-	if c.currentMiddleware != nil {
-		cm := c.currentMiddleware
-		c.moveToNextMiddleware()
-		cm.Value.(MiddlewareFunc)(ctx, c)
-	}
-}
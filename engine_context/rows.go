@@ -16,7 +16,6 @@
 package engine_context
 
 import (
-	"context"
 	"github.com/wojnosystems/vsql/vrows"
 )
 
@@ -27,15 +26,21 @@ type Rowser interface {
 	Rows() vrows.Rowser
 }
 
+// RowsHandler is a middleware of the RowsClose chain
+type RowsHandler = Handler[Rowser]
+
 func NewRows() Rowser {
-	return &rowsContext{
+	c := &rowsContext{
 		contextBase: newContextBase(),
 	}
+	c.bind(c)
+	return c
 }
 
 type rowsContext struct {
 	*contextBase
 	rows vrows.Rowser
+	chain[Rowser]
 }
 
 func (c *rowsContext) SetRows(r vrows.Rowser) {
@@ -45,12 +50,3 @@ func (c *rowsContext) SetRows(r vrows.Rowser) {
 func (c rowsContext) Rows() vrows.Rowser {
 	return c.rows
 }
-
-// Next runs the middleware, if any is available, null op if not. Next is only intended to be run once each middleware layer.
-func (c *rowsContext) Next(ctx context.Context) {
-	if c.currentMiddleware != nil {
-		cm := c.currentMiddleware
-		c.moveToNextMiddleware()
-		cm.Value.(MiddlewareFunc)(ctx, c)
-	}
-}
@@ -16,7 +16,6 @@
 package engine_context
 
 import (
-	"context"
 	"github.com/wojnosystems/vsql/vrows"
 )
 
@@ -27,15 +26,21 @@ type RowsNexter interface {
 	Row() vrows.Rower
 }
 
+// RowsNextHandler is a middleware of the RowsNext chain
+type RowsNextHandler = Handler[RowsNexter]
+
 func NewRowNext() RowsNexter {
-	return &rowNextContext{
+	c := &rowNextContext{
 		rowsContext: NewRows().(*rowsContext),
 	}
+	c.bind(c)
+	return c
 }
 
 type rowNextContext struct {
 	*rowsContext
 	row vrows.Rower
+	chain[RowsNexter]
 }
 
 func (c *rowNextContext) SetRow(r vrows.Rower) {
@@ -45,12 +50,3 @@ func (c *rowNextContext) SetRow(r vrows.Rower) {
 func (c rowNextContext) Row() vrows.Rower {
 	return c.row
 }
-
-// Next runs the middleware, if any is available, null op if not. Next is only intended to be run once each middleware layer.
-func (c *rowNextContext) Next(ctx context.Context) {
-	if c.currentMiddleware != nil {
-		cm := c.currentMiddleware
-		c.moveToNextMiddleware()
-		cm.Value.(MiddlewareFunc)(ctx, c)
-	}
-}
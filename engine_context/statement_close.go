@@ -15,27 +15,22 @@
 
 package engine_context
 
-import "context"
-
 type StatementCloser interface {
 	statementCommoner
 }
 
+// StatementCloseHandler is a middleware of the StatementClose chain
+type StatementCloseHandler = Handler[StatementCloser]
+
 func NewStatementClose() StatementCloser {
-	return &statementClose{
+	c := &statementClose{
 		statementCommon: newStatementCommon(),
 	}
+	c.bind(c)
+	return c
 }
 
 type statementClose struct {
 	*statementCommon
-}
-
-// Next runs the middleware, if any is available, null op if not. Next is only intended to be run once each middleware layer.
-func (c *statementClose) Next(ctx context.Context) {
-	if c.currentMiddleware != nil {
-		cm := c.currentMiddleware
-		c.moveToNextMiddleware()
-		cm.Value.(MiddlewareFunc)(ctx, c)
-	}
+	chain[StatementCloser]
 }
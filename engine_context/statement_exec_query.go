@@ -16,7 +16,6 @@
 package engine_context
 
 import (
-	"context"
 	"github.com/wojnosystems/vsql/vresult"
 )
 
@@ -28,15 +27,21 @@ type StatementExecQueryer interface {
 	Result() vresult.Resulter
 }
 
+// StatementExecQueryHandler is a middleware of the StatementExec chain
+type StatementExecQueryHandler = Handler[StatementExecQueryer]
+
 func NewStatementExecQuery() StatementExecQueryer {
-	return &StatementExecQuery{
+	c := &StatementExecQuery{
 		statementQueryCommon: newStatementQueryCommon(),
 	}
+	c.bind(c)
+	return c
 }
 
 type StatementExecQuery struct {
 	*statementQueryCommon
 	result vresult.Resulter
+	chain[StatementExecQueryer]
 }
 
 func (c *StatementExecQuery) SetResult(resulter vresult.Resulter) {
@@ -46,12 +51,3 @@ func (c *StatementExecQuery) SetResult(resulter vresult.Resulter) {
 func (c StatementExecQuery) Result() vresult.Resulter {
 	return c.result
 }
-
-// Next runs the middleware, if any is available, null op if not. Next is only intended to be run once each middleware layer.
-func (c *StatementExecQuery) Next(ctx context.Context) {
-	if c.currentMiddleware != nil {
-		cm := c.currentMiddleware
-		c.moveToNextMiddleware()
-		cm.Value.(MiddlewareFunc)(ctx, c)
-	}
-}
@@ -16,7 +16,6 @@
 package engine_context
 
 import (
-	"context"
 	"github.com/wojnosystems/vsql/vresult"
 )
 
@@ -28,15 +27,21 @@ type StatementInsertQueryer interface {
 	InsertResult() vresult.InsertResulter
 }
 
+// StatementInsertQueryHandler is a middleware of the StatementInsert chain
+type StatementInsertQueryHandler = Handler[StatementInsertQueryer]
+
 func NewStatementInsertQuery() StatementInsertQueryer {
-	return &StatementInsertQuery{
+	c := &StatementInsertQuery{
 		statementQueryCommon: newStatementQueryCommon(),
 	}
+	c.bind(c)
+	return c
 }
 
 type StatementInsertQuery struct {
 	*statementQueryCommon
 	result vresult.InsertResulter
+	chain[StatementInsertQueryer]
 }
 
 func (c *StatementInsertQuery) SetInsertResult(resulter vresult.InsertResulter) {
@@ -46,12 +51,3 @@ func (c *StatementInsertQuery) SetInsertResult(resulter vresult.InsertResulter)
 func (c StatementInsertQuery) InsertResult() vresult.InsertResulter {
 	return c.result
 }
-
-// Next runs the middleware, if any is available, null op if not. Next is only intended to be run once each middleware layer.
-func (c *StatementInsertQuery) Next(ctx context.Context) {
-	if c.currentMiddleware != nil {
-		cm := c.currentMiddleware
-		c.moveToNextMiddleware()
-		cm.Value.(MiddlewareFunc)(ctx, c)
-	}
-}
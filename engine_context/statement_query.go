@@ -16,7 +16,6 @@
 package engine_context
 
 import (
-	"context"
 	"github.com/wojnosystems/vsql/vrows"
 )
 
@@ -28,15 +27,21 @@ type StatementQueryer interface {
 	Rows() vrows.Rowser
 }
 
+// StatementQueryHandler is a middleware of the StatementQuery chain
+type StatementQueryHandler = Handler[StatementQueryer]
+
 func NewStatementQuery() StatementQueryer {
-	return &statementQuery{
+	c := &statementQuery{
 		statementQueryCommon: newStatementQueryCommon(),
 	}
+	c.bind(c)
+	return c
 }
 
 type statementQuery struct {
 	*statementQueryCommon
 	rows vrows.Rowser
+	chain[StatementQueryer]
 }
 
 func (c *statementQuery) SetRows(r vrows.Rowser) {
@@ -46,12 +51,3 @@ func (c *statementQuery) SetRows(r vrows.Rowser) {
 func (c statementQuery) Rows() vrows.Rowser {
 	return c.rows
 }
-
-// Next runs the middleware, if any is available, null op if not. Next is only intended to be run once each middleware layer.
-func (c *statementQuery) Next(ctx context.Context) {
-	if c.currentMiddleware != nil {
-		cm := c.currentMiddleware
-		c.moveToNextMiddleware()
-		cm.Value.(MiddlewareFunc)(ctx, c)
-	}
-}
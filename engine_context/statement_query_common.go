@@ -17,11 +17,15 @@ package engine_context
 
 import (
 	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine/fingerprint"
 )
 
 type statementQueryCommoner interface {
+	// SetQuery sets the query the statement was prepared with
 	SetQuery(queryer vparam.Queryer)
 	Query() vparam.Queryer
+	// Fingerprint is the shape of Query. It is computed on first use and cached until the query is replaced with SetQuery
+	Fingerprint() fingerprint.Fingerprinter
 }
 
 func newStatementQueryCommon() *statementQueryCommon {
@@ -32,13 +36,22 @@ func newStatementQueryCommon() *statementQueryCommon {
 
 type statementQueryCommon struct {
 	*statementCommon
-	queryer vparam.Queryer
+	queryer     vparam.Queryer
+	fingerprint fingerprint.Fingerprinter
 }
 
 func (c *statementQueryCommon) SetQuery(queryer vparam.Queryer) {
 	c.queryer = queryer
+	c.fingerprint = nil
 }
 
 func (c statementQueryCommon) Query() vparam.Queryer {
 	return c.queryer
 }
+
+func (c *statementQueryCommon) Fingerprint() fingerprint.Fingerprinter {
+	if c.fingerprint == nil && c.queryer != nil {
+		c.fingerprint = fingerprint.New(c.queryer.SQLQueryUnInterpolated())
+	}
+	return c.fingerprint
+}
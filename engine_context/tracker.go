@@ -0,0 +1,75 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package engine_context
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Tracker associates values with the driver objects that a middleware sees across calls, such as the transactions,
+// statements and rows the driver creates. Those objects are only known by their identity, so they are the keys.
+// Objects that cannot be map keys, such as those of slice types, are never tracked; see Trackable.
+// The zero value is ready to use and it is safe for concurrent use.
+type Tracker[V any] struct {
+	m sync.Map
+}
+
+// Trackable reports whether key can be tracked: it must not be nil and its dynamic type must be comparable.
+func Trackable(key interface{}) bool {
+	return key != nil && reflect.TypeOf(key).Comparable()
+}
+
+// Track associates v with key, replacing the value it had. It reports whether key was Trackable.
+func (t *Tracker[V]) Track(key interface{}, v V) bool {
+	if !Trackable(key) {
+		return false
+	}
+	t.m.Store(key, v)
+	return true
+}
+
+// Lookup returns the value associated with key, if any
+func (t *Tracker[V]) Lookup(key interface{}) (v V, ok bool) {
+	if !Trackable(key) {
+		return
+	}
+	loaded, ok := t.m.Load(key)
+	if ok {
+		v = loaded.(V)
+	}
+	return
+}
+
+// Forget stops tracking key and returns the value it was associated with, if any
+func (t *Tracker[V]) Forget(key interface{}) (v V, ok bool) {
+	if !Trackable(key) {
+		return
+	}
+	loaded, ok := t.m.LoadAndDelete(key)
+	if ok {
+		v = loaded.(V)
+	}
+	return
+}
+
+// Reset forgets every key
+func (t *Tracker[V]) Reset() {
+	t.m.Range(func(key, _ interface{}) bool {
+		t.m.Delete(key)
+		return true
+	})
+}
@@ -0,0 +1,59 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package engine_context
+
+import (
+	"testing"
+)
+
+func TestTracker(t *testing.T) {
+	var tr Tracker[int]
+	key := &struct{ name string }{"tx"}
+	if !tr.Track(key, 1) {
+		t.Error("expected a pointer to be trackable")
+	}
+	if v, ok := tr.Lookup(key); !ok || v != 1 {
+		t.Error("expected the tracked value to be found")
+	}
+	if v, ok := tr.Forget(key); !ok || v != 1 {
+		t.Error("expected the forgotten value to be returned")
+	}
+	if _, ok := tr.Lookup(key); ok {
+		t.Error("expected a forgotten key to not be found")
+	}
+
+	tr.Track(key, 2)
+	tr.Reset()
+	if _, ok := tr.Lookup(key); ok {
+		t.Error("expected Reset to forget every key")
+	}
+}
+
+// sliceRows is a driver object of a type that cannot be a map key
+type sliceRows []string
+
+func TestTracker_Untrackable(t *testing.T) {
+	var tr Tracker[int]
+	if tr.Track(sliceRows{"a"}, 1) || tr.Track(nil, 1) {
+		t.Error("expected keys that are nil or not comparable to not be tracked")
+	}
+	if _, ok := tr.Lookup(sliceRows{"a"}); ok {
+		t.Error("expected untrackable keys to not be found")
+	}
+	if _, ok := tr.Forget(sliceRows{"a"}); ok {
+		t.Error("expected untrackable keys to not be found")
+	}
+}
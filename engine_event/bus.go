@@ -0,0 +1,325 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package engine_event
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/engine_ware"
+	"github.com/wojnosystems/vsql_engine/fingerprint"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// engine_event publishes the lifecycle of every call made through an engine to asynchronous observers, such as
+// dashboards and background auditors. Publishing never blocks and never changes the call: a subscriber that falls
+// behind loses events rather than slowing the engine down.
+
+// Bus publishes events to its subscriptions. Create one with New, install it into an engine with Install and receive
+// events with Subscribe. It is safe to use from multiple goroutines and may be shared by several engines or groups.
+// While it has no subscriptions, its middleware only calls Next and forgets the transactions, statements and rows that
+// end.
+type Bus struct {
+	// mu serializes Subscribe and unsubscribe. Publishing never takes it.
+	mu sync.Mutex
+	// subscriptions holds a []*Subscription that is never modified once stored
+	subscriptions atomic.Value
+
+	seq       uint64
+	ids       uint64
+	published uint64
+	dropped   uint64
+
+	// transactions, statements and rows map the objects created by the driver to the ref the Bus gave them.
+	// Entries are removed when the transaction ends, or the statement or rows are closed.
+	transactions engine_context.Tracker[ref]
+	statements   engine_context.Tracker[ref]
+	rows         engine_context.Tracker[ref]
+
+	// now is replaceable for tests
+	now func() time.Time
+}
+
+// ref is the ID of a transaction, statement or rows and the ID of the transaction it is in
+type ref struct {
+	id uint64
+	tx uint64
+}
+
+// New creates a Bus without subscriptions
+func New() *Bus {
+	b := &Bus{now: time.Now}
+	b.subscriptions.Store([]*Subscription(nil))
+	return b
+}
+
+// Subscribe starts a subscription that buffers up to buffer events, DefaultBuffer if buffer is not positive. When the
+// buffer is full, events are dropped according to policy. Close the subscription when done with it.
+func (b *Bus) Subscribe(buffer int, policy DropPolicy) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	s := &Subscription{
+		bus:    b,
+		policy: policy,
+		events: make(chan Event, buffer),
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	old := b.load()
+	subs := make([]*Subscription, len(old), len(old)+1)
+	copy(subs, old)
+	b.subscriptions.Store(append(subs, s))
+	return s
+}
+
+func (b *Bus) unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	old := b.load()
+	subs := make([]*Subscription, 0, len(old))
+	for _, o := range old {
+		if o != s {
+			subs = append(subs, o)
+		}
+	}
+	b.subscriptions.Store(subs)
+}
+
+func (b *Bus) load() []*Subscription {
+	subs, _ := b.subscriptions.Load().([]*Subscription)
+	return subs
+}
+
+// Published is the number of events published, whether or not they were delivered
+func (b *Bus) Published() uint64 {
+	return atomic.LoadUint64(&b.published)
+}
+
+// Dropped is the number of times an event was not delivered to a subscription, summed over all subscriptions
+func (b *Bus) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
+
+// active is true if the Bus has subscriptions
+func (b *Bus) active() bool {
+	return len(b.load()) != 0
+}
+
+// publish numbers e and delivers it to every subscription
+func (b *Bus) publish(e Event) {
+	subs := b.load()
+	if len(subs) == 0 {
+		return
+	}
+	e.Seq = atomic.AddUint64(&b.seq, 1)
+	atomic.AddUint64(&b.published, 1)
+	for _, s := range subs {
+		if !s.deliver(e) {
+			atomic.AddUint64(&b.dropped, 1)
+		}
+	}
+}
+
+// Install prepends the Bus to every chain of the engine. Because it is prepended, the durations include any middleware
+// that was already installed, such as the database driver. Begin is covered for both SingleTXer and MultiTXer engines.
+func (b *Bus) Install(e vsql_engine.SQLQueryer) {
+	e.QueryMW().Prepend(func(ctx context.Context, c engine_context.Queryer) {
+		b.query(ctx, c, CallQuery, b.lookup(&b.transactions, c.QueryExecTransactioner()).id, 0, c.Rows)
+	})
+	e.InsertQueryMW().Prepend(func(ctx context.Context, c engine_context.Inserter) {
+		b.query(ctx, c, CallInsert, b.lookup(&b.transactions, c.QueryExecTransactioner()).id, 0, nil)
+	})
+	e.ExecQueryMW().Prepend(func(ctx context.Context, c engine_context.Execer) {
+		b.query(ctx, c, CallExec, b.lookup(&b.transactions, c.QueryExecTransactioner()).id, 0, nil)
+	})
+	e.StatementQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementQueryer) {
+		stmt := b.lookup(&b.statements, c.Statement())
+		b.query(ctx, c, CallStatementQuery, stmt.tx, stmt.id, c.Rows)
+	})
+	e.StatementInsertQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementInsertQueryer) {
+		stmt := b.lookup(&b.statements, c.Statement())
+		b.query(ctx, c, CallStatementInsert, stmt.tx, stmt.id, nil)
+	})
+	e.StatementExecQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		stmt := b.lookup(&b.statements, c.Statement())
+		b.query(ctx, c, CallStatementExec, stmt.tx, stmt.id, nil)
+	})
+	e.StatementPrepareMW().Prepend(func(ctx context.Context, c engine_context.Preparer) {
+		b.run(ctx, c, Prepare, func(ev *Event) {
+			ev.TxID = b.lookup(&b.transactions, c.QueryExecTransactioner()).id
+			if fp := c.Fingerprint(); fp != nil {
+				ev.Fingerprint, ev.Query = fp.ID(), fp.Normalized()
+			}
+		}, func(ev *Event) {
+			if c.Error() == nil {
+				ev.StatementID = b.track(&b.statements, c.Statement(), ev.TxID)
+			}
+		})
+	})
+	e.StatementCloseMW().Prepend(func(ctx context.Context, c engine_context.StatementCloser) {
+		// forgotten whether or not the Bus is active, as it may have been tracked while it was
+		stmt := b.forget(&b.statements, c.Statement())
+		b.run(ctx, c, StatementClose, func(ev *Event) {
+			ev.StatementID, ev.TxID = stmt.id, stmt.tx
+		}, nil)
+	})
+	e.RowsNextMW().Prepend(func(ctx context.Context, c engine_context.RowsNexter) {
+		b.run(ctx, c, RowsNext, func(ev *Event) {
+			rows := b.lookup(&b.rows, c.Rows())
+			ev.RowsID, ev.TxID = rows.id, rows.tx
+		}, func(ev *Event) {
+			ev.Done = c.Row() == nil
+		})
+	})
+	e.RowsCloseMW().Prepend(func(ctx context.Context, c engine_context.Rowser) {
+		rows := b.forget(&b.rows, c.Rows())
+		b.run(ctx, c, RowsClose, func(ev *Event) {
+			ev.RowsID, ev.TxID = rows.id, rows.tx
+		}, nil)
+	})
+	e.CommitMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+		b.end(ctx, c, Commit, c.QueryExecTransactioner())
+	})
+	e.RollbackMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+		b.end(ctx, c, Rollback, c.QueryExecTransactioner())
+	})
+	e.PingMW().Prepend(func(ctx context.Context, c engine_context.Er) {
+		b.run(ctx, c, Ping, nil, nil)
+	})
+	e.ConnCloseMW().Prepend(func(ctx context.Context, c engine_context.Er) {
+		b.run(ctx, c, ConnClose, nil, nil)
+	})
+	if w, ok := e.(engine_ware.BeginWare); ok {
+		w.BeginMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+			b.run(ctx, c, Begin, nil, func(ev *Event) {
+				if c.Error() == nil {
+					ev.TxID = b.track(&b.transactions, c.QueryExecTransactioner(), 0)
+				}
+			})
+		})
+	}
+	if w, ok := e.(engine_ware.BeginNestedWare); ok {
+		w.BeginNestedMW().Prepend(func(ctx context.Context, c engine_context.NestedBeginner) {
+			b.run(ctx, c, Begin, func(ev *Event) {
+				// the context holds the parent's transaction until the driver replaces it with the new one
+				if p := c.QueryExecNestedTransactioner(); p != nil {
+					ev.Kind = NestedBegin
+					ev.ParentTxID = b.lookup(&b.transactions, p).id
+				}
+			}, func(ev *Event) {
+				if c.Error() == nil && c.QueryExecNestedTransactioner() != nil {
+					ev.TxID = b.track(&b.transactions, c.QueryExecNestedTransactioner(), ev.ParentTxID)
+				}
+			})
+		})
+	}
+}
+
+// fingerprinted is the part of the contexts of the calls that run SQL that query needs
+type fingerprinted interface {
+	engine_context.Er
+	Fingerprint() fingerprint.Fingerprinter
+}
+
+// query runs a call that runs SQL between a QueryStart and a QueryEnd event. rows returns the rows of the calls that
+// return them, their RowsID is set on QueryEnd.
+func (b *Bus) query(ctx context.Context, c fingerprinted, call Call, txID, statementID uint64, rows func() vrows.Rowser) {
+	if !b.active() {
+		c.Next(ctx)
+		return
+	}
+	ev := Event{
+		Kind:        QueryStart,
+		Call:        call,
+		CallID:      atomic.AddUint64(&b.ids, 1),
+		TxID:        txID,
+		StatementID: statementID,
+	}
+	if fp := c.Fingerprint(); fp != nil {
+		ev.Fingerprint, ev.Query = fp.ID(), fp.Normalized()
+	}
+	started := b.now()
+	ev.Time = started
+	b.publish(ev)
+	c.Next(ctx)
+	ev.Kind = QueryEnd
+	ev.Time = b.now()
+	ev.Duration = ev.Time.Sub(started)
+	ev.Err = c.Error()
+	if rows != nil && ev.Err == nil {
+		ev.RowsID = b.track(&b.rows, rows(), txID)
+	}
+	b.publish(ev)
+}
+
+// run runs a call and publishes an event of kind once it returns. before and after, if not nil, fill in the event
+// before and after the call.
+func (b *Bus) run(ctx context.Context, c engine_context.Er, kind Kind, before, after func(ev *Event)) {
+	if !b.active() {
+		c.Next(ctx)
+		return
+	}
+	ev := Event{Kind: kind}
+	if before != nil {
+		before(&ev)
+	}
+	started := b.now()
+	c.Next(ctx)
+	ev.Time = b.now()
+	ev.Duration = ev.Time.Sub(started)
+	ev.Err = c.Error()
+	if after != nil {
+		after(&ev)
+	}
+	b.publish(ev)
+}
+
+// end runs a Commit or Rollback. Once it succeeds, the transaction is forgotten, whether or not the Bus is active.
+func (b *Bus) end(ctx context.Context, c engine_context.Er, kind Kind, tx interface{}) {
+	t := b.lookup(&b.transactions, tx)
+	b.run(ctx, c, kind, func(ev *Event) {
+		ev.TxID, ev.ParentTxID = t.id, t.tx
+	}, nil)
+	if c.Error() == nil {
+		b.forget(&b.transactions, tx)
+	}
+}
+
+// track gives key, an object created by the driver, a new ID in t. It returns 0 if key cannot be tracked.
+func (b *Bus) track(t *engine_context.Tracker[ref], key interface{}, tx uint64) uint64 {
+	if !engine_context.Trackable(key) {
+		return 0
+	}
+	r := ref{id: atomic.AddUint64(&b.ids, 1), tx: tx}
+	t.Track(key, r)
+	return r.id
+}
+
+// lookup returns the ref of key in t, if it has one
+func (b *Bus) lookup(t *engine_context.Tracker[ref], key interface{}) ref {
+	r, _ := t.Lookup(key)
+	return r
+}
+
+// forget removes key from t and returns the ref it had
+func (b *Bus) forget(t *engine_context.Tracker[ref], key interface{}) ref {
+	r, _ := t.Forget(key)
+	return r
+}
@@ -0,0 +1,244 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package engine_event
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/internal/testdriver"
+	"github.com/wojnosystems/vsql_engine/query_rewrite"
+	"testing"
+	"time"
+)
+
+// fakeClock advances by step every time it is read
+type fakeClock struct {
+	current time.Time
+	step    time.Duration
+}
+
+func (f *fakeClock) now() time.Time {
+	f.current = f.current.Add(f.step)
+	return f.current
+}
+
+func newTestEngine() (vsql_engine.MultiTXer, *Bus) {
+	e := vsql_engine.NewMulti()
+	d := testdriver.Install(e)
+	d.Columns = []string{"id"}
+	d.Rows = [][]interface{}{{1}}
+	d.Fail = func(call testdriver.Call) error {
+		if call.SQL == "DELETE broken" {
+			return errors.New("syntax error")
+		}
+		return nil
+	}
+
+	b := New()
+	b.now = (&fakeClock{step: time.Millisecond}).now
+	b.Install(e)
+	return e, b
+}
+
+// drain returns the events that are buffered in s
+func drain(s *Subscription) (events []Event) {
+	for {
+		select {
+		case e, open := <-s.Events():
+			if !open {
+				return
+			}
+			events = append(events, e)
+		default:
+			return
+		}
+	}
+}
+
+func TestBus_Lifecycle(t *testing.T) {
+	e, b := newTestEngine()
+	s := b.Subscribe(0, DropNewest)
+	defer s.Close()
+	ctx := context.Background()
+
+	assert.NoError(t, e.Ping(ctx))
+	tx, _ := e.Begin(ctx, nil)
+	_, _ = tx.Exec(ctx, vparam.New("DELETE FROM sessions WHERE id = 5"))
+	nested, _ := tx.Begin(ctx, nil)
+	stmt, _ := nested.Prepare(ctx, vparam.New("DELETE FROM sessions WHERE id = ?"))
+	_, err := stmt.Exec(ctx, query_rewrite.Parameters([]interface{}{6}))
+	assert.NoError(t, err)
+	_ = stmt.Close()
+	_ = nested.Rollback()
+	rows, _ := tx.Query(ctx, vparam.New("SELECT * FROM sessions"))
+	for rows.Next() != nil {
+	}
+	_ = rows.Close()
+	_ = tx.Commit()
+	_ = e.Close()
+
+	events := drain(s)
+	var kinds []Kind
+	for i, ev := range events {
+		kinds = append(kinds, ev.Kind)
+		assert.Equal(t, uint64(i+1), ev.Seq)
+		if ev.Kind != QueryStart {
+			assert.Equal(t, time.Millisecond, ev.Duration, ev.String())
+		}
+	}
+	assert.Equal(t, []Kind{
+		Ping, Begin, QueryStart, QueryEnd, NestedBegin, Prepare, QueryStart, QueryEnd, StatementClose, Rollback,
+		QueryStart, QueryEnd, RowsNext, RowsNext, RowsClose, Commit, ConnClose,
+	}, kinds)
+
+	begin, exec, nestedBegin, prepare := events[1], events[3], events[4], events[5]
+	assert.NotZero(t, begin.TxID)
+	assert.Equal(t, CallExec, exec.Call)
+	assert.Equal(t, events[2].CallID, exec.CallID)
+	assert.Equal(t, begin.TxID, exec.TxID)
+	assert.Equal(t, "delete from sessions where id = ?", exec.Query)
+	assert.Equal(t, begin.TxID, nestedBegin.ParentTxID)
+	assert.NotEqual(t, begin.TxID, nestedBegin.TxID)
+	assert.Equal(t, nestedBegin.TxID, prepare.TxID)
+
+	stmtExec, stmtClose, rollback := events[7], events[8], events[9]
+	assert.Equal(t, CallStatementExec, stmtExec.Call)
+	assert.NotZero(t, prepare.StatementID)
+	assert.Equal(t, prepare.StatementID, stmtExec.StatementID)
+	assert.Equal(t, nestedBegin.TxID, stmtExec.TxID)
+	assert.Equal(t, prepare.StatementID, stmtClose.StatementID)
+	assert.Equal(t, nestedBegin.TxID, rollback.TxID)
+	assert.Equal(t, begin.TxID, rollback.ParentTxID)
+
+	query, first, last, closed, commit := events[11], events[12], events[13], events[14], events[15]
+	assert.NotZero(t, query.RowsID)
+	assert.Equal(t, query.RowsID, first.RowsID)
+	assert.Equal(t, begin.TxID, first.TxID)
+	assert.False(t, first.Done)
+	assert.True(t, last.Done)
+	assert.Equal(t, query.RowsID, closed.RowsID)
+	assert.Equal(t, begin.TxID, commit.TxID)
+}
+
+func TestBus_Error(t *testing.T) {
+	e, b := newTestEngine()
+	s := b.Subscribe(0, DropNewest)
+	defer s.Close()
+	_, err := e.Exec(context.Background(), vparam.New("DELETE broken"))
+	events := drain(s)
+	if assert.Len(t, events, 2) {
+		assert.Nil(t, events[0].Err)
+		assert.Equal(t, err, events[1].Err)
+		assert.Zero(t, events[1].TxID)
+	}
+}
+
+func TestBus_DropPolicy(t *testing.T) {
+	e, b := newTestEngine()
+	newest := b.Subscribe(2, DropNewest)
+	oldest := b.Subscribe(2, DropOldest)
+	for i := 0; i < 5; i++ {
+		_ = e.Ping(context.Background())
+	}
+	assert.Equal(t, []uint64{1, 2}, seqs(drain(newest)))
+	assert.Equal(t, []uint64{4, 5}, seqs(drain(oldest)))
+	assert.Equal(t, uint64(3), newest.Dropped())
+	assert.Equal(t, uint64(3), oldest.Dropped())
+	assert.Equal(t, uint64(5), b.Published())
+	assert.Equal(t, uint64(6), b.Dropped())
+}
+
+func seqs(events []Event) (s []uint64) {
+	for _, e := range events {
+		s = append(s, e.Seq)
+	}
+	return
+}
+
+func TestBus_Close(t *testing.T) {
+	e, b := newTestEngine()
+	s := b.Subscribe(0, DropNewest)
+	_ = e.Ping(context.Background())
+	s.Close()
+	s.Close()
+	_ = e.Ping(context.Background())
+	assert.Len(t, drain(s), 1, "expected buffered events to remain readable")
+	_, open := <-s.Events()
+	assert.False(t, open)
+	assert.Equal(t, uint64(1), b.Published(), "expected nothing to be published without subscriptions")
+}
+
+func TestBus_ConcurrentClose(t *testing.T) {
+	e, b := newTestEngine()
+	b.now = time.Now
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					_ = e.Ping(context.Background())
+				}
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		s := b.Subscribe(1, DropOldest)
+		s.Close()
+	}
+	close(done)
+}
+
+func TestBus_ForgetsWithoutSubscriptions(t *testing.T) {
+	e := vsql_engine.NewMulti()
+	d := testdriver.Install(e)
+	d.Columns = []string{"id"}
+	var rows []vrows.Rowser
+	e.QueryMW().Prepend(func(ctx context.Context, c engine_context.Queryer) {
+		c.Next(ctx)
+		rows = append(rows, c.Rows())
+	})
+	b := New()
+	b.Install(e)
+	ctx := context.Background()
+
+	s := b.Subscribe(0, DropNewest)
+	tx, _ := e.Begin(ctx, nil)
+	stmt, _ := tx.Prepare(ctx, vparam.New("SELECT * FROM sessions WHERE id = ?"))
+	r, _ := tx.Query(ctx, vparam.New("SELECT * FROM sessions"))
+	s.Close()
+
+	_ = r.Close()
+	_ = stmt.Close()
+	_ = tx.Commit()
+
+	calls := d.Calls(testdriver.Prepare, testdriver.Commit)
+	if assert.Len(t, calls, 2) && assert.Len(t, rows, 1) {
+		_, ok := b.statements.Lookup(calls[0].Statement)
+		assert.False(t, ok)
+		_, ok = b.transactions.Lookup(calls[1].Tx)
+		assert.False(t, ok)
+		_, ok = b.rows.Lookup(rows[0])
+		assert.False(t, ok)
+	}
+}
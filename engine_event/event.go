@@ -0,0 +1,99 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package engine_event
+
+import (
+	"fmt"
+	"time"
+)
+
+// Kind is the lifecycle event an Event records
+type Kind string
+
+const (
+	Begin          Kind = "begin"
+	NestedBegin    Kind = "nested_begin"
+	Commit         Kind = "commit"
+	Rollback       Kind = "rollback"
+	Prepare        Kind = "prepare"
+	StatementClose Kind = "statement_close"
+	// QueryStart and QueryEnd surround every call that runs SQL, see Call
+	QueryStart Kind = "query_start"
+	QueryEnd   Kind = "query_end"
+	RowsNext   Kind = "rows_next"
+	RowsClose  Kind = "rows_close"
+	Ping       Kind = "ping"
+	ConnClose  Kind = "conn_close"
+)
+
+// Call is the call that a QueryStart or QueryEnd event was published for
+type Call string
+
+const (
+	CallQuery           Call = "query"
+	CallInsert          Call = "insert"
+	CallExec            Call = "exec"
+	CallStatementQuery  Call = "statement_query"
+	CallStatementInsert Call = "statement_insert"
+	CallStatementExec   Call = "statement_exec"
+)
+
+// Event is one lifecycle event. Events are values: they hold copies of what was known about the call when it was
+// published and never the call's context, so subscribers cannot change the call.
+//
+// IDs are assigned by the Bus, starting at 1. An ID of 0 means there is none, or that the transaction, statement or
+// rows were created while the Bus had no subscribers.
+type Event struct {
+	// Seq is the position of the event in the Bus's stream, starting at 1. Gaps are events that were dropped.
+	Seq  uint64
+	Kind Kind
+	// Call is set for QueryStart and QueryEnd
+	Call Call
+	// Time is when the event was published. Duration is how long the call took, for every Kind but QueryStart.
+	Time     time.Time
+	Duration time.Duration
+	// CallID pairs a QueryStart with its QueryEnd
+	CallID uint64
+	// TxID is the transaction the call was made in. For Begin and NestedBegin it is the transaction that was started,
+	// and ParentTxID is the transaction a NestedBegin was started in.
+	TxID       uint64
+	ParentTxID uint64
+	// StatementID is the prepared statement of Prepare, StatementClose and the statement calls
+	StatementID uint64
+	// RowsID identifies the rows returned by CallQuery and CallStatementQuery, and read by RowsNext and RowsClose
+	RowsID uint64
+	// Fingerprint is the ID of the query's fingerprint and Query is the normalized query. Neither contains values.
+	Fingerprint string
+	Query       string
+	// Done is true for the RowsNext event that found no more rows
+	Done bool
+	// Err is the error the call returned, if any
+	Err error
+}
+
+func (e Event) String() string {
+	s := fmt.Sprintf("#%d %s", e.Seq, e.Kind)
+	if e.Call != "" {
+		s += " " + string(e.Call)
+	}
+	if e.Query != "" {
+		s += ": " + e.Query
+	}
+	if e.Err != nil {
+		s += " (" + e.Err.Error() + ")"
+	}
+	return s
+}
@@ -0,0 +1,94 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package engine_event
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// DropPolicy decides which event is dropped when a subscription's buffer is full. Publishing never waits for a
+// subscriber.
+type DropPolicy uint8
+
+const (
+	// DropNewest drops the event being published, keeping the events already buffered
+	DropNewest DropPolicy = iota
+	// DropOldest drops the oldest buffered event to make room for the one being published
+	DropOldest
+)
+
+// DefaultBuffer is the number of events a subscription buffers when Subscribe is given no size
+const DefaultBuffer = 256
+
+// Subscription receives the events published after it was created. Read them from Events until it is closed.
+type Subscription struct {
+	bus    *Bus
+	policy DropPolicy
+	events chan Event
+	// mu stops Close from closing events while an event is being sent to it
+	mu      sync.RWMutex
+	closed  bool
+	dropped uint64
+}
+
+// Events is the channel the subscription's events are delivered on. It is closed by Close.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped is the number of events that were not delivered because the buffer was full
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close stops the subscription and closes Events. Buffered events can still be read. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.events)
+	}
+}
+
+// deliver sends e without waiting. It returns false if e, or an older event, was dropped.
+func (s *Subscription) deliver(e Event) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return true
+	}
+	select {
+	case s.events <- e:
+		return true
+	default:
+	}
+	if s.policy == DropOldest {
+		select {
+		case <-s.events:
+		default:
+		}
+		select {
+		case s.events <- e:
+		default:
+			// other publishers filled the space first: e is dropped instead
+		}
+	}
+	atomic.AddUint64(&s.dropped, 1)
+	return false
+}
@@ -0,0 +1,170 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package engine_ware
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+)
+
+// ChainKey identifies a chain whose calls are performed with contexts of type C, so that handlers can be attached to
+// it with Attach. Each chain has one, such as QueryChain.
+type ChainKey[C engine_context.Er] struct {
+	name string
+}
+
+// NewChainKey creates the key of a new chain. name must be unique among the chains.
+func NewChainKey[C engine_context.Er](name string) ChainKey[C] {
+	return ChainKey[C]{name: name}
+}
+
+func (k ChainKey[C]) String() string {
+	return k.name
+}
+
+// NamedAdder is implemented by every chain the engine returns, such as the QueryAdder of QueryMW. Assert an Adder to
+// the NamedAdder of its context type to add a handler under a name, so that handlers attached with Before(name) can be
+// placed in front of it:
+//
+//	e.QueryMW().(engine_ware.NamedAdder[engine_context.Queryer]).AppendNamed("driver", driver)
+type NamedAdder[C engine_context.Er] interface {
+	AppendNamed(name string, w engine_context.Handler[C])
+	PrependNamed(name string, w engine_context.Handler[C])
+}
+
+// Placement is where in the chain an attached handler is placed
+type Placement struct {
+	front  bool
+	before string
+}
+
+var (
+	// Front places the handler before every handler of the chain
+	Front = Placement{front: true}
+	// Back places the handler after every handler of the chain, so it runs when the driver calls Next
+	Back = Placement{}
+)
+
+// Before places the handler in front of the first handler that was added with name, using AppendNamed or
+// PrependNamed. If no handler has that name, it is placed at the Back.
+func Before(name string) Placement {
+	return Placement{before: name}
+}
+
+// attachment is a handler attached to a context. They form a list, newest first, through next.
+type attachment struct {
+	chain   string
+	at      Placement
+	handler interface{}
+	next    *attachment
+}
+
+type attachmentsKey struct{}
+
+// Attach returns a copy of ctx with h attached to the chain identified by key. Calls made with the returned context,
+// or one derived from it, run h at the given placement, in addition to the handlers of the chain. Handlers attached
+// with the same placement run in the order they were attached.
+//
+// Attach to the context of Begin to run h for every call made in the transaction, and to the context of Query to run
+// it when the rows it returned are read or closed.
+func Attach[C engine_context.Er](ctx context.Context, key ChainKey[C], at Placement, h engine_context.Handler[C]) context.Context {
+	return context.WithValue(ctx, attachmentsKey{}, &attachment{
+		chain:   key.name,
+		at:      at,
+		handler: h,
+		next:    attachmentsFrom(ctx),
+	})
+}
+
+// Inherit returns ctx with the handlers attached to parent ahead of its own. The engine uses it so that the handlers
+// attached to the context a transaction was begun with apply to the calls made in the transaction.
+func Inherit(ctx, parent context.Context) context.Context {
+	inherited := attachmentsFrom(parent)
+	if inherited == nil {
+		return ctx
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var own []*attachment
+	for a := attachmentsFrom(ctx); a != nil; a = a.next {
+		if a == inherited {
+			// ctx was derived from parent, so it already has them
+			return ctx
+		}
+		own = append(own, a)
+	}
+	head := inherited
+	for i := len(own) - 1; i >= 0; i-- {
+		a := *own[i]
+		a.next = head
+		head = &a
+	}
+	return context.WithValue(ctx, attachmentsKey{}, head)
+}
+
+func attachmentsFrom(ctx context.Context) *attachment {
+	if ctx == nil {
+		return nil
+	}
+	a, _ := ctx.Value(attachmentsKey{}).(*attachment)
+	return a
+}
+
+// splice returns the handlers of h with the attachments to the chain named chain placed among them. The handlers of h
+// are returned as they are if none are attached to it.
+func splice[C engine_context.Er](h *handlers[C], chain string, list *attachment) []engine_context.Handler[C] {
+	n := 0
+	for a := list; a != nil; a = a.next {
+		if a.chain == chain {
+			n++
+		}
+	}
+	if n == 0 {
+		return h.handlers
+	}
+	// oldest first, so that handlers with the same placement run in the order they were attached
+	attached := make([]*attachment, n)
+	for a := list; a != nil; a = a.next {
+		if a.chain == chain {
+			n--
+			attached[n] = a
+		}
+	}
+	out := make([]engine_context.Handler[C], 0, len(h.handlers)+len(attached))
+	placed := make([]bool, len(attached))
+	for i, a := range attached {
+		if a.at.front {
+			out = append(out, a.handler.(engine_context.Handler[C]))
+			placed[i] = true
+		}
+	}
+	for j, handler := range h.handlers {
+		for i, a := range attached {
+			if !placed[i] && a.at.before != "" && a.at.before == h.names[j] {
+				out = append(out, a.handler.(engine_context.Handler[C]))
+				placed[i] = true
+			}
+		}
+		out = append(out, handler)
+	}
+	for i, a := range attached {
+		if !placed[i] {
+			out = append(out, a.handler.(engine_context.Handler[C]))
+		}
+	}
+	return out
+}
@@ -16,12 +16,10 @@
 package engine_ware
 
 import (
-	"container/list"
-	"context"
 	"github.com/wojnosystems/vsql_engine/engine_context"
 )
 
-type BeginHandler func(ctx context.Context, c engine_context.Beginner)
+type BeginHandler = engine_context.BeginnerHandler
 
 // Middleware for begin
 type BeginAdder interface {
@@ -33,41 +31,11 @@ type BeginWare interface {
 	BeginMW() BeginAdder
 }
 
-type BeginMW struct {
-	middlewares *list.List // engine_context.BeginWareType
-}
-
-func NewBeginMW() *BeginMW {
-	return &BeginMW{
-		middlewares: list.New(),
-	}
-}
-
-func (b *BeginMW) Append(w BeginHandler) {
-	b.middlewares.PushBack(beginPackageFunc(w))
-}
+// BeginChain identifies the Begin chain to Attach
+var BeginChain = NewChainKey[engine_context.Beginner]("Begin")
 
-func (b *BeginMW) Prepend(w BeginHandler) {
-	b.middlewares.PushFront(beginPackageFunc(w))
-}
-
-// PerformMiddleware executes the middleware after injecting engine_context (if any)
-func (b *BeginMW) PerformMiddleware(ctx context.Context, c engine_context.Beginner) {
-	if b.middlewares.Len() == 0 {
-		return
-	}
-	c.(engine_context.WithMiddlewarer).SetMiddlewares(b.middlewares)
-	c.Next(ctx)
-}
+type BeginMW = Chain[engine_context.Beginner]
 
-func (b BeginMW) Copy() *BeginMW {
-	r := NewBeginMW()
-	r.middlewares.PushBackList(b.middlewares)
-	return r
-}
-
-func beginPackageFunc(w BeginHandler) engine_context.MiddlewareFunc {
-	return func(ctx context.Context, er engine_context.Er) {
-		w(ctx, er.(engine_context.Beginner))
-	}
+func NewBeginMW() *BeginMW {
+	return NewChain(BeginChain)
 }
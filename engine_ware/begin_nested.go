@@ -16,12 +16,10 @@
 package engine_ware
 
 import (
-	"container/list"
-	"context"
 	"github.com/wojnosystems/vsql_engine/engine_context"
 )
 
-type BeginNestedHandler func(ctx context.Context, c engine_context.NestedBeginner)
+type BeginNestedHandler = engine_context.NestedBeginnerHandler
 
 // Middleware for begin
 type BeginNestedAdder interface {
@@ -33,41 +31,11 @@ type BeginNestedWare interface {
 	BeginNestedMW() BeginNestedAdder
 }
 
-type BeginNestedMW struct {
-	middlewares *list.List // engine_context.BeginWareType
-}
-
-func NewBeginNestedMW() *BeginNestedMW {
-	return &BeginNestedMW{
-		middlewares: list.New(),
-	}
-}
-
-func (b *BeginNestedMW) Append(w BeginNestedHandler) {
-	b.middlewares.PushBack(beginNestedPackageFunc(w))
-}
+// BeginNestedChain identifies the BeginNested chain to Attach
+var BeginNestedChain = NewChainKey[engine_context.NestedBeginner]("BeginNested")
 
-func (b *BeginNestedMW) Prepend(w BeginNestedHandler) {
-	b.middlewares.PushFront(beginNestedPackageFunc(w))
-}
-
-// PerformMiddleware executes the middleware after injecting engine_context (if any)
-func (b *BeginNestedMW) PerformMiddleware(ctx context.Context, c engine_context.NestedBeginner) {
-	if b.middlewares.Len() == 0 {
-		return
-	}
-	c.(engine_context.WithMiddlewarer).SetMiddlewares(b.middlewares)
-	c.Next(ctx)
-}
+type BeginNestedMW = Chain[engine_context.NestedBeginner]
 
-func (b BeginNestedMW) Copy() *BeginNestedMW {
-	r := NewBeginNestedMW()
-	r.middlewares.PushBackList(b.middlewares)
-	return r
-}
-
-func beginNestedPackageFunc(w BeginNestedHandler) engine_context.MiddlewareFunc {
-	return func(ctx context.Context, er engine_context.Er) {
-		w(ctx, er.(engine_context.NestedBeginner))
-	}
+func NewBeginNestedMW() *BeginNestedMW {
+	return NewChain(BeginNestedChain)
 }
@@ -0,0 +1,163 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package engine_ware
+
+import (
+	"context"
+	"errors"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrSealed is the value Append and Prepend panic with once the chain has been sealed
+var ErrSealed = errors.New("engine_ware: middleware cannot be added to a sealed chain")
+
+// Chain is a chain of middleware whose calls are performed with contexts of type C. Every *MW type is a Chain, so a
+// new chain only needs a context type, a ChainKey, a handler type and an Adder interface.
+type Chain[C engine_context.Er] struct {
+	key ChainKey[C]
+	// mu serializes Append, Prepend and Seal. Performing the chain never takes it.
+	mu     sync.Mutex
+	sealed bool
+	// handlers holds a *handlers[C] that is never modified once stored. Append and Prepend store a new one, so calls
+	// that are being performed keep the handlers they started with.
+	handlers atomic.Value
+}
+
+// handlers are the handlers of a chain, in order, and the names they were added with
+type handlers[C engine_context.Er] struct {
+	handlers []engine_context.Handler[C]
+	names    []string
+}
+
+// NewChain creates an empty chain identified by key
+func NewChain[C engine_context.Er](key ChainKey[C]) *Chain[C] {
+	b := &Chain[C]{key: key}
+	b.handlers.Store(&handlers[C]{})
+	return b
+}
+
+// Append adds w to the end of the chain. It panics with ErrSealed if the chain has been sealed.
+func (b *Chain[C]) Append(w engine_context.Handler[C]) {
+	b.AppendNamed("", w)
+}
+
+// Prepend adds w to the front of the chain. It panics with ErrSealed if the chain has been sealed.
+func (b *Chain[C]) Prepend(w engine_context.Handler[C]) {
+	b.PrependNamed("", w)
+}
+
+// AppendNamed appends w under name, so that handlers attached to a call with Before(name) are placed in front of it
+func (b *Chain[C]) AppendNamed(name string, w engine_context.Handler[C]) {
+	b.lock()
+	defer b.mu.Unlock()
+	old := b.load()
+	n := len(old.handlers)
+	h := &handlers[C]{
+		handlers: make([]engine_context.Handler[C], n, n+1),
+		names:    make([]string, n, n+1),
+	}
+	copy(h.handlers, old.handlers)
+	copy(h.names, old.names)
+	h.handlers = append(h.handlers, w)
+	h.names = append(h.names, name)
+	b.handlers.Store(h)
+}
+
+// PrependNamed prepends w under name, so that handlers attached to a call with Before(name) are placed in front of it
+func (b *Chain[C]) PrependNamed(name string, w engine_context.Handler[C]) {
+	b.lock()
+	defer b.mu.Unlock()
+	old := b.load()
+	n := len(old.handlers)
+	h := &handlers[C]{
+		handlers: make([]engine_context.Handler[C], 1, n+1),
+		names:    make([]string, 1, n+1),
+	}
+	h.handlers[0] = w
+	h.names[0] = name
+	h.handlers = append(h.handlers, old.handlers...)
+	h.names = append(h.names, old.names...)
+	b.handlers.Store(h)
+}
+
+// AppendWhen appends w so that it only runs for the calls m matches. Calls that m does not match skip w and go on to
+// the next handler in the chain.
+func (b *Chain[C]) AppendWhen(m Matcher, w engine_context.Handler[C]) {
+	b.AppendNamed("", when(m, w))
+}
+
+// PrependWhen prepends w so that it only runs for the calls m matches, see AppendWhen
+func (b *Chain[C]) PrependWhen(m Matcher, w engine_context.Handler[C]) {
+	b.PrependNamed("", when(m, w))
+}
+
+// when wraps w so that it only runs when m matches. A nil Matcher matches every call.
+func when[C engine_context.Er](m Matcher, w engine_context.Handler[C]) engine_context.Handler[C] {
+	if m == nil {
+		return w
+	}
+	return func(ctx context.Context, c C) {
+		if m(ctx, c) {
+			w(ctx, c)
+		} else {
+			c.Next(ctx)
+		}
+	}
+}
+
+// PerformMiddleware executes the middleware after injecting engine_context (if any). Handlers attached to ctx with
+// Attach are spliced into the chain for this call.
+func (b *Chain[C]) PerformMiddleware(ctx context.Context, c C) {
+	loaded := b.load()
+	h := loaded.handlers
+	if a := attachmentsFrom(ctx); a != nil {
+		h = splice(loaded, b.key.name, a)
+	}
+	if len(h) == 0 {
+		return
+	}
+	engine_context.Perform(ctx, c, h)
+}
+
+// Copy returns a chain with the same handlers that can be added to separately. The copy is not sealed.
+func (b *Chain[C]) Copy() *Chain[C] {
+	r := NewChain(b.key)
+	r.handlers.Store(b.load())
+	return r
+}
+
+// Seal stops handlers from being added to the chain. Append and Prepend panic with ErrSealed afterwards.
+func (b *Chain[C]) Seal() {
+	b.mu.Lock()
+	b.sealed = true
+	b.mu.Unlock()
+}
+
+// lock takes the registration lock. It panics with ErrSealed if the chain has been sealed.
+func (b *Chain[C]) lock() {
+	b.mu.Lock()
+	if b.sealed {
+		b.mu.Unlock()
+		panic(ErrSealed)
+	}
+}
+
+func (b *Chain[C]) load() *handlers[C] {
+	h, _ := b.handlers.Load().(*handlers[C])
+	return h
+}
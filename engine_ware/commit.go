@@ -16,12 +16,10 @@
 package engine_ware
 
 import (
-	"container/list"
-	"context"
 	"github.com/wojnosystems/vsql_engine/engine_context"
 )
 
-type CommitHandler func(ctx context.Context, c engine_context.Beginner)
+type CommitHandler = engine_context.BeginnerHandler
 
 // Middleware for begin
 type CommitAdder interface {
@@ -33,41 +31,11 @@ type CommitWare interface {
 	CommitMW() CommitAdder
 }
 
-type CommitMW struct {
-	middlewares *list.List // engine_context.MiddlewareFunc
-}
-
-func NewCommitMW() *CommitMW {
-	return &CommitMW{
-		middlewares: list.New(),
-	}
-}
-
-func (b *CommitMW) Append(w CommitHandler) {
-	b.middlewares.PushBack(commitPackageFunc(w))
-}
+// CommitChain identifies the Commit chain to Attach
+var CommitChain = NewChainKey[engine_context.Beginner]("Commit")
 
-func (b *CommitMW) Prepend(w CommitHandler) {
-	b.middlewares.PushFront(commitPackageFunc(w))
-}
-
-// PerformMiddleware executes the middleware after injecting engine_context (if any)
-func (b *CommitMW) PerformMiddleware(ctx context.Context, c engine_context.Beginner) {
-	if b.middlewares.Len() == 0 {
-		return
-	}
-	c.(engine_context.WithMiddlewarer).SetMiddlewares(b.middlewares)
-	c.Next(ctx)
-}
+type CommitMW = Chain[engine_context.Beginner]
 
-func (b CommitMW) Copy() *CommitMW {
-	r := NewCommitMW()
-	r.middlewares.PushBackList(b.middlewares)
-	return r
-}
-
-func commitPackageFunc(w CommitHandler) engine_context.MiddlewareFunc {
-	return func(ctx context.Context, er engine_context.Er) {
-		w(ctx, er.(engine_context.Beginner))
-	}
+func NewCommitMW() *CommitMW {
+	return NewChain(CommitChain)
 }
@@ -16,8 +16,6 @@
 package engine_ware
 
 import (
-	"container/list"
-	"context"
 	"github.com/wojnosystems/vsql_engine/engine_context"
 )
 
@@ -31,35 +29,11 @@ type ConnCloseWare interface {
 	ConnCloseMW() ConnCloseAdder
 }
 
-type ConnCloseMW struct {
-	middlewares *list.List // engine_context.MiddlewareFunc
-}
-
-func NewConnCloseMW() *ConnCloseMW {
-	return &ConnCloseMW{
-		middlewares: list.New(),
-	}
-}
-
-func (b *ConnCloseMW) Append(w engine_context.MiddlewareFunc) {
-	b.middlewares.PushBack(w)
-}
+// ConnCloseChain identifies the ConnClose chain to Attach
+var ConnCloseChain = NewChainKey[engine_context.Er]("ConnClose")
 
-func (b *ConnCloseMW) Prepend(w engine_context.MiddlewareFunc) {
-	b.middlewares.PushFront(w)
-}
+type ConnCloseMW = Chain[engine_context.Er]
 
-// PerformMiddleware executes the middleware after injecting engine_context (if any)
-func (b *ConnCloseMW) PerformMiddleware(ctx context.Context, c engine_context.Er) {
-	if b.middlewares.Len() == 0 {
-		return
-	}
-	c.(engine_context.WithMiddlewarer).SetMiddlewares(b.middlewares)
-	c.Next(ctx)
-}
-
-func (b ConnCloseMW) Copy() *ConnCloseMW {
-	r := NewConnCloseMW()
-	r.middlewares.PushBackList(b.middlewares)
-	return r
+func NewConnCloseMW() *ConnCloseMW {
+	return NewChain(ConnCloseChain)
 }
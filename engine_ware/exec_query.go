@@ -16,12 +16,10 @@
 package engine_ware
 
 import (
-	"container/list"
-	"context"
 	"github.com/wojnosystems/vsql_engine/engine_context"
 )
 
-type ExecHandler func(ctx context.Context, c engine_context.Execer)
+type ExecHandler = engine_context.ExecHandler
 
 // Middleware for begin
 type ExecAdder interface {
@@ -33,41 +31,11 @@ type ExecWare interface {
 	ExecQueryMW() ExecAdder
 }
 
-type ExecMW struct {
-	middlewares *list.List // engine_context.MiddlewareFunc
-}
-
-func NewExecMW() *ExecMW {
-	return &ExecMW{
-		middlewares: list.New(),
-	}
-}
-
-func (b *ExecMW) Append(w ExecHandler) {
-	b.middlewares.PushBack(execPackageFunc(w))
-}
+// ExecChain identifies the Exec chain to Attach
+var ExecChain = NewChainKey[engine_context.Execer]("Exec")
 
-func (b *ExecMW) Prepend(w ExecHandler) {
-	b.middlewares.PushFront(execPackageFunc(w))
-}
-
-// PerformMiddleware executes the middleware after injecting engine_context (if any)
-func (b *ExecMW) PerformMiddleware(ctx context.Context, c engine_context.Execer) {
-	if b.middlewares.Len() == 0 {
-		return
-	}
-	c.(engine_context.WithMiddlewarer).SetMiddlewares(b.middlewares)
-	c.Next(ctx)
-}
+type ExecMW = Chain[engine_context.Execer]
 
-func (b ExecMW) Copy() *ExecMW {
-	r := NewExecMW()
-	r.middlewares.PushBackList(b.middlewares)
-	return r
-}
-
-func execPackageFunc(w ExecHandler) engine_context.MiddlewareFunc {
-	return func(ctx context.Context, er engine_context.Er) {
-		w(ctx, er.(engine_context.Execer))
-	}
+func NewExecMW() *ExecMW {
+	return NewChain(ExecChain)
 }
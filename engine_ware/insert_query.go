@@ -16,12 +16,10 @@
 package engine_ware
 
 import (
-	"container/list"
-	"context"
 	"github.com/wojnosystems/vsql_engine/engine_context"
 )
 
-type InsertQueryHandler func(ctx context.Context, c engine_context.Inserter)
+type InsertQueryHandler = engine_context.InsertQueryHandler
 
 // Middleware for begin
 type InsertQueryAdder interface {
@@ -33,41 +31,11 @@ type InsertQueryWare interface {
 	InsertQueryMW() InsertQueryAdder
 }
 
-type InsertQueryMW struct {
-	middlewares *list.List // engine_context.MiddlewareFunc
-}
-
-func NewInsertQueryMW() *InsertQueryMW {
-	return &InsertQueryMW{
-		middlewares: list.New(),
-	}
-}
-
-func (b *InsertQueryMW) Append(w InsertQueryHandler) {
-	b.middlewares.PushBack(insertQueryPackageFunc(w))
-}
+// InsertQueryChain identifies the InsertQuery chain to Attach
+var InsertQueryChain = NewChainKey[engine_context.Inserter]("InsertQuery")
 
-func (b *InsertQueryMW) Prepend(w InsertQueryHandler) {
-	b.middlewares.PushFront(insertQueryPackageFunc(w))
-}
-
-// PerformMiddleware executes the middleware after injecting engine_context (if any)
-func (b *InsertQueryMW) PerformMiddleware(ctx context.Context, c engine_context.Inserter) {
-	if b.middlewares.Len() == 0 {
-		return
-	}
-	c.(engine_context.WithMiddlewarer).SetMiddlewares(b.middlewares)
-	c.Next(ctx)
-}
+type InsertQueryMW = Chain[engine_context.Inserter]
 
-func (b InsertQueryMW) Copy() *InsertQueryMW {
-	r := NewInsertQueryMW()
-	r.middlewares.PushBackList(b.middlewares)
-	return r
-}
-
-func insertQueryPackageFunc(w InsertQueryHandler) engine_context.MiddlewareFunc {
-	return func(ctx context.Context, er engine_context.Er) {
-		w(ctx, er.(engine_context.Inserter))
-	}
+func NewInsertQueryMW() *InsertQueryMW {
+	return NewChain(InsertQueryChain)
 }
@@ -0,0 +1,182 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package engine_ware
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/fingerprint"
+	"regexp"
+	"strings"
+)
+
+// ConditionalAdder is implemented by every chain the engine returns, such as the QueryAdder of QueryMW. Assert an Adder
+// to the ConditionalAdder of its context type to add a handler that only runs for the calls a Matcher matches:
+//
+//	e.ExecQueryMW().(engine_ware.ConditionalAdder[engine_context.Execer]).PrependWhen(engine_ware.Writes(), audit)
+type ConditionalAdder[C engine_context.Er] interface {
+	AppendWhen(m Matcher, w engine_context.Handler[C])
+	PrependWhen(m Matcher, w engine_context.Handler[C])
+}
+
+// Matcher decides whether a handler added with AppendWhen or PrependWhen runs for a call. Matchers are combined with
+// And, Or and Not, for example: Table("invoices").And(Writes()).And(Not(InTransaction())).
+// Matchers are called for every call made through the chain, so they should be cheap and must not call Next.
+type Matcher func(ctx context.Context, c engine_context.Er) bool
+
+// And matches the calls that m and every one of o match
+func (m Matcher) And(o ...Matcher) Matcher {
+	return All(append([]Matcher{m}, o...)...)
+}
+
+// Or matches the calls that m or any one of o match
+func (m Matcher) Or(o ...Matcher) Matcher {
+	return Any(append([]Matcher{m}, o...)...)
+}
+
+// All matches the calls every one of ms matches. All with no matchers matches every call.
+func All(ms ...Matcher) Matcher {
+	return func(ctx context.Context, c engine_context.Er) bool {
+		for _, m := range ms {
+			if !m(ctx, c) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Any matches the calls any one of ms matches. Any with no matchers matches no calls.
+func Any(ms ...Matcher) Matcher {
+	return func(ctx context.Context, c engine_context.Er) bool {
+		for _, m := range ms {
+			if m(ctx, c) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not matches the calls m does not match
+func Not(m Matcher) Matcher {
+	return func(ctx context.Context, c engine_context.Er) bool {
+		return !m(ctx, c)
+	}
+}
+
+// fingerprinter is implemented by the contexts of the calls that run SQL: Query, Insert, Exec, Prepare and the
+// statement calls
+type fingerprinter interface {
+	Fingerprint() fingerprint.Fingerprinter
+}
+
+// fingerprintOf returns the fingerprint of the SQL the call runs, or nil if the call does not run SQL
+func fingerprintOf(c engine_context.Er) fingerprint.Fingerprinter {
+	if f, ok := c.(fingerprinter); ok {
+		return f.Fingerprint()
+	}
+	return nil
+}
+
+// Fingerprint matches the calls whose normalized SQL, see fingerprint.Fingerprinter, matches re. Calls that do not
+// run SQL, such as Commit or RowsNext, are not matched.
+func Fingerprint(re *regexp.Regexp) Matcher {
+	return func(ctx context.Context, c engine_context.Er) bool {
+		f := fingerprintOf(c)
+		return f != nil && re.MatchString(f.Normalized())
+	}
+}
+
+// StatementType matches the calls whose SQL is one of types. Calls that do not run SQL are not matched.
+func StatementType(types ...fingerprint.StatementType) Matcher {
+	return func(ctx context.Context, c engine_context.Er) bool {
+		f := fingerprintOf(c)
+		if f == nil {
+			return false
+		}
+		for _, t := range types {
+			if f.StatementType() == t {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Reads matches the calls that run a SELECT
+func Reads() Matcher {
+	return StatementType(fingerprint.Select)
+}
+
+// Writes matches the calls whose SQL modifies data or the schema, see fingerprint.StatementType.IsWrite
+func Writes() Matcher {
+	return func(ctx context.Context, c engine_context.Er) bool {
+		f := fingerprintOf(c)
+		return f != nil && f.StatementType().IsWrite()
+	}
+}
+
+// Table matches the calls whose SQL references any of tables. Names are compared without regard to case, and
+// schema-qualified names only match when qualified the same way, see fingerprint.Fingerprinter.Tables.
+func Table(tables ...string) Matcher {
+	return func(ctx context.Context, c engine_context.Er) bool {
+		f := fingerprintOf(c)
+		if f == nil {
+			return false
+		}
+		for _, referenced := range f.Tables() {
+			for _, t := range tables {
+				if strings.EqualFold(referenced, t) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+// InTransaction matches the calls made in a transaction, including Commit and Rollback, see engine_context.TxDepther
+func InTransaction() Matcher {
+	return TxDepthAtLeast(1)
+}
+
+// TxDepthAtLeast matches the calls made in a transaction nested at least depth deep. The outermost transaction is at
+// depth 1.
+func TxDepthAtLeast(depth int) Matcher {
+	return func(ctx context.Context, c engine_context.Er) bool {
+		return engine_context.TxDepth(c) >= depth
+	}
+}
+
+// ContextValue matches the calls whose context.Context holds a value for key that match accepts. Calls without a
+// context never match.
+func ContextValue(key interface{}, match func(value interface{}) bool) Matcher {
+	return func(ctx context.Context, c engine_context.Er) bool {
+		if ctx == nil {
+			return false
+		}
+		v := ctx.Value(key)
+		return v != nil && match(v)
+	}
+}
+
+// ContextValueIs matches the calls whose context.Context holds want for key. want must be comparable.
+func ContextValueIs(key, want interface{}) Matcher {
+	return ContextValue(key, func(value interface{}) bool {
+		return value == want
+	})
+}
@@ -16,8 +16,6 @@
 package engine_ware
 
 import (
-	"container/list"
-	"context"
 	"github.com/wojnosystems/vsql_engine/engine_context"
 )
 
@@ -31,35 +29,11 @@ type PingWare interface {
 	PingMW() PingAdder
 }
 
-type PingMW struct {
-	middlewares *list.List // engine_context.MiddlewareFunc
-}
-
-func NewPingMW() *PingMW {
-	return &PingMW{
-		middlewares: list.New(),
-	}
-}
-
-func (b *PingMW) Append(w engine_context.MiddlewareFunc) {
-	b.middlewares.PushBack(w)
-}
+// PingChain identifies the Ping chain to Attach
+var PingChain = NewChainKey[engine_context.Er]("Ping")
 
-func (b *PingMW) Prepend(w engine_context.MiddlewareFunc) {
-	b.middlewares.PushFront(w)
-}
+type PingMW = Chain[engine_context.Er]
 
-// PerformMiddleware executes the middleware after injecting engine_context (if any)
-func (b *PingMW) PerformMiddleware(ctx context.Context, c engine_context.WithMiddlewarer) {
-	if b.middlewares.Len() == 0 {
-		return
-	}
-	c.(engine_context.WithMiddlewarer).SetMiddlewares(b.middlewares)
-	c.Next(ctx)
-}
-
-func (b PingMW) Copy() *PingMW {
-	r := NewPingMW()
-	r.middlewares.PushBackList(b.middlewares)
-	return r
+func NewPingMW() *PingMW {
+	return NewChain(PingChain)
 }
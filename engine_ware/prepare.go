@@ -16,12 +16,10 @@
 package engine_ware
 
 import (
-	"container/list"
-	"context"
 	"github.com/wojnosystems/vsql_engine/engine_context"
 )
 
-type PrepareHandler func(ctx context.Context, c engine_context.Preparer)
+type PrepareHandler = engine_context.PrepareHandler
 
 // Middleware for begin
 type StatementPrepareAdder interface {
@@ -33,41 +31,11 @@ type StatementPrepareWare interface {
 	StatementPrepareMW() StatementPrepareAdder
 }
 
-type StatementPrepareMW struct {
-	middlewares *list.List // engine_context.MiddlewareFunc
-}
-
-func NewStatementPrepareMW() *StatementPrepareMW {
-	return &StatementPrepareMW{
-		middlewares: list.New(),
-	}
-}
-
-func (b *StatementPrepareMW) Append(w PrepareHandler) {
-	b.middlewares.PushBack(preparePackageFunc(w))
-}
+// StatementPrepareChain identifies the StatementPrepare chain to Attach
+var StatementPrepareChain = NewChainKey[engine_context.Preparer]("StatementPrepare")
 
-func (b *StatementPrepareMW) Prepend(w PrepareHandler) {
-	b.middlewares.PushFront(preparePackageFunc(w))
-}
-
-// PerformMiddleware executes the middleware after injecting engine_context (if any)
-func (b *StatementPrepareMW) PerformMiddleware(ctx context.Context, c engine_context.Preparer) {
-	if b.middlewares.Len() == 0 {
-		return
-	}
-	c.(engine_context.WithMiddlewarer).SetMiddlewares(b.middlewares)
-	c.Next(ctx)
-}
+type StatementPrepareMW = Chain[engine_context.Preparer]
 
-func (b StatementPrepareMW) Copy() *StatementPrepareMW {
-	r := NewStatementPrepareMW()
-	r.middlewares.PushBackList(b.middlewares)
-	return r
-}
-
-func preparePackageFunc(w PrepareHandler) engine_context.MiddlewareFunc {
-	return func(ctx context.Context, er engine_context.Er) {
-		w(ctx, er.(engine_context.Preparer))
-	}
+func NewStatementPrepareMW() *StatementPrepareMW {
+	return NewChain(StatementPrepareChain)
 }
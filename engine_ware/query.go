@@ -16,12 +16,10 @@
 package engine_ware
 
 import (
-	"container/list"
-	"context"
 	"github.com/wojnosystems/vsql_engine/engine_context"
 )
 
-type QueryHandler func(ctx context.Context, c engine_context.Queryer)
+type QueryHandler = engine_context.QueryHandler
 
 // Middleware for begin
 type QueryAdder interface {
@@ -33,41 +31,11 @@ type QueryWare interface {
 	QueryMW() QueryAdder
 }
 
-type QueryMW struct {
-	middlewares *list.List // engine_context.MiddlewareFunc
-}
-
-func NewQueryMW() *QueryMW {
-	return &QueryMW{
-		middlewares: list.New(),
-	}
-}
-
-func (b *QueryMW) Append(w QueryHandler) {
-	b.middlewares.PushBack(queryPackageFunc(w))
-}
+// QueryChain identifies the Query chain to Attach
+var QueryChain = NewChainKey[engine_context.Queryer]("Query")
 
-func (b *QueryMW) Prepend(w QueryHandler) {
-	b.middlewares.PushFront(queryPackageFunc(w))
-}
-
-// PerformMiddleware executes the middleware after injecting engine_context (if any)
-func (b *QueryMW) PerformMiddleware(ctx context.Context, c engine_context.Queryer) {
-	if b.middlewares.Len() == 0 {
-		return
-	}
-	c.(engine_context.WithMiddlewarer).SetMiddlewares(b.middlewares)
-	c.Next(ctx)
-}
+type QueryMW = Chain[engine_context.Queryer]
 
-func (b QueryMW) Copy() *QueryMW {
-	r := NewQueryMW()
-	r.middlewares.PushBackList(b.middlewares)
-	return r
-}
-
-func queryPackageFunc(w QueryHandler) engine_context.MiddlewareFunc {
-	return func(ctx context.Context, er engine_context.Er) {
-		w(ctx, er.(engine_context.Queryer))
-	}
+func NewQueryMW() *QueryMW {
+	return NewChain(QueryChain)
 }
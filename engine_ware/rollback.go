@@ -16,12 +16,10 @@
 package engine_ware
 
 import (
-	"container/list"
-	"context"
 	"github.com/wojnosystems/vsql_engine/engine_context"
 )
 
-type RollbackHandler func(ctx context.Context, c engine_context.Beginner)
+type RollbackHandler = engine_context.BeginnerHandler
 
 // Middleware for begin
 type RollbackAdder interface {
@@ -33,41 +31,11 @@ type RollbackWare interface {
 	RollbackMW() RollbackAdder
 }
 
-type RollbackMW struct {
-	middlewares *list.List // engine_context.MiddlewareFunc
-}
-
-func NewRollbackMW() *RollbackMW {
-	return &RollbackMW{
-		middlewares: list.New(),
-	}
-}
-
-func (b *RollbackMW) Append(w RollbackHandler) {
-	b.middlewares.PushBack(rollbackPackageFunc(w))
-}
+// RollbackChain identifies the Rollback chain to Attach
+var RollbackChain = NewChainKey[engine_context.Beginner]("Rollback")
 
-func (b *RollbackMW) Prepend(w RollbackHandler) {
-	b.middlewares.PushFront(rollbackPackageFunc(w))
-}
-
-// PerformMiddleware executes the middleware after injecting engine_context (if any)
-func (b *RollbackMW) PerformMiddleware(ctx context.Context, c engine_context.Beginner) {
-	if b.middlewares.Len() == 0 {
-		return
-	}
-	c.(engine_context.WithMiddlewarer).SetMiddlewares(b.middlewares)
-	c.Next(ctx)
-}
+type RollbackMW = Chain[engine_context.Beginner]
 
-func (b RollbackMW) Copy() *RollbackMW {
-	r := NewRollbackMW()
-	r.middlewares.PushBackList(b.middlewares)
-	return r
-}
-
-func rollbackPackageFunc(w RollbackHandler) engine_context.MiddlewareFunc {
-	return func(ctx context.Context, er engine_context.Er) {
-		w(ctx, er.(engine_context.Beginner))
-	}
+func NewRollbackMW() *RollbackMW {
+	return NewChain(RollbackChain)
 }
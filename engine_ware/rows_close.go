@@ -16,12 +16,10 @@
 package engine_ware
 
 import (
-	"container/list"
-	"context"
 	"github.com/wojnosystems/vsql_engine/engine_context"
 )
 
-type RowsCloseHandler func(ctx context.Context, c engine_context.Rowser)
+type RowsCloseHandler = engine_context.RowsHandler
 
 // Middleware for begin
 type RowsCloseAdder interface {
@@ -33,41 +31,11 @@ type RowsCloseWare interface {
 	RowsCloseMW() RowsCloseAdder
 }
 
-type RowsCloseMW struct {
-	middlewares *list.List // engine_context.MiddlewareFunc
-}
-
-func NewRowsCloseMW() *RowsCloseMW {
-	return &RowsCloseMW{
-		middlewares: list.New(),
-	}
-}
-
-func (b *RowsCloseMW) Append(w RowsCloseHandler) {
-	b.middlewares.PushBack(rowsClosePackageFunc(w))
-}
+// RowsCloseChain identifies the RowsClose chain to Attach
+var RowsCloseChain = NewChainKey[engine_context.Rowser]("RowsClose")
 
-func (b *RowsCloseMW) Prepend(w RowsCloseHandler) {
-	b.middlewares.PushFront(rowsClosePackageFunc(w))
-}
-
-// PerformMiddleware executes the middleware after injecting engine_context (if any)
-func (b *RowsCloseMW) PerformMiddleware(ctx context.Context, c engine_context.Rowser) {
-	if b.middlewares.Len() == 0 {
-		return
-	}
-	c.(engine_context.WithMiddlewarer).SetMiddlewares(b.middlewares)
-	c.Next(ctx)
-}
+type RowsCloseMW = Chain[engine_context.Rowser]
 
-func (b RowsCloseMW) Copy() *RowsCloseMW {
-	r := NewRowsCloseMW()
-	r.middlewares.PushBackList(b.middlewares)
-	return r
-}
-
-func rowsClosePackageFunc(w RowsCloseHandler) engine_context.MiddlewareFunc {
-	return func(ctx context.Context, er engine_context.Er) {
-		w(ctx, er.(engine_context.Rowser))
-	}
+func NewRowsCloseMW() *RowsCloseMW {
+	return NewChain(RowsCloseChain)
 }
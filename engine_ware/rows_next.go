@@ -16,12 +16,10 @@
 package engine_ware
 
 import (
-	"container/list"
-	"context"
 	"github.com/wojnosystems/vsql_engine/engine_context"
 )
 
-type RowsNextHandler func(ctx context.Context, c engine_context.RowsNexter)
+type RowsNextHandler = engine_context.RowsNextHandler
 
 // Middleware for begin
 type RowsNextAdder interface {
@@ -33,41 +31,11 @@ type RowsNextWare interface {
 	RowsNextMW() RowsNextAdder
 }
 
-type RowsNextMW struct {
-	middlewares *list.List // engine_context.MiddlewareFunc
-}
-
-func NewRowsNextMW() *RowsNextMW {
-	return &RowsNextMW{
-		middlewares: list.New(),
-	}
-}
-
-func (b *RowsNextMW) Append(w RowsNextHandler) {
-	b.middlewares.PushBack(rowsNextPackageFunc(w))
-}
+// RowsNextChain identifies the RowsNext chain to Attach
+var RowsNextChain = NewChainKey[engine_context.RowsNexter]("RowsNext")
 
-func (b *RowsNextMW) Prepend(w RowsNextHandler) {
-	b.middlewares.PushFront(rowsNextPackageFunc(w))
-}
-
-// PerformMiddleware executes the middleware after injecting engine_context (if any)
-func (b *RowsNextMW) PerformMiddleware(ctx context.Context, c engine_context.Rowser) {
-	if b.middlewares.Len() == 0 {
-		return
-	}
-	c.(engine_context.WithMiddlewarer).SetMiddlewares(b.middlewares)
-	c.Next(ctx)
-}
+type RowsNextMW = Chain[engine_context.RowsNexter]
 
-func (b RowsNextMW) Copy() *RowsNextMW {
-	r := NewRowsNextMW()
-	r.middlewares.PushBackList(b.middlewares)
-	return r
-}
-
-func rowsNextPackageFunc(w RowsNextHandler) engine_context.MiddlewareFunc {
-	return func(ctx context.Context, er engine_context.Er) {
-		w(ctx, er.(engine_context.RowsNexter))
-	}
+func NewRowsNextMW() *RowsNextMW {
+	return NewChain(RowsNextChain)
 }
@@ -16,12 +16,10 @@
 package engine_ware
 
 import (
-	"container/list"
-	"context"
 	"github.com/wojnosystems/vsql_engine/engine_context"
 )
 
-type StatementCloseHandler func(ctx context.Context, c engine_context.StatementCloser)
+type StatementCloseHandler = engine_context.StatementCloseHandler
 
 // Middleware for begin
 type StatementCloseAdder interface {
@@ -33,41 +31,11 @@ type StatementCloseWare interface {
 	StatementCloseMW() StatementCloseAdder
 }
 
-type StatementCloseMW struct {
-	middlewares *list.List // StatementCloseHandler
-}
-
-func NewStatementCloseMW() *StatementCloseMW {
-	return &StatementCloseMW{
-		middlewares: list.New(),
-	}
-}
-
-func (b *StatementCloseMW) Append(w StatementCloseHandler) {
-	b.middlewares.PushBack(statementClosePackageFunc(w))
-}
+// StatementCloseChain identifies the StatementClose chain to Attach
+var StatementCloseChain = NewChainKey[engine_context.StatementCloser]("StatementClose")
 
-func (b *StatementCloseMW) Prepend(w StatementCloseHandler) {
-	b.middlewares.PushFront(statementClosePackageFunc(w))
-}
-
-// PerformMiddleware executes the middleware after injecting engine_context (if any)
-func (b *StatementCloseMW) PerformMiddleware(ctx context.Context, c engine_context.StatementCloser) {
-	if b.middlewares.Len() == 0 {
-		return
-	}
-	c.(engine_context.WithMiddlewarer).SetMiddlewares(b.middlewares)
-	c.Next(ctx)
-}
+type StatementCloseMW = Chain[engine_context.StatementCloser]
 
-func (b StatementCloseMW) Copy() *StatementCloseMW {
-	r := NewStatementCloseMW()
-	r.middlewares.PushBackList(b.middlewares)
-	return r
-}
-
-func statementClosePackageFunc(w StatementCloseHandler) engine_context.MiddlewareFunc {
-	return func(ctx context.Context, er engine_context.Er) {
-		w(ctx, er.(engine_context.StatementCloser))
-	}
+func NewStatementCloseMW() *StatementCloseMW {
+	return NewChain(StatementCloseChain)
 }
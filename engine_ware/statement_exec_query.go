@@ -16,12 +16,10 @@
 package engine_ware
 
 import (
-	"container/list"
-	"context"
 	"github.com/wojnosystems/vsql_engine/engine_context"
 )
 
-type StatementExecQueryHandler func(ctx context.Context, c engine_context.StatementExecQueryer)
+type StatementExecQueryHandler = engine_context.StatementExecQueryHandler
 
 // Middleware for begin
 type StatementExecQueryAdder interface {
@@ -33,41 +31,11 @@ type StatementExecQueryWare interface {
 	StatementExecQueryMW() StatementExecQueryAdder
 }
 
-type StatementExecQueryMW struct {
-	middlewares *list.List // StatementExecQueryHandler
-}
-
-func NewStatementExecQueryMW() *StatementExecQueryMW {
-	return &StatementExecQueryMW{
-		middlewares: list.New(),
-	}
-}
-
-func (b *StatementExecQueryMW) Append(w StatementExecQueryHandler) {
-	b.middlewares.PushBack(statementExecQueryPackageFunc(w))
-}
+// StatementExecQueryChain identifies the StatementExecQuery chain to Attach
+var StatementExecQueryChain = NewChainKey[engine_context.StatementExecQueryer]("StatementExecQuery")
 
-func (b *StatementExecQueryMW) Prepend(w StatementExecQueryHandler) {
-	b.middlewares.PushFront(statementExecQueryPackageFunc(w))
-}
-
-// PerformMiddleware executes the middleware after injecting engine_context (if any)
-func (b *StatementExecQueryMW) PerformMiddleware(ctx context.Context, c engine_context.StatementExecQueryer) {
-	if b.middlewares.Len() == 0 {
-		return
-	}
-	c.(engine_context.WithMiddlewarer).SetMiddlewares(b.middlewares)
-	c.Next(ctx)
-}
+type StatementExecQueryMW = Chain[engine_context.StatementExecQueryer]
 
-func (b StatementExecQueryMW) Copy() *StatementExecQueryMW {
-	r := NewStatementExecQueryMW()
-	r.middlewares.PushBackList(b.middlewares)
-	return r
-}
-
-func statementExecQueryPackageFunc(w StatementExecQueryHandler) engine_context.MiddlewareFunc {
-	return func(ctx context.Context, er engine_context.Er) {
-		w(ctx, er.(engine_context.StatementExecQueryer))
-	}
+func NewStatementExecQueryMW() *StatementExecQueryMW {
+	return NewChain(StatementExecQueryChain)
 }
@@ -16,12 +16,10 @@
 package engine_ware
 
 import (
-	"container/list"
-	"context"
 	"github.com/wojnosystems/vsql_engine/engine_context"
 )
 
-type StatementInsertQueryHandler func(ctx context.Context, c engine_context.StatementInsertQueryer)
+type StatementInsertQueryHandler = engine_context.StatementInsertQueryHandler
 
 // Middleware for begin
 type StatementInsertQueryAdder interface {
@@ -33,41 +31,11 @@ type StatementInsertQueryWare interface {
 	StatementInsertQueryMW() StatementInsertQueryAdder
 }
 
-type StatementInsertQueryMW struct {
-	middlewares *list.List // StatementInsertQueryHandler
-}
-
-func NewStatementInsertQueryMW() *StatementInsertQueryMW {
-	return &StatementInsertQueryMW{
-		middlewares: list.New(),
-	}
-}
-
-func (b *StatementInsertQueryMW) Append(w StatementInsertQueryHandler) {
-	b.middlewares.PushBack(statementInsertQueryPackageFunc(w))
-}
+// StatementInsertQueryChain identifies the StatementInsertQuery chain to Attach
+var StatementInsertQueryChain = NewChainKey[engine_context.StatementInsertQueryer]("StatementInsertQuery")
 
-func (b *StatementInsertQueryMW) Prepend(w StatementInsertQueryHandler) {
-	b.middlewares.PushFront(statementInsertQueryPackageFunc(w))
-}
-
-// PerformMiddleware executes the middleware after injecting engine_context (if any)
-func (b *StatementInsertQueryMW) PerformMiddleware(ctx context.Context, c engine_context.StatementInsertQueryer) {
-	if b.middlewares.Len() == 0 {
-		return
-	}
-	c.(engine_context.WithMiddlewarer).SetMiddlewares(b.middlewares)
-	c.Next(ctx)
-}
+type StatementInsertQueryMW = Chain[engine_context.StatementInsertQueryer]
 
-func (b StatementInsertQueryMW) Copy() *StatementInsertQueryMW {
-	r := NewStatementInsertQueryMW()
-	r.middlewares.PushBackList(b.middlewares)
-	return r
-}
-
-func statementInsertQueryPackageFunc(w StatementInsertQueryHandler) engine_context.MiddlewareFunc {
-	return func(ctx context.Context, er engine_context.Er) {
-		w(ctx, er.(engine_context.StatementInsertQueryer))
-	}
+func NewStatementInsertQueryMW() *StatementInsertQueryMW {
+	return NewChain(StatementInsertQueryChain)
 }
@@ -16,12 +16,10 @@
 package engine_ware
 
 import (
-	"container/list"
-	"context"
 	"github.com/wojnosystems/vsql_engine/engine_context"
 )
 
-type StatementQueryHandler func(ctx context.Context, c engine_context.StatementQueryer)
+type StatementQueryHandler = engine_context.StatementQueryHandler
 
 // Middleware for begin
 type StatementQueryAdder interface {
@@ -33,41 +31,11 @@ type StatementQueryWare interface {
 	StatementQueryMW() StatementQueryAdder
 }
 
-type StatementQueryMW struct {
-	middlewares *list.List // StatementQueryHandler
-}
-
-func NewStatementQueryMW() *StatementQueryMW {
-	return &StatementQueryMW{
-		middlewares: list.New(),
-	}
-}
-
-func (b *StatementQueryMW) Append(w StatementQueryHandler) {
-	b.middlewares.PushBack(statementQueryPackageFunc(w))
-}
+// StatementQueryChain identifies the StatementQuery chain to Attach
+var StatementQueryChain = NewChainKey[engine_context.StatementQueryer]("StatementQuery")
 
-func (b *StatementQueryMW) Prepend(w StatementQueryHandler) {
-	b.middlewares.PushFront(statementQueryPackageFunc(w))
-}
-
-// PerformMiddleware executes the middleware after injecting engine_context (if any)
-func (b *StatementQueryMW) PerformMiddleware(ctx context.Context, c engine_context.StatementQueryer) {
-	if b.middlewares.Len() == 0 {
-		return
-	}
-	c.(engine_context.WithMiddlewarer).SetMiddlewares(b.middlewares)
-	c.Next(ctx)
-}
+type StatementQueryMW = Chain[engine_context.StatementQueryer]
 
-func (b StatementQueryMW) Copy() *StatementQueryMW {
-	r := NewStatementQueryMW()
-	r.middlewares.PushBackList(b.middlewares)
-	return r
-}
-
-func statementQueryPackageFunc(w StatementQueryHandler) engine_context.MiddlewareFunc {
-	return func(ctx context.Context, er engine_context.Er) {
-		w(ctx, er.(engine_context.StatementQueryer))
-	}
+func NewStatementQueryMW() *StatementQueryMW {
+	return NewChain(StatementQueryChain)
 }
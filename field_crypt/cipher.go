@@ -0,0 +1,127 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package field_crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Mode is how the values of a column are encrypted
+type Mode int
+
+const (
+	// Randomized encrypts each value with a random nonce, so equal values are not equal once encrypted
+	Randomized Mode = iota
+	// Deterministic encrypts equal values with the same key to equal ciphertexts, so that equality comparisons and
+	// unique indexes keep working. It reveals which rows have equal values. Deterministic values are always
+	// encrypted with the key Encryptor.DeterministicKeyID names, so that they still compare equal after the current
+	// key is rotated.
+	Deterministic
+)
+
+// ErrDecrypt is returned when a value of an encrypted column cannot be decrypted
+type ErrDecrypt struct {
+	Column string
+	Reason string
+}
+
+func (e ErrDecrypt) Error() string {
+	return fmt.Sprintf("field_crypt: column \"%s\" cannot be decrypted: %s", e.Column, e.Reason)
+}
+
+// Encrypted values are stored as text: the ID of the key, a separator, then the base64 nonce and sealed value
+const keyIDSeparator = ":"
+
+// newAEAD creates the cipher for key. The AES key and the key deterministic nonces are made with are both derived
+// from key, so neither is used for two purposes.
+func newAEAD(key []byte) (aead cipher.AEAD, nonceKey []byte, err error) {
+	block, err := aes.NewCipher(derive(key, "field_crypt encryption"))
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err = cipher.NewGCM(block)
+	return aead, derive(key, "field_crypt nonce"), err
+}
+
+func derive(key []byte, purpose string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(purpose))
+	return h.Sum(nil)
+}
+
+// encrypt seals plaintext with the key with the given ID, or the current key if id is "". Deterministic nonces are a
+// keyed hash of the plaintext, so equal plaintexts share a nonce only when they are the same value.
+func encrypt(keys KeyProvider, id string, mode Mode, plaintext []byte) (string, error) {
+	var key []byte
+	var err error
+	if id == "" {
+		id, key, err = keys.CurrentKey()
+	} else {
+		key, err = keys.Key(id)
+	}
+	if err != nil {
+		return "", err
+	}
+	aead, nonceKey, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if mode == Deterministic {
+		h := hmac.New(sha256.New, nonceKey)
+		_, _ = h.Write(plaintext)
+		copy(nonce, h.Sum(nil))
+	} else if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, nil)
+	return id + keyIDSeparator + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt opens a value made by encrypt, with the key it names
+func decrypt(keys KeyProvider, column string, ciphertext string) ([]byte, error) {
+	i := strings.Index(ciphertext, keyIDSeparator)
+	if i < 0 {
+		return nil, &ErrDecrypt{Column: column, Reason: "the value is not encrypted"}
+	}
+	key, err := keys.Key(ciphertext[:i])
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext[i+1:])
+	if err != nil {
+		return nil, &ErrDecrypt{Column: column, Reason: err.Error()}
+	}
+	aead, _, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, &ErrDecrypt{Column: column, Reason: "the value is too short"}
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, &ErrDecrypt{Column: column, Reason: err.Error()}
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,330 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package field_crypt
+
+import (
+	"github.com/wojnosystems/vsql_engine/sql_lexer"
+)
+
+// use is how a placeholder's value is used with the column it is bound to
+type use uint8
+
+const (
+	// unknown is for values that could not be tied to a column, nor shown to be unrelated to every column
+	unknown use = iota
+	// unbound is for values known to not be bound to a column, such as the value of LIMIT ?
+	unbound
+	// stored is for values stored in the column
+	stored
+	// compared is for values compared with the column for equality
+	compared
+	// matched is for patterns the column is matched against, such as with LIKE
+	matched
+	// transformed is for values combined with the column, such as by a function or an ordering comparison, where
+	// the database would operate on the ciphertext
+	transformed
+)
+
+// equalities are the operators a value may be compared to a column with
+var equalities = map[string]bool{"=": true, "<>": true, "!=": true, "<=>": true}
+
+// orderings are the operators that order a value and a column
+var orderings = map[string]bool{"<": true, ">": true, "<=": true, ">=": true}
+
+// patterns are the operators that match a column against a pattern
+var patterns = []string{"like", "ilike", "rlike", "regexp"}
+
+// binding is the column a placeholder's value is bound to
+type binding struct {
+	// Column is the name of the column, or "" if the value is not bound to a column or its column is unknown
+	Column string
+	Use    use
+}
+
+// boundColumns returns, for each placeholder of sqlQuery in order, the column the placeholder's value is bound to.
+// Values are stored in a column when they are:
+//   - in the VALUES of an INSERT with a column list, such as INSERT INTO users (email) VALUES (?)
+//   - assigned to it in a SET clause, such as SET email = ?
+//
+// compared with a column when they are:
+//   - compared to it, such as email = ? or ? = email
+//   - in an IN list, such as email IN (?, ?)
+//
+// matched with a column for email LIKE ?, and transformed when they are part of an expression stored in or
+// compared with it, such as SET email = LOWER(?) or email > ?. Other values are unknown, except for the values of
+// LIMIT, OFFSET and FETCH, which are unbound.
+func boundColumns(sqlQuery string) (bindings []binding) {
+	s := &scanner{tokens: sql_lexer.Significant(sql_lexer.Lex(sqlQuery))}
+	tokens := s.tokens
+	isInsert := len(tokens) > 0 && (tokens[0].IsWord("insert") || tokens[0].IsWord("replace"))
+	for i, t := range tokens {
+		depth := len(s.parens)
+		switch {
+		case t.IsPunctuation("("):
+			p := paren{subquery: i+1 < len(tokens) && tokens[i+1].IsWord("select")}
+			if i >= 1 && tokens[i-1].IsWord("in") {
+				p.in = columnBefore(tokens, notBefore(tokens, i-1))
+			}
+			if isInsert && depth == 0 && !s.inValues && s.insertColumns == nil {
+				s.insertColumns = identifierList(tokens[i+1:])
+			}
+			s.parens = append(s.parens, p)
+			if depth == 0 {
+				s.position = 0
+			}
+		case t.IsPunctuation(")"):
+			if depth > 0 {
+				s.parens = s.parens[:depth-1]
+			}
+		case t.IsPunctuation(","):
+			if depth == 1 {
+				s.position++
+			}
+			if depth == 0 {
+				s.target = ""
+			}
+		case t.IsWord("values") && depth == 0:
+			s.inValues = isInsert
+		case depth == 0 && s.inValues:
+			// the VALUES tuples are over, as for ON DUPLICATE KEY UPDATE
+			s.inValues = false
+		case depth == 0 && (t.IsWord("set") || t.IsWord("update") && i > 0 && tokens[i-1].IsWord("key")):
+			s.assigning, s.target = true, ""
+		case depth == 0 && (t.IsWord("where") || t.IsWord("from")):
+			s.assigning, s.target = false, ""
+		case depth == 0 && s.assigning && isColumn(t) && i+1 < len(tokens) && tokens[i+1].IsPunctuation("="):
+			s.target = t.Unquoted()
+		case t.Kind == sql_lexer.Placeholder:
+			bindings = append(bindings, s.bind(i))
+		}
+	}
+	return
+}
+
+// paren is an open parenthesis
+type paren struct {
+	// in is the column of the IN list the parenthesis starts, if it starts one
+	in string
+	// subquery is true if the parenthesis starts a sub-query
+	subquery bool
+}
+
+// scanner is the state of boundColumns at a token
+type scanner struct {
+	tokens []sql_lexer.Token
+	// insertColumns is the column list of an INSERT, nil if it has none
+	insertColumns []string
+	inValues      bool
+	// position is the index of the current value in a VALUES tuple
+	position int
+	parens   []paren
+	// assigning is true in a SET clause, where = assigns rather than compares, and target is the column of the
+	// current assignment
+	assigning bool
+	target    string
+}
+
+// bind returns the column the placeholder at tokens[i] is bound to
+func (s *scanner) bind(i int) binding {
+	if isPaging(s.tokens, i) {
+		return binding{Use: unbound}
+	}
+	if b, ok := s.direct(i); ok {
+		return b
+	}
+	// the value is part of an expression
+	for _, p := range s.parens {
+		if p.subquery {
+			return binding{}
+		}
+	}
+	if s.inValues && len(s.parens) > 0 {
+		if s.position < len(s.insertColumns) {
+			return binding{Column: s.insertColumns[s.position], Use: transformed}
+		}
+		return binding{}
+	}
+	if s.assigning && s.target != "" {
+		return binding{Column: s.target, Use: transformed}
+	}
+	return binding{}
+}
+
+// direct returns the binding of the placeholder at tokens[i] if it is a whole operand: a VALUES item, a SET value,
+// an IN list item or the operand of a comparison with a column
+func (s *scanner) direct(i int) (b binding, ok bool) {
+	tokens := s.tokens
+	depth := len(s.parens)
+	listItem := i > 0 && (tokens[i-1].IsPunctuation("(") || tokens[i-1].IsPunctuation(","))
+	listItem = listItem && i+1 < len(tokens) && (tokens[i+1].IsPunctuation(")") || tokens[i+1].IsPunctuation(","))
+	if s.inValues && depth == 1 && listItem {
+		if s.position < len(s.insertColumns) {
+			return binding{Column: s.insertColumns[s.position], Use: stored}, true
+		}
+		return binding{}, true
+	}
+	if depth > 0 && s.parens[depth-1].in != "" && listItem {
+		return binding{Column: s.parens[depth-1].in, Use: compared}, true
+	}
+	// ? op column
+	if i+2 < len(tokens) && startsOperand(tokens, i) {
+		if u, ok := operatorUse(tokens[i+1]); ok {
+			if column := columnAfter(tokens, i+2); column != "" {
+				return binding{Column: column, Use: u}, true
+			}
+		}
+	}
+	if !endsOperand(tokens, i+1) {
+		return binding{}, false
+	}
+	// column BETWEEN ? AND ?
+	between := i - 1
+	if between >= 2 && tokens[between].IsWord("and") && tokens[between-1].Kind == sql_lexer.Placeholder {
+		between -= 2
+	}
+	if between >= 1 && tokens[between].IsWord("between") {
+		if column := columnBefore(tokens, notBefore(tokens, between)); column != "" {
+			return binding{Column: column, Use: transformed}, true
+		}
+	}
+	// column op ?
+	if i >= 2 {
+		op := tokens[i-1]
+		if u, ok := operatorUse(op); ok {
+			at := i - 1
+			if u == matched {
+				at = notBefore(tokens, at)
+			}
+			if column := columnBefore(tokens, at); column != "" {
+				if u == compared && op.Text == "=" && s.assigning && depth == 0 && column == s.target {
+					u = stored
+				}
+				return binding{Column: column, Use: u}, true
+			}
+		}
+	}
+	return binding{}, false
+}
+
+// operatorUse returns how a value is used with a column it is compared with by the operator op
+func operatorUse(op sql_lexer.Token) (use, bool) {
+	switch {
+	case op.Kind == sql_lexer.Punctuation && equalities[op.Text]:
+		return compared, true
+	case op.Kind == sql_lexer.Punctuation && orderings[op.Text]:
+		return transformed, true
+	}
+	for _, p := range patterns {
+		if op.IsWord(p) {
+			return matched, true
+		}
+	}
+	return unknown, false
+}
+
+// isPaging is true if the placeholder at tokens[i] is the value of LIMIT, OFFSET or FETCH
+func isPaging(tokens []sql_lexer.Token, i int) bool {
+	if i == 0 {
+		return false
+	}
+	previous := tokens[i-1]
+	switch {
+	case previous.IsWord("limit"), previous.IsWord("offset"):
+		return true
+	case previous.IsWord("first") || previous.IsWord("next"):
+		return i >= 2 && tokens[i-2].IsWord("fetch")
+	case previous.IsPunctuation(","):
+		// LIMIT ?, ?
+		return i >= 3 && tokens[i-2].Kind == sql_lexer.Placeholder && tokens[i-3].IsWord("limit")
+	}
+	return false
+}
+
+// notBefore returns the index of the NOT before tokens[i], as in NOT LIKE and NOT IN, or i if there is none
+func notBefore(tokens []sql_lexer.Token, i int) int {
+	if i >= 1 && tokens[i-1].IsWord("not") {
+		return i - 1
+	}
+	return i
+}
+
+// columnBefore returns the column that is the whole operand before tokens[i], such as email or u.email, or "" if
+// there is none
+func columnBefore(tokens []sql_lexer.Token, i int) string {
+	j := i - 1
+	if j < 0 || !isColumn(tokens[j]) {
+		return ""
+	}
+	column := tokens[j].Unquoted()
+	for j >= 2 && tokens[j-1].IsPunctuation(".") && isColumn(tokens[j-2]) {
+		j -= 2
+	}
+	if !startsOperand(tokens, j) {
+		return ""
+	}
+	return column
+}
+
+// columnAfter returns the column that is the whole operand starting at tokens[i], or "" if there is none
+func columnAfter(tokens []sql_lexer.Token, i int) string {
+	if i >= len(tokens) || !isColumn(tokens[i]) {
+		return ""
+	}
+	for i+2 < len(tokens) && tokens[i+1].IsPunctuation(".") && isColumn(tokens[i+2]) {
+		i += 2
+	}
+	if !endsOperand(tokens, i+1) {
+		return ""
+	}
+	return tokens[i].Unquoted()
+}
+
+// startsOperand is true if an operand starts at tokens[i]: it is the first token or follows a separator or keyword
+func startsOperand(tokens []sql_lexer.Token, i int) bool {
+	return i == 0 || isSeparator(tokens[i-1])
+}
+
+// endsOperand is true if an operand ends before tokens[i]: it is past the end or a separator or keyword
+func endsOperand(tokens []sql_lexer.Token, i int) bool {
+	return i >= len(tokens) || isSeparator(tokens[i])
+}
+
+func isSeparator(t sql_lexer.Token) bool {
+	return t.IsPunctuation("(") || t.IsPunctuation(")") || t.IsPunctuation(",") || t.IsPunctuation(";") || t.IsKeyword()
+}
+
+// identifierList reads the names of a parenthesized list of identifiers, such as an INSERT's column list, from the
+// tokens after the opening parenthesis. It returns nil if the list contains anything else.
+func identifierList(tokens []sql_lexer.Token) (names []string) {
+	for i := 0; i < len(tokens); i += 2 {
+		if !isColumn(tokens[i]) || i+1 >= len(tokens) {
+			return nil
+		}
+		names = append(names, tokens[i].Unquoted())
+		if tokens[i+1].IsPunctuation(")") {
+			return names
+		}
+		if !tokens[i+1].IsPunctuation(",") {
+			return nil
+		}
+	}
+	return nil
+}
+
+// isColumn is true for tokens that may name a column: identifiers that are not keywords
+func isColumn(t sql_lexer.Token) bool {
+	return t.Kind == sql_lexer.QuotedIdentifier || t.Kind == sql_lexer.Word && !t.IsKeyword()
+}
@@ -0,0 +1,334 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package field_crypt
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/fingerprint"
+	"github.com/wojnosystems/vsql_engine/internal/columnrule"
+	"github.com/wojnosystems/vsql_engine/query_rewrite"
+	"reflect"
+	"strings"
+)
+
+// field_crypt encrypts the values of configured columns before they are sent to the database and decrypts them as
+// they are read back, so the database only ever holds ciphertext.
+
+// ErrUnsupportedType is returned when the value of an encrypted column is not text. Values bound to encrypted
+// columns must be strings or []byte, and encrypted columns must be scanned into a *string, *[]byte, *sql.NullString,
+// *sql.RawBytes or *interface{}.
+type ErrUnsupportedType struct {
+	Column string
+	Type   string
+}
+
+func (e ErrUnsupportedType) Error() string {
+	return fmt.Sprintf("field_crypt: column \"%s\" is encrypted and cannot hold a %s", e.Column, e.Type)
+}
+
+// ErrRandomizedComparison is returned when a value is compared with a Randomized column, such as in WHERE ssn = ?.
+// Randomized values are never equal once encrypted, so the comparison would never match. Compare with Deterministic
+// columns instead.
+type ErrRandomizedComparison struct {
+	Column string
+}
+
+func (e ErrRandomizedComparison) Error() string {
+	return fmt.Sprintf("field_crypt: column \"%s\" is Randomized and cannot be compared with a value", e.Column)
+}
+
+// ErrUnsupportedUse is returned when a value is used with an encrypted column in a way encryption does not allow,
+// such as LOWER(?), LIKE ? or an ordering comparison: the database would operate on the ciphertext.
+type ErrUnsupportedUse struct {
+	Column string
+}
+
+func (e ErrUnsupportedUse) Error() string {
+	return fmt.Sprintf("field_crypt: column \"%s\" is encrypted and can only store values or compare them for equality", e.Column)
+}
+
+// ErrUnknownBinding is returned when the column a value is bound to cannot be worked out, such as in
+// INSERT INTO users VALUES (?), and the query is of a table with encrypted columns. The value may belong in an
+// encrypted column, so it is not sent in plaintext. Name the columns of INSERTs and compare values with columns
+// directly.
+type ErrUnknownBinding struct {
+	// Placeholder is the position of the value's placeholder in the query, starting at 1
+	Placeholder int
+}
+
+func (e ErrUnknownBinding) Error() string {
+	return fmt.Sprintf("field_crypt: the column of placeholder %d is unknown and may be encrypted", e.Placeholder)
+}
+
+// ErrNoDeterministicKey is returned when a value of a Deterministic column is encrypted and
+// Encryptor.DeterministicKeyID is not set
+var ErrNoDeterministicKey = errors.New("field_crypt: DeterministicKeyID must be set to encrypt Deterministic columns")
+
+// Encryptor encrypts and decrypts the configured columns. Configure the exported fields before calling Install.
+type Encryptor struct {
+	Keys KeyProvider
+	// Columns are the encrypted columns and how they are encrypted. Keys are either a column name, such as "email",
+	// or a table and column, such as "users.email", which only applies to queries of the table. Names are matched
+	// without regard to case.
+	Columns map[string]Mode
+	// DeterministicKeyID is the ID of the key Deterministic columns are encrypted with. It must not change when the
+	// current key is rotated: values encrypted with another key would no longer compare equal to the values stored.
+	DeterministicKeyID string
+
+	openRows columnrule.OpenRows
+}
+
+// New creates an Encryptor using keys, with no encrypted columns
+func New(keys KeyProvider) *Encryptor {
+	return &Encryptor{
+		Keys:    keys,
+		Columns: make(map[string]Mode),
+	}
+}
+
+// Install prepends the encryptor to the engine. Values are encrypted when they are inserted, assigned to or
+// compared with an encrypted column in Exec, Insert and Query, and in prepared statements. Encrypted columns of the
+// rows read are decrypted as they are scanned.
+func (e *Encryptor) Install(engine vsql_engine.SQLQueryer) {
+	e.openRows.Install(engine)
+	engine.ExecQueryMW().Prepend(func(ctx context.Context, c engine_context.Execer) {
+		e.encryptQuery(ctx, c)
+	})
+	engine.InsertQueryMW().Prepend(func(ctx context.Context, c engine_context.Inserter) {
+		e.encryptQuery(ctx, c)
+	})
+	engine.QueryMW().Prepend(func(ctx context.Context, c engine_context.Queryer) {
+		e.encryptQuery(ctx, c)
+	})
+	engine.StatementExecQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		e.encryptStatement(ctx, c)
+	})
+	engine.StatementInsertQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementInsertQueryer) {
+		e.encryptStatement(ctx, c)
+	})
+	engine.StatementQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementQueryer) {
+		e.encryptStatement(ctx, c)
+	})
+	engine.RowsNextMW().Prepend(func(ctx context.Context, c engine_context.RowsNexter) {
+		c.Next(ctx)
+		if c.Row() == nil {
+			return
+		}
+		tables, _ := e.openRows.Tables(c.Rows())
+		var encrypted []bool
+		for i, column := range c.Row().Columns() {
+			if _, ok := e.mode(column, tables); ok {
+				if encrypted == nil {
+					encrypted = make([]bool, len(c.Row().Columns()))
+				}
+				encrypted[i] = true
+			}
+		}
+		if encrypted != nil {
+			c.SetRow(&decryptingRow{Rower: c.Row(), keys: e.Keys, encrypted: encrypted})
+		}
+	})
+}
+
+// encryptQuery encrypts the values of the call's query that are bound to encrypted columns
+func (e *Encryptor) encryptQuery(ctx context.Context, c engine_context.CommonQueryer) {
+	if c.Query() != nil && len(e.Columns) != 0 {
+		sqlQuery, params, err := query_rewrite.Positional(c.Query())
+		if err == nil {
+			var changed bool
+			changed, err = e.encryptParams(sqlQuery, c.Fingerprint(), params)
+			if changed {
+				c.SetQuery(query_rewrite.New(sqlQuery, params))
+			}
+		}
+		if err != nil {
+			c.SetError(err)
+			return
+		}
+	}
+	c.Next(ctx)
+}
+
+// statementContext is the part of the statement contexts encryptStatement needs
+type statementContext interface {
+	engine_context.Er
+	SetParameterer(vparam.Parameterer)
+	Parameterer() vparam.Parameterer
+	Query() vparam.Queryer
+	Fingerprint() fingerprint.Fingerprinter
+}
+
+// encryptStatement encrypts the values used with a prepared statement that are bound to encrypted columns
+func (e *Encryptor) encryptStatement(ctx context.Context, c statementContext) {
+	if c.Query() != nil && c.Parameterer() != nil && len(e.Columns) != 0 {
+		params, err := query_rewrite.PositionalParameters(c.Query().SQLQueryUnInterpolated(), c.Parameterer())
+		if err == nil {
+			var changed bool
+			changed, err = e.encryptParams(query_rewrite.PositionalSQL(c.Query()), c.Fingerprint(), params)
+			if changed {
+				c.SetParameterer(query_rewrite.Parameters(params))
+			}
+		}
+		if err != nil {
+			c.SetError(err)
+			return
+		}
+	}
+	c.Next(ctx)
+}
+
+// encryptParams encrypts, in place, the params of the positional sqlQuery that are bound to encrypted columns
+func (e *Encryptor) encryptParams(sqlQuery string, f fingerprint.Fingerprinter, params []interface{}) (changed bool, err error) {
+	var tables []string
+	if f != nil {
+		tables = f.Tables()
+	}
+	for i, bound := range boundColumns(sqlQuery) {
+		if i >= len(params) || params[i] == nil || bound.Use == unbound {
+			continue
+		}
+		column := bound.Column
+		if column == "" {
+			if e.hasEncryptedColumns(tables) {
+				return changed, &ErrUnknownBinding{Placeholder: i + 1}
+			}
+			continue
+		}
+		mode, ok := e.mode(column, tables)
+		if !ok {
+			continue
+		}
+		keyID := ""
+		switch {
+		case mode == Randomized && (bound.Use == compared || bound.Use == matched):
+			return changed, &ErrRandomizedComparison{Column: column}
+		case bound.Use == matched || bound.Use == transformed:
+			return changed, &ErrUnsupportedUse{Column: column}
+		case mode == Deterministic && e.DeterministicKeyID == "":
+			return changed, ErrNoDeterministicKey
+		case mode == Deterministic:
+			keyID = e.DeterministicKeyID
+		}
+		var plaintext []byte
+		switch v := params[i].(type) {
+		case string:
+			plaintext = []byte(v)
+		case []byte:
+			plaintext = v
+		default:
+			return changed, &ErrUnsupportedType{Column: column, Type: reflect.TypeOf(v).String()}
+		}
+		if params[i], err = encrypt(e.Keys, keyID, mode, plaintext); err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+	return
+}
+
+// hasEncryptedColumns is true if a query of tables may use encrypted columns: a column is encrypted in every table
+// or in one of tables
+func (e *Encryptor) hasEncryptedColumns(tables []string) bool {
+	for key := range e.Columns {
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			return true
+		}
+		for _, table := range tables {
+			if columnrule.TableMatches(key[:i], table) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mode returns how column is encrypted in the results of a query of tables, and whether it is encrypted
+func (e *Encryptor) mode(column string, tables []string) (Mode, bool) {
+	return columnrule.Lookup(e.Columns, column, tables, false)
+}
+
+// decryptingRow decrypts the encrypted columns of a row as they are scanned
+type decryptingRow struct {
+	vrows.Rower
+	keys      KeyProvider
+	encrypted []bool
+}
+
+func (r *decryptingRow) Scan(destination ...interface{}) error {
+	// encrypted columns are scanned as text, then decrypted into the caller's destination
+	scanned := make([]interface{}, len(destination))
+	copy(scanned, destination)
+	texts := make([]sql.NullString, len(destination))
+	for i := range destination {
+		if i < len(r.encrypted) && r.encrypted[i] {
+			scanned[i] = &texts[i]
+		}
+	}
+	if err := r.Rower.Scan(scanned...); err != nil {
+		return err
+	}
+	var columns []string
+	for i, d := range destination {
+		if i >= len(r.encrypted) || !r.encrypted[i] {
+			continue
+		}
+		if columns == nil {
+			columns = r.Columns()
+		}
+		var plaintext []byte
+		if texts[i].Valid {
+			var err error
+			if plaintext, err = decrypt(r.keys, columns[i], texts[i].String); err != nil {
+				return err
+			}
+		}
+		if !assign(d, plaintext, texts[i].Valid) {
+			return &ErrUnsupportedType{Column: columns[i], Type: reflect.TypeOf(d).String()}
+		}
+	}
+	return nil
+}
+
+// assign stores the decrypted value into destination. It returns false if destination cannot hold text.
+func assign(destination interface{}, plaintext []byte, valid bool) bool {
+	switch d := destination.(type) {
+	case *string:
+		*d = string(plaintext)
+	case *[]byte:
+		*d = plaintext
+	case *sql.RawBytes:
+		*d = plaintext
+	case *sql.NullString:
+		*d = sql.NullString{String: string(plaintext), Valid: valid}
+	case *interface{}:
+		if valid {
+			*d = string(plaintext)
+		} else {
+			*d = nil
+		}
+	default:
+		return false
+	}
+	return true
+}
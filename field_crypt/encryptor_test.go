@@ -0,0 +1,273 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package field_crypt
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/internal/testdriver"
+	"github.com/wojnosystems/vsql_engine/query_rewrite"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newEngine(keys ...string) (vsql_engine.SingleTXer, *testdriver.Driver, *Encryptor) {
+	e := vsql_engine.NewSingle()
+	d := testdriver.Install(e)
+	enc := New(staticKeys(keys))
+	enc.DeterministicKeyID = keys[0]
+	enc.Install(e)
+	return e, d, enc
+}
+
+// lastParams returns the values of the last call that reached the driver
+func lastParams(d *testdriver.Driver) []interface{} {
+	calls := d.Calls()
+	return calls[len(calls)-1].Params
+}
+
+// staticKeys is a KeyProvider of keys held in memory. The last key is the current key.
+type staticKeys []string
+
+func (k staticKeys) CurrentKey() (string, []byte, error) {
+	key, _ := k.Key(k[len(k)-1])
+	return k[len(k)-1], key, nil
+}
+
+func (k staticKeys) Key(id string) ([]byte, error) {
+	for _, known := range k {
+		if known == id {
+			return []byte(strings.Repeat(id, MinKeySize)), nil
+		}
+	}
+	return nil, &ErrUnknownKey{ID: id}
+}
+
+func TestEncryptor_RoundTrip(t *testing.T) {
+	e, d, enc := newEngine("k1")
+	enc.Columns["email"] = Deterministic
+	enc.Columns["users.ssn"] = Randomized
+	ctx := context.Background()
+
+	_, err := e.Insert(ctx, vparam.NewAppendWithData("INSERT INTO users (id, email, ssn) VALUES (?, ?, ?)", 7, "bob@example.com", []byte("123-45-6789")))
+	assert.NoError(t, err)
+	stored := lastParams(d)
+	assert.Equal(t, 7, stored[0])
+	assert.True(t, strings.HasPrefix(stored[1].(string), "k1:"))
+	assert.True(t, strings.HasPrefix(stored[2].(string), "k1:"))
+	assert.NotContains(t, stored[1], "bob")
+
+	d.Columns = []string{"id", "email", "ssn", "note"}
+	d.Rows = [][]interface{}{append(stored, nil)}
+	rows, err := e.Query(ctx, vparam.New("SELECT id, email, ssn, note FROM users"))
+	assert.NoError(t, err)
+	var id int
+	var email string
+	var ssn []byte
+	var note interface{}
+	assert.NoError(t, rows.Next().Scan(&id, &email, &ssn, &note))
+	assert.NoError(t, rows.Close())
+	assert.Equal(t, 7, id)
+	assert.Equal(t, "bob@example.com", email)
+	assert.Equal(t, []byte("123-45-6789"), ssn)
+	assert.Nil(t, note)
+}
+
+func TestEncryptor_Deterministic(t *testing.T) {
+	e, d, enc := newEngine("k1")
+	enc.Columns["email"] = Deterministic
+	enc.Columns["ssn"] = Randomized
+	ctx := context.Background()
+
+	update := vparam.NewNamedWithData("UPDATE users SET ssn = :ssn WHERE email = :email",
+		map[string]interface{}{"ssn": "123-45-6789", "email": "bob@example.com"})
+	_, err := e.Exec(ctx, update)
+	assert.NoError(t, err)
+	first := lastParams(d)
+	_, err = e.Exec(ctx, update)
+	assert.NoError(t, err)
+	assert.Equal(t, first[1], lastParams(d)[1], "deterministic values can be compared")
+	assert.NotEqual(t, first[0], lastParams(d)[0], "randomized values differ each time")
+
+	_, err = e.Exec(ctx, vparam.NewAppendWithData("DELETE FROM users WHERE email IN (?, ?)", "bob@example.com", "amy@example.com"))
+	assert.NoError(t, err)
+	assert.Equal(t, first[1], lastParams(d)[0])
+}
+
+func TestEncryptor_DeterministicKeySurvivesRotation(t *testing.T) {
+	e, d, enc := newEngine("k1")
+	enc.Columns["email"] = Deterministic
+	ctx := context.Background()
+
+	_, err := e.Exec(ctx, vparam.NewAppendWithData("DELETE FROM users WHERE email = ?", "bob@example.com"))
+	assert.NoError(t, err)
+	before := lastParams(d)[0]
+	enc.Keys = staticKeys{"k1", "k2"}
+	_, err = e.Exec(ctx, vparam.NewAppendWithData("DELETE FROM users WHERE email = ?", "bob@example.com"))
+	assert.NoError(t, err)
+	assert.Equal(t, before, lastParams(d)[0])
+
+	enc.DeterministicKeyID = ""
+	_, err = e.Exec(ctx, vparam.NewAppendWithData("DELETE FROM users WHERE email = ?", "bob@example.com"))
+	assert.Equal(t, ErrNoDeterministicKey, err)
+}
+
+func TestEncryptor_RandomizedComparison(t *testing.T) {
+	e, d, enc := newEngine("k1")
+	enc.Columns["ssn"] = Randomized
+	ctx := context.Background()
+
+	for _, query := range []string{
+		"SELECT id FROM users WHERE ssn = ?",
+		"UPDATE users SET name = 'x' WHERE ssn <> ?",
+		"DELETE FROM users WHERE ssn IN (?)",
+		"UPDATE users SET id = (SELECT id FROM old WHERE ssn = ?)",
+	} {
+		_, err := e.Exec(ctx, vparam.NewAppendWithData(query, "123-45-6789"))
+		assert.Equal(t, &ErrRandomizedComparison{Column: "ssn"}, err, query)
+	}
+	assert.Empty(t, d.Calls())
+
+	_, err := e.Exec(ctx, vparam.NewAppendWithData("INSERT INTO users (ssn) VALUES (?) ON DUPLICATE KEY UPDATE ssn = ?", "1", "2"))
+	assert.NoError(t, err, "assignments are not comparisons")
+}
+
+func TestEncryptor_RefusesUnknownBindings(t *testing.T) {
+	e, d, enc := newEngine("k1")
+	enc.Columns["email"] = Randomized
+	enc.Columns["accounts.iban"] = Deterministic
+	ctx := context.Background()
+
+	for query, expected := range map[string]error{
+		"INSERT INTO users VALUES (?)":              &ErrUnknownBinding{Placeholder: 1},
+		"INSERT INTO users (email) SELECT ?":        &ErrUnknownBinding{Placeholder: 1},
+		"UPDATE users SET email = LOWER(?)":         &ErrUnsupportedUse{Column: "email"},
+		"SELECT * FROM users WHERE ? = email":       &ErrRandomizedComparison{Column: "email"},
+		"SELECT * FROM users WHERE email LIKE ?":    &ErrRandomizedComparison{Column: "email"},
+		"SELECT * FROM users WHERE email > ?":       &ErrUnsupportedUse{Column: "email"},
+		"SELECT * FROM accounts WHERE iban LIKE ?":  &ErrUnsupportedUse{Column: "iban"},
+		"SELECT * FROM accounts WHERE id = ? + 1":   &ErrUnknownBinding{Placeholder: 1},
+		"UPDATE accounts SET iban = CONCAT(?, 'x')": &ErrUnsupportedUse{Column: "iban"},
+	} {
+		_, err := e.Exec(ctx, vparam.NewAppendWithData(query, "bob@example.com"))
+		assert.Equal(t, expected, err, query)
+	}
+	assert.Empty(t, d.Calls(), "expected no plaintext to reach the driver")
+
+	enc.Columns = map[string]Mode{"accounts.iban": Deterministic}
+	_, err := e.Exec(ctx, vparam.NewAppendWithData("INSERT INTO logs VALUES (?)", "started"))
+	assert.NoError(t, err, "expected queries of tables without encrypted columns to be left alone")
+	_, err = e.Exec(ctx, vparam.NewAppendWithData("SELECT * FROM accounts WHERE iban = ? LIMIT ?", "DE89", 10))
+	assert.NoError(t, err)
+	if params := lastParams(d); assert.Len(t, params, 2) {
+		assert.True(t, strings.HasPrefix(params[0].(string), "k1:"))
+		assert.Equal(t, 10, params[1])
+	}
+}
+
+func TestEncryptor_Statements(t *testing.T) {
+	e, d, enc := newEngine("k1")
+	enc.Columns["email"] = Deterministic
+	ctx := context.Background()
+
+	stmt, err := e.Prepare(ctx, vparam.New("UPDATE users SET email = :email WHERE id = :id"))
+	assert.NoError(t, err)
+	_, err = stmt.Exec(ctx, vparam.NewNamedData(map[string]interface{}{"email": "bob@example.com", "id": 7}))
+	assert.NoError(t, err)
+	if params := lastParams(d); assert.Len(t, params, 2) {
+		assert.True(t, strings.HasPrefix(params[0].(string), "k1:"))
+		assert.Equal(t, 7, params[1])
+	}
+	_, err = stmt.Exec(ctx, query_rewrite.Parameters([]interface{}{7, 7}))
+	assert.Equal(t, &ErrUnsupportedType{Column: "email", Type: "int"}, err)
+}
+
+func TestEncryptor_UnsupportedType(t *testing.T) {
+	e, _, enc := newEngine("k1")
+	enc.Columns["salary"] = Randomized
+	_, err := e.Exec(context.Background(), vparam.NewAppendWithData("UPDATE users SET salary = ?", 100000))
+	assert.Equal(t, &ErrUnsupportedType{Column: "salary", Type: "int"}, err)
+}
+
+func TestFileKeys_Rotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "field_crypt")
+	assert.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "keys")
+	key := func(b byte) string {
+		return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), 32)))
+	}
+	assert.NoError(t, ioutil.WriteFile(path, []byte("# keys\n2019-01 "+key('a')+"\n"), 0600))
+	keys, err := LoadKeyFile(path)
+	assert.NoError(t, err)
+	old, err := encrypt(keys, "", Randomized, []byte("secret"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(old, "2019-01:"))
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("2019-01 "+key('a')+"\n2019-06 "+key('b')+"\n"), 0600))
+	assert.NoError(t, keys.Reload())
+	rotated, err := encrypt(keys, "", Randomized, []byte("secret"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(rotated, "2019-06:"))
+	for _, ciphertext := range []string{old, rotated} {
+		plaintext, err := decrypt(keys, "c", ciphertext)
+		assert.NoError(t, err)
+		assert.Equal(t, "secret", string(plaintext))
+	}
+
+	_, err = decrypt(keys, "c", "2018-01:"+old[len("2019-01:"):])
+	assert.Equal(t, &ErrUnknownKey{ID: "2018-01"}, err)
+	_, err = decrypt(keys, "c", old[:len(old)-4]+"AAAA")
+	assert.IsType(t, &ErrDecrypt{}, err)
+}
+
+func TestReadKeys_Syntax(t *testing.T) {
+	_, _, err := readKeys(strings.NewReader("k1 c2hvcnQ=\n"))
+	assert.Equal(t, &ErrKeyFileSyntax{Line: 1, Reason: "keys must be at least 16 bytes"}, err)
+	_, _, err = readKeys(strings.NewReader("# no keys\n"))
+	assert.Equal(t, &ErrKeyFileSyntax{Reason: "the file has no keys"}, err)
+}
+
+func TestBoundColumns(t *testing.T) {
+	bound := func(u use) func(string) binding {
+		return func(column string) binding { return binding{Column: column, Use: u} }
+	}
+	stored, compared, matched, transformed := bound(stored), bound(compared), bound(matched), bound(transformed)
+	cases := map[string][]binding{
+		"INSERT INTO users (id, `email`) VALUES (?, LOWER(?)), (?, ?)":                      {stored("id"), transformed("email"), stored("id"), stored("email")},
+		"INSERT INTO users VALUES (?, ?)":                                                   {{}, {}},
+		"INSERT INTO users (email) SELECT ?":                                                {{}},
+		"INSERT INTO users (email) VALUES ((SELECT email FROM old WHERE id = ?))":           {compared("id")},
+		"INSERT INTO users (email) VALUES (?) ON DUPLICATE KEY UPDATE email = ?":            {stored("email"), stored("email")},
+		"UPDATE users u SET u.email = ?, name = ? WHERE id = ? AND ssn IN (?, ?)":           {stored("email"), stored("name"), compared("id"), compared("ssn"), compared("ssn")},
+		"UPDATE users SET a = (SELECT b FROM t WHERE c = ?), d = ?":                         {compared("c"), stored("d")},
+		"UPDATE users SET email = LOWER(?), name = ? || 'x' WHERE id = ?":                   {transformed("email"), transformed("name"), compared("id")},
+		"SELECT * FROM users WHERE email <> ? AND COALESCE(name, ?) = ? AND id > ?":         {compared("email"), {}, {}, transformed("id")},
+		"SELECT * FROM users WHERE ? = u.email OR ? <> ssn AND id NOT IN (?)":               {compared("email"), compared("ssn"), compared("id")},
+		"SELECT * FROM users WHERE email LIKE ? AND name NOT LIKE ? AND id BETWEEN ? AND ?": {matched("email"), matched("name"), transformed("id"), transformed("id")},
+		"SELECT * FROM users WHERE id = ? + 1 ORDER BY id LIMIT ? OFFSET ?":                 {{}, {Use: unbound}, {Use: unbound}},
+		"SELECT * FROM users LIMIT ?, ?":                                                    {{Use: unbound}, {Use: unbound}},
+	}
+	for sqlQuery, expected := range cases {
+		assert.Equal(t, expected, boundColumns(sqlQuery), sqlQuery)
+	}
+}
@@ -0,0 +1,148 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package field_crypt
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// KeyProvider supplies the keys values are encrypted with. Keys are identified by an ID that is stored with each
+// encrypted value, so keys can be rotated: new values use the current key while values encrypted with older keys can
+// still be decrypted. Implementations must be safe for concurrent use.
+type KeyProvider interface {
+	// CurrentKey returns the ID and the key that new values are encrypted with
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key with the given ID
+	Key(id string) (key []byte, err error)
+}
+
+// MinKeySize is the smallest key, in bytes, that may be used
+const MinKeySize = 16
+
+var keyID = regexp.MustCompile("^[A-Za-z0-9_.-]+$")
+
+// ErrUnknownKey is returned when a value was encrypted with a key the KeyProvider does not have
+type ErrUnknownKey struct {
+	ID string
+}
+
+func (e ErrUnknownKey) Error() string {
+	return fmt.Sprintf("field_crypt: unknown key \"%s\"", e.ID)
+}
+
+// ErrKeyFileSyntax is returned when a key file cannot be read
+type ErrKeyFileSyntax struct {
+	Line   int
+	Reason string
+}
+
+func (e ErrKeyFileSyntax) Error() string {
+	return fmt.Sprintf("field_crypt: key file line %d: %s", e.Line, e.Reason)
+}
+
+// FileKeys is a KeyProvider of keys read from a file. Each line of the file is a key ID followed by the base64 key.
+// The last key is the current key, so keys are rotated by appending a new key to the file and calling Reload. Blank
+// lines and lines starting with # are ignored.
+type FileKeys struct {
+	path    string
+	mu      sync.RWMutex
+	keys    map[string][]byte
+	current string
+}
+
+// LoadKeyFile reads the keys in the file at path
+func LoadKeyFile(path string) (*FileKeys, error) {
+	k := &FileKeys{path: path}
+	return k, k.Reload()
+}
+
+// Reload reads the key file again, such as after a key is added to it
+func (k *FileKeys) Reload() error {
+	f, err := os.Open(k.path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	keys, current, err := readKeys(f)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	k.keys, k.current = keys, current
+	k.mu.Unlock()
+	return nil
+}
+
+func (k *FileKeys) CurrentKey() (id string, key []byte, err error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current, k.keys[k.current], nil
+}
+
+func (k *FileKeys) Key(id string) (key []byte, err error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, &ErrUnknownKey{ID: id}
+	}
+	return key, nil
+}
+
+// readKeys reads a key file. The last key read is the current key.
+func readKeys(r io.Reader) (keys map[string][]byte, current string, err error) {
+	keys = make(map[string][]byte)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, "", &ErrKeyFileSyntax{Line: line, Reason: `expected "<key ID> <base64 key>"`}
+		}
+		if !keyID.MatchString(fields[0]) {
+			return nil, "", &ErrKeyFileSyntax{Line: line, Reason: "key IDs may only contain letters, digits, _, . and -"}
+		}
+		if _, ok := keys[fields[0]]; ok {
+			return nil, "", &ErrKeyFileSyntax{Line: line, Reason: fmt.Sprintf("key \"%s\" is repeated", fields[0])}
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, "", &ErrKeyFileSyntax{Line: line, Reason: err.Error()}
+		}
+		if len(key) < MinKeySize {
+			return nil, "", &ErrKeyFileSyntax{Line: line, Reason: fmt.Sprintf("keys must be at least %d bytes", MinKeySize)}
+		}
+		keys[fields[0]] = key
+		current = fields[0]
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, "", err
+	}
+	if current == "" {
+		return nil, "", &ErrKeyFileSyntax{Reason: "the file has no keys"}
+	}
+	return keys, current, nil
+}
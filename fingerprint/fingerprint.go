@@ -0,0 +1,88 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package fingerprint
+
+import (
+	"fmt"
+	"github.com/wojnosystems/vsql_engine/sql_lexer"
+	"hash/fnv"
+)
+
+// fingerprint reduces a SQL query to its "shape": two queries that differ only by their literal values, placeholder
+// style, whitespace, comments or the length of their IN-lists have the same fingerprint. Middleware that needs to
+// group, count, cache or allow queries should key off of the fingerprint rather than the raw SQL.
+
+// Fingerprinter describes the shape of a single SQL query
+type Fingerprinter interface {
+	// Normalized is the query with literals replaced by ?, IN-lists collapsed, comments removed, whitespace
+	// normalized and keywords lower-cased
+	Normalized() string
+	// Hash is a stable, 64-bit FNV-1a hash of Normalized
+	Hash() uint64
+	// ID is Hash as a fixed-width, 16 character hexadecimal string, suitable for use as a map or file key
+	ID() string
+	// StatementType classifies the query as a SELECT, INSERT, UPDATE, DELETE, DDL or other statement
+	StatementType() StatementType
+	// Tables are the names of the tables referenced by the query, in the order they first appear, without quotes.
+	// Schema-qualified names are returned qualified, such as "audit.events". Common table expressions are not tables.
+	Tables() []string
+}
+
+// New parses sqlQuery and returns its fingerprint. It never fails: SQL that it does not understand simply produces a
+// less-normalized fingerprint and an Other StatementType.
+func New(sqlQuery string) Fingerprinter {
+	tokens := sql_lexer.Significant(sql_lexer.Lex(sqlQuery))
+	normalized := normalize(tokens)
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(normalized))
+	return &fingerprint{
+		normalized:    normalized,
+		hash:          h.Sum64(),
+		statementType: classify(tokens),
+		tables:        extractTables(tokens),
+	}
+}
+
+type fingerprint struct {
+	normalized    string
+	hash          uint64
+	statementType StatementType
+	tables        []string
+}
+
+func (f fingerprint) Normalized() string {
+	return f.normalized
+}
+
+func (f fingerprint) Hash() uint64 {
+	return f.hash
+}
+
+func (f fingerprint) ID() string {
+	return fmt.Sprintf("%016x", f.hash)
+}
+
+func (f fingerprint) StatementType() StatementType {
+	return f.statementType
+}
+
+func (f fingerprint) Tables() []string {
+	return f.tables
+}
+
+func (f fingerprint) String() string {
+	return f.normalized
+}
@@ -0,0 +1,132 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package fingerprint
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	cases := map[string]struct {
+		sql      string
+		expected string
+	}{
+		"literals": {
+			sql:      "SELECT * FROM users WHERE name = 'chris' AND age > 21",
+			expected: "select * from users where name = ? and age > ?",
+		},
+		"placeholder styles share a shape": {
+			sql:      "SELECT * FROM users WHERE id = $1 OR id = :id OR id = @p1",
+			expected: "select * from users where id = ? or id = ? or id = ?",
+		},
+		"whitespace and comments": {
+			sql:      "  SELECT\n\t* -- everything\nFROM /* the */ users ;",
+			expected: "select * from users",
+		},
+		"in lists": {
+			sql:      "select id from users where id in (1, 2, 3) and name IN (?)",
+			expected: "select id from users where id in (...) and name in (...)",
+		},
+		"sub-select in list is kept": {
+			sql:      "select id from users where id in (select user_id from admins)",
+			expected: "select id from users where id in (select user_id from admins)",
+		},
+		"multi-row values": {
+			sql:      "INSERT INTO t (a, b) VALUES (1, 'x'), (2, 'y'), (3, 'z')",
+			expected: "insert into t(a, b) values (?, ?)",
+		},
+		"negative numbers": {
+			sql:      "select a - 1 from t where b = -1",
+			expected: "select a - ? from t where b = ?",
+		},
+		"function calls": {
+			sql:      "SELECT COUNT ( * ) FROM t",
+			expected: "select COUNT(*) from t",
+		},
+		"identifiers keep their case": {
+			sql:      "SELECT UserName FROM `Users`",
+			expected: "select UserName from `Users`",
+		},
+	}
+	for caseName, c := range cases {
+		assert.Equal(t, c.expected, Normalize(c.sql), caseName)
+	}
+}
+
+func TestNew_HashIsStable(t *testing.T) {
+	a := New("SELECT * FROM users WHERE id IN (1,2,3)")
+	b := New("select *\nfrom users\nwhere id in (?)")
+	assert.Equal(t, a.Hash(), b.Hash())
+	assert.Equal(t, a.ID(), b.ID())
+	assert.Len(t, a.ID(), 16)
+	c := New("select * from users where name in (?)")
+	assert.NotEqual(t, a.Hash(), c.Hash())
+}
+
+func TestNew_StatementType(t *testing.T) {
+	cases := map[string]StatementType{
+		"SELECT 1":                             Select,
+		"(SELECT 1) UNION (SELECT 2)":          Select,
+		"insert into t values (1)":             Insert,
+		"REPLACE INTO t VALUES (1)":            Insert,
+		"UPDATE t SET a = 1":                   Update,
+		"DELETE FROM t":                        Delete,
+		"CREATE TABLE t (id int)":              DDL,
+		"ALTER TABLE t ADD COLUMN b int":       DDL,
+		"TRUNCATE t":                           DDL,
+		"WITH x AS (SELECT 1) SELECT * FROM x": Select,
+		"WITH x AS (SELECT 1) DELETE FROM t":   Delete,
+		"SET search_path TO tenant":            Other,
+		"":                                     Other,
+	}
+	for sql, expected := range cases {
+		assert.Equal(t, expected, New(sql).StatementType(), sql)
+	}
+}
+
+func TestNew_Tables(t *testing.T) {
+	cases := map[string][]string{
+		"SELECT * FROM users": {"users"},
+		"SELECT * FROM users u, orders AS o WHERE u.id = o.user_id":                  {"users", "orders"},
+		"SELECT * FROM users u JOIN orders o ON u.id = o.uid LEFT JOIN items i ON 1": {"users", "orders", "items"},
+		"SELECT * FROM `app`.`users`":                                                {"app.users"},
+		"SELECT * FROM (SELECT * FROM inner_t) AS d":                                 {"inner_t"},
+		"INSERT INTO audit.events (a) VALUES (1)":                                    {"audit.events"},
+		"UPDATE users SET a = 1 WHERE id IN (SELECT uid FROM bans)":                  {"users", "bans"},
+		"DELETE FROM sessions":                                                       {"sessions"},
+		"CREATE TABLE IF NOT EXISTS widgets (id int)":                                {"widgets"},
+		"DROP TABLE IF EXISTS widgets":                                               {"widgets"},
+		"TRUNCATE logs":                                                              {"logs"},
+		"WITH recent AS (SELECT * FROM orders) SELECT * FROM recent":                 {"orders"},
+		"SELECT EXTRACT(YEAR FROM created_at) FROM users":                            {"users"},
+		"SELECT * FROM users FOR UPDATE":                                             {"users"},
+		"INSERT INTO t (a) VALUES (1) ON DUPLICATE KEY UPDATE a = 2":                 {"t"},
+		"SELECT 1": {},
+	}
+	for sql, expected := range cases {
+		assert.Equal(t, expected, New(sql).Tables(), sql)
+	}
+}
+
+func TestTableReferences(t *testing.T) {
+	sql := "SELECT * FROM users u JOIN `app`.`orders` AS o ON u.id = o.uid JOIN users"
+	assert.Equal(t, []TableReference{
+		{Name: "users", Alias: "u", Pos: 14, End: 19},
+		{Name: "app.orders", Qualified: true, Alias: "o", Pos: 27, End: 41},
+		{Name: "users", Pos: 68, End: 73},
+	}, TableReferences(sql))
+}
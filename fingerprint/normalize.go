@@ -0,0 +1,174 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package fingerprint
+
+import (
+	"github.com/wojnosystems/vsql_engine/sql_lexer"
+	"strings"
+)
+
+// InListPlaceholder replaces the contents of an IN-list in the normalized query, so lists of any length share a shape
+const InListPlaceholder = "..."
+
+// Normalize returns only the normalized form of sqlQuery. See Fingerprinter.Normalized.
+func Normalize(sqlQuery string) string {
+	return normalize(sql_lexer.Significant(sql_lexer.Lex(sqlQuery)))
+}
+
+// normalize expects only significant tokens
+func normalize(tokens []sql_lexer.Token) string {
+	pieces := collapseValues(collapseInLists(replaceLiterals(tokens)))
+	for len(pieces) > 0 && pieces[len(pieces)-1].IsPunctuation(";") {
+		pieces = pieces[:len(pieces)-1]
+	}
+	return render(pieces)
+}
+
+// replaceLiterals converts every literal and placeholder into a ? placeholder and lower-cases keywords. A sign in
+// front of a number is folded into the literal so that "x = -1" and "x = 1" have the same shape.
+func replaceLiterals(tokens []sql_lexer.Token) (out []sql_lexer.Token) {
+	out = make([]sql_lexer.Token, 0, len(tokens))
+	for _, t := range tokens {
+		switch {
+		case t.IsLiteral(), t.Kind == sql_lexer.Placeholder:
+			if t.Kind == sql_lexer.Number && len(out) > 0 && isUnarySign(out) {
+				out = out[:len(out)-1]
+			}
+			out = append(out, sql_lexer.Token{Kind: sql_lexer.Placeholder, Text: "?", Pos: t.Pos})
+		case t.IsKeyword():
+			t.Text = strings.ToLower(t.Text)
+			out = append(out, t)
+		default:
+			out = append(out, t)
+		}
+	}
+	return
+}
+
+// isUnarySign is true if the last token of out is a + or - that is not a binary operator
+func isUnarySign(out []sql_lexer.Token) bool {
+	last := out[len(out)-1]
+	if !last.IsPunctuation("-") && !last.IsPunctuation("+") {
+		return false
+	}
+	if len(out) == 1 {
+		return true
+	}
+	return !isOperand(out[len(out)-2])
+}
+
+// isOperand is true if the token could be the left-hand side of a binary operator
+func isOperand(t sql_lexer.Token) bool {
+	switch t.Kind {
+	case sql_lexer.Word:
+		return !t.IsKeyword()
+	case sql_lexer.QuotedIdentifier, sql_lexer.String, sql_lexer.Number, sql_lexer.Placeholder:
+		return true
+	}
+	return t.IsPunctuation(")")
+}
+
+// collapseInLists rewrites "in (?, ?, ?)" to "in (...)"
+func collapseInLists(tokens []sql_lexer.Token) (out []sql_lexer.Token) {
+	out = make([]sql_lexer.Token, 0, len(tokens))
+	for i := 0; i < len(tokens); i++ {
+		out = append(out, tokens[i])
+		if !tokens[i].IsWord("in") || i+1 >= len(tokens) || !tokens[i+1].IsPunctuation("(") {
+			continue
+		}
+		if end, ok := placeholderTuple(tokens, i+1); ok {
+			out = append(out,
+				sql_lexer.Token{Kind: sql_lexer.Punctuation, Text: "("},
+				sql_lexer.Token{Kind: sql_lexer.Placeholder, Text: InListPlaceholder},
+				sql_lexer.Token{Kind: sql_lexer.Punctuation, Text: ")"},
+			)
+			i = end - 1
+		}
+	}
+	return
+}
+
+// collapseValues rewrites "values (?, ?), (?, ?)" to "values (?, ?)" so multi-row inserts share the shape of a
+// single-row insert with the same columns
+func collapseValues(tokens []sql_lexer.Token) (out []sql_lexer.Token) {
+	out = make([]sql_lexer.Token, 0, len(tokens))
+	for i := 0; i < len(tokens); i++ {
+		out = append(out, tokens[i])
+		if !tokens[i].IsWord("values") || i+1 >= len(tokens) {
+			continue
+		}
+		end, ok := placeholderTuple(tokens, i+1)
+		if !ok {
+			continue
+		}
+		out = append(out, tokens[i+1:end]...)
+		arity := end - (i + 1)
+		for end < len(tokens) && tokens[end].IsPunctuation(",") {
+			next, ok := placeholderTuple(tokens, end+1)
+			if !ok || next-(end+1) != arity {
+				break
+			}
+			end = next
+		}
+		i = end - 1
+	}
+	return
+}
+
+// placeholderTuple determines if the tokens starting at open are a parenthesized list made of only placeholders and
+// commas. If so, end is the index just past the closing parenthesis.
+func placeholderTuple(tokens []sql_lexer.Token, open int) (end int, ok bool) {
+	if open >= len(tokens) || !tokens[open].IsPunctuation("(") {
+		return
+	}
+	for i := open + 1; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.IsPunctuation(")"):
+			return i + 1, i > open+1
+		case t.Kind == sql_lexer.Placeholder, t.IsPunctuation(","):
+		default:
+			return
+		}
+	}
+	return
+}
+
+// render joins the tokens with single spaces, except around punctuation that reads better without them
+func render(tokens []sql_lexer.Token) string {
+	sb := strings.Builder{}
+	for i, t := range tokens {
+		if i > 0 && needsSpace(tokens[i-1], t) {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(t.Text)
+	}
+	return sb.String()
+}
+
+func needsSpace(previous, current sql_lexer.Token) bool {
+	if previous.IsPunctuation("(") || previous.IsPunctuation(".") {
+		return false
+	}
+	if current.IsPunctuation(",") || current.IsPunctuation(")") || current.IsPunctuation(".") || current.IsPunctuation(";") {
+		return false
+	}
+	if current.IsPunctuation("(") && (previous.Kind == sql_lexer.QuotedIdentifier || (previous.Kind == sql_lexer.Word && !previous.IsKeyword())) {
+		// function calls: count(*)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,146 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package fingerprint
+
+import "github.com/wojnosystems/vsql_engine/sql_lexer"
+
+// StatementType is the broad category of a SQL statement
+type StatementType uint8
+
+const (
+	// Other is any statement that is not one of the types below, such as SET, SHOW, CALL or an unparseable query
+	Other StatementType = iota
+	Select
+	Insert
+	Update
+	Delete
+	// DDL is any statement that changes the schema: CREATE, ALTER, DROP, TRUNCATE and RENAME
+	DDL
+)
+
+var statementTypeNames = [...]string{
+	Other:  "OTHER",
+	Select: "SELECT",
+	Insert: "INSERT",
+	Update: "UPDATE",
+	Delete: "DELETE",
+	DDL:    "DDL",
+}
+
+func (t StatementType) String() string {
+	if int(t) < len(statementTypeNames) {
+		return statementTypeNames[t]
+	}
+	return statementTypeNames[Other]
+}
+
+// IsWrite is true for statements that modify data or the schema
+func (t StatementType) IsWrite() bool {
+	return t == Insert || t == Update || t == Delete || t == DDL
+}
+
+var leadingWordTypes = map[string]StatementType{
+	"select":   Select,
+	"insert":   Insert,
+	"replace":  Insert,
+	"update":   Update,
+	"delete":   Delete,
+	"create":   DDL,
+	"alter":    DDL,
+	"drop":     DDL,
+	"truncate": DDL,
+	"rename":   DDL,
+}
+
+// classify determines the statement type from the first word of the statement. WITH queries are classified by the
+// first statement that follows their common table expressions.
+func classify(tokens []sql_lexer.Token) StatementType {
+	i := 0
+	for i < len(tokens) && tokens[i].IsPunctuation("(") {
+		i++
+	}
+	if i >= len(tokens) || tokens[i].Kind != sql_lexer.Word {
+		return Other
+	}
+	if tokens[i].IsWord("with") {
+		_, i = commonTableExpressions(tokens)
+		if i >= len(tokens) {
+			return Other
+		}
+	}
+	return wordType(tokens[i])
+}
+
+func wordType(t sql_lexer.Token) StatementType {
+	if t.Kind != sql_lexer.Word {
+		return Other
+	}
+	for word, st := range leadingWordTypes {
+		if t.IsWord(word) {
+			return st
+		}
+	}
+	return Other
+}
+
+// commonTableExpressions reads the "WITH [RECURSIVE] name [(columns)] AS (...), ..." prefix of a query and returns the
+// names it defines and the index of the first token after it. tokens[0] must be WITH.
+func commonTableExpressions(tokens []sql_lexer.Token) (names map[string]bool, next int) {
+	names = make(map[string]bool)
+	next = 1
+	if next < len(tokens) && tokens[next].IsWord("recursive") {
+		next++
+	}
+	for next < len(tokens) {
+		names[tokens[next].Unquoted()] = true
+		next++
+		if next < len(tokens) && tokens[next].IsPunctuation("(") {
+			next = skipParenthesis(tokens, next)
+		}
+		if next < len(tokens) && tokens[next].IsWord("as") {
+			next++
+		}
+		// Postgres: AS [NOT] MATERIALIZED (...)
+		for next < len(tokens) && (tokens[next].IsWord("not") || tokens[next].IsWord("materialized")) {
+			next++
+		}
+		if next < len(tokens) && tokens[next].IsPunctuation("(") {
+			next = skipParenthesis(tokens, next)
+		}
+		if next < len(tokens) && tokens[next].IsPunctuation(",") {
+			next++
+			continue
+		}
+		break
+	}
+	return
+}
+
+// skipParenthesis returns the index just past the parenthesis that closes the one at tokens[open]
+func skipParenthesis(tokens []sql_lexer.Token, open int) int {
+	depth := 0
+	for i := open; i < len(tokens); i++ {
+		if tokens[i].IsPunctuation("(") {
+			depth++
+		} else if tokens[i].IsPunctuation(")") {
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(tokens)
+}
@@ -0,0 +1,185 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package fingerprint
+
+import (
+	"github.com/wojnosystems/vsql_engine/sql_lexer"
+	"strings"
+)
+
+// TableReference is a table named by a query
+type TableReference struct {
+	// Name is the table's name without quotes, schema-qualified if the query qualified it, such as "audit.events"
+	Name string
+	// Qualified is true if the query named the table's schema
+	Qualified bool
+	// Alias is the name the query gave the table, without quotes, or empty if it has none
+	Alias string
+	// Pos and End are the byte offsets of the name, including any schema and quotes, in the query
+	Pos, End int
+}
+
+// TableReferences finds every reference to a table in sqlQuery, in order, including repeated references to the same
+// table. References are found as for Fingerprinter.Tables.
+func TableReferences(sqlQuery string) []TableReference {
+	return tableReferences(sql_lexer.Significant(sql_lexer.Lex(sqlQuery)))
+}
+
+// extractTables returns the distinct names of the tables referenced by tokens
+func extractTables(tokens []sql_lexer.Token) (tables []string) {
+	tables = make([]string, 0, 1)
+	seen := make(map[string]bool)
+	for _, ref := range tableReferences(tokens) {
+		if !seen[ref.Name] {
+			seen[ref.Name] = true
+			tables = append(tables, ref.Name)
+		}
+	}
+	return
+}
+
+// tableReferences finds the table references that follow FROM, JOIN, INTO, UPDATE and the DDL TABLE keyword.
+// Derived tables (sub-queries) and common table expressions are skipped.
+func tableReferences(tokens []sql_lexer.Token) (refs []TableReference) {
+	cteNames := map[string]bool{}
+	if len(tokens) > 0 && tokens[0].IsWord("with") {
+		cteNames, _ = commonTableExpressions(tokens)
+	}
+	add := func(found []TableReference) {
+		for _, ref := range found {
+			if !cteNames[ref.Name] {
+				refs = append(refs, ref)
+			}
+		}
+	}
+	// functionParens tracks, for each open parenthesis, whether it belongs to a function call, such as
+	// EXTRACT(YEAR FROM created_at), where FROM does not introduce a table
+	functionParens := make([]bool, 0, 4)
+	for i, t := range tokens {
+		if t.IsPunctuation("(") {
+			functionParens = append(functionParens, i > 0 && isFunctionName(tokens[i-1]))
+			continue
+		}
+		if t.IsPunctuation(")") && len(functionParens) > 0 {
+			functionParens = functionParens[:len(functionParens)-1]
+			continue
+		}
+		if t.Kind != sql_lexer.Word || (len(functionParens) > 0 && functionParens[len(functionParens)-1]) {
+			continue
+		}
+		switch {
+		case t.IsWord("from"), t.IsWord("update") && isStatementStart(tokens, i):
+			add(tableList(tokens, i+1, true))
+		case t.IsWord("join"), t.IsWord("into"), t.IsWord("table") && isDDLTable(tokens, i),
+			t.IsWord("truncate") && i+1 < len(tokens) && !tokens[i+1].IsWord("table"):
+			add(tableList(tokens, i+1, false))
+		}
+	}
+	return
+}
+
+func isFunctionName(t sql_lexer.Token) bool {
+	return t.Kind == sql_lexer.Word && !t.IsKeyword()
+}
+
+// isStatementStart is true if the token at i begins a statement (as opposed to "FOR UPDATE" or "ON DUPLICATE KEY UPDATE")
+func isStatementStart(tokens []sql_lexer.Token, i int) bool {
+	if i == 0 {
+		return true
+	}
+	previous := tokens[i-1]
+	return previous.IsPunctuation("(") || previous.IsPunctuation(";") || previous.IsPunctuation(")")
+}
+
+func isDDLTable(tokens []sql_lexer.Token, i int) bool {
+	for j := i - 1; j >= 0; j-- {
+		if tokens[j].Kind != sql_lexer.Word {
+			return false
+		}
+		if tokens[j].IsWord("create") || tokens[j].IsWord("alter") || tokens[j].IsWord("drop") ||
+			tokens[j].IsWord("truncate") || tokens[j].IsWord("rename") || tokens[j].IsWord("lock") {
+			return true
+		}
+	}
+	return false
+}
+
+// tableList reads the table references starting at tokens[i]. If list is set, comma-separated references are read.
+func tableList(tokens []sql_lexer.Token, i int, list bool) (refs []TableReference) {
+	for i < len(tokens) {
+		// modifiers that can appear before the table name
+		for i < len(tokens) && (tokens[i].IsWord("if") || tokens[i].IsWord("not") || tokens[i].IsWord("exists") ||
+			tokens[i].IsWord("only") || tokens[i].IsWord("lateral") || tokens[i].IsWord("ignore")) {
+			i++
+		}
+		if i >= len(tokens) {
+			return
+		}
+		if tokens[i].IsPunctuation("(") {
+			i = skipParenthesis(tokens, i)
+			_, i = readAlias(tokens, i)
+		} else {
+			var ref TableReference
+			ref, i = qualifiedName(tokens, i)
+			if ref.Name == "" {
+				return
+			}
+			ref.Alias, i = readAlias(tokens, i)
+			refs = append(refs, ref)
+		}
+		if !list || i >= len(tokens) || !tokens[i].IsPunctuation(",") {
+			return
+		}
+		i++
+	}
+	return
+}
+
+// qualifiedName reads an identifier such as schema.table or `schema`.`table`
+func qualifiedName(tokens []sql_lexer.Token, i int) (ref TableReference, next int) {
+	parts := make([]string, 0, 2)
+	for i < len(tokens) {
+		t := tokens[i]
+		if t.Kind == sql_lexer.QuotedIdentifier || (t.Kind == sql_lexer.Word && !t.IsKeyword()) {
+			if len(parts) == 0 {
+				ref.Pos = t.Pos
+			}
+			ref.End = t.Pos + len(t.Text)
+			parts = append(parts, t.Unquoted())
+			i++
+			if i < len(tokens) && tokens[i].IsPunctuation(".") {
+				i++
+				continue
+			}
+		}
+		break
+	}
+	ref.Name = strings.Join(parts, ".")
+	ref.Qualified = len(parts) > 1
+	return ref, i
+}
+
+// readAlias reads "AS alias" or a bare alias that follows a table reference
+func readAlias(tokens []sql_lexer.Token, i int) (alias string, next int) {
+	if i < len(tokens) && tokens[i].IsWord("as") {
+		i++
+	}
+	if i < len(tokens) && (tokens[i].Kind == sql_lexer.QuotedIdentifier || (tokens[i].Kind == sql_lexer.Word && !tokens[i].IsKeyword())) {
+		alias = tokens[i].Unquoted()
+		i++
+	}
+	return alias, i
+}
@@ -1,9 +1,15 @@
 module github.com/wojnosystems/vsql_engine
 
-go 1.12
+go 1.18
 
 require (
 	github.com/stretchr/testify v1.3.1-0.20190311161405-34c6fa2dc709
 	github.com/wojnosystems/go_keyvaluer v1.0.2
 	github.com/wojnosystems/vsql v0.0.13
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/stretchr/objx v0.1.0 // indirect
+)
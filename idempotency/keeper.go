@@ -0,0 +1,398 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package idempotency
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vresult"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/engine_ware"
+	"github.com/wojnosystems/vsql_engine/query_rewrite"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// idempotency stops retried writes from being made twice. A write made with a context from WithKey records its result
+// under the key. Until the Window passes, the same write made with the same key returns the recorded result instead
+// of reaching the driver.
+
+// DefaultWindow is how long Records are replayed when the Keeper's Window is not set
+const DefaultWindow = 24 * time.Hour
+
+// the kinds of write hashed into a Record's RequestHash. Statements are hashed as their direct equivalents, so a
+// retry may use a prepared statement where the original did not.
+const (
+	kindExec   = "exec"
+	kindInsert = "insert"
+)
+
+// Keeper makes keyed writes idempotent. Create one with New and install it into an engine with Install.
+//
+// The writes made with the same key in a transaction are told apart by their order in its outermost transaction, so
+// a retried transaction replays each of them in turn. Outside of a transaction, a key is for a single write.
+//
+// A write saves a pending Record before it is made, which reserves its key until the write is made or, in a
+// transaction, until the outermost transaction ends: the same key is then waited for by this Keeper and refused by
+// others sharing the Store with ErrInProgress. The Records of a transaction are completed once the outermost
+// transaction commits. They are removed if it, or the nested transaction they were made in, rolls back, so a
+// transaction whose Commit fails must be rolled back.
+type Keeper struct {
+	Store Store
+	// Window is how long a Record is replayed for. Writes made with a key after the Window are made again.
+	Window time.Duration
+
+	// inflight holds a channel for each key being written, closed once the write is recorded, so that a retry that
+	// arrives while the original is still being made waits for it
+	inflight sync.Map
+
+	// transactions are the pending writes of the transactions created by the driver. Entries are removed when the
+	// transaction ends.
+	transactions engine_context.Tracker[*pending]
+
+	// now is replaceable for tests
+	now func() time.Time
+}
+
+// pending are the writes made in a transaction
+type pending struct {
+	// parent is the pending writes of the transaction this one is nested in. It is nil for outermost transactions.
+	parent *pending
+	// mu is used by outermost transactions and guards the transactions nested in them
+	mu sync.Mutex
+	// ordinals counts the writes made with each key in the transaction, and in those committed into it, so that the
+	// writes of a rolled back nested transaction are not counted
+	ordinals map[string]int
+	writes   []write
+}
+
+// write is a write made in a transaction, which holds its key until the outermost transaction ends
+type write struct {
+	// key is the key the write was made with and storeKey the one it is stored under, see scopedKey
+	key      string
+	storeKey string
+	record   Record
+	unlock   func()
+}
+
+func newPending(parent *pending) *pending {
+	return &pending{parent: parent, ordinals: make(map[string]int)}
+}
+
+// lock locks the outermost transaction of p
+func (p *pending) lock() (unlock func()) {
+	root := p
+	for root.parent != nil {
+		root = root.parent
+	}
+	root.mu.Lock()
+	return root.mu.Unlock
+}
+
+// next returns the ordinal of the next write made with key in the outermost transaction of p
+func (p *pending) next(key string) (ordinal int) {
+	defer p.lock()()
+	for t := p; t != nil; t = t.parent {
+		ordinal += t.ordinals[key]
+	}
+	p.ordinals[key]++
+	return
+}
+
+// undo stops counting a write made with key that was not made
+func (p *pending) undo(key string) {
+	defer p.lock()()
+	p.ordinals[key]--
+}
+
+func (p *pending) add(w write) {
+	defer p.lock()()
+	p.writes = append(p.writes, w)
+}
+
+// commitInto adds the writes of p to those of its parent
+func (p *pending) commitInto() {
+	defer p.lock()()
+	for key, n := range p.ordinals {
+		p.parent.ordinals[key] += n
+	}
+	p.parent.writes = append(p.parent.writes, p.writes...)
+	p.ordinals, p.writes = nil, nil
+}
+
+func (p *pending) take() (writes []write) {
+	defer p.lock()()
+	writes, p.writes = p.writes, nil
+	return
+}
+
+// New creates a Keeper that records writes in store for window, DefaultWindow if window is not positive
+func New(store Store, window time.Duration) *Keeper {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	return &Keeper{Store: store, Window: window, now: time.Now}
+}
+
+// Install prepends the Keeper to the write chains of the engine: Exec, Insert, the statement Exec and Insert, and the
+// chains it tracks transactions with: Begin, Commit and Rollback. Begin is covered for both SingleTXer and MultiTXer
+// engines. Because it is prepended, replayed writes skip every other middleware.
+func (k *Keeper) Install(e vsql_engine.SQLQueryer) {
+	e.ExecQueryMW().Prepend(func(ctx context.Context, c engine_context.Execer) {
+		k.keep(ctx, c, kindExec, c.Query(), nil, func(r *result) {
+			c.SetResult(r)
+		}, func() vresult.Resulter {
+			return c.Result()
+		})
+	})
+	e.InsertQueryMW().Prepend(func(ctx context.Context, c engine_context.Inserter) {
+		k.keep(ctx, c, kindInsert, c.Query(), nil, func(r *result) {
+			c.SetInsertResult(r)
+		}, func() vresult.Resulter {
+			return c.InsertResult()
+		})
+	})
+	e.StatementExecQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		k.keep(ctx, c, kindExec, c.Query(), c.Parameterer(), func(r *result) {
+			c.SetResult(r)
+		}, func() vresult.Resulter {
+			return c.Result()
+		})
+	})
+	e.StatementInsertQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementInsertQueryer) {
+		k.keep(ctx, c, kindInsert, c.Query(), c.Parameterer(), func(r *result) {
+			c.SetInsertResult(r)
+		}, func() vresult.Resulter {
+			return c.InsertResult()
+		})
+	})
+	e.CommitMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+		k.commit(ctx, c)
+	})
+	e.RollbackMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+		c.Next(ctx)
+		// the keys are released even if the rollback failed, as the transaction cannot commit after it
+		if p, ok := k.transactions.Forget(engine_context.TransactionOf(c)); ok {
+			k.release(ctx, p.take())
+		}
+	})
+	if b, ok := e.(engine_ware.BeginWare); ok {
+		b.BeginMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+			c.Next(ctx)
+			if c.Error() == nil {
+				k.transactions.Track(c.QueryExecTransactioner(), newPending(nil))
+			}
+		})
+	}
+	if b, ok := e.(engine_ware.BeginNestedWare); ok {
+		b.BeginNestedMW().Prepend(func(ctx context.Context, c engine_context.NestedBeginner) {
+			var parent *pending
+			if parentTx := engine_context.TransactionOf(c); parentTx != nil {
+				var ok bool
+				if parent, ok = k.transactions.Lookup(parentTx); !ok {
+					// the parent began before the Keeper was installed: its writes are recorded as they are made
+					c.Next(ctx)
+					return
+				}
+			}
+			c.Next(ctx)
+			if tx := c.QueryExecNestedTransactioner(); c.Error() == nil && tx != nil {
+				k.transactions.Track(tx, newPending(parent))
+			}
+		})
+	}
+}
+
+// keep makes a write idempotent. params are the statement's values, for statements. replay sets the replayed result
+// on the context and made gets the result of the write once it was made.
+func (k *Keeper) keep(ctx context.Context, c engine_context.Er, kind string, query vparam.Queryer, params vparam.Parameterer, replay func(*result), made func() vresult.Resulter) {
+	key, ok := KeyFrom(ctx)
+	if !ok || query == nil {
+		c.Next(ctx)
+		return
+	}
+	hash, err := k.hash(kind, query, params)
+	if err != nil {
+		c.SetError(err)
+		return
+	}
+	txPending, inTx := k.transactions.Lookup(engine_context.TransactionOf(c))
+	ordinal := 0
+	if inTx {
+		ordinal = txPending.next(key)
+	}
+	storeKey := scopedKey(key, ordinal)
+	unlock, err := k.lock(ctx, storeKey)
+	if err != nil {
+		if inTx {
+			txPending.undo(key)
+		}
+		c.SetError(err)
+		return
+	}
+	counted := false
+	defer func() {
+		if inTx && !counted {
+			txPending.undo(key)
+		}
+		// unlock is cleared once the transaction holds the key
+		if unlock != nil {
+			unlock()
+		}
+	}()
+
+	record, found, err := k.Store.Load(withoutKey(ctx), storeKey)
+	if err != nil {
+		c.SetError(err)
+		return
+	}
+	expired := k.now().Add(-k.Window)
+	if found && record.Created.After(expired) {
+		switch {
+		case record.Pending:
+			c.SetError(&ErrInProgress{Key: key})
+		case record.RequestHash != hash:
+			c.SetError(&ErrKeyReused{Key: key})
+		default:
+			counted = true
+			replay(&result{record: record})
+		}
+		return
+	}
+
+	// the key is reserved in the Store, as Keepers in other processes do not share the inflight lock
+	reserved, err := k.Store.Reserve(withoutKey(ctx), storeKey, Record{RequestHash: hash, Created: k.now(), Pending: true}, expired)
+	if err != nil {
+		c.SetError(err)
+		return
+	}
+	if !reserved {
+		c.SetError(&ErrInProgress{Key: key})
+		return
+	}
+	c.Next(ctx)
+	if c.Error() != nil {
+		// nothing is recorded, so that the write can be retried
+		k.release(ctx, []write{{key: key, storeKey: storeKey}})
+		return
+	}
+	record = Record{RequestHash: hash, Created: k.now()}
+	if r := made(); r != nil {
+		if n, err := r.RowsAffected(); err == nil {
+			v := uint64(n)
+			record.RowsAffected = &v
+		}
+		if ir, ok := r.(vresult.InsertResulter); ok && kind == kindInsert {
+			if id, err := ir.LastInsertId(); err == nil {
+				v := uint64(id)
+				record.LastInsertID = &v
+			}
+		}
+	}
+	if inTx {
+		txPending.add(write{key: key, storeKey: storeKey, record: record, unlock: unlock})
+		counted, unlock = true, nil
+		return
+	}
+	if err = k.Store.Save(withoutKey(ctx), storeKey, record); err != nil {
+		// the pending Record would hold the key until the Window passes
+		_ = k.Store.Delete(withoutKey(ctx), storeKey)
+		c.SetError(&ErrNotRecorded{Key: key, Err: err})
+	}
+}
+
+// hash returns the RequestHash of a write. Placeholders and values are made positional first, so the same write
+// hashes the same whichever placeholder style it uses.
+func (k *Keeper) hash(kind string, query vparam.Queryer, params vparam.Parameterer) (string, error) {
+	if params == nil {
+		sqlQuery, values, err := query_rewrite.Positional(query)
+		if err != nil {
+			return "", err
+		}
+		return requestHash(kind, sqlQuery, values)
+	}
+	values, err := query_rewrite.PositionalParameters(query.SQLQueryUnInterpolated(), params)
+	if err != nil {
+		return "", err
+	}
+	return requestHash(kind, query_rewrite.PositionalSQL(query), values)
+}
+
+// lock waits until no other write with key is being made, then reserves the key until unlock is called
+func (k *Keeper) lock(ctx context.Context, key string) (unlock func(), err error) {
+	mine := make(chan struct{})
+	for {
+		other, loaded := k.inflight.LoadOrStore(key, mine)
+		if !loaded {
+			return func() {
+				k.inflight.Delete(key)
+				close(mine)
+			}, nil
+		}
+		select {
+		case <-other.(chan struct{}):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// commit commits the transaction of c. The writes of a nested transaction are added to its parent's; those of an
+// outermost transaction are recorded once it commits, which releases their keys.
+func (k *Keeper) commit(ctx context.Context, c engine_context.Beginner) {
+	tx := engine_context.TransactionOf(c)
+	p, ok := k.transactions.Lookup(tx)
+	c.Next(ctx)
+	if !ok || c.Error() != nil {
+		return
+	}
+	k.transactions.Forget(tx)
+	if p.parent != nil {
+		p.commitInto()
+		return
+	}
+	for _, w := range p.take() {
+		if err := k.Store.Save(withoutKey(ctx), w.storeKey, w.record); err != nil {
+			// the pending Record would hold the key until the Window passes
+			_ = k.Store.Delete(withoutKey(ctx), w.storeKey)
+			if c.Error() == nil {
+				c.SetError(&ErrNotRecorded{Key: w.key, Err: err})
+			}
+		}
+		w.unlock()
+	}
+}
+
+// release removes the pending Records of writes that will not be committed and releases their keys
+func (k *Keeper) release(ctx context.Context, writes []write) {
+	for _, w := range writes {
+		if err := k.Store.Delete(withoutKey(ctx), w.storeKey); err != nil {
+			log.Printf("idempotency: unable to remove the pending record of key %q, it is held until the window passes: %s", w.key, err)
+		}
+		if w.unlock != nil {
+			w.unlock()
+		}
+	}
+}
+
+// scopedKey is the key a write is stored under: its key and its ordinal among the writes made with the key in the
+// outermost transaction, 0 outside of transactions
+func scopedKey(key string, ordinal int) string {
+	return key + "#" + strconv.Itoa(ordinal)
+}
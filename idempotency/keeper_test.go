@@ -0,0 +1,427 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/wojnosystems/vsql"
+	"github.com/wojnosystems/vsql/ulong"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vresult"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/internal/testdriver"
+	"github.com/wojnosystems/vsql_engine/query_rewrite"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestEngine(store Store) (vsql_engine.MultiTXer, *testdriver.Driver, *Keeper) {
+	e := vsql_engine.NewMulti()
+	d := testdriver.Install(e)
+	d.RowsAffected = 3
+	k := New(store, time.Hour)
+	k.now = func() time.Time {
+		return time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	}
+	k.Install(e)
+	return e, d, k
+}
+
+// writes returns the writes that reached the driver
+func writes(d *testdriver.Driver) []testdriver.Call {
+	return d.Calls(testdriver.Exec, testdriver.Insert, testdriver.StatementExec, testdriver.StatementInsert)
+}
+
+func TestKeeper_Replay(t *testing.T) {
+	e, d, _ := newTestEngine(NewMemoryStore())
+	ctx := WithKey(context.Background(), "order-1")
+
+	first, err := e.Insert(ctx, vparam.NewAppendWithData("INSERT INTO orders (sku, quantity) VALUES (?, ?)", "abc", 2))
+	assert.NoError(t, err)
+	quantity := int64(2)
+	replayed, err := e.Insert(ctx, vparam.NewNamedWithData("INSERT INTO orders (sku, quantity) VALUES (:sku, :quantity)", map[string]interface{}{"sku": "abc", "quantity": &quantity}))
+	assert.NoError(t, err)
+	assert.Len(t, writes(d), 1, "expected the replay to not reach the driver")
+	firstID, _ := first.LastInsertId()
+	replayedID, _ := replayed.LastInsertId()
+	assert.Equal(t, firstID, replayedID)
+
+	_, err = e.Insert(ctx, vparam.NewAppendWithData("INSERT INTO orders (sku, quantity) VALUES (?, ?)", "xyz", 2))
+	var reused *ErrKeyReused
+	assert.True(t, errors.As(err, &reused))
+	_, err = e.Exec(ctx, vparam.NewAppendWithData("INSERT INTO orders (sku, quantity) VALUES (?, ?)", "abc", 2))
+	assert.True(t, errors.As(err, &reused), "expected Exec and Insert to be different writes")
+	assert.Len(t, writes(d), 1)
+
+	_, _ = e.Insert(context.Background(), vparam.NewAppendWithData("INSERT INTO orders (sku, quantity) VALUES (?, ?)", "abc", 2))
+	assert.Len(t, writes(d), 2, "expected writes without a key to be made")
+}
+
+func TestRequestHash(t *testing.T) {
+	n := 7
+	a, err := requestHash(kindExec, "SELECT ?", []interface{}{&n})
+	assert.NoError(t, err)
+	b, err := requestHash(kindExec, "SELECT ?", []interface{}{int64(7)})
+	assert.NoError(t, err)
+	assert.Equal(t, a, b, "expected pointers and values of other integer types to hash as their value")
+	c, _ := requestHash(kindExec, "SELECT ?", []interface{}{"7"})
+	assert.NotEqual(t, a, c)
+	d, _ := requestHash(kindExec, "SELECT ?, ?", []interface{}{"a", "b"})
+	e, _ := requestHash(kindExec, "SELECT ?, ?", []interface{}{"a\x00", "b"})
+	assert.NotEqual(t, d, e)
+	at := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	f, _ := requestHash(kindExec, "SELECT ?", []interface{}{at})
+	g, _ := requestHash(kindExec, "SELECT ?", []interface{}{at.In(time.FixedZone("east", 3600))})
+	assert.Equal(t, f, g, "expected the same instant to hash the same")
+	_, err = requestHash(kindExec, "SELECT ?", []interface{}{struct{}{}})
+	assert.Error(t, err)
+}
+
+func TestKeeper_Statement(t *testing.T) {
+	e, d, _ := newTestEngine(NewMemoryStore())
+	ctx := WithKey(context.Background(), "order-1")
+	_, _ = e.Insert(ctx, vparam.NewAppendWithData("INSERT INTO orders (sku) VALUES (?)", "abc"))
+	stmt, _ := e.Prepare(context.Background(), vparam.New("INSERT INTO orders (sku) VALUES (?)"))
+	r, err := stmt.Insert(ctx, query_rewrite.Parameters([]interface{}{"abc"}))
+	assert.NoError(t, err)
+	id, _ := r.LastInsertId()
+	assert.Equal(t, ulong.New(1), id)
+	assert.Len(t, writes(d), 1, "expected a statement to replay the same write")
+}
+
+func TestKeeper_Window(t *testing.T) {
+	e, d, k := newTestEngine(NewMemoryStore())
+	ctx := WithKey(context.Background(), "purge-1")
+	_, _ = e.Exec(ctx, vparam.New("DELETE FROM sessions"))
+	k.now = func() time.Time {
+		return time.Date(2019, 1, 2, 4, 4, 5, 0, time.UTC)
+	}
+	_, _ = e.Exec(ctx, vparam.New("DELETE FROM sessions"))
+	assert.Len(t, writes(d), 2, "expected the write to be made again after the window")
+}
+
+func TestKeeper_Failed(t *testing.T) {
+	e, d, _ := newTestEngine(NewMemoryStore())
+	ctx := WithKey(context.Background(), "purge-1")
+	deadlock := errors.New("deadlock")
+	d.Fail = func(call testdriver.Call) error {
+		return deadlock
+	}
+	_, err := e.Exec(ctx, vparam.New("DELETE FROM sessions"))
+	assert.Equal(t, deadlock, err)
+	d.Fail = nil
+	_, err = e.Exec(ctx, vparam.New("DELETE FROM sessions"))
+	assert.NoError(t, err)
+	assert.Len(t, writes(d), 1, "expected failed writes to not be recorded")
+}
+
+func TestKeeper_Transaction(t *testing.T) {
+	store := NewMemoryStore()
+	e, d, _ := newTestEngine(store)
+	ctx := WithKey(context.Background(), "order-1")
+
+	tx, _ := e.Begin(context.Background(), nil)
+	nested, _ := tx.Begin(context.Background(), nil)
+	_, _ = nested.Exec(ctx, vparam.New("DELETE FROM carts"))
+	assert.NoError(t, nested.Rollback())
+	_, ok, _ := store.Load(context.Background(), "order-1#0")
+	assert.False(t, ok, "expected a rolled back write to release its key")
+	_, err := tx.Exec(ctx, vparam.New("DELETE FROM carts"))
+	assert.NoError(t, err)
+	_, err = tx.Insert(ctx, vparam.NewAppendWithData("INSERT INTO orders (sku) VALUES (?)", "abc"))
+	assert.NoError(t, err, "expected the writes of a transaction to share its key")
+	assert.Len(t, writes(d), 3)
+
+	record, _, _ := store.Load(context.Background(), "order-1#1")
+	assert.True(t, record.Pending, "expected the key to be reserved until the transaction ends")
+	assert.NoError(t, tx.Commit())
+	record, _, _ = store.Load(context.Background(), "order-1#1")
+	assert.False(t, record.Pending)
+
+	retry, _ := e.Begin(context.Background(), nil)
+	_, err = retry.Exec(ctx, vparam.New("DELETE FROM carts"))
+	assert.NoError(t, err)
+	r, err := retry.Insert(ctx, vparam.NewAppendWithData("INSERT INTO orders (sku) VALUES (?)", "abc"))
+	assert.NoError(t, err)
+	id, _ := r.LastInsertId()
+	assert.Equal(t, ulong.New(1), id)
+	assert.NoError(t, retry.Commit())
+	assert.Len(t, writes(d), 3, "expected a retried transaction to replay its writes in turn")
+}
+
+func TestKeeper_ReservedUntilCommit(t *testing.T) {
+	e, d, _ := newTestEngine(NewMemoryStore())
+	ctx := WithKey(context.Background(), "order-1")
+	tx, _ := e.Begin(context.Background(), nil)
+	_, _ = tx.Exec(ctx, vparam.New("DELETE FROM carts"))
+
+	retried := make(chan error)
+	go func() {
+		_, err := e.Exec(ctx, vparam.New("DELETE FROM carts"))
+		retried <- err
+	}()
+	select {
+	case <-retried:
+		t.Fatal("expected the retry to wait for the transaction to end")
+	case <-time.After(20 * time.Millisecond):
+	}
+	assert.NoError(t, tx.Commit())
+	assert.NoError(t, <-retried)
+	assert.Len(t, writes(d), 1, "expected the retry to be replayed")
+}
+
+func TestKeeper_ReservedAcrossKeepers(t *testing.T) {
+	store := NewMemoryStore()
+	e, _, _ := newTestEngine(store)
+	other, otherDriver, _ := newTestEngine(store)
+	ctx := WithKey(context.Background(), "order-1")
+	tx, _ := e.Begin(context.Background(), nil)
+	_, _ = tx.Exec(ctx, vparam.New("DELETE FROM carts"))
+
+	_, err := other.Exec(ctx, vparam.New("DELETE FROM carts"))
+	var inProgress *ErrInProgress
+	assert.True(t, errors.As(err, &inProgress))
+	assert.NoError(t, tx.Rollback())
+	_, err = other.Exec(ctx, vparam.New("DELETE FROM carts"))
+	assert.NoError(t, err)
+	assert.Len(t, writes(otherDriver), 1, "expected the write to be made once the transaction rolled back")
+}
+
+func TestKeeper_ReservedAcrossKeepersWithoutTransaction(t *testing.T) {
+	store := NewMemoryStore()
+	e, d, _ := newTestEngine(store)
+	other, otherDriver, _ := newTestEngine(store)
+	started, release := make(chan struct{}), make(chan struct{})
+	d.Fail = func(call testdriver.Call) error {
+		close(started)
+		<-release
+		return nil
+	}
+	ctx := WithKey(context.Background(), "order-1")
+	made := make(chan error)
+	go func() {
+		_, err := e.Exec(ctx, vparam.New("DELETE FROM carts"))
+		made <- err
+	}()
+	<-started
+
+	_, err := other.Exec(ctx, vparam.New("DELETE FROM carts"))
+	var inProgress *ErrInProgress
+	assert.True(t, errors.As(err, &inProgress), "expected the key to be reserved while the write is made")
+	close(release)
+	assert.NoError(t, <-made)
+	_, err = other.Exec(ctx, vparam.New("DELETE FROM carts"))
+	assert.NoError(t, err)
+	assert.Len(t, writes(d), 1)
+	assert.Empty(t, writes(otherDriver), "expected the write to be replayed once it was made")
+}
+
+func TestKeeper_Concurrent(t *testing.T) {
+	e, d, _ := newTestEngine(NewMemoryStore())
+	release := make(chan struct{})
+	d.Fail = func(call testdriver.Call) error {
+		<-release
+		return nil
+	}
+	ctx := WithKey(context.Background(), "order-1")
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := e.Exec(ctx, vparam.New("DELETE FROM carts"))
+			assert.NoError(t, err)
+		}()
+	}
+	close(release)
+	wg.Wait()
+	assert.Len(t, writes(d), 1, "expected retries that arrive during the write to wait for it")
+}
+
+func TestKeeper_NotRecorded(t *testing.T) {
+	e, _, _ := newTestEngine(&failingStore{})
+	_, err := e.Exec(WithKey(context.Background(), "order-1"), vparam.New("DELETE FROM carts"))
+	var notRecorded *ErrNotRecorded
+	assert.True(t, errors.As(err, &notRecorded))
+}
+
+type failingStore struct{}
+
+func (failingStore) Load(ctx context.Context, key string) (Record, bool, error) {
+	return Record{}, false, nil
+}
+
+func (failingStore) Reserve(ctx context.Context, key string, record Record, expired time.Time) (bool, error) {
+	return true, nil
+}
+
+func (failingStore) Save(ctx context.Context, key string, record Record) error {
+	return errors.New("disk full")
+}
+
+func (failingStore) Delete(ctx context.Context, key string) error {
+	return nil
+}
+
+func TestMemoryStore_Purge(t *testing.T) {
+	s := NewMemoryStore()
+	at := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	_ = s.Save(context.Background(), "old", Record{Created: at})
+	_ = s.Save(context.Background(), "new", Record{Created: at.Add(time.Hour)})
+	assert.Equal(t, 1, s.Purge(at.Add(time.Minute)))
+	_, ok, _ := s.Load(context.Background(), "new")
+	assert.True(t, ok)
+}
+
+// tableDB is a vsql.QueryExecer that keeps the rows of a SQLStore table by key
+type tableDB struct {
+	vsql.QueryExecerMock
+	rows map[string][]interface{}
+}
+
+func (db *tableDB) Query(ctx context.Context, q vparam.Queryer) (vrows.Rowser, error) {
+	_, params, err := query_rewrite.Positional(q)
+	rows := &tableRows{}
+	if row, ok := db.rows[params[0].(string)]; ok {
+		rows.row = row[1:]
+	}
+	return rows, err
+}
+
+func (db *tableDB) Exec(ctx context.Context, q vparam.Queryer) (vresult.Resulter, error) {
+	sqlQuery, params, err := query_rewrite.Positional(q)
+	if err != nil {
+		return nil, err
+	}
+	affected := uint64(0)
+	switch {
+	case strings.HasPrefix(sqlQuery, "DELETE"):
+		key := params[0].(string)
+		// the expired rows are deleted when the query compares created_at
+		if row, ok := db.rows[key]; ok && (len(params) == 1 || row[4].(int64) <= params[1].(int64)) {
+			delete(db.rows, key)
+			affected = 1
+		}
+	case strings.HasPrefix(sqlQuery, "UPDATE"):
+		key := params[len(params)-1].(string)
+		if _, ok := db.rows[key]; ok {
+			db.rows[key] = append([]interface{}{key}, params[:len(params)-1]...)
+			affected = 1
+		}
+	default:
+		if _, ok := db.rows[params[0].(string)]; ok {
+			return nil, errors.New("duplicate key")
+		}
+		db.rows[params[0].(string)] = params
+		affected = 1
+	}
+	r := &vresult.ResulterMock{}
+	r.On("RowsAffected").Return(ulong.New(affected), nil)
+	return r, nil
+}
+
+// tableRows returns row once, if it is not nil
+type tableRows struct {
+	row []interface{}
+}
+
+func (r *tableRows) Next() vrows.Rower {
+	if r.row == nil {
+		return nil
+	}
+	row := tableRow(r.row)
+	r.row = nil
+	return row
+}
+
+func (r *tableRows) Close() error {
+	return nil
+}
+
+type tableRow []interface{}
+
+func (r tableRow) Columns() []string {
+	return nil
+}
+
+func (r tableRow) Scan(destination ...interface{}) error {
+	for i, d := range destination {
+		switch d := d.(type) {
+		case *string:
+			*d = r[i].(string)
+		case *sql.NullInt64:
+			*d = r[i].(sql.NullInt64)
+		case *int64:
+			*d = r[i].(int64)
+		case *bool:
+			*d = r[i].(bool)
+		}
+	}
+	return nil
+}
+
+func TestSQLStore(t *testing.T) {
+	db := &tableDB{rows: make(map[string][]interface{})}
+	s := NewSQLStore(db, "idempotency_keys")
+	_, ok, err := s.Load(context.Background(), "order-1")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	affected := uint64(1)
+	saved := Record{RequestHash: "abc", RowsAffected: &affected, Created: time.Unix(0, 1546398245000000000)}
+	assert.NoError(t, s.Save(context.Background(), "order-1", saved))
+	assert.NoError(t, s.Save(context.Background(), "order-1", saved))
+	loaded, ok, err := s.Load(context.Background(), "order-1")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, saved, loaded)
+
+	assert.NoError(t, s.Delete(context.Background(), "order-1"))
+	_, ok, err = s.Load(context.Background(), "order-1")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestSQLStore_Reserve(t *testing.T) {
+	db := &tableDB{rows: make(map[string][]interface{})}
+	s := NewSQLStore(db, "idempotency_keys")
+	at := time.Unix(0, 1546398245000000000)
+	pending := Record{RequestHash: "abc", Created: at, Pending: true}
+	reserved, err := s.Reserve(context.Background(), "order-1", pending, at.Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.True(t, reserved)
+	reserved, err = s.Reserve(context.Background(), "order-1", pending, at.Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.False(t, reserved, "expected a reserved key to not be reserved again")
+
+	assert.NoError(t, s.Save(context.Background(), "order-1", Record{RequestHash: "abc", Created: at}))
+	reserved, err = s.Reserve(context.Background(), "order-1", pending, at.Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.False(t, reserved, "expected a recorded key to not be reserved")
+
+	later := Record{RequestHash: "abc", Created: at.Add(time.Hour), Pending: true}
+	reserved, err = s.Reserve(context.Background(), "order-1", later, at)
+	assert.NoError(t, err)
+	assert.True(t, reserved, "expected an expired key to be reserved")
+	loaded, _, _ := s.Load(context.Background(), "order-1")
+	assert.Equal(t, later, loaded)
+}
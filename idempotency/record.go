@@ -0,0 +1,170 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/wojnosystems/vsql/ulong"
+	"io"
+	"strconv"
+	"time"
+)
+
+type keyKey struct{}
+
+// WithKey returns a copy of ctx for writes that must be made only once for key, such as the Idempotency-Key header
+// of an API request. Every Exec and Insert made with the context uses the key. The writes of a transaction may share
+// a key, as they are told apart by their order; outside of a transaction, give each write its own key.
+func WithKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, keyKey{}, key)
+}
+
+// KeyFrom returns the key set by WithKey, if any
+func KeyFrom(ctx context.Context) (key string, ok bool) {
+	if ctx == nil {
+		return "", false
+	}
+	key, ok = ctx.Value(keyKey{}).(string)
+	return key, ok && key != ""
+}
+
+// withoutKey returns a copy of ctx without a key, so that the Store's own queries are not made idempotent
+func withoutKey(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, keyKey{}, nil)
+}
+
+// Record is the result of a write, kept under its key
+type Record struct {
+	// RequestHash identifies the write: the kind of call, its SQL and its values. A key is only replayed for the
+	// same write.
+	RequestHash string
+	// RowsAffected and LastInsertID are nil if the driver did not return them
+	RowsAffected *uint64
+	LastInsertID *uint64
+	Created      time.Time
+	// Pending is true while the write is being made or its transaction has not ended. The key is reserved until then.
+	Pending bool
+}
+
+// ErrNotReturned is returned by a replayed result for a value the driver did not return to the original call
+var ErrNotReturned = errors.New("idempotency: the driver did not return this value when the write was made")
+
+// result is the vresult.InsertResulter of a replayed write
+type result struct {
+	record Record
+}
+
+func (r *result) RowsAffected() (ulong.ULong, error) {
+	if r.record.RowsAffected == nil {
+		return 0, ErrNotReturned
+	}
+	return ulong.New(*r.record.RowsAffected), nil
+}
+
+func (r *result) LastInsertId() (ulong.ULong, error) {
+	if r.record.LastInsertID == nil {
+		return 0, ErrNotReturned
+	}
+	return ulong.New(*r.record.LastInsertID), nil
+}
+
+// ErrKeyReused is returned when a key is used for a different write than the one it was first used for
+type ErrKeyReused struct {
+	Key string
+}
+
+func (e ErrKeyReused) Error() string {
+	return fmt.Sprintf("idempotency: key %q was already used for a different write", e.Key)
+}
+
+// ErrInProgress is returned when the key is reserved by a Keeper in another process, for a write that is being made or
+// that was made in a transaction that has not ended. Retry once that write was made or its transaction ended.
+type ErrInProgress struct {
+	Key string
+}
+
+func (e ErrInProgress) Error() string {
+	return fmt.Sprintf("idempotency: a write for key %q is being made", e.Key)
+}
+
+// ErrNotRecorded is returned when the write was made, but its result could not be saved to the Store. Retrying the
+// write with the same key will make it again.
+type ErrNotRecorded struct {
+	Key string
+	Err error
+}
+
+func (e ErrNotRecorded) Error() string {
+	return fmt.Sprintf("idempotency: the write for key %q was made, but could not be recorded: %s", e.Key, e.Err)
+}
+
+func (e ErrNotRecorded) Unwrap() error {
+	return e.Err
+}
+
+// requestHash identifies a write by its kind, SQL and values. The values are converted as the driver would convert
+// them, so that equal values of different types, or pointers to them, hash the same.
+func requestHash(kind, sqlQuery string, params []interface{}) (string, error) {
+	h := sha256.New()
+	writeString(h, kind)
+	writeString(h, sqlQuery)
+	for _, p := range params {
+		v, err := driver.DefaultParameterConverter.ConvertValue(p)
+		if err != nil {
+			return "", err
+		}
+		if err = writeValue(h, v); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeString writes s prefixed with its length, so that the strings written one after the other cannot be confused
+func writeString(w io.Writer, s string) {
+	_, _ = io.WriteString(w, strconv.Itoa(len(s))+":"+s)
+}
+
+// writeValue writes a driver.Value, prefixed with its kind
+func writeValue(w io.Writer, v driver.Value) error {
+	switch v := v.(type) {
+	case nil:
+		_, _ = io.WriteString(w, "n")
+	case int64:
+		writeString(w, "i"+strconv.FormatInt(v, 10))
+	case float64:
+		writeString(w, "f"+strconv.FormatFloat(v, 'g', -1, 64))
+	case bool:
+		writeString(w, "b"+strconv.FormatBool(v))
+	case []byte:
+		writeString(w, "x"+string(v))
+	case string:
+		writeString(w, "s"+v)
+	case time.Time:
+		writeString(w, "t"+v.UTC().Format(time.RFC3339Nano))
+	default:
+		return fmt.Errorf("idempotency: unable to hash a value of type %T", v)
+	}
+	return nil
+}
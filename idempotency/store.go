@@ -0,0 +1,204 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"github.com/wojnosystems/vsql"
+	"github.com/wojnosystems/vsql/vparam"
+	"sync"
+	"time"
+)
+
+// Store keeps the Records of writes. The contexts passed to it never hold a key.
+type Store interface {
+	// Load returns the Record saved under key, if any
+	Load(ctx context.Context, key string) (record Record, ok bool, err error)
+	// Reserve saves record under key unless a Record created after expired is saved under it, in which case it
+	// returns false. Expired Records are replaced. Of the Reserves of a key made at once, by any process sharing the
+	// Store, at most one returns true.
+	Reserve(ctx context.Context, key string, record Record, expired time.Time) (reserved bool, err error)
+	// Save saves record under key, replacing any Record already saved under it
+	Save(ctx context.Context, key string, record Record) error
+	// Delete removes the Record saved under key, if any
+	Delete(ctx context.Context, key string) error
+}
+
+// MemoryStore keeps Records in memory, so they only protect against retries made to the same process. Call Purge
+// periodically to remove the Records that are older than the Keeper's Window.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	records map[string]Record
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]Record)}
+}
+
+func (s *MemoryStore) Load(ctx context.Context, key string) (Record, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.records[key]
+	return r, ok, nil
+}
+
+func (s *MemoryStore) Reserve(ctx context.Context, key string, record Record, expired time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.records[key]; ok && r.Created.After(expired) {
+		return false, nil
+	}
+	s.records[key] = record
+	return true, nil
+}
+
+func (s *MemoryStore) Save(ctx context.Context, key string, record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[key] = record
+	return nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+// Purge removes the Records created before before and returns how many it removed
+func (s *MemoryStore) Purge(before time.Time) (removed int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, r := range s.records {
+		if r.Created.Before(before) {
+			delete(s.records, key)
+			removed++
+		}
+	}
+	return
+}
+
+// SQLStore keeps Records in a database table, so they are shared by every process using the database. The table
+// needs these columns:
+//
+//	idempotency_key: the key, the primary key of the table
+//	request_hash:    a string of 64 characters
+//	rows_affected:   a nullable BIGINT
+//	last_insert_id:  a nullable BIGINT
+//	created_at:      a BIGINT of nanoseconds since the Unix epoch
+//	pending:         a BOOLEAN
+//
+// Delete the rows whose created_at is older than the Keeper's Window periodically.
+type SQLStore struct {
+	db    vsql.QueryExecer
+	table string
+}
+
+// NewSQLStore creates a SQLStore that keeps Records in table, using db. table is written into the SQL as given, so
+// quote it if it needs quoting.
+func NewSQLStore(db vsql.QueryExecer, table string) *SQLStore {
+	return &SQLStore{db: db, table: table}
+}
+
+func (s *SQLStore) Load(ctx context.Context, key string) (record Record, ok bool, err error) {
+	rows, err := s.db.Query(ctx, vparam.NewAppendWithData(
+		"SELECT request_hash, rows_affected, last_insert_id, created_at, pending FROM "+s.table+" WHERE idempotency_key = ?", key))
+	if err != nil {
+		return
+	}
+	defer func() {
+		if closeErr := rows.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	row := rows.Next()
+	if row == nil {
+		return
+	}
+	var rowsAffected, lastInsertID sql.NullInt64
+	var created int64
+	if err = row.Scan(&record.RequestHash, &rowsAffected, &lastInsertID, &created, &record.Pending); err != nil {
+		return
+	}
+	record.RowsAffected = fromNull(rowsAffected)
+	record.LastInsertID = fromNull(lastInsertID)
+	record.Created = time.Unix(0, created)
+	return record, true, nil
+}
+
+// Reserve deletes the Record under key if it expired, then inserts record. The primary key fails the INSERT if
+// another Record is saved under key, which is told apart from other failures by loading the key.
+func (s *SQLStore) Reserve(ctx context.Context, key string, record Record, expired time.Time) (bool, error) {
+	_, err := s.db.Exec(ctx, vparam.NewAppendWithData(
+		"DELETE FROM "+s.table+" WHERE idempotency_key = ? AND created_at <= ?", key, expired.UnixNano()))
+	if err != nil {
+		return false, err
+	}
+	if err = s.insert(ctx, key, record); err != nil {
+		if _, saved, loadErr := s.Load(ctx, key); loadErr == nil && saved {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Save updates the Record under key, or inserts record if no row was updated. Updating, rather than replacing, the
+// row keeps the key reserved throughout, as a Keeper completes the Records it reserved with Save. The driver must
+// return the number of rows affected.
+func (s *SQLStore) Save(ctx context.Context, key string, record Record) error {
+	r, err := s.db.Exec(ctx, vparam.NewAppendWithData(
+		"UPDATE "+s.table+" SET request_hash = ?, rows_affected = ?, last_insert_id = ?, created_at = ?, pending = ? WHERE idempotency_key = ?",
+		record.RequestHash, toNull(record.RowsAffected), toNull(record.LastInsertID), record.Created.UnixNano(), record.Pending, key))
+	if err != nil {
+		return err
+	}
+	updated, err := r.RowsAffected()
+	if err != nil || updated != 0 {
+		return err
+	}
+	return s.insert(ctx, key, record)
+}
+
+func (s *SQLStore) insert(ctx context.Context, key string, record Record) error {
+	_, err := s.db.Exec(ctx, vparam.NewAppendWithData(
+		"INSERT INTO "+s.table+" (idempotency_key, request_hash, rows_affected, last_insert_id, created_at, pending) VALUES (?, ?, ?, ?, ?, ?)",
+		key, record.RequestHash, toNull(record.RowsAffected), toNull(record.LastInsertID), record.Created.UnixNano(), record.Pending))
+	return err
+}
+
+func (s *SQLStore) Delete(ctx context.Context, key string) error {
+	_, err := s.db.Exec(ctx, vparam.NewAppendWithData("DELETE FROM "+s.table+" WHERE idempotency_key = ?", key))
+	return err
+}
+
+func fromNull(v sql.NullInt64) *uint64 {
+	if !v.Valid {
+		return nil
+	}
+	u := uint64(v.Int64)
+	return &u
+}
+
+func toNull(v *uint64) sql.NullInt64 {
+	if v == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(*v), Valid: true}
+}
@@ -0,0 +1,181 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package injection_lint
+
+import (
+	"fmt"
+	"github.com/wojnosystems/vsql_engine/sql_lexer"
+	"strings"
+)
+
+// Kind is the type of risk a Finding describes
+type Kind uint8
+
+const (
+	// Literal is a string or number in a WHERE, HAVING or VALUES clause that should probably be a bound parameter
+	Literal Kind = iota
+	// Tautology is a condition that is always true joined with OR, such as OR 1=1, the signature of an injection
+	Tautology
+	// StackedStatements is a second statement after a semicolon
+	StackedStatements
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Literal:
+		return "literal"
+	case Tautology:
+		return "tautology"
+	case StackedStatements:
+		return "stacked statements"
+	}
+	return "unknown"
+}
+
+// Finding is a part of a query that looks like it was built by concatenating values into the SQL
+type Finding struct {
+	Kind Kind
+	// Text is the offending SQL. It may hold user data or secrets, so it is left out of String and of the errors
+	// and reports of the Linter.
+	Text string
+	// Pos is the byte offset of Text in the query
+	Pos int
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s at %d", f.Kind, f.Pos)
+}
+
+// benignNumbers are numeric literals that are commonly written into queries as flags rather than values
+var benignNumbers = map[string]bool{
+	"0": true,
+	"1": true,
+}
+
+// clause is the part of the query a token is in
+type clause uint8
+
+const (
+	otherClause clause = iota
+	whereClause
+	valuesClause
+)
+
+// endsWhere are the words that end a WHERE or HAVING clause
+var endsWhere = []string{"group", "order", "limit", "offset", "fetch", "for", "window", "returning", "union", "intersect", "except", "select"}
+
+// endsValues are the words that end a VALUES clause
+var endsValues = []string{"on", "returning", "select"}
+
+// Lint finds the parts of sqlQuery that look like they were concatenated into it rather than bound as parameters
+func Lint(sqlQuery string) (findings []Finding) {
+	tokens := sql_lexer.Significant(sql_lexer.Lex(sqlQuery))
+	// clauses holds the clause at each open parenthesis depth, so sub-queries do not end the outer clause
+	clauses := []clause{otherClause}
+	for i, t := range tokens {
+		current := &clauses[len(clauses)-1]
+		switch {
+		case t.IsPunctuation("("):
+			clauses = append(clauses, *current)
+		case t.IsPunctuation(")"):
+			if len(clauses) > 1 {
+				clauses = clauses[:len(clauses)-1]
+			}
+		case t.IsPunctuation(";"):
+			*current = otherClause
+			if len(clauses) == 1 && i+1 < len(tokens) {
+				findings = append(findings, Finding{Kind: StackedStatements, Text: sqlQuery[tokens[i+1].Pos:], Pos: tokens[i+1].Pos})
+			}
+		case t.IsWord("where"), t.IsWord("having"):
+			*current = whereClause
+		case t.IsWord("values"), t.IsWord("value"):
+			*current = valuesClause
+		case *current == whereClause && isAnyWord(t, endsWhere), *current == valuesClause && isAnyWord(t, endsValues):
+			*current = otherClause
+		case t.IsWord("or"):
+			if end, ok := tautology(tokens, i); ok {
+				findings = append(findings, Finding{Kind: Tautology, Text: sqlQuery[t.Pos:end], Pos: t.Pos})
+			}
+		case t.IsLiteral() && *current != otherClause:
+			if t.Kind == sql_lexer.String && t.Text == "''" || t.Kind == sql_lexer.Number && benignNumbers[t.Text] {
+				continue
+			}
+			findings = append(findings, Finding{Kind: Literal, Text: t.Text, Pos: t.Pos})
+		}
+	}
+	return findings
+}
+
+func isAnyWord(t sql_lexer.Token, words []string) bool {
+	for _, w := range words {
+		if t.IsWord(w) {
+			return true
+		}
+	}
+	return false
+}
+
+// comparisons are the operators of conditions tautology checks
+var comparisons = []string{"=", "<>", "!=", "<", ">", "<=", ">=", "<=>"}
+
+// tautology checks whether the OR at tokens[i] is followed by a condition that is always true: a comparison of two
+// literals, a comparison of a column with itself or a lone literal. It returns the end of the condition.
+func tautology(tokens []sql_lexer.Token, i int) (end int, ok bool) {
+	j := i + 1
+	for j < len(tokens) && tokens[j].IsPunctuation("(") {
+		j++
+	}
+	if j >= len(tokens) {
+		return 0, false
+	}
+	a := tokens[j]
+	endOf := func(t sql_lexer.Token) int {
+		return t.Pos + len(t.Text)
+	}
+	// a lone literal, such as OR 1 or OR TRUE
+	if a.IsLiteral() || a.IsWord("true") {
+		if j+1 == len(tokens) || tokens[j+1].IsPunctuation(")") || tokens[j+1].IsPunctuation(";") || tokens[j+1].IsKeyword() {
+			return endOf(a), true
+		}
+	}
+	if j+2 >= len(tokens) || !isComparison(tokens[j+1]) {
+		return 0, false
+	}
+	b := tokens[j+2]
+	if a.IsLiteral() && b.IsLiteral() {
+		return endOf(b), true
+	}
+	isName := func(t sql_lexer.Token) bool {
+		return t.Kind == sql_lexer.QuotedIdentifier || t.Kind == sql_lexer.Word && !t.IsKeyword()
+	}
+	if isName(a) && isName(b) && strings.EqualFold(a.Unquoted(), b.Unquoted()) && tokens[j+1].IsPunctuation("=") {
+		// a = a.b compares a column with a column of table a
+		if j+3 < len(tokens) && tokens[j+3].IsPunctuation(".") {
+			return 0, false
+		}
+		return endOf(b), true
+	}
+	return 0, false
+}
+
+func isComparison(t sql_lexer.Token) bool {
+	for _, op := range comparisons {
+		if t.IsPunctuation(op) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package injection_lint
+
+import (
+	"context"
+	"fmt"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"log"
+	"strings"
+	"sync"
+)
+
+// injection_lint looks for SQL that was built by concatenating values into the query instead of binding them as
+// parameters, which is how SQL injection vulnerabilities are written. It is a lint: its findings are likely, not
+// certain, problems, so queries that are known to be safe can be suppressed by fingerprint.
+
+// Mode decides what the Linter does with a query that has findings
+type Mode uint8
+
+const (
+	// Warn reports the query to the Linter's Reporter once per fingerprint and lets it run
+	Warn Mode = iota
+	// Error fails every call with findings with an *ErrInjectionRisk without running the query
+	Error
+	// Fail reports the query to the Linter's TestingT once per fingerprint, failing the test, and lets it run
+	Fail
+)
+
+// TestingT is the part of testing.TB that the Fail mode uses
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+// ErrInjectionRisk describes a query that looks like it was built by concatenating values into it
+type ErrInjectionRisk struct {
+	// Fingerprint is the ID of the query's fingerprint. Pass it to Suppress if the query is safe.
+	Fingerprint string
+	// Query is the normalized query
+	Query    string
+	Findings []Finding
+}
+
+func (e ErrInjectionRisk) Error() string {
+	findings := make([]string, len(e.Findings))
+	for i, f := range e.Findings {
+		findings[i] = f.String()
+	}
+	return fmt.Sprintf("possible SQL injection in query %s (%s): %s", e.Fingerprint, strings.Join(findings, ", "), e.Query)
+}
+
+// Linter checks queries for signs of SQL injection. Configure the exported fields before calling Install.
+type Linter struct {
+	Mode Mode
+	// Reporter receives queries with findings in Warn mode. If nil, they are written to the standard logger.
+	Reporter func(risk *ErrInjectionRisk)
+	// T receives queries with findings in Fail mode. It is required in that mode.
+	T TestingT
+
+	// suppressed holds the IDs of the fingerprints that are not checked
+	suppressed sync.Map
+	// reported holds the IDs of the fingerprints already reported in Warn and Fail modes
+	reported sync.Map
+}
+
+// New creates a Linter in Warn mode
+func New() *Linter {
+	return &Linter{
+		Mode: Warn,
+	}
+}
+
+// NewStrict creates a Linter that fails the test t on any finding. Use this in your test suites.
+func NewStrict(t TestingT) *Linter {
+	return &Linter{
+		Mode: Fail,
+		T:    t,
+	}
+}
+
+// Suppress stops the linter from checking the queries with the given fingerprint IDs, such as
+// ErrInjectionRisk.Fingerprint, for queries that are known to be safe
+func (l *Linter) Suppress(fingerprintIDs ...string) {
+	for _, id := range fingerprintIDs {
+		l.suppressed.Store(id, true)
+	}
+}
+
+// Install prepends the linter to the chains that run SQL given by the caller: Query, Insert, Exec and Prepare.
+// Statements are checked when they are prepared.
+func (l *Linter) Install(e vsql_engine.SQLQueryer) {
+	e.QueryMW().Prepend(func(ctx context.Context, c engine_context.Queryer) {
+		l.check(ctx, c)
+	})
+	e.InsertQueryMW().Prepend(func(ctx context.Context, c engine_context.Inserter) {
+		l.check(ctx, c)
+	})
+	e.ExecQueryMW().Prepend(func(ctx context.Context, c engine_context.Execer) {
+		l.check(ctx, c)
+	})
+	e.StatementPrepareMW().Prepend(func(ctx context.Context, c engine_context.Preparer) {
+		l.check(ctx, c)
+	})
+}
+
+func (l *Linter) check(ctx context.Context, c engine_context.CommonQueryer) {
+	fp := c.Fingerprint()
+	if fp == nil {
+		// there is no query to lint
+		c.Next(ctx)
+		return
+	}
+	if _, ok := l.suppressed.Load(fp.ID()); ok {
+		c.Next(ctx)
+		return
+	}
+	findings := Lint(c.Query().SQLQueryUnInterpolated())
+	if len(findings) == 0 {
+		c.Next(ctx)
+		return
+	}
+	risk := &ErrInjectionRisk{
+		Fingerprint: fp.ID(),
+		Query:       fp.Normalized(),
+		Findings:    findings,
+	}
+	if l.Mode == Error {
+		c.SetError(risk)
+		return
+	}
+	if _, reported := l.reported.LoadOrStore(fp.ID(), true); !reported {
+		switch {
+		case l.Mode == Fail:
+			l.T.Errorf("%s", risk.Error())
+		case l.Reporter != nil:
+			l.Reporter(risk)
+		default:
+			log.Println(risk.Error())
+		}
+	}
+	c.Next(ctx)
+}
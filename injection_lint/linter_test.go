@@ -0,0 +1,122 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package injection_lint
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/fingerprint"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	cases := map[string][]Kind{
+		"SELECT * FROM users WHERE id = ?":                                   nil,
+		"SELECT * FROM users WHERE deleted = 0 AND name = ''":                nil,
+		"SELECT 'x', 42 FROM users LIMIT 10":                                 nil,
+		"SELECT * FROM users WHERE name = 'bob'":                             {Literal},
+		"SELECT * FROM users WHERE id = 42 ORDER BY 2":                       {Literal},
+		"SELECT * FROM t WHERE a IN (SELECT 'x' FROM u) AND b = 7":           {Literal},
+		"INSERT INTO users (name, age) VALUES ('bob', ?)":                    {Literal},
+		"SELECT * FROM users WHERE id = ? OR 1=1":                            {Tautology},
+		"SELECT * FROM users WHERE name = ? OR 'a' = 'a'":                    {Tautology, Literal, Literal},
+		"SELECT * FROM users WHERE id = ? OR id = id":                        {Tautology},
+		"SELECT * FROM users u JOIN t ON u.id = t.id WHERE a = ? OR u = u.x": nil,
+		"SELECT * FROM users WHERE id = ?; DROP TABLE users":                 {StackedStatements},
+		"SELECT * FROM users;":                                               nil,
+	}
+	for sqlQuery, expected := range cases {
+		kinds := make([]Kind, 0)
+		for _, f := range Lint(sqlQuery) {
+			kinds = append(kinds, f.Kind)
+		}
+		if expected == nil {
+			expected = []Kind{}
+		}
+		assert.Equal(t, expected, kinds, sqlQuery)
+	}
+}
+
+type fakeT struct {
+	errors []string
+}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func newTestEngine(l *Linter) (e vsql_engine.SingleTXer, calls *int) {
+	calls = new(int)
+	e = vsql_engine.NewSingle()
+	e.QueryMW().Append(func(ctx context.Context, c engine_context.Queryer) {
+		*calls++
+		c.SetRows(&vrows.RowserMock{})
+		c.Next(ctx)
+	})
+	l.Install(e)
+	return e, calls
+}
+
+func TestLinter_Error(t *testing.T) {
+	l := New()
+	l.Mode = Error
+	e, calls := newTestEngine(l)
+	_, err := e.Query(context.Background(), vparam.New("SELECT * FROM users WHERE name = 'bob'"))
+	if assert.IsType(t, &ErrInjectionRisk{}, err) {
+		assert.Equal(t, []Finding{{Kind: Literal, Text: "'bob'", Pos: 33}}, err.(*ErrInjectionRisk).Findings)
+		assert.NotContains(t, err.Error(), "bob", "expected the literal to be kept out of the error")
+	}
+	_, err = e.Query(context.Background(), vparam.New("SELECT * FROM users WHERE name = ?"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, *calls)
+}
+
+func TestLinter_FailReportsOncePerFingerprint(t *testing.T) {
+	ft := &fakeT{}
+	e, calls := newTestEngine(NewStrict(ft))
+	_, err := e.Query(context.Background(), vparam.New("SELECT * FROM users WHERE name = 'bob'"))
+	assert.NoError(t, err)
+	_, err = e.Query(context.Background(), vparam.New("SELECT * FROM users WHERE name = 'alice'"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, *calls)
+	if assert.Len(t, ft.errors, 1) {
+		assert.NotContains(t, ft.errors[0], "bob")
+	}
+}
+
+func TestLinter_Suppress(t *testing.T) {
+	ft := &fakeT{}
+	l := NewStrict(ft)
+	l.Suppress(fingerprint.New("SELECT * FROM users WHERE status = 'active'").ID())
+	e, _ := newTestEngine(l)
+	_, err := e.Query(context.Background(), vparam.New("SELECT * FROM users WHERE status = 'active'"))
+	assert.NoError(t, err)
+	assert.Empty(t, ft.errors)
+}
+
+func TestLinter_NilQueryPassesThrough(t *testing.T) {
+	ft := &fakeT{}
+	e, calls := newTestEngine(NewStrict(ft))
+	_, err := e.Query(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, *calls)
+	assert.Empty(t, ft.errors)
+}
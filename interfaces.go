@@ -74,3 +74,12 @@ type SQLQueryer interface {
 	// Enables the connection to be closed
 	engine_ware.ConnCloseWare
 }
+
+// Sealer is implemented by the engines made by NewSingle and NewMulti and by their Groups. Assert an engine to Sealer
+// to seal it.
+type Sealer interface {
+	// Seal stops middleware from being added to the engine: Append and Prepend panic with engine_ware.ErrSealed
+	// afterwards. Middleware may be added while calls are in flight until then; each call keeps the chain it started
+	// with. Groups made from a sealed engine are not sealed.
+	Seal()
+}
@@ -0,0 +1,108 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package columnrule matches the columns of query results to rules keyed by column, such as the masking rules of
+// column_mask and the encrypted columns of field_crypt. Rules are keyed either by a column name, such as "email",
+// which applies to the column in every result, or by a table and column, such as "users.email", which only applies
+// to the results of queries that read the table. Names are matched without regard to case.
+//
+// Results are matched by the names of their columns, so a column that is renamed with an alias or computed by an
+// expression does not match the rules of the column it was read from.
+package columnrule
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/fingerprint"
+	"strings"
+)
+
+// Lookup returns the rule of rules that applies to column in the results of a query that reads tables. Table and
+// column rules take precedence over column rules. If anyTable, table and column rules apply whatever tables are,
+// such as when the tables of the query are not known.
+func Lookup[V any](rules map[string]V, column string, tables []string, anyTable bool) (rule V, ok bool) {
+	// some drivers name result columns after their table
+	if i := strings.LastIndex(column, "."); i >= 0 {
+		column = column[i+1:]
+	}
+	for key, v := range rules {
+		i := strings.LastIndex(key, ".")
+		if !strings.EqualFold(key[i+1:], column) {
+			continue
+		}
+		if i < 0 {
+			rule, ok = v, true
+			continue
+		}
+		if anyTable {
+			return v, true
+		}
+		for _, table := range tables {
+			if TableMatches(key[:i], table) {
+				return v, true
+			}
+		}
+	}
+	return
+}
+
+// TableMatches is true if the table of a rule names table. Rules without a schema match the table in any schema.
+func TableMatches(rule, table string) bool {
+	if strings.EqualFold(rule, table) {
+		return true
+	}
+	if strings.Contains(rule, ".") {
+		return false
+	}
+	i := strings.LastIndex(table, ".")
+	return i >= 0 && strings.EqualFold(rule, table[i+1:])
+}
+
+// OpenRows remembers the tables read by the query of each open rows, so that table and column rules can be applied
+// to the rows' columns. Rows a driver returns as a type that cannot be tracked, see engine_context.Trackable, are not
+// remembered. The zero value is ready to use.
+type OpenRows struct {
+	rows engine_context.Tracker[[]string]
+}
+
+// Install prepends the handlers that remember the rows of queries and prepared statement queries, and forget them
+// when they are closed
+func (o *OpenRows) Install(e vsql_engine.SQLQueryer) {
+	e.QueryMW().Prepend(func(ctx context.Context, c engine_context.Queryer) {
+		c.Next(ctx)
+		o.track(c.Rows(), c.Fingerprint(), c.Error())
+	})
+	e.StatementQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementQueryer) {
+		c.Next(ctx)
+		o.track(c.Rows(), c.Fingerprint(), c.Error())
+	})
+	e.RowsCloseMW().Prepend(func(ctx context.Context, c engine_context.Rowser) {
+		c.Next(ctx)
+		o.rows.Forget(c.Rows())
+	})
+}
+
+// Tables returns the tables read by the query of rows. It returns false if the rows are not remembered.
+func (o *OpenRows) Tables(rows vrows.Rowser) (tables []string, ok bool) {
+	return o.rows.Lookup(rows)
+}
+
+func (o *OpenRows) track(rows vrows.Rowser, f fingerprint.Fingerprinter, err error) {
+	if err == nil && f != nil {
+		o.rows.Track(rows, f.Tables())
+	}
+}
@@ -0,0 +1,55 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package columnrule
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	rules := map[string]string{
+		"email":        "any table",
+		"users.email":  "users",
+		"billing.card": "billing schema",
+	}
+	cases := []struct {
+		column   string
+		tables   []string
+		anyTable bool
+		expected string
+		ok       bool
+	}{
+		{column: "EMAIL", tables: []string{"orders"}, expected: "any table", ok: true},
+		{column: "u.email", tables: []string{"public.users"}, expected: "users", ok: true},
+		{column: "card", tables: []string{"card"}},
+		{column: "card", tables: []string{"billing.card", "payments"}},
+		{column: "card", anyTable: true, expected: "billing schema", ok: true},
+		{column: "name", anyTable: true},
+	}
+	for _, c := range cases {
+		rule, ok := Lookup(rules, c.column, c.tables, c.anyTable)
+		assert.Equal(t, c.ok, ok, c.column)
+		assert.Equal(t, c.expected, rule, c.column)
+	}
+}
+
+func TestTableMatches(t *testing.T) {
+	assert.True(t, TableMatches("users", "public.users"))
+	assert.True(t, TableMatches("Public.Users", "public.users"))
+	assert.False(t, TableMatches("billing.users", "public.users"))
+	assert.False(t, TableMatches("public.users", "users"))
+}
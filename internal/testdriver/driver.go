@@ -0,0 +1,443 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package testdriver is a fake database driver for the tests of the middleware packages. Install adds it to the end
+// of an engine's chains, where a real driver would be. It answers every call and records the calls that reach it.
+package testdriver
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/wojnosystems/vsql"
+	"github.com/wojnosystems/vsql/ulong"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vresult"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql/vstmt"
+	"github.com/wojnosystems/vsql/vtxn"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/engine_ware"
+	"github.com/wojnosystems/vsql_engine/query_rewrite"
+	"reflect"
+	"sync"
+)
+
+// Chains of the calls a Driver records. Calls made directly on a Tx or Statement are recorded with the chain the
+// engine would have made them through.
+const (
+	Query           = "Query"
+	Insert          = "Insert"
+	Exec            = "Exec"
+	Prepare         = "Prepare"
+	StatementQuery  = "StatementQuery"
+	StatementInsert = "StatementInsert"
+	StatementExec   = "StatementExec"
+	StatementClose  = "StatementClose"
+	Begin           = "Begin"
+	Commit          = "Commit"
+	Rollback        = "Rollback"
+)
+
+// Call is a call that reached the driver
+type Call struct {
+	Chain string
+	// SQL is the SQL of the call with its placeholders replaced by ?, and Params are its values
+	SQL    string
+	Params []interface{}
+	// Tx is the transaction the call was made in, if any. For Begin, it is the transaction that was begun.
+	Tx *Tx
+	// Statement is the statement the call was made with, for statement calls and Prepare
+	Statement *Statement
+}
+
+// Driver is the fake driver. Set its exported fields before making calls.
+type Driver struct {
+	// Columns and Rows are the results of every query
+	Columns []string
+	Rows    [][]interface{}
+	// RowsAffected is the result of every write. Each Insert gets the next LastInsertId, starting at 1.
+	RowsAffected uint64
+	// Fail, if not nil, is called with each call before it is made. The call fails with the error it returns, if any.
+	Fail func(call Call) error
+
+	mu           sync.Mutex
+	calls        []Call
+	lastInsertID uint64
+	transactions int
+}
+
+// Install creates a Driver and appends it to every chain of e
+func Install(e vsql_engine.SQLQueryer) *Driver {
+	d := &Driver{}
+	e.QueryMW().Append(func(ctx context.Context, c engine_context.Queryer) {
+		rows, err := d.queryer(c.QueryExecTransactioner()).Query(ctx, c.Query())
+		c.SetRows(rows)
+		c.SetError(err)
+		c.Next(ctx)
+	})
+	e.InsertQueryMW().Append(func(ctx context.Context, c engine_context.Inserter) {
+		r, err := d.queryer(c.QueryExecTransactioner()).Insert(ctx, c.Query())
+		c.SetInsertResult(r)
+		c.SetError(err)
+		c.Next(ctx)
+	})
+	e.ExecQueryMW().Append(func(ctx context.Context, c engine_context.Execer) {
+		r, err := d.queryer(c.QueryExecTransactioner()).Exec(ctx, c.Query())
+		c.SetResult(r)
+		c.SetError(err)
+		c.Next(ctx)
+	})
+	e.StatementPrepareMW().Append(func(ctx context.Context, c engine_context.Preparer) {
+		s, err := d.queryer(c.QueryExecTransactioner()).Prepare(ctx, c.Query())
+		c.SetStatement(s)
+		c.SetError(err)
+		c.Next(ctx)
+	})
+	e.StatementQueryMW().Append(func(ctx context.Context, c engine_context.StatementQueryer) {
+		rows, err := c.Statement().Query(ctx, c.Parameterer())
+		c.SetRows(rows)
+		c.SetError(err)
+		c.Next(ctx)
+	})
+	e.StatementInsertQueryMW().Append(func(ctx context.Context, c engine_context.StatementInsertQueryer) {
+		r, err := c.Statement().Insert(ctx, c.Parameterer())
+		c.SetInsertResult(r)
+		c.SetError(err)
+		c.Next(ctx)
+	})
+	e.StatementExecQueryMW().Append(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		r, err := c.Statement().Exec(ctx, c.Parameterer())
+		c.SetResult(r)
+		c.SetError(err)
+		c.Next(ctx)
+	})
+	e.StatementCloseMW().Append(func(ctx context.Context, c engine_context.StatementCloser) {
+		c.SetError(c.Statement().Close())
+		c.Next(ctx)
+	})
+	e.RowsNextMW().Append(func(ctx context.Context, c engine_context.RowsNexter) {
+		c.SetRow(c.Rows().Next())
+		c.Next(ctx)
+	})
+	e.RowsCloseMW().Append(func(ctx context.Context, c engine_context.Rowser) {
+		c.SetError(c.Rows().Close())
+		c.Next(ctx)
+	})
+	e.CommitMW().Append(func(ctx context.Context, c engine_context.Beginner) {
+		c.SetError(c.QueryExecTransactioner().Commit())
+		c.Next(ctx)
+	})
+	e.RollbackMW().Append(func(ctx context.Context, c engine_context.Beginner) {
+		c.SetError(c.QueryExecTransactioner().Rollback())
+		c.Next(ctx)
+	})
+	if b, ok := e.(engine_ware.BeginWare); ok {
+		b.BeginMW().Append(func(ctx context.Context, c engine_context.Beginner) {
+			tx, err := d.Begin(ctx, c.TxOptions())
+			c.SetQueryExecTransactioner(tx)
+			c.SetError(err)
+			c.Next(ctx)
+		})
+	}
+	if b, ok := e.(engine_ware.BeginNestedWare); ok {
+		b.BeginNestedMW().Append(func(ctx context.Context, c engine_context.NestedBeginner) {
+			var starter vsql.TransactionNestedStarter = d
+			if parent, ok := c.QueryExecNestedTransactioner().(*Tx); ok {
+				starter = parent
+			}
+			tx, err := starter.Begin(ctx, c.TxOptions())
+			c.SetQueryExecNestedTransactioner(tx)
+			c.SetError(err)
+			c.Next(ctx)
+		})
+	}
+	return d
+}
+
+// Calls returns the calls that reached the driver through the chains given, or every call if none are given
+func (d *Driver) Calls(chains ...string) []Call {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var calls []Call
+	for _, call := range d.calls {
+		if len(chains) == 0 || contains(chains, call.Chain) {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func contains(chains []string, chain string) bool {
+	for _, c := range chains {
+		if c == chain {
+			return true
+		}
+	}
+	return false
+}
+
+// call records call and returns the error it fails with, if any
+func (d *Driver) call(call Call) error {
+	if d.Fail != nil {
+		if err := d.Fail(call); err != nil {
+			return err
+		}
+	}
+	d.mu.Lock()
+	d.calls = append(d.calls, call)
+	d.mu.Unlock()
+	return nil
+}
+
+// queryer returns the transaction tx, if it is one of the driver's, or the driver
+func (d *Driver) queryer(tx vsql.QueryExecTransactioner) vsql.QueryExecer {
+	if t, ok := tx.(*Tx); ok {
+		return t
+	}
+	return &Tx{driver: d, outside: true}
+}
+
+// Begin starts an outermost transaction
+func (d *Driver) Begin(ctx context.Context, txOp vtxn.TxOptioner) (vsql.QueryExecNestedTransactioner, error) {
+	return d.begin(nil)
+}
+
+func (d *Driver) begin(parent *Tx) (*Tx, error) {
+	d.mu.Lock()
+	d.transactions++
+	tx := &Tx{driver: d, Parent: parent, ID: d.transactions}
+	d.mu.Unlock()
+	if err := d.call(Call{Chain: Begin, Tx: tx}); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Tx is a transaction of the Driver
+type Tx struct {
+	driver *Driver
+	// outside is true for the Tx that makes the calls that are not in a transaction
+	outside bool
+	// Parent is the transaction this one is nested in, if any
+	Parent *Tx
+	// ID numbers the transactions in the order they were begun, starting at 1
+	ID int
+}
+
+func (t *Tx) String() string {
+	return fmt.Sprintf("tx %d", t.ID)
+}
+
+// in returns the transaction calls made with t are in
+func (t *Tx) in() *Tx {
+	if t.outside {
+		return nil
+	}
+	return t
+}
+
+// record records a call made with query
+func (t *Tx) record(chain string, query vparam.Queryer) error {
+	sqlQuery, params, err := query_rewrite.Positional(query)
+	if err != nil {
+		return err
+	}
+	return t.driver.call(Call{Chain: chain, SQL: sqlQuery, Params: params, Tx: t.in()})
+}
+
+func (t *Tx) Query(ctx context.Context, query vparam.Queryer) (vrows.Rowser, error) {
+	if err := t.record(Query, query); err != nil {
+		return nil, err
+	}
+	return t.driver.newRows(), nil
+}
+
+func (t *Tx) Insert(ctx context.Context, query vparam.Queryer) (vresult.InsertResulter, error) {
+	if err := t.record(Insert, query); err != nil {
+		return nil, err
+	}
+	return t.driver.newResult(true), nil
+}
+
+func (t *Tx) Exec(ctx context.Context, query vparam.Queryer) (vresult.Resulter, error) {
+	if err := t.record(Exec, query); err != nil {
+		return nil, err
+	}
+	return t.driver.newResult(false), nil
+}
+
+func (t *Tx) Prepare(ctx context.Context, query vparam.Queryer) (vstmt.Statementer, error) {
+	s := &Statement{
+		driver: t.driver,
+		SQL:    query_rewrite.PositionalSQL(query),
+		query:  query.SQLQueryUnInterpolated(),
+		Tx:     t.in(),
+	}
+	if err := t.driver.call(Call{Chain: Prepare, SQL: s.SQL, Tx: s.Tx, Statement: s}); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (t *Tx) Begin(ctx context.Context, txOp vtxn.TxOptioner) (vsql.QueryExecNestedTransactioner, error) {
+	return t.driver.begin(t.in())
+}
+
+func (t *Tx) Commit() error {
+	return t.driver.call(Call{Chain: Commit, Tx: t.in()})
+}
+
+func (t *Tx) Rollback() error {
+	return t.driver.call(Call{Chain: Rollback, Tx: t.in()})
+}
+
+// Statement is a statement prepared by the Driver
+type Statement struct {
+	driver *Driver
+	// SQL is the SQL the statement was prepared with, with its placeholders replaced by ?
+	SQL string
+	// query is the SQL the statement was prepared with
+	query string
+	// Tx is the transaction it was prepared in, if any
+	Tx *Tx
+}
+
+// record records a call made with the statement
+func (s *Statement) record(chain string, parameterer vparam.Parameterer) error {
+	params, err := query_rewrite.PositionalParameters(s.query, parameterer)
+	if err != nil {
+		return err
+	}
+	return s.driver.call(Call{Chain: chain, SQL: s.SQL, Params: params, Tx: s.Tx, Statement: s})
+}
+
+func (s *Statement) Query(ctx context.Context, parameterer vparam.Parameterer) (vrows.Rowser, error) {
+	if err := s.record(StatementQuery, parameterer); err != nil {
+		return nil, err
+	}
+	return s.driver.newRows(), nil
+}
+
+func (s *Statement) Insert(ctx context.Context, parameterer vparam.Parameterer) (vresult.InsertResulter, error) {
+	if err := s.record(StatementInsert, parameterer); err != nil {
+		return nil, err
+	}
+	return s.driver.newResult(true), nil
+}
+
+func (s *Statement) Exec(ctx context.Context, parameterer vparam.Parameterer) (vresult.Resulter, error) {
+	if err := s.record(StatementExec, parameterer); err != nil {
+		return nil, err
+	}
+	return s.driver.newResult(false), nil
+}
+
+func (s *Statement) Close() error {
+	return s.driver.call(Call{Chain: StatementClose, SQL: s.SQL, Tx: s.Tx, Statement: s})
+}
+
+// result is the result of a write
+type result struct {
+	rowsAffected uint64
+	lastInsertID uint64
+}
+
+func (d *Driver) newResult(insert bool) *result {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r := &result{rowsAffected: d.RowsAffected}
+	if insert {
+		d.lastInsertID++
+		r.lastInsertID = d.lastInsertID
+	}
+	return r
+}
+
+func (r *result) RowsAffected() (ulong.ULong, error) {
+	return ulong.New(r.rowsAffected), nil
+}
+
+func (r *result) LastInsertId() (ulong.ULong, error) {
+	return ulong.New(r.lastInsertID), nil
+}
+
+// Rows are the rows of a query. Closed is set when they are closed.
+type Rows struct {
+	columns []string
+	rows    [][]interface{}
+	Closed  bool
+}
+
+func (d *Driver) newRows() *Rows {
+	return &Rows{columns: d.Columns, rows: d.Rows}
+}
+
+func (r *Rows) Next() vrows.Rower {
+	if r.Closed || len(r.rows) == 0 {
+		return nil
+	}
+	row := &Row{columns: r.columns, values: r.rows[0]}
+	r.rows = r.rows[1:]
+	return row
+}
+
+func (r *Rows) Close() error {
+	r.Closed = true
+	return nil
+}
+
+// Row is a row of Rows
+type Row struct {
+	columns []string
+	values  []interface{}
+}
+
+func (r *Row) Columns() []string {
+	return r.columns
+}
+
+// Scan assigns each value to the pointer in destination at its position. Values are converted to the type pointed to,
+// or passed to destinations that are a sql.Scanner.
+func (r *Row) Scan(destination ...interface{}) error {
+	if len(destination) != len(r.values) {
+		return fmt.Errorf("testdriver: expected %d destinations, got %d", len(r.values), len(destination))
+	}
+	for i, dest := range destination {
+		if scanner, ok := dest.(sql.Scanner); ok {
+			if err := scanner.Scan(r.values[i]); err != nil {
+				return err
+			}
+			continue
+		}
+		to := reflect.ValueOf(dest)
+		if to.Kind() != reflect.Ptr || to.IsNil() {
+			return fmt.Errorf("testdriver: destination %d is not a pointer", i)
+		}
+		to = to.Elem()
+		if r.values[i] == nil {
+			to.Set(reflect.Zero(to.Type()))
+			continue
+		}
+		from := reflect.ValueOf(r.values[i])
+		numberToString := to.Kind() == reflect.String && from.Kind() != reflect.String && from.Kind() != reflect.Slice
+		if numberToString || !from.Type().ConvertibleTo(to.Type()) {
+			return fmt.Errorf("testdriver: cannot scan %T into %s", r.values[i], to.Type())
+		}
+		to.Set(from.Convert(to.Type()))
+	}
+	return nil
+}
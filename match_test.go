@@ -0,0 +1,172 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/wojnosystems/vsql"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vresult"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql/vstmt"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/engine_ware"
+	"github.com/wojnosystems/vsql_engine/fingerprint"
+	"regexp"
+	"testing"
+)
+
+// matchEngine returns an engine whose Exec, Query and RowsNext chains run a handler that is only added when m matches
+// and a driver after it. ran counts the calls m matched, drove the calls that reached the driver.
+func matchEngine(m engine_ware.Matcher) (e MultiTXer, ran, drove *int) {
+	ran, drove = new(int), new(int)
+	e = NewMulti()
+	e.ExecQueryMW().(engine_ware.ConditionalAdder[engine_context.Execer]).AppendWhen(m, func(ctx context.Context, c engine_context.Execer) {
+		*ran++
+		c.Next(ctx)
+	})
+	e.ExecQueryMW().Append(func(ctx context.Context, c engine_context.Execer) {
+		*drove++
+		c.SetResult(&vresult.ResulterMock{})
+		c.Next(ctx)
+	})
+	e.QueryMW().Append(func(ctx context.Context, c engine_context.Queryer) {
+		c.SetRows(&vrows.RowserMock{})
+		c.Next(ctx)
+	})
+	e.RowsNextMW().(engine_ware.ConditionalAdder[engine_context.RowsNexter]).AppendWhen(m, func(ctx context.Context, c engine_context.RowsNexter) {
+		*ran++
+		c.Next(ctx)
+	})
+	e.BeginNestedMW().Append(func(ctx context.Context, c engine_context.NestedBeginner) {
+		c.SetQueryExecNestedTransactioner(&vsql.QueryExecNestedTransactionerMock{})
+		c.Next(ctx)
+	})
+	e.StatementPrepareMW().Append(func(ctx context.Context, c engine_context.Preparer) {
+		c.SetStatement(&vstmt.StatementerMock{})
+		c.Next(ctx)
+	})
+	e.StatementExecQueryMW().(engine_ware.ConditionalAdder[engine_context.StatementExecQueryer]).AppendWhen(m, func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		*ran++
+		c.Next(ctx)
+	})
+	return
+}
+
+func TestAppendWhen_SQL(t *testing.T) {
+	cases := map[string]struct {
+		matcher engine_ware.Matcher
+		matched []string
+		skipped []string
+	}{
+		"table": {
+			matcher: engine_ware.Table("invoices"),
+			matched: []string{"DELETE FROM invoices WHERE id = 1", "UPDATE `INVOICES` SET paid = 1"},
+			skipped: []string{"DELETE FROM customers", "DELETE FROM billing.invoices"},
+		},
+		"statement type": {
+			matcher: engine_ware.StatementType(fingerprint.Update, fingerprint.Delete),
+			matched: []string{"DELETE FROM invoices", "UPDATE invoices SET paid = 1"},
+			skipped: []string{"INSERT INTO invoices (id) VALUES (1)", "SET NAMES utf8"},
+		},
+		"reads": {
+			matcher: engine_ware.Reads(),
+			matched: []string{"SELECT 1"},
+			skipped: []string{"DELETE FROM invoices"},
+		},
+		"writes": {
+			matcher: engine_ware.Writes(),
+			matched: []string{"INSERT INTO invoices (id) VALUES (1)", "DROP TABLE invoices"},
+			skipped: []string{"SELECT 1", "SET NAMES utf8"},
+		},
+		"fingerprint": {
+			matcher: engine_ware.Fingerprint(regexp.MustCompile(`^delete from invoices where id = \?$`)),
+			matched: []string{"DELETE FROM invoices WHERE id = 7", "delete  from invoices where id=?"},
+			skipped: []string{"DELETE FROM invoices WHERE paid = 1"},
+		},
+		"combined": {
+			matcher: engine_ware.Table("invoices", "customers").And(engine_ware.Writes(), engine_ware.Not(engine_ware.StatementType(fingerprint.DDL))),
+			matched: []string{"DELETE FROM customers"},
+			skipped: []string{"SELECT * FROM invoices", "DROP TABLE invoices", "DELETE FROM orders"},
+		},
+		"either": {
+			matcher: engine_ware.Reads().Or(engine_ware.Table("invoices")),
+			matched: []string{"SELECT 1", "DELETE FROM invoices"},
+			skipped: []string{"DELETE FROM orders"},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			for _, sqlQuery := range c.matched {
+				e, ran, drove := matchEngine(c.matcher)
+				_, _ = e.Exec(context.Background(), vparam.New(sqlQuery))
+				assert.Equal(t, 1, *ran, sqlQuery)
+				assert.Equal(t, 1, *drove, sqlQuery)
+			}
+			for _, sqlQuery := range c.skipped {
+				e, ran, drove := matchEngine(c.matcher)
+				_, _ = e.Exec(context.Background(), vparam.New(sqlQuery))
+				assert.Equal(t, 0, *ran, sqlQuery)
+				assert.Equal(t, 1, *drove, "expected a skipped handler to continue the chain: "+sqlQuery)
+			}
+		})
+	}
+}
+
+func TestAppendWhen_NoSQL(t *testing.T) {
+	e, ran, _ := matchEngine(engine_ware.Table("invoices"))
+	rows, _ := e.Query(context.Background(), vparam.New("SELECT * FROM invoices"))
+	rows.Next()
+	assert.Equal(t, 0, *ran, "expected calls that run no SQL to not match")
+}
+
+func TestAppendWhen_TxDepth(t *testing.T) {
+	e, ran, _ := matchEngine(engine_ware.InTransaction())
+	_, _ = e.Exec(context.Background(), vparam.New("DELETE FROM invoices"))
+	assert.Equal(t, 0, *ran)
+
+	tx, _ := e.Begin(context.Background(), nil)
+	_, _ = tx.Exec(context.Background(), vparam.New("DELETE FROM invoices"))
+	assert.Equal(t, 1, *ran)
+	rows, _ := tx.Query(context.Background(), vparam.New("SELECT * FROM invoices"))
+	rows.Next()
+	assert.Equal(t, 2, *ran, "expected rows queried in a transaction to be in it")
+	stmt, _ := tx.Prepare(context.Background(), vparam.New("DELETE FROM invoices WHERE id = ?"))
+	_, _ = stmt.Exec(context.Background(), vparam.NewAppendData(1))
+	assert.Equal(t, 3, *ran, "expected statements prepared in a transaction to be in it")
+
+	e, ran, _ = matchEngine(engine_ware.TxDepthAtLeast(2))
+	tx, _ = e.Begin(context.Background(), nil)
+	_, _ = tx.Exec(context.Background(), vparam.New("DELETE FROM invoices"))
+	assert.Equal(t, 0, *ran)
+	nested, _ := tx.Begin(context.Background(), nil)
+	_, _ = nested.Exec(context.Background(), vparam.New("DELETE FROM invoices"))
+	assert.Equal(t, 1, *ran)
+	_, _ = tx.Exec(context.Background(), vparam.New("DELETE FROM invoices"))
+	assert.Equal(t, 1, *ran, "expected the parent to remain at its own depth")
+}
+
+type matchKey struct{}
+
+func TestAppendWhen_ContextValue(t *testing.T) {
+	e, ran, _ := matchEngine(engine_ware.ContextValueIs(matchKey{}, "audit"))
+	_, _ = e.Exec(context.Background(), vparam.New("DELETE FROM invoices"))
+	_, _ = e.Exec(context.WithValue(context.Background(), matchKey{}, "other"), vparam.New("DELETE FROM invoices"))
+	assert.Equal(t, 0, *ran)
+	_, _ = e.Exec(context.WithValue(context.Background(), matchKey{}, "audit"), vparam.New("DELETE FROM invoices"))
+	assert.Equal(t, 1, *ran)
+}
@@ -0,0 +1,181 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package n_plus_one
+
+import (
+	"context"
+	"fmt"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/fingerprint"
+	"log"
+	"runtime"
+	"strings"
+)
+
+// n_plus_one finds loops that issue the same query once per row. Queries are grouped by fingerprint within a Scope
+// attached to the caller's context.Context. When a fingerprint repeats more than the threshold within one scope, the
+// Detector reacts according to its Mode.
+
+// Mode decides what the Detector does when a query is repeated too many times
+type Mode uint8
+
+const (
+	// Warn reports the violation to the Detector's Reporter once per fingerprint per scope and lets the query run
+	Warn Mode = iota
+	// Error fails every call over the threshold with an *ErrRepeatedQuery without running the query
+	Error
+	// Fail reports the violation to the Detector's TestingT once per fingerprint per scope, failing the test,
+	// and lets the query run
+	Fail
+)
+
+// DefaultThreshold is the number of times a query may run within one scope before it is considered an N+1
+const DefaultThreshold = 10
+
+// TestingT is the part of testing.TB that the Fail mode uses
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+// ErrRepeatedQuery describes a query that was issued more times than allowed within a single scope
+type ErrRepeatedQuery struct {
+	// Fingerprint is the ID of the fingerprint of the repeated query
+	Fingerprint string
+	// Query is the normalized query
+	Query string
+	// Count is the number of times the query was made in the scope so far
+	Count int
+	// CallSites are the "file:line function" locations in the calling code that made the query, sorted
+	CallSites []string
+}
+
+func (e ErrRepeatedQuery) Error() string {
+	return fmt.Sprintf("n+1 query detected: %d calls to \"%s\" from: %s", e.Count, e.Query, strings.Join(e.CallSites, ", "))
+}
+
+// Detector checks queries for N+1 patterns. Configure the exported fields before calling Install.
+type Detector struct {
+	// Threshold is the number of times the same fingerprint may run in one scope. The call after that is a violation.
+	Threshold int
+	Mode      Mode
+	// Reporter receives violations in Warn mode. If nil, they are written to the standard logger.
+	Reporter func(violation *ErrRepeatedQuery)
+	// T receives violations in Fail mode. It is required in that mode.
+	T TestingT
+}
+
+// New creates a Detector in Warn mode with the DefaultThreshold
+func New() *Detector {
+	return &Detector{
+		Threshold: DefaultThreshold,
+		Mode:      Warn,
+	}
+}
+
+// NewStrict creates a Detector that fails the test t on any violation. Use this in your test suites.
+func NewStrict(t TestingT, threshold int) *Detector {
+	return &Detector{
+		Threshold: threshold,
+		Mode:      Fail,
+		T:         t,
+	}
+}
+
+// Install prepends the detector to the query chains of the engine, so that violations are detected before any other
+// middleware runs
+func (d *Detector) Install(e vsql_engine.SQLQueryer) {
+	e.QueryMW().Prepend(func(ctx context.Context, c engine_context.Queryer) {
+		if err := d.check(ctx, c.Fingerprint()); err != nil {
+			c.SetError(err)
+			return
+		}
+		c.Next(ctx)
+	})
+	e.StatementQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementQueryer) {
+		if err := d.check(ctx, c.Fingerprint()); err != nil {
+			c.SetError(err)
+			return
+		}
+		c.Next(ctx)
+	})
+}
+
+// check records the call in the scope of ctx, if any. An error is returned only if the call must not proceed.
+func (d *Detector) check(ctx context.Context, f fingerprint.Fingerprinter) error {
+	scope := ScopeFrom(ctx)
+	if scope == nil || f == nil {
+		return nil
+	}
+	violation, first := scope.record(f, callSite(), d.Threshold)
+	if violation == nil {
+		return nil
+	}
+	switch d.Mode {
+	case Error:
+		return violation
+	case Fail:
+		if first {
+			d.T.Errorf("%s", violation.Error())
+		}
+	default:
+		if first {
+			if d.Reporter != nil {
+				d.Reporter(violation)
+			} else {
+				log.Println(violation.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// enginePackagePrefixes identify the stack frames that belong to the engine and vsql rather than the calling code
+var enginePackagePrefixes = []string{
+	"github.com/wojnosystems/vsql_engine.",
+	"github.com/wojnosystems/vsql_engine/",
+	"github.com/wojnosystems/vsql/",
+	"github.com/wojnosystems/vsql.",
+}
+
+// callSite finds the first stack frame outside of the engine and returns it as "file:line function".
+// Frames in _test.go files are always considered calling code.
+func callSite() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !isEngineFrame(frame) {
+			return fmt.Sprintf("%s:%d %s", frame.File, frame.Line, frame.Function)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+func isEngineFrame(frame runtime.Frame) bool {
+	if strings.HasSuffix(frame.File, "_test.go") {
+		return false
+	}
+	for _, prefix := range enginePackagePrefixes {
+		if strings.HasPrefix(frame.Function, prefix) {
+			return true
+		}
+	}
+	return false
+}
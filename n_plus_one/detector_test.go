@@ -0,0 +1,126 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package n_plus_one
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql/vstmt"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"strings"
+	"testing"
+)
+
+type fakeT struct {
+	messages []string
+}
+
+func (f *fakeT) Errorf(format string, args ...interface{}) {
+	f.messages = append(f.messages, fmt.Sprintf(format, args...))
+}
+
+func newTestEngine(d *Detector) (e vsql_engine.SingleTXer, driverCalls *int) {
+	driverCalls = new(int)
+	e = vsql_engine.NewSingle()
+	e.QueryMW().Append(func(ctx context.Context, c engine_context.Queryer) {
+		*driverCalls++
+		c.SetRows(&vrows.RowserMock{})
+		c.Next(ctx)
+	})
+	e.StatementPrepareMW().Append(func(ctx context.Context, c engine_context.Preparer) {
+		c.SetStatement(&vstmt.StatementerMock{})
+		c.Next(ctx)
+	})
+	e.StatementQueryMW().Append(func(ctx context.Context, c engine_context.StatementQueryer) {
+		*driverCalls++
+		c.SetRows(&vrows.RowserMock{})
+		c.Next(ctx)
+	})
+	d.Install(e)
+	return
+}
+
+func TestDetector_Fail(t *testing.T) {
+	ft := &fakeT{}
+	e, _ := newTestEngine(NewStrict(ft, 2))
+	ctx, scope := WithScope(context.Background())
+	for i := 0; i < 5; i++ {
+		_, _ = e.Query(ctx, vparam.NewAppendWithData("SELECT * FROM orders WHERE user_id = ?", i))
+	}
+	_, _ = e.Query(ctx, vparam.New("SELECT * FROM users"))
+
+	if assert.Len(t, ft.messages, 1, "expected a single failure per fingerprint") {
+		assert.Contains(t, ft.messages[0], "select * from orders where user_id = ?")
+		assert.Contains(t, ft.messages[0], "detector_test.go")
+	}
+	violations := scope.Violations()
+	if assert.Len(t, violations, 1) {
+		assert.Equal(t, 5, violations[0].Count)
+		assert.Len(t, violations[0].CallSites, 1)
+		assert.True(t, strings.Contains(violations[0].CallSites[0], "TestDetector_Fail"))
+	}
+}
+
+func TestDetector_Error(t *testing.T) {
+	d := New()
+	d.Threshold = 1
+	d.Mode = Error
+	e, driverCalls := newTestEngine(d)
+	ctx, _ := WithScope(context.Background())
+	stmt, _ := e.Prepare(ctx, vparam.New("SELECT * FROM orders WHERE user_id = ?"))
+	_, err := stmt.Query(ctx, vparam.NewAppendData(1))
+	assert.NoError(t, err)
+	_, err = stmt.Query(ctx, vparam.NewAppendData(2))
+	if assert.Error(t, err) {
+		assert.IsType(t, &ErrRepeatedQuery{}, err)
+	}
+	assert.Equal(t, 1, *driverCalls, "expected the query over the threshold to not run")
+}
+
+func TestDetector_Warn(t *testing.T) {
+	reported := make([]*ErrRepeatedQuery, 0)
+	d := New()
+	d.Threshold = 1
+	d.Reporter = func(v *ErrRepeatedQuery) {
+		reported = append(reported, v)
+	}
+	e, driverCalls := newTestEngine(d)
+	ctx, _ := WithScope(context.Background())
+	for i := 0; i < 3; i++ {
+		_, err := e.Query(ctx, vparam.NewAppendWithData("SELECT * FROM orders WHERE user_id = ?", i))
+		assert.NoError(t, err)
+	}
+	assert.Len(t, reported, 1)
+	assert.Equal(t, 3, *driverCalls)
+}
+
+func TestDetector_ScopesAreIndependent(t *testing.T) {
+	ft := &fakeT{}
+	e, _ := newTestEngine(NewStrict(ft, 1))
+	for i := 0; i < 3; i++ {
+		ctx, _ := WithScope(context.Background())
+		_, _ = e.Query(ctx, vparam.NewAppendWithData("SELECT * FROM orders WHERE user_id = ?", i))
+	}
+	// no scope at all
+	for i := 0; i < 3; i++ {
+		_, _ = e.Query(context.Background(), vparam.NewAppendWithData("SELECT * FROM orders WHERE user_id = ?", i))
+	}
+	assert.Empty(t, ft.messages)
+}
@@ -0,0 +1,109 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package n_plus_one
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql_engine/fingerprint"
+	"sort"
+	"sync"
+)
+
+type scopeKey struct{}
+
+// Scope groups the queries made with a single context.Context, usually everything done to serve one inbound request.
+// Queries made with a context that has no Scope are not checked.
+type Scope struct {
+	mu     sync.Mutex
+	groups map[uint64]*group
+}
+
+// group tracks the calls made for one fingerprint within a scope
+type group struct {
+	fingerprint fingerprint.Fingerprinter
+	count       int
+	callSites   map[string]int
+	reported    bool
+}
+
+// WithScope returns a copy of ctx with a new, empty Scope attached, and that Scope.
+// Pass the returned context to every Query made while serving the request.
+func WithScope(ctx context.Context) (context.Context, *Scope) {
+	s := &Scope{
+		groups: make(map[uint64]*group),
+	}
+	return context.WithValue(ctx, scopeKey{}, s), s
+}
+
+// ScopeFrom returns the Scope attached to ctx, or nil if there isn't one
+func ScopeFrom(ctx context.Context) *Scope {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(scopeKey{}).(*Scope)
+	return s
+}
+
+// record counts a call for the fingerprint. If the call pushes the count over threshold for the first time in this
+// scope, the violation is returned and firstViolation is true. Calls after that return the (updated) violation with
+// firstViolation false.
+func (s *Scope) record(f fingerprint.Fingerprinter, callSite string, threshold int) (v *ErrRepeatedQuery, firstViolation bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.groups[f.Hash()]
+	if !ok {
+		g = &group{
+			fingerprint: f,
+			callSites:   make(map[string]int),
+		}
+		s.groups[f.Hash()] = g
+	}
+	g.count++
+	g.callSites[callSite]++
+	if g.count <= threshold {
+		return nil, false
+	}
+	firstViolation = !g.reported
+	g.reported = true
+	return g.violation(), firstViolation
+}
+
+// Violations returns every fingerprint that was repeated more than the threshold in this scope, in no particular order.
+// Call this at the end of the request to log or assert on the outcome.
+func (s *Scope) Violations() (violations []*ErrRepeatedQuery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, g := range s.groups {
+		if g.reported {
+			violations = append(violations, g.violation())
+		}
+	}
+	return
+}
+
+func (g *group) violation() *ErrRepeatedQuery {
+	sites := make([]string, 0, len(g.callSites))
+	for site := range g.callSites {
+		sites = append(sites, site)
+	}
+	sort.Strings(sites)
+	return &ErrRepeatedQuery{
+		Fingerprint: g.fingerprint.ID(),
+		Query:       g.fingerprint.Normalized(),
+		Count:       g.count,
+		CallSites:   sites,
+	}
+}
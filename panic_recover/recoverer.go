@@ -0,0 +1,221 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package panic_recover
+
+import (
+	"context"
+	"fmt"
+	"github.com/wojnosystems/vsql"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/engine_ware"
+	"runtime/debug"
+)
+
+// panic_recover turns a panic in middleware, or in the driver, into an error returned by the call that panicked,
+// instead of letting it unwind into the caller.
+
+// ErrPanic is set as the error of a call whose middleware panicked
+type ErrPanic struct {
+	// Value is what was passed to panic
+	Value interface{}
+	// Stack is the stack of the goroutine when it panicked, as formatted by runtime/debug.Stack
+	Stack []byte
+	// Chain is the name of the middleware chain, such as Query or RowsNext
+	Chain string
+	// Position is the index, in the chain, of the handler that panicked
+	Position int
+	// RolledBack is true if the Policy rolled back the transaction the call was made in. RollbackErr is the error the
+	// rollback returned.
+	RolledBack  bool
+	RollbackErr error
+}
+
+func (e ErrPanic) Error() string {
+	return fmt.Sprintf("panic_recover: handler %d of the %s chain panicked: %v", e.Position, e.Chain, e.Value)
+}
+
+// Unwrap returns Value if it is an error, such as a runtime.Error
+func (e ErrPanic) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Policy decides whether the transaction a call was made in is rolled back after its middleware panicked
+type Policy func(err *ErrPanic) bool
+
+// Never leaves the transaction for the caller to end
+func Never(*ErrPanic) bool {
+	return false
+}
+
+// Always rolls the transaction back
+func Always(*ErrPanic) bool {
+	return true
+}
+
+// Recoverer recovers panics. Configure the exported fields before calling Install.
+type Recoverer struct {
+	// Policy decides whether to roll back the transaction a call that panicked was made in. If nil, Never is used.
+	Policy Policy
+	// OnPanic, if set, is called with every recovered panic, such as to log it
+	OnPanic func(ctx context.Context, err *ErrPanic)
+
+	rollbackChain rollbackPerformer
+}
+
+// New creates a Recoverer that applies policy
+func New(policy Policy) *Recoverer {
+	return &Recoverer{Policy: policy}
+}
+
+// rollbackPerformer runs the whole Rollback chain. It is implemented by engine_ware.RollbackMW.
+type rollbackPerformer interface {
+	PerformMiddleware(ctx context.Context, c engine_context.Beginner)
+}
+
+// Install prepends the recoverer to every chain of the engine, Begin and BeginNested included. Install it after any
+// other middleware that is prepended, as it only recovers panics in the handlers that run after it.
+//
+// A panic in Begin, Commit, Query, Exec, Insert, Prepare, in a prepared statement or while reading rows is in the
+// transaction the call was made in, if any. A panic in Rollback or BeginNested is never rolled back by the Policy.
+// Transactions are rolled back through the Rollback chain, so its middleware sees the rollback.
+//
+// As rows.Next has no error to return, a panic in RowsNext returns no row, which ends the iteration; its error only
+// reaches OnPanic.
+func (r *Recoverer) Install(e vsql_engine.SQLQueryer) {
+	r.rollbackChain, _ = e.RollbackMW().(rollbackPerformer)
+
+	e.QueryMW().Prepend(func(ctx context.Context, c engine_context.Queryer) {
+		defer r.catch(ctx, c, "Query")
+		c.Next(ctx)
+	})
+	e.ExecQueryMW().Prepend(func(ctx context.Context, c engine_context.Execer) {
+		defer r.catch(ctx, c, "Exec")
+		c.Next(ctx)
+	})
+	e.InsertQueryMW().Prepend(func(ctx context.Context, c engine_context.Inserter) {
+		defer r.catch(ctx, c, "Insert")
+		c.Next(ctx)
+	})
+	e.StatementPrepareMW().Prepend(func(ctx context.Context, c engine_context.Preparer) {
+		defer r.catch(ctx, c, "StatementPrepare")
+		c.Next(ctx)
+	})
+	e.StatementQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementQueryer) {
+		defer r.catch(ctx, c, "StatementQuery")
+		c.Next(ctx)
+	})
+	e.StatementExecQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		defer r.catch(ctx, c, "StatementExec")
+		c.Next(ctx)
+	})
+	e.StatementInsertQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementInsertQueryer) {
+		defer r.catch(ctx, c, "StatementInsert")
+		c.Next(ctx)
+	})
+	e.StatementCloseMW().Prepend(func(ctx context.Context, c engine_context.StatementCloser) {
+		defer r.catch(ctx, c, "StatementClose")
+		c.Next(ctx)
+	})
+	e.RowsNextMW().Prepend(func(ctx context.Context, c engine_context.RowsNexter) {
+		defer r.catch(ctx, c, "RowsNext")
+		c.Next(ctx)
+	})
+	e.RowsCloseMW().Prepend(func(ctx context.Context, c engine_context.Rowser) {
+		defer r.catch(ctx, c, "RowsClose")
+		c.Next(ctx)
+	})
+	e.CommitMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+		defer r.catch(ctx, c, "Commit")
+		c.Next(ctx)
+	})
+	e.RollbackMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+		defer r.catch(ctx, c, "Rollback")
+		c.Next(ctx)
+	})
+	e.PingMW().Prepend(func(ctx context.Context, c engine_context.Er) {
+		defer r.catch(ctx, c, "Ping")
+		c.Next(ctx)
+	})
+	e.ConnCloseMW().Prepend(func(ctx context.Context, c engine_context.Er) {
+		defer r.catch(ctx, c, "ConnClose")
+		c.Next(ctx)
+	})
+	if b, ok := e.(engine_ware.BeginWare); ok {
+		b.BeginMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+			defer r.catch(ctx, c, "Begin")
+			c.Next(ctx)
+		})
+	}
+	if b, ok := e.(engine_ware.BeginNestedWare); ok {
+		b.BeginNestedMW().Prepend(func(ctx context.Context, c engine_context.NestedBeginner) {
+			defer r.catch(ctx, c, "BeginNested")
+			c.Next(ctx)
+		})
+	}
+}
+
+// catch is deferred by every handler of the recoverer. If the handlers after it panicked, the panic is set as the
+// error of c.
+func (r *Recoverer) catch(ctx context.Context, c engine_context.Er, chain string) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	err := &ErrPanic{
+		Value:    v,
+		Stack:    debug.Stack(),
+		Chain:    chain,
+		Position: engine_context.Current(c),
+	}
+	if tx := r.transaction(c); tx != nil && chain != "Rollback" && r.Policy != nil && r.Policy(err) {
+		err.RolledBack = true
+		err.RollbackErr = r.rollback(ctx, c, tx)
+	}
+	if r.OnPanic != nil {
+		r.OnPanic(ctx, err)
+	}
+	if n, ok := c.(engine_context.RowsNexter); ok {
+		n.SetRow(nil)
+	}
+	c.SetError(err)
+}
+
+// transaction returns the transaction the call of c was made in, or nil if there is none. Statements and rows
+// carry the transaction they were created in, so nothing needs to be remembered about them.
+func (r *Recoverer) transaction(c engine_context.Er) vsql.QueryExecTransactioner {
+	if v, ok := c.(interface {
+		QueryExecTransactioner() vsql.QueryExecTransactioner
+	}); ok && v.QueryExecTransactioner() != nil {
+		return v.QueryExecTransactioner()
+	}
+	return engine_context.TransactionOf(c)
+}
+
+// rollback rolls tx back through the Rollback chain, or directly if the engine's chain cannot be performed
+func (r *Recoverer) rollback(ctx context.Context, c engine_context.Er, tx vsql.QueryExecTransactioner) error {
+	if r.rollbackChain == nil {
+		return tx.Rollback()
+	}
+	bc := engine_context.NewBeginner()
+	if w, ok := c.(engine_context.WithMiddlewarer); ok {
+		bc.(engine_context.WithMiddlewarer).ShallowCopyFrom(w)
+	}
+	bc.SetQueryExecTransactioner(tx)
+	r.rollbackChain.PerformMiddleware(ctx, bc)
+	return bc.Error()
+}
@@ -0,0 +1,144 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package panic_recover
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/internal/testdriver"
+	"github.com/wojnosystems/vsql_engine/query_rewrite"
+	"testing"
+)
+
+var errBoom = errors.New("boom")
+
+// newEngine creates an engine with a fake driver behind handlers that panic in Exec, StatementExec and RowsNext
+func newEngine(r *Recoverer) (vsql_engine.SingleTXer, *testdriver.Driver) {
+	e := vsql_engine.NewSingle()
+	d := testdriver.Install(e)
+	e.ExecQueryMW().Prepend(func(ctx context.Context, c engine_context.Execer) {
+		panic(errBoom)
+	})
+	e.StatementExecQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		panic(errBoom)
+	})
+	e.RowsNextMW().Prepend(func(ctx context.Context, c engine_context.RowsNexter) {
+		c.Next(ctx)
+		panic("no more rows")
+	})
+	r.Install(e)
+	return e, d
+}
+
+// rolledBack returns the transactions the driver rolled back
+func rolledBack(d *testdriver.Driver) (txs []*testdriver.Tx) {
+	for _, c := range d.Calls(testdriver.Rollback) {
+		txs = append(txs, c.Tx)
+	}
+	return
+}
+
+func TestRecoverer_Exec(t *testing.T) {
+	e, d := newEngine(New(Always))
+	_, err := e.Exec(context.Background(), vparam.New("DELETE FROM puppies"))
+	p, ok := err.(*ErrPanic)
+	if assert.True(t, ok, "expected an ErrPanic, got %v", err) {
+		assert.Equal(t, "Exec", p.Chain)
+		assert.Equal(t, 1, p.Position)
+		assert.Contains(t, string(p.Stack), "panic")
+		assert.True(t, errors.Is(err, errBoom))
+		assert.False(t, p.RolledBack, "expected nothing to roll back outside of a transaction")
+	}
+	assert.Empty(t, rolledBack(d))
+}
+
+func TestRecoverer_PositionOfHandler(t *testing.T) {
+	r := New(nil)
+	e, _ := newEngine(r)
+	e.ExecQueryMW().Prepend(func(ctx context.Context, c engine_context.Execer) {
+		c.Next(ctx)
+	})
+	_, err := e.Exec(context.Background(), vparam.New("DELETE FROM puppies"))
+	if p, ok := err.(*ErrPanic); assert.True(t, ok) {
+		assert.Equal(t, 2, p.Position)
+	}
+}
+
+func TestRecoverer_RollsBackTransaction(t *testing.T) {
+	e, d := newEngine(New(Always))
+	tx, err := e.Begin(context.Background(), nil)
+	assert.NoError(t, err)
+	_, err = tx.Exec(context.Background(), vparam.New("DELETE FROM puppies"))
+	if p, ok := err.(*ErrPanic); assert.True(t, ok) {
+		assert.True(t, p.RolledBack)
+		assert.NoError(t, p.RollbackErr)
+	}
+	assert.Equal(t, []*testdriver.Tx{d.Calls(testdriver.Begin)[0].Tx}, rolledBack(d))
+}
+
+func TestRecoverer_StatementRollsBackItsTransaction(t *testing.T) {
+	e, d := newEngine(New(Always))
+	ctx := context.Background()
+	tx, err := e.Begin(ctx, nil)
+	assert.NoError(t, err)
+	stmt, err := tx.Prepare(ctx, vparam.New("DELETE FROM puppies WHERE id = ?"))
+	assert.NoError(t, err)
+	_, err = stmt.Exec(ctx, query_rewrite.Parameters([]interface{}{1}))
+	if p, ok := err.(*ErrPanic); assert.True(t, ok) {
+		assert.Equal(t, "StatementExec", p.Chain)
+		assert.True(t, p.RolledBack)
+	}
+	assert.Equal(t, []*testdriver.Tx{d.Calls(testdriver.Begin)[0].Tx}, rolledBack(d))
+
+	stmt, err = e.Prepare(ctx, vparam.New("DELETE FROM puppies WHERE id = ?"))
+	assert.NoError(t, err)
+	_, err = stmt.Exec(ctx, query_rewrite.Parameters([]interface{}{1}))
+	if p, ok := err.(*ErrPanic); assert.True(t, ok) {
+		assert.False(t, p.RolledBack, "statements prepared outside of a transaction have nothing to roll back")
+	}
+}
+
+func TestRecoverer_NeverRollsBack(t *testing.T) {
+	e, d := newEngine(New(Never))
+	tx, _ := e.Begin(context.Background(), nil)
+	_, err := tx.Exec(context.Background(), vparam.New("DELETE FROM puppies"))
+	if p, ok := err.(*ErrPanic); assert.True(t, ok) {
+		assert.False(t, p.RolledBack)
+	}
+	assert.Empty(t, rolledBack(d))
+}
+
+func TestRecoverer_RowsNext(t *testing.T) {
+	r := New(Always)
+	var recovered []*ErrPanic
+	r.OnPanic = func(ctx context.Context, err *ErrPanic) {
+		recovered = append(recovered, err)
+	}
+	e, d := newEngine(r)
+	tx, _ := e.Begin(context.Background(), nil)
+	rows, err := tx.Query(context.Background(), vparam.New("SELECT * FROM puppies"))
+	assert.NoError(t, err)
+	assert.Nil(t, rows.Next(), "expected the iteration to end")
+	if assert.Len(t, recovered, 1) {
+		assert.Equal(t, "RowsNext", recovered[0].Chain)
+		assert.Equal(t, "no more rows", recovered[0].Value)
+	}
+	assert.Equal(t, []*testdriver.Tx{d.Calls(testdriver.Begin)[0].Tx}, rolledBack(d))
+}
@@ -0,0 +1,238 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package placeholder
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/wojnosystems/vsql/interpolation_strategy"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine/sql_lexer"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Dialect is the placeholder syntax a database expects
+type Dialect int
+
+const (
+	// Question is ?, as used by MySQL and SQLite. Named parameters are expanded into one ? per use.
+	Question Dialect = iota
+	// Dollar is $1, $2, as used by Postgres. Each named parameter is numbered once and its number is reused.
+	Dollar
+	// Colon is :name, as used by Oracle. Positional parameters are named p1, p2. Values are passed as sql.NamedArg.
+	Colon
+	// AtP is @p1, @p2, as used by SQL Server. Each named parameter is numbered once and its number is reused.
+	AtP
+)
+
+// ErrMixedPlaceholders is returned for SQL that uses both ? and :name placeholders
+var ErrMixedPlaceholders = errors.New("placeholder: the query uses both ? and :name placeholders")
+
+// ErrUnsupportedPlaceholder is returned for SQL with a placeholder that is not ? or :name, such as SQL already
+// written for a dialect
+type ErrUnsupportedPlaceholder struct {
+	Placeholder string
+}
+
+func (e ErrUnsupportedPlaceholder) Error() string {
+	return fmt.Sprintf("placeholder: %s is not a ? or :name placeholder", e.Placeholder)
+}
+
+// plan is how the placeholders of a query are translated
+type plan struct {
+	// sql is the translated SQL
+	sql string
+	// names are the names of the placeholders of the query, in order, if it uses :name placeholders
+	names []string
+	// uses is the number of placeholders in the query
+	uses int
+	// slots are, for each parameter of the translated SQL, the index of the placeholder whose value it takes
+	slots []int
+	// slotNames are the names of the parameters of the translated SQL, for the Colon dialect
+	slotNames []string
+}
+
+// newPlan translates the placeholders of sqlQuery to the dialect. Placeholders in string literals, comments and
+// quoted identifiers are not placeholders and are left alone. It returns nil if sqlQuery has no placeholders.
+func newPlan(sqlQuery string, d Dialect) (*plan, error) {
+	tokens := lex(sqlQuery, d)
+	p := &plan{}
+	positional := false
+	numbers := make(map[string]int)
+	for i, t := range tokens {
+		if t.Kind != sql_lexer.Placeholder {
+			continue
+		}
+		name := ""
+		switch {
+		case t.Text == "?":
+			positional = true
+		case strings.HasPrefix(t.Text, ":"):
+			name = t.Text[1:]
+			p.names = append(p.names, name)
+		default:
+			return nil, &ErrUnsupportedPlaceholder{Placeholder: t.Text}
+		}
+		if positional && len(p.names) != 0 {
+			return nil, ErrMixedPlaceholders
+		}
+		use := p.uses
+		p.uses++
+		if d == Question || name == "" {
+			p.slots = append(p.slots, use)
+			number := strconv.Itoa(len(p.slots))
+			p.slotNames = append(p.slotNames, "p"+number)
+			tokens[i].Text = format(d, number, "p"+number)
+			continue
+		}
+		// named placeholders are numbered once, so that a value used twice is passed once
+		number, ok := numbers[name]
+		if !ok {
+			p.slots = append(p.slots, use)
+			p.slotNames = append(p.slotNames, name)
+			number = len(p.slots)
+			numbers[name] = number
+		}
+		tokens[i].Text = format(d, strconv.Itoa(number), name)
+	}
+	if p.uses == 0 {
+		return nil, nil
+	}
+	p.sql = sql_lexer.Join(tokens)
+	return p, nil
+}
+
+// lex splits sqlQuery into tokens the way the databases of the dialect read it. Only MySQL and SQLite, which use
+// Question, escape with a backslash in '...' strings.
+func lex(sqlQuery string, d Dialect) []sql_lexer.Token {
+	if d == Question {
+		return sql_lexer.Lex(sqlQuery)
+	}
+	return sql_lexer.LexStandard(sqlQuery)
+}
+
+// format returns the placeholder of the dialect for the parameter with the given number and name
+func format(d Dialect, number, name string) string {
+	switch d {
+	case Dollar:
+		return "$" + number
+	case Colon:
+		return ":" + name
+	case AtP:
+		return "@p" + number
+	}
+	return "?"
+}
+
+// values returns the value of each placeholder of the query parameterer is for, in order
+func (p *plan) values(parameterer vparam.Parameterer) (values []interface{}, err error) {
+	// parameterers only use the SQL to count the ? placeholders or find the order of the names, so the placeholders
+	// the lexer found are passed alone. This stops ? and colons in string literals, comments and casts from being
+	// taken for placeholders.
+	sqlQuery := strings.TrimSuffix(strings.Repeat("? ", p.uses), " ")
+	if p.names != nil {
+		sqlQuery = ":" + strings.Join(p.names, " :")
+	}
+	parameterer = withSQL(parameterer, sqlQuery)
+	defer func() {
+		// vparam.Namer panics when its own SQL has a colon that does not start a name, such as in '10:30'
+		if r := recover(); r != nil {
+			err = fmt.Errorf("placeholder: the query's values cannot be read: %v", r)
+		}
+	}()
+	_, values, err = parameterer.Interpolate(sqlQuery, questionMark{})
+	if err == nil && len(values) != p.uses {
+		err = vparam.ErrParameterPlaceholderMismatch
+	}
+	return
+}
+
+// sqlSetter is implemented by the vparam queries, which also interpolate their own SQL
+type sqlSetter interface {
+	SetSQLQueryUnInterpolated(string)
+}
+
+// withSQL returns a shallow copy of parameterer with its own SQL replaced by sqlQuery, if it has its own SQL. The
+// copy shares the values of parameterer. vparam.Namer interpolates its own SQL by splitting it at every colon, which
+// fails for SQL with colons that are not placeholders.
+func withSQL(parameterer vparam.Parameterer, sqlQuery string) vparam.Parameterer {
+	v := reflect.ValueOf(parameterer)
+	if _, ok := parameterer.(sqlSetter); !ok || v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return parameterer
+	}
+	c := reflect.New(v.Elem().Type())
+	c.Elem().Set(v.Elem())
+	copied := c.Interface().(vparam.Parameterer)
+	copied.(sqlSetter).SetSQLQueryUnInterpolated(sqlQuery)
+	return copied
+}
+
+// params arranges the values of the query's placeholders for the translated SQL
+func (p *plan) params(d Dialect, values []interface{}) []interface{} {
+	params := make([]interface{}, len(p.slots))
+	for i, use := range p.slots {
+		params[i] = values[use]
+		if d == Colon {
+			params[i] = sql.Named(p.slotNames[i], values[use])
+		}
+	}
+	return params
+}
+
+// Translate returns query with its placeholders translated to the dialect and its values arranged to match. Queries
+// without placeholders, and nil queries, are returned as they are.
+func Translate(query vparam.Queryer, d Dialect) (vparam.Queryer, error) {
+	if query == nil {
+		return nil, nil
+	}
+	p, err := newPlan(query.SQLQueryUnInterpolated(), d)
+	if err != nil || p == nil {
+		return query, err
+	}
+	values, err := p.values(query)
+	if err != nil {
+		return nil, err
+	}
+	return &translated{sql: p.sql, params: p.params(d, values)}, nil
+}
+
+// translated is SQL already written for the dialect, and its values. The driver's interpolation strategy is not
+// applied again.
+type translated struct {
+	sql    string
+	params []interface{}
+}
+
+func (q *translated) SQLQueryUnInterpolated() string {
+	return q.sql
+}
+
+func (q *translated) SQLQueryInterpolated(interpolation_strategy.InterpolateStrategy) string {
+	return q.sql
+}
+
+func (q *translated) Interpolate(string, interpolation_strategy.InterpolateStrategy) (string, []interface{}, error) {
+	return q.sql, q.params, nil
+}
+
+type questionMark struct{}
+
+func (questionMark) InsertPlaceholderIntoSQL() string {
+	return "?"
+}
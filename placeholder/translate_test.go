@@ -0,0 +1,192 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package placeholder
+
+import (
+	"context"
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vresult"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql/vstmt"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"testing"
+)
+
+// driverStrategy is the strategy a driver interpolates with. Translated queries must not be interpolated again.
+type driverStrategy struct{}
+
+func (driverStrategy) InsertPlaceholderIntoSQL() string {
+	return "<driver>"
+}
+
+func interpolate(t *testing.T, q vparam.Queryer) (string, []interface{}) {
+	sqlQuery, params, err := q.Interpolate(q.SQLQueryUnInterpolated(), driverStrategy{})
+	assert.NoError(t, err)
+	return sqlQuery, params
+}
+
+func TestTranslate_Positional(t *testing.T) {
+	sqlQuery := "SELECT 'what?', \"a?\" /* ? */ FROM t WHERE a = ? AND b IN (?, ?) -- ?"
+	cases := map[Dialect]string{
+		Question: "SELECT 'what?', \"a?\" /* ? */ FROM t WHERE a = ? AND b IN (?, ?) -- ?",
+		Dollar:   "SELECT 'what?', \"a?\" /* ? */ FROM t WHERE a = $1 AND b IN ($2, $3) -- ?",
+		Colon:    "SELECT 'what?', \"a?\" /* ? */ FROM t WHERE a = :p1 AND b IN (:p2, :p3) -- ?",
+		AtP:      "SELECT 'what?', \"a?\" /* ? */ FROM t WHERE a = @p1 AND b IN (@p2, @p3) -- ?",
+	}
+	for d, expected := range cases {
+		translated, err := Translate(vparam.NewAppendWithData(sqlQuery, 1, 2, 3), d)
+		if !assert.NoError(t, err, d) {
+			continue
+		}
+		translatedSQL, params := interpolate(t, translated)
+		assert.Equal(t, expected, translatedSQL)
+		if d == Colon {
+			assert.Equal(t, []interface{}{sql.Named("p1", 1), sql.Named("p2", 2), sql.Named("p3", 3)}, params)
+		} else {
+			assert.Equal(t, []interface{}{1, 2, 3}, params)
+		}
+	}
+
+	translated, err := Translate(vparam.NewAppendWithData("SELECT 'what?' FROM t WHERE a = ?", 1), Dollar)
+	if assert.NoError(t, err) {
+		translatedSQL, params := interpolate(t, translated)
+		assert.Equal(t, "SELECT 'what?' FROM t WHERE a = $1", translatedSQL)
+		assert.Equal(t, []interface{}{1}, params)
+	}
+	_, err = Translate(vparam.NewAppendWithData("SELECT 'what?' FROM t WHERE a = ?", 1, 2), Dollar)
+	assert.Equal(t, vparam.ErrParameterPlaceholderMismatch, err)
+}
+
+func TestTranslate_Backslashes(t *testing.T) {
+	sqlQuery := "SELECT * FROM files WHERE dir = 'C:\\' AND owner = ? AND note <> E'it\\'s?'"
+	cases := map[Dialect]string{
+		Dollar: "SELECT * FROM files WHERE dir = 'C:\\' AND owner = $1 AND note <> E'it\\'s?'",
+		Colon:  "SELECT * FROM files WHERE dir = 'C:\\' AND owner = :p1 AND note <> E'it\\'s?'",
+		AtP:    "SELECT * FROM files WHERE dir = 'C:\\' AND owner = @p1 AND note <> E'it\\'s?'",
+	}
+	for d, expected := range cases {
+		translated, err := Translate(vparam.NewAppendWithData(sqlQuery, 1), d)
+		if assert.NoError(t, err, d) {
+			translatedSQL, _ := interpolate(t, translated)
+			assert.Equal(t, expected, translatedSQL)
+		}
+	}
+
+	translated, err := Translate(vparam.NewAppendWithData("SELECT * FROM t WHERE a = 'it\\'s?' AND b = ?", 1), Question)
+	if assert.NoError(t, err) {
+		translatedSQL, params := interpolate(t, translated)
+		assert.Equal(t, "SELECT * FROM t WHERE a = 'it\\'s?' AND b = ?", translatedSQL)
+		assert.Equal(t, []interface{}{1}, params)
+	}
+}
+
+func TestTranslate_Named(t *testing.T) {
+	q := vparam.NewNamedWithData("UPDATE t SET a = :a, note = 'at 10::30', c = x::int WHERE a <> :a AND b = :b",
+		map[string]interface{}{"a": 1, "b": 2})
+	cases := map[Dialect]struct {
+		sql    string
+		params []interface{}
+	}{
+		Question: {"UPDATE t SET a = ?, note = 'at 10::30', c = x::int WHERE a <> ? AND b = ?", []interface{}{1, 1, 2}},
+		Dollar:   {"UPDATE t SET a = $1, note = 'at 10::30', c = x::int WHERE a <> $1 AND b = $2", []interface{}{1, 2}},
+		Colon:    {"UPDATE t SET a = :a, note = 'at 10::30', c = x::int WHERE a <> :a AND b = :b", []interface{}{sql.Named("a", 1), sql.Named("b", 2)}},
+		AtP:      {"UPDATE t SET a = @p1, note = 'at 10::30', c = x::int WHERE a <> @p1 AND b = @p2", []interface{}{1, 2}},
+	}
+	for d, expected := range cases {
+		translated, err := Translate(q, d)
+		if assert.NoError(t, err) {
+			sqlQuery, params := interpolate(t, translated)
+			assert.Equal(t, expected.sql, sqlQuery)
+			assert.Equal(t, expected.params, params)
+		}
+	}
+}
+
+func TestTranslate_Errors(t *testing.T) {
+	_, err := Translate(vparam.NewAppendWithData("SELECT ? FROM t WHERE a = :a", 1), Dollar)
+	assert.Equal(t, ErrMixedPlaceholders, err)
+	_, err = Translate(vparam.New("SELECT * FROM t WHERE a = $1"), Dollar)
+	assert.Equal(t, &ErrUnsupportedPlaceholder{Placeholder: "$1"}, err)
+	_, err = Translate(vparam.NewAppendWithData("SELECT * FROM t WHERE a = ?"), Dollar)
+	assert.Equal(t, vparam.ErrParameterPlaceholderMismatch, err)
+
+	q := vparam.New("SELECT * FROM t")
+	translated, err := Translate(q, Dollar)
+	assert.NoError(t, err)
+	assert.Equal(t, q, translated)
+}
+
+func TestTranslator_Statements(t *testing.T) {
+	var prepared string
+	var sqlQuery string
+	var params []interface{}
+	e := vsql_engine.NewSingle()
+	e.StatementPrepareMW().Append(func(ctx context.Context, c engine_context.Preparer) {
+		prepared = c.Query().SQLQueryInterpolated(driverStrategy{})
+		c.SetStatement(&vstmt.StatementerMock{})
+		c.Next(ctx)
+	})
+	e.StatementExecQueryMW().Append(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		sqlQuery, params, _ = c.Parameterer().Interpolate(c.Query().SQLQueryUnInterpolated(), driverStrategy{})
+		c.SetResult(&vresult.ResulterMock{})
+		c.Next(ctx)
+	})
+	New(Dollar).Install(e)
+
+	stmt, err := e.Prepare(context.Background(), vparam.New("UPDATE t SET a = :a WHERE b = :b OR a = :a"))
+	assert.NoError(t, err)
+	assert.Equal(t, "UPDATE t SET a = $1 WHERE b = $2 OR a = $1", prepared)
+	_, err = stmt.Exec(context.Background(), vparam.NewNamedData(map[string]interface{}{"a": 1, "b": 2}))
+	assert.NoError(t, err)
+	assert.Equal(t, "UPDATE t SET a = $1 WHERE b = $2 OR a = $1", sqlQuery)
+	assert.Equal(t, []interface{}{1, 2}, params)
+
+	stmt, err = e.Prepare(context.Background(), vparam.New("UPDATE t SET note = 'why?' WHERE a = ?"))
+	assert.NoError(t, err)
+	_, err = stmt.Exec(context.Background(), vparam.NewAppendData(3))
+	assert.NoError(t, err)
+	assert.Equal(t, "UPDATE t SET note = 'why?' WHERE a = $1", sqlQuery)
+	assert.Equal(t, []interface{}{3}, params)
+}
+
+func TestTranslator_NilQueryPassesThrough(t *testing.T) {
+	calls := 0
+	e := vsql_engine.NewSingle()
+	e.QueryMW().Append(func(ctx context.Context, c engine_context.Queryer) {
+		calls++
+		c.SetRows(&vrows.RowserMock{})
+		c.Next(ctx)
+	})
+	e.StatementPrepareMW().Append(func(ctx context.Context, c engine_context.Preparer) {
+		calls++
+		c.SetStatement(&vstmt.StatementerMock{})
+		c.Next(ctx)
+	})
+	New(Dollar).Install(e)
+
+	_, err := e.Query(context.Background(), nil)
+	assert.NoError(t, err)
+	_, err = e.Prepare(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+
+	translated, err := Translate(nil, Dollar)
+	assert.NoError(t, err)
+	assert.Nil(t, translated)
+}
@@ -0,0 +1,111 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package placeholder
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+)
+
+// placeholder lets code written with ? or :name placeholders run against databases that expect another
+// placeholder syntax. The SQL is translated before the driver sees it and the values are arranged to match.
+
+// Translator translates the placeholders of every query to its Dialect
+type Translator struct {
+	Dialect Dialect
+}
+
+// New creates a Translator to the dialect
+func New(d Dialect) *Translator {
+	return &Translator{Dialect: d}
+}
+
+// Install prepends the translator to the Query, Exec, Insert and Prepare chains of the engine. The values used with
+// statements it prepared are arranged for the translated SQL as well.
+func (t *Translator) Install(e vsql_engine.SQLQueryer) {
+	e.QueryMW().Prepend(func(ctx context.Context, c engine_context.Queryer) {
+		t.translate(ctx, c)
+	})
+	e.ExecQueryMW().Prepend(func(ctx context.Context, c engine_context.Execer) {
+		t.translate(ctx, c)
+	})
+	e.InsertQueryMW().Prepend(func(ctx context.Context, c engine_context.Inserter) {
+		t.translate(ctx, c)
+	})
+	e.StatementPrepareMW().Prepend(func(ctx context.Context, c engine_context.Preparer) {
+		if c.Query() == nil {
+			c.Next(ctx)
+			return
+		}
+		p, err := newPlan(c.Query().SQLQueryUnInterpolated(), t.Dialect)
+		if err != nil {
+			c.SetError(err)
+			return
+		}
+		if p != nil {
+			c.SetQuery(&prepared{translated: translated{sql: p.sql}, plan: p})
+		}
+		c.Next(ctx)
+	})
+	e.StatementQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementQueryer) {
+		t.bind(ctx, c, c.Query())
+	})
+	e.StatementExecQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		t.bind(ctx, c, c.Query())
+	})
+	e.StatementInsertQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementInsertQueryer) {
+		t.bind(ctx, c, c.Query())
+	})
+}
+
+func (t *Translator) translate(ctx context.Context, c engine_context.CommonQueryer) {
+	q, err := Translate(c.Query(), t.Dialect)
+	if err != nil {
+		c.SetError(err)
+		return
+	}
+	c.SetQuery(q)
+	c.Next(ctx)
+}
+
+// prepared is the query of a statement prepared with translated SQL. It remembers how to arrange the values used
+// with the statement.
+type prepared struct {
+	translated
+	plan *plan
+}
+
+// statementContext is the part of the statement contexts bind needs
+type statementContext interface {
+	engine_context.Er
+	SetParameterer(vparam.Parameterer)
+	Parameterer() vparam.Parameterer
+}
+
+// bind arranges the values used with a statement prepared with translated SQL
+func (t *Translator) bind(ctx context.Context, c statementContext, query vparam.Queryer) {
+	if p, ok := query.(*prepared); ok && c.Parameterer() != nil {
+		values, err := p.plan.values(c.Parameterer())
+		if err != nil {
+			c.SetError(err)
+			return
+		}
+		c.SetParameterer(&translated{sql: p.sql, params: p.plan.params(t.Dialect, values)})
+	}
+	c.Next(ctx)
+}
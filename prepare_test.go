@@ -44,8 +44,10 @@ func TestEngine_Prepare(t *testing.T) {
 		t.Error("expected parameters to be passed")
 	}
 
+	var actualStatementQuery vparam.Queryer
 	engine.StatementQueryMW().Append(func(ctx context.Context, c engine_context.StatementQueryer) {
 		actualStatement = c.Statement()
+		actualStatementQuery = c.Query()
 		c.SetRows(expectedRows)
 		c.Next(ctx)
 	})
@@ -53,6 +55,9 @@ func TestEngine_Prepare(t *testing.T) {
 	if actualStatement != expectedStatement {
 		t.Error("expected actual statement to be set")
 	}
+	if actualStatementQuery != expectedParams {
+		t.Error("expected the prepared query to be passed to the statement")
+	}
 
 	engine.RowsNextMW().Append(func(ctx context.Context, c engine_context.RowsNexter) {
 		actualRows = c.Rows()
@@ -0,0 +1,161 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package query_budget
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// query_budget caps the database work a single inbound request may do. A Budget is attached to the context.Context
+// the application passes to Query/Exec/Prepare/Begin, and the middleware installed by Install charges every call made
+// with that context against it.
+
+// Resource names the part of the budget that ran out
+type Resource string
+
+const (
+	// Queries is the number of Query, Insert and Exec calls, direct or through prepared statements
+	Queries Resource = "queries"
+	// Rows is the number of rows read from query results with Next
+	Rows Resource = "rows"
+	// DBTime is the cumulative time spent in every chain, including Begin, Commit, Prepare and RowsNext
+	DBTime Resource = "db time"
+)
+
+// Limits are the maximums for a single Budget. A zero value means that resource is unlimited.
+type Limits struct {
+	MaxQueries int
+	MaxRows    int
+	MaxDBTime  time.Duration
+}
+
+// Usage is the amount of the budget consumed so far
+type Usage struct {
+	Queries int
+	Rows    int
+	DBTime  time.Duration
+}
+
+// ErrBudgetExceeded is returned by calls that would exceed the budget. The call that returns it is not made.
+type ErrBudgetExceeded struct {
+	// Resource is the limit that was reached
+	Resource Resource
+	Limits   Limits
+	// Usage is the usage at the time the call was refused
+	Usage Usage
+}
+
+func (e ErrBudgetExceeded) Error() string {
+	return fmt.Sprintf("query budget exceeded: %s (queries: %d/%d, rows: %d/%d, db time: %s/%s)", e.Resource,
+		e.Usage.Queries, e.Limits.MaxQueries, e.Usage.Rows, e.Limits.MaxRows, e.Usage.DBTime, e.Limits.MaxDBTime)
+}
+
+type budgetKey struct{}
+
+// Budget tracks the usage of one request. It is safe for concurrent use by the goroutines serving the request.
+type Budget struct {
+	mu       sync.Mutex
+	limits   Limits
+	usage    Usage
+	exceeded *ErrBudgetExceeded
+}
+
+// WithBudget returns a copy of ctx with a new Budget attached, and that Budget. Read the Budget's Usage at the end of
+// the request to log it.
+func WithBudget(ctx context.Context, limits Limits) (context.Context, *Budget) {
+	b := &Budget{
+		limits: limits,
+	}
+	return context.WithValue(ctx, budgetKey{}, b), b
+}
+
+// BudgetFrom returns the Budget attached to ctx, or nil if there isn't one
+func BudgetFrom(ctx context.Context) *Budget {
+	if ctx == nil {
+		return nil
+	}
+	b, _ := ctx.Value(budgetKey{}).(*Budget)
+	return b
+}
+
+// Limits are the limits the Budget was created with
+func (b *Budget) Limits() Limits {
+	return b.limits
+}
+
+// Usage is a copy of the usage so far
+func (b *Budget) Usage() Usage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.usage
+}
+
+// Err returns the most recent ErrBudgetExceeded, or nil if the budget has never been exceeded.
+// Because Rowser.Next cannot return an error, this is the way to find out that a result was cut short.
+func (b *Budget) Err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.exceeded == nil {
+		return nil
+	}
+	return b.exceeded
+}
+
+// admit checks that a call may proceed. If isQuery, the call also counts against MaxQueries.
+func (b *Budget) admit(isQuery bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limits.MaxDBTime > 0 && b.usage.DBTime >= b.limits.MaxDBTime {
+		return b.exceed(DBTime)
+	}
+	if isQuery {
+		if b.limits.MaxQueries > 0 && b.usage.Queries >= b.limits.MaxQueries {
+			return b.exceed(Queries)
+		}
+		b.usage.Queries++
+	}
+	return nil
+}
+
+// admitRow counts a row that was read. It returns an error, and the row is not counted, if it is one too many.
+func (b *Budget) admitRow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limits.MaxRows > 0 && b.usage.Rows >= b.limits.MaxRows {
+		return b.exceed(Rows)
+	}
+	b.usage.Rows++
+	return nil
+}
+
+func (b *Budget) spend(elapsed time.Duration) {
+	b.mu.Lock()
+	b.usage.DBTime += elapsed
+	b.mu.Unlock()
+}
+
+// exceed records and returns the error. b.mu must be held.
+func (b *Budget) exceed(r Resource) error {
+	b.exceeded = &ErrBudgetExceeded{
+		Resource: r,
+		Limits:   b.limits,
+		Usage:    b.usage,
+	}
+	return b.exceeded
+}
@@ -0,0 +1,118 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package query_budget
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vresult"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"testing"
+	"time"
+)
+
+// useFakeClock makes every read of the clock advance it by step. Call the returned function to restore the clock.
+func useFakeClock(step time.Duration) (restore func()) {
+	current := time.Time{}
+	now = func() time.Time {
+		current = current.Add(step)
+		return current
+	}
+	return func() { now = time.Now }
+}
+
+func newTestEngine() vsql_engine.MultiTXer {
+	e := vsql_engine.NewMulti()
+	e.QueryMW().Append(func(ctx context.Context, c engine_context.Queryer) {
+		rows := &vrows.RowserMock{}
+		rows.On("Next").Return(&vrows.RowerMock{})
+		rows.On("Close").Return(nil)
+		c.SetRows(rows)
+		c.Next(ctx)
+	})
+	e.ExecQueryMW().Append(func(ctx context.Context, c engine_context.Execer) {
+		c.SetResult(&vresult.ResulterMock{})
+		c.Next(ctx)
+	})
+	e.RowsNextMW().Append(func(ctx context.Context, c engine_context.RowsNexter) {
+		c.SetRow(c.Rows().Next())
+		c.Next(ctx)
+	})
+	e.RowsCloseMW().Append(func(ctx context.Context, c engine_context.Rowser) {
+		c.SetError(c.Rows().Close())
+		c.Next(ctx)
+	})
+	Install(e)
+	return e
+}
+
+func TestBudget_MaxQueries(t *testing.T) {
+	e := newTestEngine()
+	ctx, b := WithBudget(context.Background(), Limits{MaxQueries: 2})
+	_, err := e.Query(ctx, vparam.New("SELECT 1"))
+	assert.NoError(t, err)
+	_, err = e.Exec(ctx, vparam.New("DELETE FROM t"))
+	assert.NoError(t, err)
+	_, err = e.Query(ctx, vparam.New("SELECT 1"))
+	if assert.IsType(t, &ErrBudgetExceeded{}, err) {
+		exceeded := err.(*ErrBudgetExceeded)
+		assert.Equal(t, Queries, exceeded.Resource)
+		assert.Equal(t, 2, exceeded.Usage.Queries)
+	}
+	assert.Equal(t, 2, b.Usage().Queries)
+	assert.Equal(t, err, b.Err())
+
+	// other requests are not affected
+	_, err = e.Query(context.Background(), vparam.New("SELECT 1"))
+	assert.NoError(t, err)
+}
+
+func TestBudget_MaxRows(t *testing.T) {
+	e := newTestEngine()
+	ctx, b := WithBudget(context.Background(), Limits{MaxRows: 3})
+	rows, err := e.Query(ctx, vparam.New("SELECT * FROM t"))
+	assert.NoError(t, err)
+	read := 0
+	for rows.Next() != nil {
+		read++
+	}
+	assert.NoError(t, rows.Close())
+	assert.Equal(t, 3, read)
+	assert.Equal(t, 3, b.Usage().Rows)
+	if assert.Error(t, b.Err()) {
+		assert.Equal(t, Rows, b.Err().(*ErrBudgetExceeded).Resource)
+	}
+}
+
+func TestBudget_MaxDBTime(t *testing.T) {
+	defer useFakeClock(time.Second)()
+	e := newTestEngine()
+	ctx, b := WithBudget(context.Background(), Limits{MaxDBTime: 2 * time.Second})
+	tx, err := e.Begin(ctx, nil)
+	assert.NoError(t, err)
+	_, err = tx.Exec(ctx, vparam.New("UPDATE t SET a = 1"))
+	assert.NoError(t, err)
+	_, err = tx.Exec(ctx, vparam.New("UPDATE t SET a = 2"))
+	if assert.Error(t, err) {
+		assert.Equal(t, DBTime, err.(*ErrBudgetExceeded).Resource)
+	}
+	assert.Error(t, tx.Commit(), "expected commit to be refused")
+	assert.NoError(t, tx.Rollback(), "expected rollback to always be allowed")
+	assert.Equal(t, 3*time.Second, b.Usage().DBTime)
+}
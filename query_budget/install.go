@@ -0,0 +1,145 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package query_budget
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/engine_ware"
+	"time"
+)
+
+// now is replaceable for tests
+var now = time.Now
+
+// Install prepends budget enforcement to every chain of the engine that receives the caller's context. Calls made
+// with a context that has no Budget are not affected. Begin is covered for both SingleTXer and MultiTXer engines.
+func Install(e vsql_engine.SQLQueryer) {
+	e.QueryMW().Prepend(func(ctx context.Context, c engine_context.Queryer) {
+		if err := charge(ctx, true, c.Next); err != nil {
+			c.SetError(err)
+		}
+	})
+	e.InsertQueryMW().Prepend(func(ctx context.Context, c engine_context.Inserter) {
+		if err := charge(ctx, true, c.Next); err != nil {
+			c.SetError(err)
+		}
+	})
+	e.ExecQueryMW().Prepend(func(ctx context.Context, c engine_context.Execer) {
+		if err := charge(ctx, true, c.Next); err != nil {
+			c.SetError(err)
+		}
+	})
+	e.StatementQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementQueryer) {
+		if err := charge(ctx, true, c.Next); err != nil {
+			c.SetError(err)
+		}
+	})
+	e.StatementInsertQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementInsertQueryer) {
+		if err := charge(ctx, true, c.Next); err != nil {
+			c.SetError(err)
+		}
+	})
+	e.StatementExecQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		if err := charge(ctx, true, c.Next); err != nil {
+			c.SetError(err)
+		}
+	})
+	e.StatementPrepareMW().Prepend(func(ctx context.Context, c engine_context.Preparer) {
+		if err := charge(ctx, false, c.Next); err != nil {
+			c.SetError(err)
+		}
+	})
+	e.PingMW().Prepend(func(ctx context.Context, c engine_context.Er) {
+		if err := charge(ctx, false, c.Next); err != nil {
+			c.SetError(err)
+		}
+	})
+	e.CommitMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+		if err := charge(ctx, false, c.Next); err != nil {
+			c.SetError(err)
+		}
+	})
+	e.RollbackMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+		// rolling back must always be allowed so the transaction is not left open
+		chargeAlways(ctx, c.Next)
+	})
+	if b, ok := e.(engine_ware.BeginWare); ok {
+		b.BeginMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+			if err := charge(ctx, false, c.Next); err != nil {
+				c.SetError(err)
+			}
+		})
+	}
+	if b, ok := e.(engine_ware.BeginNestedWare); ok {
+		b.BeginNestedMW().Prepend(func(ctx context.Context, c engine_context.NestedBeginner) {
+			if err := charge(ctx, false, c.Next); err != nil {
+				c.SetError(err)
+			}
+		})
+	}
+	e.RowsNextMW().Prepend(func(ctx context.Context, c engine_context.RowsNexter) {
+		b := BudgetFrom(ctx)
+		if b == nil {
+			c.Next(ctx)
+			return
+		}
+		if err := charge(ctx, false, c.Next); err != nil {
+			c.SetError(err)
+			c.SetRow(nil)
+			return
+		}
+		if c.Row() != nil {
+			if err := b.admitRow(); err != nil {
+				// the row was fetched, but the caller may not have it. Ending the results early is the only way to
+				// refuse it as Next does not return errors.
+				c.SetError(err)
+				c.SetRow(nil)
+			}
+		}
+	})
+	e.RowsCloseMW().Prepend(func(ctx context.Context, c engine_context.Rowser) {
+		// closing must always be allowed to release resources
+		chargeAlways(ctx, c.Next)
+	})
+}
+
+// charge runs next if the budget in ctx allows it and adds the time it took to the budget. If the budget does not
+// allow it, next is not run and the ErrBudgetExceeded is returned.
+func charge(ctx context.Context, isQuery bool, next func(ctx context.Context)) error {
+	b := BudgetFrom(ctx)
+	if b == nil {
+		next(ctx)
+		return nil
+	}
+	if err := b.admit(isQuery); err != nil {
+		return err
+	}
+	start := now()
+	next(ctx)
+	b.spend(now().Sub(start))
+	return nil
+}
+
+// chargeAlways runs next and adds the time it took to the budget in ctx, if any, even if the budget is exhausted
+func chargeAlways(ctx context.Context, next func(ctx context.Context)) {
+	start := now()
+	next(ctx)
+	if b := BudgetFrom(ctx); b != nil {
+		b.spend(now().Sub(start))
+	}
+}
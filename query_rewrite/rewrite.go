@@ -0,0 +1,106 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package query_rewrite
+
+import (
+	"github.com/wojnosystems/vsql/interpolation_strategy"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine/sql_lexer"
+	"sort"
+	"strings"
+)
+
+// query_rewrite helps middleware change the SQL of a vparam.Queryer before the driver sees it. vparam.Queryer does
+// not allow its SQL to be replaced and named parameters cannot be moved independently of their values, so queries
+// are first flattened to positional ? placeholders and their values. The rewritten SQL and values are then wrapped
+// in a new vparam.Appender with New.
+
+// Positional returns the SQL of query with every placeholder replaced by ?, and the values of the placeholders, in
+// order
+func Positional(query vparam.Queryer) (sqlQuery string, params []interface{}, err error) {
+	return query.Interpolate(query.SQLQueryUnInterpolated(), questionMark{})
+}
+
+// PositionalSQL returns the SQL of query with every placeholder replaced by ?. Unlike Positional, the query's values
+// are not needed, so it may be used for queries being prepared.
+func PositionalSQL(query vparam.Queryer) string {
+	return query.SQLQueryInterpolated(questionMark{})
+}
+
+// PositionalParameters returns the values of parameterer, in order, for the un-interpolated sqlQuery they are for
+func PositionalParameters(sqlQuery string, parameterer vparam.Parameterer) (params []interface{}, err error) {
+	_, params, err = parameterer.Interpolate(sqlQuery, questionMark{})
+	return
+}
+
+// New creates a query from positional SQL and its values, such as those returned by Positional
+func New(sqlQuery string, params []interface{}) vparam.Queryer {
+	return vparam.NewAppendWithData(sqlQuery, params...)
+}
+
+// Parameters creates a Parameterer of positional values for a statement prepared with positional SQL, such as SQL
+// returned by PositionalSQL. Unlike vparam.NewAppendData, it interpolates the statement's SQL.
+func Parameters(params []interface{}) vparam.Parameterer {
+	return positionalParameters(params)
+}
+
+type positionalParameters []interface{}
+
+func (p positionalParameters) Interpolate(sqlQuery string, strategy interpolation_strategy.InterpolateStrategy) (interpolatedSQLQuery string, params []interface{}, err error) {
+	tokens := sql_lexer.Lex(sqlQuery)
+	count := 0
+	for i, t := range tokens {
+		if t.Kind == sql_lexer.Placeholder {
+			tokens[i].Text = strategy.InsertPlaceholderIntoSQL()
+			count++
+		}
+	}
+	if count != len(p) {
+		return "", []interface{}{}, vparam.ErrParameterPlaceholderMismatch
+	}
+	return sql_lexer.Join(tokens), p, nil
+}
+
+type questionMark struct{}
+
+func (questionMark) InsertPlaceholderIntoSQL() string {
+	return "?"
+}
+
+// Edit replaces the bytes from Pos up to End of a query with Text. Use Pos == End to insert.
+type Edit struct {
+	Pos, End int
+	Text     string
+}
+
+// Splice applies edits to sqlQuery. Positions are of the original sqlQuery. Edits must not overlap; edits inserting
+// at the same position are applied in the order given.
+func Splice(sqlQuery string, edits ...Edit) string {
+	sorted := make([]Edit, len(edits))
+	copy(sorted, edits)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Pos < sorted[j].Pos
+	})
+	sb := strings.Builder{}
+	last := 0
+	for _, e := range sorted {
+		sb.WriteString(sqlQuery[last:e.Pos])
+		sb.WriteString(e.Text)
+		last = e.End
+	}
+	sb.WriteString(sqlQuery[last:])
+	return sb.String()
+}
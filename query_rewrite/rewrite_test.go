@@ -0,0 +1,63 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package query_rewrite
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/wojnosystems/vsql/vparam"
+	"testing"
+)
+
+func TestPositional(t *testing.T) {
+	sqlQuery, params, err := Positional(vparam.NewNamedWithData("SELECT * FROM t WHERE a = :a AND b = :b OR a = :a",
+		map[string]interface{}{"a": 1, "b": "two"}))
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM t WHERE a = ? AND b = ? OR a = ?", sqlQuery)
+	assert.Equal(t, []interface{}{1, "two", 1}, params)
+
+	sqlQuery, params, err = Positional(vparam.NewAppendWithData("SELECT ?", 1))
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT ?", sqlQuery)
+	assert.Equal(t, []interface{}{1}, params)
+}
+
+func TestSplice(t *testing.T) {
+	assert.Equal(t, "SELECT a FROM s.t WHERE x = ? LIMIT 1",
+		Splice("SELECT * FROM t LIMIT 1",
+			Edit{Pos: 14, End: 15, Text: "s.t"},
+			Edit{Pos: 15, End: 15, Text: " WHERE x = ?"},
+			Edit{Pos: 7, End: 8, Text: "a"},
+		))
+}
+
+type dollar struct {
+	n int
+}
+
+func (d *dollar) InsertPlaceholderIntoSQL() string {
+	d.n++
+	return "$" + string(rune('0'+d.n))
+}
+
+func TestParameters(t *testing.T) {
+	sqlQuery, params, err := Parameters([]interface{}{1, 2}).Interpolate("SELECT '?' FROM t WHERE a = ? AND b = ?", &dollar{})
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT '?' FROM t WHERE a = $1 AND b = $2", sqlQuery)
+	assert.Equal(t, []interface{}{1, 2}, params)
+
+	_, _, err = Parameters([]interface{}{1}).Interpolate("SELECT ?, ?", &dollar{})
+	assert.Equal(t, vparam.ErrParameterPlaceholderMismatch, err)
+}
@@ -0,0 +1,169 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package query_stats
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql/vresult"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/fingerprint"
+	"sync"
+	"time"
+)
+
+// query_stats is an in-process version of Postgres' pg_stat_statements. It aggregates call counts, latencies, row
+// counts and errors per query fingerprint for both direct and prepared-statement queries.
+
+// Aggregator collects the statistics. Create one with New and install it into an engine with Install.
+// It is safe to use from multiple goroutines and may be shared by several engines or groups.
+type Aggregator struct {
+	mu         sync.RWMutex
+	entries    map[uint64]*entry
+	sampleSize int
+
+	// openRows maps the vrows.Rowser returned by a query to the entry of the query that created it, so rows read
+	// through RowsNext are credited to the right fingerprint. Entries are removed when the rows are closed, so rows
+	// that are never closed are held until Reset.
+	openRows engine_context.Tracker[*entry]
+
+	// now is replaceable for tests
+	now func() time.Time
+}
+
+// New creates an empty Aggregator that keeps DefaultLatencySamples durations per fingerprint for the P95 calculation
+func New() *Aggregator {
+	return NewWithLatencySamples(DefaultLatencySamples)
+}
+
+// NewWithLatencySamples creates an empty Aggregator that keeps sampleSize durations per fingerprint for the P95
+// calculation. Larger sample sizes are more accurate, but use more memory.
+func NewWithLatencySamples(sampleSize int) *Aggregator {
+	if sampleSize < 0 {
+		sampleSize = 0
+	}
+	return &Aggregator{
+		entries:    make(map[uint64]*entry),
+		sampleSize: sampleSize,
+		now:        time.Now,
+	}
+}
+
+// Install prepends the aggregator to the query chains of the engine. Because it is prepended, the time measured
+// includes any middleware that was already installed, such as the database driver.
+func (a *Aggregator) Install(e vsql_engine.SQLQueryer) {
+	e.QueryMW().Prepend(func(ctx context.Context, c engine_context.Queryer) {
+		started := a.now()
+		c.Next(ctx)
+		if en := a.recordCall(c.Fingerprint(), started, c.Error()); en != nil && c.Error() == nil {
+			a.trackRows(c.Rows(), en)
+		}
+	})
+	e.ExecQueryMW().Prepend(func(ctx context.Context, c engine_context.Execer) {
+		started := a.now()
+		c.Next(ctx)
+		if en := a.recordCall(c.Fingerprint(), started, c.Error()); en != nil && c.Error() == nil && c.Result() != nil {
+			addRowsAffected(en, c.Result())
+		}
+	})
+	e.InsertQueryMW().Prepend(func(ctx context.Context, c engine_context.Inserter) {
+		started := a.now()
+		c.Next(ctx)
+		if en := a.recordCall(c.Fingerprint(), started, c.Error()); en != nil && c.Error() == nil && c.InsertResult() != nil {
+			addRowsAffected(en, c.InsertResult())
+		}
+	})
+	e.StatementQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementQueryer) {
+		started := a.now()
+		c.Next(ctx)
+		if en := a.recordCall(c.Fingerprint(), started, c.Error()); en != nil && c.Error() == nil {
+			a.trackRows(c.Rows(), en)
+		}
+	})
+	e.StatementExecQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		started := a.now()
+		c.Next(ctx)
+		if en := a.recordCall(c.Fingerprint(), started, c.Error()); en != nil && c.Error() == nil && c.Result() != nil {
+			addRowsAffected(en, c.Result())
+		}
+	})
+	e.StatementInsertQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementInsertQueryer) {
+		started := a.now()
+		c.Next(ctx)
+		if en := a.recordCall(c.Fingerprint(), started, c.Error()); en != nil && c.Error() == nil && c.InsertResult() != nil {
+			addRowsAffected(en, c.InsertResult())
+		}
+	})
+	e.RowsNextMW().Prepend(func(ctx context.Context, c engine_context.RowsNexter) {
+		c.Next(ctx)
+		if c.Row() == nil {
+			return
+		}
+		if en, ok := a.openRows.Lookup(c.Rows()); ok {
+			en.addRowsReturned(1)
+		}
+	})
+	e.RowsCloseMW().Prepend(func(ctx context.Context, c engine_context.Rowser) {
+		c.Next(ctx)
+		a.openRows.Forget(c.Rows())
+	})
+}
+
+// recordCall credits a call to the entry for f, creating it if necessary. Calls without a query are ignored.
+func (a *Aggregator) recordCall(f fingerprint.Fingerprinter, started time.Time, err error) *entry {
+	if f == nil {
+		return nil
+	}
+	en := a.entryFor(f)
+	en.recordCall(a.now().Sub(started), err)
+	return en
+}
+
+func (a *Aggregator) entryFor(f fingerprint.Fingerprinter) *entry {
+	a.mu.RLock()
+	en, ok := a.entries[f.Hash()]
+	a.mu.RUnlock()
+	if ok {
+		return en
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if en, ok = a.entries[f.Hash()]; !ok {
+		en = newEntry(f.ID(), f.Normalized(), f.StatementType().String(), a.sampleSize)
+		a.entries[f.Hash()] = en
+	}
+	return en
+}
+
+func (a *Aggregator) trackRows(rows vrows.Rowser, en *entry) {
+	a.openRows.Track(rows, en)
+}
+
+func addRowsAffected(en *entry, result vresult.Resulter) {
+	if n, err := result.RowsAffected(); err == nil {
+		en.addRowsAffected(uint64(n))
+	}
+}
+
+// Reset discards every statistic collected so far. It also forgets the rows that are still open, which are no longer
+// counted; call it periodically if the application may leave rows unclosed.
+func (a *Aggregator) Reset() {
+	a.mu.Lock()
+	a.entries = make(map[uint64]*entry)
+	a.mu.Unlock()
+	a.openRows.Reset()
+}
@@ -0,0 +1,205 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package query_stats
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/wojnosystems/vsql/ulong"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vresult"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql/vstmt"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/fingerprint"
+	"testing"
+	"time"
+)
+
+// fakeClock advances by step every time it is read
+type fakeClock struct {
+	current time.Time
+	step    time.Duration
+}
+
+func (f *fakeClock) now() time.Time {
+	f.current = f.current.Add(f.step)
+	return f.current
+}
+
+func newTestEngine() (vsql_engine.SingleTXer, *Aggregator) {
+	e := vsql_engine.NewSingle()
+	rows := &vrows.RowserMock{}
+	rows.On("Next").Return(&vrows.RowerMock{}).Twice()
+	rows.On("Next").Return(nil)
+	rows.On("Close").Return(nil)
+	result := &vresult.ResulterMock{}
+	result.On("RowsAffected").Return(ulong.New(3), nil)
+
+	// the "driver"
+	e.QueryMW().Append(func(ctx context.Context, c engine_context.Queryer) {
+		if c.Query().SQLQueryUnInterpolated() == "SELECT broken" {
+			c.SetError(errors.New("syntax error"))
+			return
+		}
+		c.SetRows(rows)
+		c.Next(ctx)
+	})
+	e.ExecQueryMW().Append(func(ctx context.Context, c engine_context.Execer) {
+		c.SetResult(result)
+		c.Next(ctx)
+	})
+	e.StatementPrepareMW().Append(func(ctx context.Context, c engine_context.Preparer) {
+		c.SetStatement(&vstmt.StatementerMock{})
+		c.Next(ctx)
+	})
+	e.StatementExecQueryMW().Append(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		c.SetResult(result)
+		c.Next(ctx)
+	})
+	e.RowsNextMW().Append(func(ctx context.Context, c engine_context.RowsNexter) {
+		c.SetRow(c.Rows().Next())
+		c.Next(ctx)
+	})
+	e.RowsCloseMW().Append(func(ctx context.Context, c engine_context.Rowser) {
+		c.SetError(c.Rows().Close())
+		c.Next(ctx)
+	})
+
+	a := New()
+	clock := &fakeClock{step: time.Millisecond}
+	a.now = clock.now
+	a.Install(e)
+	return e, a
+}
+
+func TestAggregator_Query(t *testing.T) {
+	e, a := newTestEngine()
+	ctx := context.Background()
+
+	r, err := e.Query(ctx, vparam.New("SELECT * FROM users WHERE id = 1"))
+	assert.NoError(t, err)
+	for r.Next() != nil {
+	}
+	assert.NoError(t, r.Close())
+	_, _ = e.Query(ctx, vparam.New("SELECT * FROM users WHERE id = 2"))
+	_, err = e.Query(ctx, vparam.New("SELECT broken"))
+	assert.Error(t, err)
+
+	stats := a.TopN(0)
+	assert.Len(t, stats, 2)
+	users := stats[0]
+	assert.Equal(t, "select * from users where id = ?", users.Query)
+	assert.Equal(t, "SELECT", users.StatementType)
+	assert.Equal(t, uint64(2), users.Calls)
+	assert.Equal(t, uint64(2), users.RowsReturned)
+	assert.Equal(t, 2*time.Millisecond, users.TotalTime)
+	assert.Equal(t, time.Millisecond, users.MinTime)
+	assert.Equal(t, time.Millisecond, users.MeanTime)
+	assert.Equal(t, time.Millisecond, users.P95Time)
+
+	broken := stats[1]
+	assert.Equal(t, uint64(1), broken.Errors)
+	assert.Equal(t, "syntax error", broken.LastError)
+}
+
+func TestAggregator_Exec(t *testing.T) {
+	e, a := newTestEngine()
+	ctx := context.Background()
+
+	_, _ = e.Exec(ctx, vparam.New("DELETE FROM sessions WHERE id = 5"))
+	s, _ := e.Prepare(ctx, vparam.New("DELETE FROM sessions WHERE id = ?"))
+	_, _ = s.Exec(ctx, vparam.NewAppendData(6))
+
+	stats := a.Snapshot()
+	if assert.Len(t, stats, 1, "direct and prepared calls share a fingerprint") {
+		assert.Equal(t, uint64(2), stats[0].Calls)
+		assert.Equal(t, uint64(6), stats[0].RowsAffected)
+		assert.Equal(t, "DELETE", stats[0].StatementType)
+	}
+}
+
+func TestAggregator_TopNAndReset(t *testing.T) {
+	a := New()
+	a.entryFor(fakeFingerprint("a")).recordCall(time.Second, nil)
+	a.entryFor(fakeFingerprint("b")).recordCall(3*time.Second, nil)
+	a.entryFor(fakeFingerprint("c")).recordCall(2*time.Second, nil)
+
+	top := a.TopN(2)
+	if assert.Len(t, top, 2) {
+		assert.Equal(t, "select b", top[0].Query)
+		assert.Equal(t, "select c", top[1].Query)
+	}
+	a.Reset()
+	assert.Empty(t, a.Snapshot())
+}
+
+func TestAggregator_ResetForgetsOpenRows(t *testing.T) {
+	e, a := newTestEngine()
+	var rows vrows.Rowser
+	e.RowsNextMW().Prepend(func(ctx context.Context, c engine_context.RowsNexter) {
+		rows = c.Rows()
+		c.Next(ctx)
+	})
+	r, err := e.Query(context.Background(), vparam.New("SELECT * FROM users"))
+	assert.NoError(t, err)
+	r.Next()
+	_, tracked := a.openRows.Lookup(rows)
+	assert.True(t, tracked)
+	a.Reset()
+	_, tracked = a.openRows.Lookup(rows)
+	assert.False(t, tracked, "expected Reset to forget rows that were not closed")
+}
+
+func TestAggregator_Export(t *testing.T) {
+	a := New()
+	a.entryFor(fakeFingerprint("a")).recordCall(time.Second, errors.New("boom"))
+
+	jsonOut := &bytes.Buffer{}
+	assert.NoError(t, a.WriteJSON(jsonOut))
+	var decoded []Stat
+	assert.NoError(t, json.Unmarshal(jsonOut.Bytes(), &decoded))
+	assert.Equal(t, a.Snapshot(), decoded)
+
+	csvOut := &bytes.Buffer{}
+	assert.NoError(t, a.WriteCSV(csvOut))
+	records, err := csv.NewReader(csvOut).ReadAll()
+	assert.NoError(t, err)
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, csvHeader, records[0])
+		assert.Equal(t, "1", records[1][2])
+		assert.Equal(t, "boom", records[1][11])
+		assert.Equal(t, "select a", records[1][12])
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	samples := make([]time.Duration, 0, 100)
+	for i := 100; i > 0; i-- {
+		samples = append(samples, time.Duration(i))
+	}
+	assert.Equal(t, time.Duration(95), percentile(samples, 0.95))
+	assert.Equal(t, time.Duration(0), percentile(nil, 0.95))
+}
+
+func fakeFingerprint(name string) fingerprint.Fingerprinter {
+	return fingerprint.New("SELECT " + name)
+}
@@ -0,0 +1,89 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package query_stats
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Snapshot returns a copy of the statistics for every fingerprint seen since the aggregator was created or last Reset.
+// The result is sorted by fingerprint so consecutive snapshots are easy to compare.
+func (a *Aggregator) Snapshot() (stats []Stat) {
+	a.mu.RLock()
+	stats = make([]Stat, 0, len(a.entries))
+	for _, en := range a.entries {
+		stats = append(stats, en.snapshot())
+	}
+	a.mu.RUnlock()
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Fingerprint < stats[j].Fingerprint })
+	return
+}
+
+// TopN returns the n fingerprints that have consumed the most total time, most expensive first.
+// If n is less than 1 or greater than the number of fingerprints, all fingerprints are returned.
+func (a *Aggregator) TopN(n int) (stats []Stat) {
+	stats = a.Snapshot()
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].TotalTime > stats[j].TotalTime })
+	if n > 0 && n < len(stats) {
+		stats = stats[:n]
+	}
+	return
+}
+
+// WriteJSON writes the Snapshot to w as a JSON array. Durations are written in nanoseconds.
+func (a *Aggregator) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(a.Snapshot())
+}
+
+// csvHeader is the first row written by WriteCSV. Durations are written in nanoseconds.
+var csvHeader = []string{
+	"fingerprint", "statement_type", "calls", "errors", "total_time_ns", "min_time_ns", "max_time_ns",
+	"mean_time_ns", "p95_time_ns", "rows_returned", "rows_affected", "last_error", "query",
+}
+
+// WriteCSV writes the Snapshot to w as CSV with a header row
+func (a *Aggregator) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, s := range a.Snapshot() {
+		err := cw.Write([]string{
+			s.Fingerprint,
+			s.StatementType,
+			strconv.FormatUint(s.Calls, 10),
+			strconv.FormatUint(s.Errors, 10),
+			strconv.FormatInt(int64(s.TotalTime), 10),
+			strconv.FormatInt(int64(s.MinTime), 10),
+			strconv.FormatInt(int64(s.MaxTime), 10),
+			strconv.FormatInt(int64(s.MeanTime), 10),
+			strconv.FormatInt(int64(s.P95Time), 10),
+			strconv.FormatUint(s.RowsReturned, 10),
+			strconv.FormatUint(s.RowsAffected, 10),
+			s.LastError,
+			s.Query,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,133 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package query_stats
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stat is a point-in-time copy of the statistics for a single query fingerprint
+type Stat struct {
+	// Fingerprint is the fingerprint ID of the query
+	Fingerprint string `json:"fingerprint"`
+	// Query is the normalized query
+	Query string `json:"query"`
+	// StatementType is SELECT, INSERT, UPDATE, DELETE, DDL or OTHER
+	StatementType string `json:"statement_type"`
+	Calls         uint64 `json:"calls"`
+	Errors        uint64 `json:"errors"`
+	// LastError is the message of the most recent error, if any
+	LastError string        `json:"last_error,omitempty"`
+	TotalTime time.Duration `json:"total_time_ns"`
+	MinTime   time.Duration `json:"min_time_ns"`
+	MaxTime   time.Duration `json:"max_time_ns"`
+	MeanTime  time.Duration `json:"mean_time_ns"`
+	// P95Time is the 95th percentile of the most recent calls, see DefaultLatencySamples
+	P95Time time.Duration `json:"p95_time_ns"`
+	// RowsReturned is the number of rows read through Next from Query results
+	RowsReturned uint64 `json:"rows_returned"`
+	// RowsAffected is the sum of RowsAffected reported by Exec and Insert results
+	RowsAffected uint64 `json:"rows_affected"`
+}
+
+// DefaultLatencySamples is the number of most recent call durations kept per fingerprint to calculate P95Time
+const DefaultLatencySamples = 1024
+
+// entry accumulates the statistics for one fingerprint
+type entry struct {
+	mu   sync.Mutex
+	stat Stat
+	// samples is a ring buffer of the most recent call durations
+	samples    []time.Duration
+	nextSample int
+}
+
+func newEntry(id, query, statementType string, sampleSize int) *entry {
+	return &entry{
+		stat: Stat{
+			Fingerprint:   id,
+			Query:         query,
+			StatementType: statementType,
+		},
+		samples: make([]time.Duration, 0, sampleSize),
+	}
+}
+
+func (e *entry) recordCall(elapsed time.Duration, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := &e.stat
+	s.Calls++
+	s.TotalTime += elapsed
+	if s.Calls == 1 || elapsed < s.MinTime {
+		s.MinTime = elapsed
+	}
+	if elapsed > s.MaxTime {
+		s.MaxTime = elapsed
+	}
+	if err != nil {
+		s.Errors++
+		s.LastError = err.Error()
+	}
+	if len(e.samples) < cap(e.samples) {
+		e.samples = append(e.samples, elapsed)
+	} else if cap(e.samples) > 0 {
+		e.samples[e.nextSample] = elapsed
+		e.nextSample = (e.nextSample + 1) % cap(e.samples)
+	}
+}
+
+func (e *entry) addRowsReturned(n uint64) {
+	e.mu.Lock()
+	e.stat.RowsReturned += n
+	e.mu.Unlock()
+}
+
+func (e *entry) addRowsAffected(n uint64) {
+	e.mu.Lock()
+	e.stat.RowsAffected += n
+	e.mu.Unlock()
+}
+
+// snapshot copies the statistics and calculates the derived values
+func (e *entry) snapshot() Stat {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := e.stat
+	if s.Calls > 0 {
+		s.MeanTime = s.TotalTime / time.Duration(s.Calls)
+	}
+	s.P95Time = percentile(e.samples, 0.95)
+	return s
+}
+
+// percentile calculates the nearest-rank percentile p (0-1) of samples
+func percentile(samples []time.Duration, p float64) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
@@ -68,3 +68,32 @@ func TestEngine_Query(t *testing.T) {
 	}
 
 }
+
+type queryTestKey struct{}
+
+func TestEngine_QueryContextPassedToRows(t *testing.T) {
+	ctx := context.WithValue(context.Background(), queryTestKey{}, "puppy")
+	engine := NewSingle()
+	engine.QueryMW().Append(func(ctx context.Context, c engine_context.Queryer) {
+		c.SetRows(&vrows.RowserMock{})
+		c.Next(ctx)
+	})
+	var nextValue, closeValue interface{}
+	engine.RowsNextMW().Append(func(ctx context.Context, c engine_context.RowsNexter) {
+		nextValue = ctx.Value(queryTestKey{})
+		c.Next(ctx)
+	})
+	engine.RowsCloseMW().Append(func(ctx context.Context, c engine_context.Rowser) {
+		closeValue = ctx.Value(queryTestKey{})
+		c.Next(ctx)
+	})
+	rows, _ := engine.Query(ctx, vparam.New("SELECT * FROM puppies"))
+	rows.Next()
+	_ = rows.Close()
+	if nextValue != "puppy" {
+		t.Error("expected RowsNext to receive the query context")
+	}
+	if closeValue != "puppy" {
+		t.Error("expected RowsClose to receive the query context")
+	}
+}
@@ -0,0 +1,109 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package vsql_engine
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/engine_ware"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestEngine_ConcurrentRegistration(t *testing.T) {
+	engine := NewSingle()
+	engine.QueryMW().Append(func(ctx context.Context, c engine_context.Queryer) {
+		c.SetRows(&vrows.RowserMock{})
+		c.Next(ctx)
+	})
+	var added int32
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				engine.QueryMW().Prepend(func(ctx context.Context, c engine_context.Queryer) {
+					atomic.AddInt32(&added, 1)
+					c.Next(ctx)
+				})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				rows, err := engine.Query(context.Background(), vparam.New("SELECT 1"))
+				if err != nil || rows == nil {
+					t.Error("expected the query to succeed")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	atomic.StoreInt32(&added, 0)
+	_, _ = engine.Query(context.Background(), vparam.New("SELECT 1"))
+	if added != 400 {
+		t.Errorf("expected all 400 handlers to be registered, %d ran", added)
+	}
+}
+
+func TestEngine_InFlightCallKeepsItsChain(t *testing.T) {
+	engine := NewSingle()
+	var lateRan bool
+	engine.QueryMW().Append(func(ctx context.Context, c engine_context.Queryer) {
+		engine.QueryMW().Append(func(ctx context.Context, c engine_context.Queryer) {
+			lateRan = true
+			c.Next(ctx)
+		})
+		c.Next(ctx)
+	})
+	_, _ = engine.Query(context.Background(), vparam.New("SELECT 1"))
+	if lateRan {
+		t.Error("expected a handler added during the call to not run in it")
+	}
+	_, _ = engine.Query(context.Background(), vparam.New("SELECT 1"))
+	if !lateRan {
+		t.Error("expected the handler to run in the next call")
+	}
+}
+
+func TestEngine_Seal(t *testing.T) {
+	engine := NewMulti()
+	engine.(Sealer).Seal()
+	assertPanicsWithErrSealed(t, func() {
+		engine.QueryMW().Append(func(ctx context.Context, c engine_context.Queryer) {})
+	})
+	assertPanicsWithErrSealed(t, func() {
+		engine.BeginNestedMW().Prepend(func(ctx context.Context, c engine_context.NestedBeginner) {})
+	})
+
+	group := engine.Group()
+	group.QueryMW().Append(func(ctx context.Context, c engine_context.Queryer) {})
+}
+
+func assertPanicsWithErrSealed(t *testing.T, f func()) {
+	defer func() {
+		if r := recover(); r != engine_ware.ErrSealed {
+			t.Errorf("expected a panic with ErrSealed, got %v", r)
+		}
+	}()
+	f()
+}
@@ -22,24 +22,40 @@ import (
 )
 
 type rows struct {
+	// ctx is the context the rows were queried with. It is passed on to the RowsNext and RowsClose middleware so that
+	// request-scoped values remain available while the results are read.
+	ctx                context.Context
 	rows               vrows.Rowser
 	queryEngineFactory *engineQuery
+	// middlewareContext is the context the calls made with the rows copy from. It holds the depth of the transaction they were queried in.
+	middlewareContext engine_context.WithMiddlewarer
 }
 
 // Next calls Next() on the sql.Rows object
 func (m *rows) Next() vrows.Rower {
-	c := engine_context.NewRowNext()
-	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.queryEngineFactory.middlewareContext)
+	c := engine_context.AcquireRowNext()
+	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
 	c.SetRows(m.rows)
-	m.queryEngineFactory.rowsNextMW.PerformMiddleware(context.Background(), c)
-	return c.Row()
+	m.queryEngineFactory.rowsNextMW.PerformMiddleware(m.context(), c)
+	row := c.Row()
+	engine_context.Release(c)
+	return row
 }
 
 // Close cleans up the Rows object, releasing it's object back to the pool. Call this when you're done with your vquery results
 func (m *rows) Close() error {
-	c := engine_context.NewRows()
-	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.queryEngineFactory.middlewareContext)
+	c := engine_context.AcquireRows()
+	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
 	c.SetRows(m.rows)
-	m.queryEngineFactory.rowsCloseMW.PerformMiddleware(context.Background(), c)
-	return c.Error()
+	m.queryEngineFactory.rowsCloseMW.PerformMiddleware(m.context(), c)
+	err := c.Error()
+	engine_context.Release(c)
+	return err
+}
+
+func (m *rows) context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
 }
@@ -0,0 +1,96 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rw_split
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Balancer chooses which replica serves a read. Implementations must be safe for concurrent use.
+type Balancer interface {
+	// Pick returns the index of the replica to use, from 0 to replicas-1
+	Pick(replicas int) int
+	// Observe is called with the time each read took on the replica Pick chose
+	Observe(replica int, elapsed time.Duration)
+}
+
+// NewRoundRobin creates a Balancer that uses each replica in turn
+func NewRoundRobin() Balancer {
+	return &roundRobin{}
+}
+
+type roundRobin struct {
+	next uint64
+}
+
+func (b *roundRobin) Pick(replicas int) int {
+	return int((atomic.AddUint64(&b.next, 1) - 1) % uint64(replicas))
+}
+
+func (b *roundRobin) Observe(int, time.Duration) {}
+
+// leastLatencyDecay is the weight of the newest observation in the moving average
+const leastLatencyDecay = 0.2
+
+// NewLeastLatency creates a Balancer that uses the replica with the lowest exponentially-weighted moving average
+// read latency. Replicas that have not been used yet are picked first, so every replica is measured.
+func NewLeastLatency() Balancer {
+	return &leastLatency{}
+}
+
+type leastLatency struct {
+	mu       sync.Mutex
+	averages []float64
+	observed []bool
+}
+
+func (b *leastLatency) Pick(replicas int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.grow(replicas)
+	best := 0
+	for i := 0; i < replicas; i++ {
+		if !b.observed[i] {
+			return i
+		}
+		if b.averages[i] < b.averages[best] {
+			best = i
+		}
+	}
+	return best
+}
+
+func (b *leastLatency) Observe(replica int, elapsed time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.grow(replica + 1)
+	if !b.observed[replica] {
+		b.averages[replica] = float64(elapsed)
+		b.observed[replica] = true
+		return
+	}
+	b.averages[replica] = leastLatencyDecay*float64(elapsed) + (1-leastLatencyDecay)*b.averages[replica]
+}
+
+// grow makes room for n replicas. b.mu must be held.
+func (b *leastLatency) grow(n int) {
+	for len(b.averages) < n {
+		b.averages = append(b.averages, 0)
+		b.observed = append(b.observed, false)
+	}
+}
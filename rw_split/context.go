@@ -0,0 +1,72 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rw_split
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type forcePrimaryKey struct{}
+
+// ForcePrimary returns a copy of ctx that sends reads made with it to the primary, for reads that must not be stale
+func ForcePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forcePrimaryKey{}, true)
+}
+
+func isPrimaryForced(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	forced, _ := ctx.Value(forcePrimaryKey{}).(bool)
+	return forced
+}
+
+type scopeKey struct{}
+
+// scope remembers when the request last wrote to the primary
+type scope struct {
+	mu        sync.Mutex
+	lastWrite time.Time
+}
+
+// WithScope returns a copy of ctx that tracks writes for read-your-writes stickiness. Use one scope per inbound
+// request: after a write made with the returned context, reads made with it go to the primary for the router's
+// StickyWindow, so the request sees its own writes even if the replicas lag.
+func WithScope(ctx context.Context) context.Context {
+	return context.WithValue(ctx, scopeKey{}, &scope{})
+}
+
+func scopeFrom(ctx context.Context) *scope {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(scopeKey{}).(*scope)
+	return s
+}
+
+func (s *scope) wrote(at time.Time) {
+	s.mu.Lock()
+	s.lastWrite = at
+	s.mu.Unlock()
+}
+
+func (s *scope) isSticky(at time.Time, window time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.lastWrite.IsZero() && at.Sub(s.lastWrite) < window
+}
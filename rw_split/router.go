@@ -0,0 +1,259 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rw_split
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql"
+	"github.com/wojnosystems/vsql/pinger"
+	"github.com/wojnosystems/vsql/vstmt"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/fingerprint"
+	"io"
+	"time"
+)
+
+// now is replaceable for tests
+var now = time.Now
+
+// Backend is a database the router sends calls to. The primary and the replicas are Backends.
+type Backend interface {
+	vsql.QueryExecer
+	pinger.Pinger
+	io.Closer
+}
+
+// Config adjusts how the router picks a backend
+type Config struct {
+	// Balancer picks the replica for each read. If nil, the replicas are used round-robin.
+	Balancer Balancer
+	// StickyWindow is how long reads go to the primary after a write made in the same scope. See WithScope.
+	// Zero disables read-your-writes stickiness.
+	StickyWindow time.Duration
+}
+
+// NewSingle creates an engine that sends reads made outside of a transaction to the replicas and everything else to
+// primary. If there are no replicas, every call goes to primary. The router is the driver, so middleware added to the
+// returned engine runs before the call is routed.
+func NewSingle(primary vsql.SQLer, replicas []Backend, config Config) vsql_engine.SingleTXer {
+	e := vsql_engine.NewSingle()
+	r := newRouter(primary, replicas, config)
+	r.install(e)
+	e.BeginMW().Append(func(ctx context.Context, c engine_context.Beginner) {
+		tx, err := primary.Begin(ctx, c.TxOptions())
+		if err != nil {
+			c.SetError(err)
+			return
+		}
+		c.SetQueryExecTransactioner(tx)
+		c.Next(ctx)
+	})
+	return e
+}
+
+// NewMulti creates the nested transaction version of NewSingle
+func NewMulti(primary vsql.SQLNester, replicas []Backend, config Config) vsql_engine.MultiTXer {
+	e := vsql_engine.NewMulti()
+	r := newRouter(primary, replicas, config)
+	r.install(e)
+	e.BeginNestedMW().Append(func(ctx context.Context, c engine_context.NestedBeginner) {
+		var starter vsql.TransactionNestedStarter = primary
+		if parent := c.QueryExecNestedTransactioner(); parent != nil {
+			starter = parent
+		}
+		tx, err := starter.Begin(ctx, c.TxOptions())
+		if err != nil {
+			c.SetError(err)
+			return
+		}
+		c.SetQueryExecNestedTransactioner(tx)
+		c.Next(ctx)
+	})
+	return e
+}
+
+type router struct {
+	primary  Backend
+	replicas []Backend
+	config   Config
+}
+
+func newRouter(primary Backend, replicas []Backend, config Config) *router {
+	if config.Balancer == nil {
+		config.Balancer = NewRoundRobin()
+	}
+	return &router{
+		primary:  primary,
+		replicas: replicas,
+		config:   config,
+	}
+}
+
+// readsFromReplica is true if a read made with ctx, outside of a transaction, may be served by a replica
+func (r *router) readsFromReplica(ctx context.Context) bool {
+	if len(r.replicas) == 0 || isPrimaryForced(ctx) {
+		return false
+	}
+	if s := scopeFrom(ctx); s != nil && s.isSticky(now(), r.config.StickyWindow) {
+		return false
+	}
+	return true
+}
+
+// wrote records a write for read-your-writes stickiness
+func (r *router) wrote(ctx context.Context) {
+	if s := scopeFrom(ctx); s != nil {
+		s.wrote(now())
+	}
+}
+
+// queryExecer is the transaction of the call, if any, or the primary
+func (r *router) queryExecer(tx vsql.QueryExecTransactioner) vsql.QueryExecer {
+	if tx != nil {
+		return tx
+	}
+	return r.primary
+}
+
+func (r *router) install(e vsql_engine.SQLQueryer) {
+	e.QueryMW().Append(func(ctx context.Context, c engine_context.Queryer) {
+		// Query may be used for writes that return rows, such as UPDATE ... RETURNING. Calls without a query are passed
+		// to the primary, which reports the error.
+		fp := c.Fingerprint()
+		write := fp != nil && fp.StatementType().IsWrite()
+		if tx := c.QueryExecTransactioner(); tx != nil || fp == nil || write || !r.readsFromReplica(ctx) {
+			rows, err := r.queryExecer(tx).Query(ctx, c.Query())
+			if write {
+				r.wrote(ctx)
+			}
+			c.SetRows(rows)
+			c.SetError(err)
+			c.Next(ctx)
+			return
+		}
+		i := r.config.Balancer.Pick(len(r.replicas))
+		start := now()
+		rows, err := r.replicas[i].Query(ctx, c.Query())
+		r.config.Balancer.Observe(i, now().Sub(start))
+		c.SetRows(rows)
+		c.SetError(err)
+		c.Next(ctx)
+	})
+	e.InsertQueryMW().Append(func(ctx context.Context, c engine_context.Inserter) {
+		res, err := r.queryExecer(c.QueryExecTransactioner()).Insert(ctx, c.Query())
+		r.wrote(ctx)
+		c.SetInsertResult(res)
+		c.SetError(err)
+		c.Next(ctx)
+	})
+	e.ExecQueryMW().Append(func(ctx context.Context, c engine_context.Execer) {
+		res, err := r.queryExecer(c.QueryExecTransactioner()).Exec(ctx, c.Query())
+		r.wrote(ctx)
+		c.SetResult(res)
+		c.SetError(err)
+		c.Next(ctx)
+	})
+	e.StatementPrepareMW().Append(func(ctx context.Context, c engine_context.Preparer) {
+		var p vstmt.Preparer = r.queryExecer(c.QueryExecTransactioner())
+		// only statements known to be reads are prepared on a replica as the statement may be used to Exec
+		fp := c.Fingerprint()
+		if c.QueryExecTransactioner() == nil && fp != nil && fp.StatementType() == fingerprint.Select && r.readsFromReplica(ctx) {
+			stmt, err := r.replicas[r.config.Balancer.Pick(len(r.replicas))].Prepare(ctx, c.Query())
+			if err == nil {
+				stmt = &replicaStatement{Statementer: stmt, router: r, query: c.Query()}
+			}
+			c.SetStatement(stmt)
+			c.SetError(err)
+			c.Next(ctx)
+			return
+		}
+		stmt, err := p.Prepare(ctx, c.Query())
+		c.SetStatement(stmt)
+		c.SetError(err)
+		c.Next(ctx)
+	})
+	e.StatementQueryMW().Append(func(ctx context.Context, c engine_context.StatementQueryer) {
+		rows, err := c.Statement().Query(ctx, c.Parameterer())
+		c.SetRows(rows)
+		c.SetError(err)
+		c.Next(ctx)
+	})
+	e.StatementInsertQueryMW().Append(func(ctx context.Context, c engine_context.StatementInsertQueryer) {
+		res, err := c.Statement().Insert(ctx, c.Parameterer())
+		r.wrote(ctx)
+		c.SetInsertResult(res)
+		c.SetError(err)
+		c.Next(ctx)
+	})
+	e.StatementExecQueryMW().Append(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		res, err := c.Statement().Exec(ctx, c.Parameterer())
+		r.wrote(ctx)
+		c.SetResult(res)
+		c.SetError(err)
+		c.Next(ctx)
+	})
+	e.StatementCloseMW().Append(func(ctx context.Context, c engine_context.StatementCloser) {
+		if c.Statement() != nil {
+			c.SetError(c.Statement().Close())
+		}
+		c.Next(ctx)
+	})
+	e.RowsNextMW().Append(func(ctx context.Context, c engine_context.RowsNexter) {
+		if c.Rows() != nil {
+			c.SetRow(c.Rows().Next())
+		}
+		c.Next(ctx)
+	})
+	e.RowsCloseMW().Append(func(ctx context.Context, c engine_context.Rowser) {
+		if c.Rows() != nil {
+			c.SetError(c.Rows().Close())
+		}
+		c.Next(ctx)
+	})
+	e.CommitMW().Append(func(ctx context.Context, c engine_context.Beginner) {
+		c.SetError(c.QueryExecTransactioner().Commit())
+		c.Next(ctx)
+	})
+	e.RollbackMW().Append(func(ctx context.Context, c engine_context.Beginner) {
+		c.SetError(c.QueryExecTransactioner().Rollback())
+		c.Next(ctx)
+	})
+	e.PingMW().Append(func(ctx context.Context, c engine_context.Er) {
+		c.SetError(r.each(func(b Backend) error {
+			return b.Ping(ctx)
+		}))
+		c.Next(ctx)
+	})
+	e.ConnCloseMW().Append(func(ctx context.Context, c engine_context.Er) {
+		c.SetError(r.each(func(b Backend) error {
+			return b.Close()
+		}))
+		c.Next(ctx)
+	})
+}
+
+// each calls f with the primary, then every replica, and returns the first error. f is called for every backend even
+// if one fails.
+func (r *router) each(f func(b Backend) error) (err error) {
+	err = f(r.primary)
+	for _, replica := range r.replicas {
+		if replicaErr := f(replica); err == nil {
+			err = replicaErr
+		}
+	}
+	return err
+}
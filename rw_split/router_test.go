@@ -0,0 +1,262 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rw_split
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/wojnosystems/vsql"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vresult"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql/vstmt"
+	"testing"
+	"time"
+)
+
+func newRowsMock() *vrows.RowserMock {
+	rows := &vrows.RowserMock{}
+	rows.On("Next").Return(nil)
+	rows.On("Close").Return(nil)
+	return rows
+}
+
+func newReplicaMock() *vsql.SQLerMock {
+	replica := &vsql.SQLerMock{}
+	replica.On("Query", mock.Anything, mock.Anything).Return(newRowsMock(), nil)
+	return replica
+}
+
+func TestRouter_ReadsGoToReplicas(t *testing.T) {
+	primary := &vsql.SQLerMock{}
+	replicas := []*vsql.SQLerMock{newReplicaMock(), newReplicaMock()}
+	e := NewSingle(primary, []Backend{replicas[0], replicas[1]}, Config{})
+	for i := 0; i < 4; i++ {
+		rows, err := e.Query(context.Background(), vparam.New("SELECT * FROM t"))
+		assert.NoError(t, err)
+		assert.Nil(t, rows.Next())
+		assert.NoError(t, rows.Close())
+	}
+	replicas[0].AssertNumberOfCalls(t, "Query", 2)
+	replicas[1].AssertNumberOfCalls(t, "Query", 2)
+	primary.AssertNotCalled(t, "Query", mock.Anything, mock.Anything)
+}
+
+func TestRouter_WritesGoToPrimary(t *testing.T) {
+	primary := &vsql.SQLerMock{}
+	primary.On("Exec", mock.Anything, mock.Anything).Return(&vresult.ResulterMock{}, nil)
+	primary.On("Insert", mock.Anything, mock.Anything).Return(&vresult.InsertResulterMock{}, nil)
+	primary.On("Query", mock.Anything, mock.Anything).Return(newRowsMock(), nil)
+	replica := newReplicaMock()
+	e := NewSingle(primary, []Backend{replica}, Config{})
+	_, err := e.Exec(context.Background(), vparam.New("DELETE FROM t"))
+	assert.NoError(t, err)
+	_, err = e.Insert(context.Background(), vparam.New("INSERT INTO t (a) VALUES (1)"))
+	assert.NoError(t, err)
+	_, err = e.Query(context.Background(), vparam.New("UPDATE t SET a = 1 RETURNING a"))
+	assert.NoError(t, err)
+	primary.AssertExpectations(t)
+	replica.AssertNotCalled(t, "Query", mock.Anything, mock.Anything)
+}
+
+func TestRouter_NilQueryGoesToPrimary(t *testing.T) {
+	primary := &vsql.SQLerMock{}
+	primary.On("Query", mock.Anything, nil).Return(nil, errors.New("no query"))
+	primary.On("Prepare", mock.Anything, nil).Return(nil, errors.New("no query"))
+	replica := newReplicaMock()
+	e := NewSingle(primary, []Backend{replica}, Config{})
+	_, err := e.Query(context.Background(), nil)
+	assert.EqualError(t, err, "no query")
+	_, err = e.Prepare(context.Background(), nil)
+	assert.EqualError(t, err, "no query")
+	replica.AssertNotCalled(t, "Query", mock.Anything, mock.Anything)
+}
+
+func TestRouter_ForcePrimary(t *testing.T) {
+	primary := &vsql.SQLerMock{}
+	primary.On("Query", mock.Anything, mock.Anything).Return(newRowsMock(), nil)
+	replica := newReplicaMock()
+	e := NewSingle(primary, []Backend{replica}, Config{})
+	_, err := e.Query(ForcePrimary(context.Background()), vparam.New("SELECT * FROM t"))
+	assert.NoError(t, err)
+	primary.AssertNumberOfCalls(t, "Query", 1)
+	replica.AssertNotCalled(t, "Query", mock.Anything, mock.Anything)
+}
+
+func TestRouter_StickyAfterWrite(t *testing.T) {
+	current := time.Time{}.Add(time.Hour)
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+
+	primary := &vsql.SQLerMock{}
+	primary.On("Exec", mock.Anything, mock.Anything).Return(&vresult.ResulterMock{}, nil)
+	primary.On("Query", mock.Anything, mock.Anything).Return(newRowsMock(), nil)
+	replica := newReplicaMock()
+	e := NewSingle(primary, []Backend{replica}, Config{StickyWindow: time.Second})
+
+	ctx := WithScope(context.Background())
+	_, err := e.Exec(ctx, vparam.New("UPDATE t SET a = 1"))
+	assert.NoError(t, err)
+	_, err = e.Query(ctx, vparam.New("SELECT a FROM t"))
+	assert.NoError(t, err)
+	primary.AssertNumberOfCalls(t, "Query", 1)
+
+	// other requests are not sticky
+	_, err = e.Query(WithScope(context.Background()), vparam.New("SELECT a FROM t"))
+	assert.NoError(t, err)
+	replica.AssertNumberOfCalls(t, "Query", 1)
+
+	// once the window has passed, reads go back to the replicas
+	current = current.Add(time.Second)
+	_, err = e.Query(ctx, vparam.New("SELECT a FROM t"))
+	assert.NoError(t, err)
+	replica.AssertNumberOfCalls(t, "Query", 2)
+	primary.AssertNumberOfCalls(t, "Query", 1)
+}
+
+func TestRouter_TransactionsStayOnPrimary(t *testing.T) {
+	tx := &vsql.QueryExecTransactionerMock{}
+	tx.On("Query", mock.Anything, mock.Anything).Return(newRowsMock(), nil)
+	tx.On("Commit").Return(nil)
+	primary := &vsql.SQLerMock{}
+	primary.On("Begin", mock.Anything, mock.Anything).Return(tx, nil)
+	replica := newReplicaMock()
+	e := NewSingle(primary, []Backend{replica}, Config{})
+	txn, err := e.Begin(context.Background(), nil)
+	assert.NoError(t, err)
+	_, err = txn.Query(context.Background(), vparam.New("SELECT * FROM t"))
+	assert.NoError(t, err)
+	assert.NoError(t, txn.Commit())
+	tx.AssertExpectations(t)
+	replica.AssertNotCalled(t, "Query", mock.Anything, mock.Anything)
+}
+
+func TestRouter_NestedTransactions(t *testing.T) {
+	inner := &vsql.QueryExecNestedTransactionerMock{}
+	inner.On("Exec", mock.Anything, mock.Anything).Return(&vresult.ResulterMock{}, nil)
+	inner.On("Commit").Return(nil)
+	outer := &vsql.QueryExecNestedTransactionerMock{}
+	outer.On("Begin", mock.Anything, mock.Anything).Return(inner, nil)
+	primary := &vsql.SQLNesterMock{}
+	primary.On("Begin", mock.Anything, mock.Anything).Return(outer, nil)
+	e := NewMulti(primary, []Backend{newReplicaMock()}, Config{})
+	txn, err := e.Begin(context.Background(), nil)
+	assert.NoError(t, err)
+	nested, err := txn.Begin(context.Background(), nil)
+	assert.NoError(t, err)
+	_, err = nested.Exec(context.Background(), vparam.New("DELETE FROM t"))
+	assert.NoError(t, err)
+	assert.NoError(t, nested.Commit())
+	primary.AssertNumberOfCalls(t, "Begin", 1)
+	outer.AssertExpectations(t)
+	inner.AssertExpectations(t)
+}
+
+func TestRouter_PrepareRoutesByStatementType(t *testing.T) {
+	stmt := &vstmt.StatementerMock{}
+	primary := &vsql.SQLerMock{}
+	primary.On("Prepare", mock.Anything, mock.Anything).Return(stmt, nil)
+	replica := &vsql.SQLerMock{}
+	replica.On("Prepare", mock.Anything, mock.Anything).Return(stmt, nil)
+	e := NewSingle(primary, []Backend{replica}, Config{})
+	_, err := e.Prepare(context.Background(), vparam.New("SELECT * FROM t WHERE a = ?"))
+	assert.NoError(t, err)
+	_, err = e.Prepare(context.Background(), vparam.New("UPDATE t SET a = ?"))
+	assert.NoError(t, err)
+	replica.AssertNumberOfCalls(t, "Prepare", 1)
+	primary.AssertNumberOfCalls(t, "Prepare", 1)
+}
+
+func TestRouter_PreparedReadsStickAfterWrite(t *testing.T) {
+	current := time.Time{}.Add(time.Hour)
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+
+	replicaStmt := &vstmt.StatementerMock{}
+	replicaStmt.On("Query", mock.Anything, mock.Anything).Return(newRowsMock(), nil)
+	replicaStmt.On("Close").Return(nil)
+	primaryStmt := &vstmt.StatementerMock{}
+	primaryStmt.On("Query", mock.Anything, mock.Anything).Return(newRowsMock(), nil)
+	primaryStmt.On("Close").Return(nil)
+	primary := &vsql.SQLerMock{}
+	primary.On("Exec", mock.Anything, mock.Anything).Return(&vresult.ResulterMock{}, nil)
+	primary.On("Prepare", mock.Anything, mock.Anything).Return(primaryStmt, nil)
+	replica := &vsql.SQLerMock{}
+	replica.On("Prepare", mock.Anything, mock.Anything).Return(replicaStmt, nil)
+	e := NewSingle(primary, []Backend{replica}, Config{StickyWindow: time.Second})
+
+	ctx := WithScope(context.Background())
+	stmt, err := e.Prepare(ctx, vparam.New("SELECT a FROM t WHERE id = ?"))
+	assert.NoError(t, err)
+	_, err = stmt.Query(ctx, vparam.NewAppendWithData("", 1))
+	assert.NoError(t, err)
+	replicaStmt.AssertNumberOfCalls(t, "Query", 1)
+
+	_, err = e.Exec(ctx, vparam.New("UPDATE t SET a = 1"))
+	assert.NoError(t, err)
+	_, err = stmt.Query(ctx, vparam.NewAppendWithData("", 1))
+	assert.NoError(t, err)
+	_, err = stmt.Query(ctx, vparam.NewAppendWithData("", 1))
+	assert.NoError(t, err)
+	primary.AssertNumberOfCalls(t, "Prepare", 1)
+	primaryStmt.AssertNumberOfCalls(t, "Query", 2)
+	replicaStmt.AssertNumberOfCalls(t, "Query", 1)
+
+	current = current.Add(time.Second)
+	_, err = stmt.Query(ctx, vparam.NewAppendWithData("", 1))
+	assert.NoError(t, err)
+	replicaStmt.AssertNumberOfCalls(t, "Query", 2)
+	assert.NoError(t, stmt.Close())
+	replicaStmt.AssertCalled(t, "Close")
+	primaryStmt.AssertCalled(t, "Close")
+}
+
+func TestRouter_NilContext(t *testing.T) {
+	assert.False(t, isPrimaryForced(nil))
+	assert.Nil(t, scopeFrom(nil))
+	r := newRouter(&vsql.SQLerMock{}, []Backend{newReplicaMock()}, Config{StickyWindow: time.Second})
+	assert.True(t, r.readsFromReplica(nil))
+	r.wrote(nil)
+}
+
+func TestRouter_PingAndCloseAllBackends(t *testing.T) {
+	primary := &vsql.SQLerMock{}
+	primary.On("Ping", mock.Anything).Return(nil)
+	primary.On("Close").Return(nil)
+	replica := &vsql.SQLerMock{}
+	replica.On("Ping", mock.Anything).Return(assert.AnError)
+	replica.On("Close").Return(nil)
+	e := NewSingle(primary, []Backend{replica}, Config{})
+	assert.Equal(t, assert.AnError, e.Ping(context.Background()))
+	assert.NoError(t, e.Close())
+	primary.AssertExpectations(t)
+	replica.AssertExpectations(t)
+}
+
+func TestLeastLatency(t *testing.T) {
+	b := NewLeastLatency()
+	assert.Equal(t, 0, b.Pick(2))
+	b.Observe(0, 10*time.Millisecond)
+	assert.Equal(t, 1, b.Pick(2), "unmeasured replicas are tried first")
+	b.Observe(1, time.Millisecond)
+	assert.Equal(t, 1, b.Pick(2))
+	for i := 0; i < 20; i++ {
+		b.Observe(1, 100*time.Millisecond)
+	}
+	assert.Equal(t, 0, b.Pick(2))
+}
@@ -0,0 +1,73 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package rw_split
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql/vstmt"
+	"sync"
+)
+
+// replicaStatement is a read statement prepared on a replica. Its reads go to the primary while the caller's reads
+// must, such as after a write in the same scope, so the statement is prepared again on the primary when first needed.
+type replicaStatement struct {
+	vstmt.Statementer
+	router *router
+	query  vparam.Queryer
+
+	mu      sync.Mutex
+	primary vstmt.Statementer
+}
+
+func (s *replicaStatement) Query(ctx context.Context, params vparam.Parameterer) (vrows.Rowser, error) {
+	if s.router.readsFromReplica(ctx) {
+		return s.Statementer.Query(ctx, params)
+	}
+	stmt, err := s.onPrimary(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.Query(ctx, params)
+}
+
+// onPrimary returns the statement prepared on the primary, preparing it if it was not yet
+func (s *replicaStatement) onPrimary(ctx context.Context) (vstmt.Statementer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.primary == nil {
+		stmt, err := s.router.primary.Prepare(ctx, s.query)
+		if err != nil {
+			return nil, err
+		}
+		s.primary = stmt
+	}
+	return s.primary, nil
+}
+
+// Close closes the statement on the replica and, if it was prepared there, on the primary
+func (s *replicaStatement) Close() error {
+	err := s.Statementer.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.primary != nil {
+		if primaryErr := s.primary.Close(); err == nil {
+			err = primaryErr
+		}
+	}
+	return err
+}
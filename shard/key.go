@@ -0,0 +1,90 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package shard
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql/vparam"
+	"regexp"
+	"strings"
+)
+
+type keyKey struct{}
+
+// WithKey returns a copy of ctx that routes calls made with it to the shard holding key. A key in the context takes
+// precedence over one in the query's parameters.
+func WithKey(ctx context.Context, key interface{}) context.Context {
+	return context.WithValue(ctx, keyKey{}, key)
+}
+
+// KeyFrom returns the shard key set by WithKey, if any
+func KeyFrom(ctx context.Context) (key interface{}, ok bool) {
+	key = ctx.Value(keyKey{})
+	return key, key != nil
+}
+
+type shardIndexKey struct{}
+
+// withShardIndex routes calls made with the returned context to the shard at index, regardless of key. QueryAll
+// uses this to visit every shard.
+func withShardIndex(ctx context.Context, index int) context.Context {
+	return context.WithValue(ctx, shardIndexKey{}, index)
+}
+
+func shardIndexFrom(ctx context.Context) (index int, ok bool) {
+	index, ok = ctx.Value(shardIndexKey{}).(int)
+	return
+}
+
+// questionMark is the interpolation strategy used to read parameter values. The SQL it produces is discarded.
+type questionMark struct{}
+
+func (questionMark) InsertPlaceholderIntoSQL() string {
+	return "?"
+}
+
+// namedParameterName matches the name following the vparam.NamedPlaceholderPrefix, as vparam does
+var namedParameterName = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*")
+
+// keyFromParameters returns the value of the named parameter called name in query, if it is a vparam.NewNamed query
+// that sets that parameter
+func keyFromParameters(query vparam.Queryer, name string) (key interface{}, ok bool) {
+	if name == "" || query == nil {
+		return nil, false
+	}
+	sqlQuery := query.SQLQueryUnInterpolated()
+	// vparam.Namer does not expose its values, but it returns them from Interpolate in the order the names appear
+	// in the query. The names are found here the same way vparam finds them so the two line up.
+	parts := strings.Split(sqlQuery, vparam.NamedPlaceholderPrefix)
+	names := make([]string, 0, len(parts))
+	for _, part := range parts[1:] {
+		match := namedParameterName.FindString(part)
+		if match == "" {
+			return nil, false
+		}
+		names = append(names, match)
+	}
+	_, params, err := query.Interpolate(sqlQuery, questionMark{})
+	if err != nil || len(params) != len(names) {
+		return nil, false
+	}
+	for i, n := range names {
+		if n == name {
+			return params[i], true
+		}
+	}
+	return nil, false
+}
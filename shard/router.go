@@ -0,0 +1,339 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package shard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/wojnosystems/vsql"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"sync"
+)
+
+// ErrNoBackends is returned by New when it is given no backends
+var ErrNoBackends = errors.New("shard: at least one backend is required")
+
+// ErrNoShardKey is returned when a call needs a shard, but neither the context nor the query's parameters have a key
+var ErrNoShardKey = errors.New("shard: no shard key was provided in the context or the query parameters")
+
+// ErrUnknownKey is returned when the Strategy cannot place a key on any of the shards
+type ErrUnknownKey struct {
+	Key interface{}
+}
+
+func (e ErrUnknownKey) Error() string {
+	return fmt.Sprintf(`shard: no shard holds the key "%v"`, e.Key)
+}
+
+// ErrCrossShard is returned when a call inside a transaction is for a different shard than the transaction's.
+// Transactions cannot span shards.
+type ErrCrossShard struct {
+	// Pinned is the index of the shard the transaction was begun on
+	Pinned int
+	// Requested is the index of the shard the call was for
+	Requested int
+}
+
+func (e ErrCrossShard) Error() string {
+	return fmt.Sprintf("shard: the transaction is on shard %d and cannot access shard %d", e.Pinned, e.Requested)
+}
+
+// Config adjusts how keys are mapped to shards
+type Config struct {
+	// Strategy maps keys to shards. If nil, keys are hashed. See NewHash.
+	Strategy Strategy
+	// KeyParam is the name of the vparam.NewNamed parameter holding the shard key, for calls whose context has no key.
+	// If empty, the key must be set with WithKey.
+	KeyParam string
+}
+
+// Engine is a SingleTXer that routes each call to one of several backends by shard key
+type Engine interface {
+	vsql_engine.SingleTXer
+	// QueryAll runs the query on every shard and returns the rows of all of them, one shard after the other. The
+	// shards are queried concurrently. If any shard fails, the rows of the others are closed and the first error is
+	// returned.
+	QueryAll(ctx context.Context, query vparam.Queryer) (vrows.Rowser, error)
+}
+
+// New creates an engine that routes each call to one of the backends. The shard key for a call is taken from its
+// context (see WithKey) or the query's parameters (see Config.KeyParam). Begin pins the transaction to the shard of
+// the key in its context, so calls within the transaction go to that shard and calls for any other shard fail with
+// ErrCrossShard. The router is the driver, so middleware added to the returned engine runs before the call is routed.
+// Groups made from the engine route the same way, but do not have QueryAll. New fails with ErrNoBackends if backends
+// is empty.
+func New(backends []vsql.SQLer, config Config) (Engine, error) {
+	if len(backends) == 0 {
+		return nil, ErrNoBackends
+	}
+	if config.Strategy == nil {
+		config.Strategy = NewHash()
+	}
+	e := &engine{
+		SingleTXer: vsql_engine.NewSingle(),
+		router: &router{
+			backends: backends,
+			config:   config,
+		},
+	}
+	e.router.install(e.SingleTXer)
+	return e, nil
+}
+
+type engine struct {
+	vsql_engine.SingleTXer
+	router *router
+}
+
+// QueryAll see Engine
+func (e *engine) QueryAll(ctx context.Context, query vparam.Queryer) (vrows.Rowser, error) {
+	results := make([]vrows.Rowser, len(e.router.backends))
+	errs := make([]error, len(e.router.backends))
+	wg := sync.WaitGroup{}
+	for i := range e.router.backends {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = e.Query(withShardIndex(ctx, i), query)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			for _, r := range results {
+				if r != nil {
+					_ = r.Close()
+				}
+			}
+			return nil, err
+		}
+	}
+	return &mergedRows{rows: results}, nil
+}
+
+// mergedRows returns the rows of each Rowser in turn
+type mergedRows struct {
+	rows    []vrows.Rowser
+	current int
+}
+
+func (m *mergedRows) Next() vrows.Rower {
+	for m.current < len(m.rows) {
+		if row := m.rows[m.current].Next(); row != nil {
+			return row
+		}
+		m.current++
+	}
+	return nil
+}
+
+// Close closes every shard's rows and returns the first error
+func (m *mergedRows) Close() (err error) {
+	for _, r := range m.rows {
+		if closeErr := r.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// pinnedTx is a transaction on one shard
+type pinnedTx struct {
+	vsql.QueryExecTransactioner
+	shard int
+}
+
+type router struct {
+	backends []vsql.SQLer
+	config   Config
+}
+
+// shardFor returns the index of the shard for a call made with ctx and query
+func (r *router) shardFor(ctx context.Context, query vparam.Queryer) (int, error) {
+	if index, ok := shardIndexFrom(ctx); ok {
+		return index, nil
+	}
+	key, ok := KeyFrom(ctx)
+	if !ok {
+		key, ok = keyFromParameters(query, r.config.KeyParam)
+	}
+	if !ok {
+		return 0, ErrNoShardKey
+	}
+	index, err := r.config.Strategy.Shard(key, len(r.backends))
+	if err != nil {
+		return 0, err
+	}
+	if index < 0 || index >= len(r.backends) {
+		return 0, &ErrUnknownKey{Key: key}
+	}
+	return index, nil
+}
+
+// queryExecer returns the transaction, if the call is in one, or the backend of the call's shard
+func (r *router) queryExecer(ctx context.Context, tx vsql.QueryExecTransactioner, query vparam.Queryer) (vsql.QueryExecer, error) {
+	if pinned, ok := tx.(*pinnedTx); ok {
+		index, err := r.shardFor(ctx, query)
+		if err == ErrNoShardKey {
+			// calls without a key are made on the transaction's shard
+			return pinned, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if index != pinned.shard {
+			return nil, &ErrCrossShard{Pinned: pinned.shard, Requested: index}
+		}
+		return pinned, nil
+	}
+	index, err := r.shardFor(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return r.backends[index], nil
+}
+
+func (r *router) install(e vsql_engine.SingleTXer) {
+	e.BeginMW().Append(func(ctx context.Context, c engine_context.Beginner) {
+		index, err := r.shardFor(ctx, nil)
+		if err != nil {
+			c.SetError(err)
+			return
+		}
+		tx, err := r.backends[index].Begin(ctx, c.TxOptions())
+		if err != nil {
+			c.SetError(err)
+			return
+		}
+		c.SetQueryExecTransactioner(&pinnedTx{QueryExecTransactioner: tx, shard: index})
+		c.Next(ctx)
+	})
+	e.QueryMW().Append(func(ctx context.Context, c engine_context.Queryer) {
+		qe, err := r.queryExecer(ctx, c.QueryExecTransactioner(), c.Query())
+		if err != nil {
+			c.SetError(err)
+			return
+		}
+		rows, err := qe.Query(ctx, c.Query())
+		c.SetRows(rows)
+		c.SetError(err)
+		c.Next(ctx)
+	})
+	e.InsertQueryMW().Append(func(ctx context.Context, c engine_context.Inserter) {
+		qe, err := r.queryExecer(ctx, c.QueryExecTransactioner(), c.Query())
+		if err != nil {
+			c.SetError(err)
+			return
+		}
+		res, err := qe.Insert(ctx, c.Query())
+		c.SetInsertResult(res)
+		c.SetError(err)
+		c.Next(ctx)
+	})
+	e.ExecQueryMW().Append(func(ctx context.Context, c engine_context.Execer) {
+		qe, err := r.queryExecer(ctx, c.QueryExecTransactioner(), c.Query())
+		if err != nil {
+			c.SetError(err)
+			return
+		}
+		res, err := qe.Exec(ctx, c.Query())
+		c.SetResult(res)
+		c.SetError(err)
+		c.Next(ctx)
+	})
+	e.StatementPrepareMW().Append(func(ctx context.Context, c engine_context.Preparer) {
+		qe, err := r.queryExecer(ctx, c.QueryExecTransactioner(), c.Query())
+		if err != nil {
+			c.SetError(err)
+			return
+		}
+		stmt, err := qe.Prepare(ctx, c.Query())
+		c.SetStatement(stmt)
+		c.SetError(err)
+		c.Next(ctx)
+	})
+	// statements stay on the shard they were prepared on
+	e.StatementQueryMW().Append(func(ctx context.Context, c engine_context.StatementQueryer) {
+		rows, err := c.Statement().Query(ctx, c.Parameterer())
+		c.SetRows(rows)
+		c.SetError(err)
+		c.Next(ctx)
+	})
+	e.StatementInsertQueryMW().Append(func(ctx context.Context, c engine_context.StatementInsertQueryer) {
+		res, err := c.Statement().Insert(ctx, c.Parameterer())
+		c.SetInsertResult(res)
+		c.SetError(err)
+		c.Next(ctx)
+	})
+	e.StatementExecQueryMW().Append(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		res, err := c.Statement().Exec(ctx, c.Parameterer())
+		c.SetResult(res)
+		c.SetError(err)
+		c.Next(ctx)
+	})
+	e.StatementCloseMW().Append(func(ctx context.Context, c engine_context.StatementCloser) {
+		if c.Statement() != nil {
+			c.SetError(c.Statement().Close())
+		}
+		c.Next(ctx)
+	})
+	e.RowsNextMW().Append(func(ctx context.Context, c engine_context.RowsNexter) {
+		if c.Rows() != nil {
+			c.SetRow(c.Rows().Next())
+		}
+		c.Next(ctx)
+	})
+	e.RowsCloseMW().Append(func(ctx context.Context, c engine_context.Rowser) {
+		if c.Rows() != nil {
+			c.SetError(c.Rows().Close())
+		}
+		c.Next(ctx)
+	})
+	e.CommitMW().Append(func(ctx context.Context, c engine_context.Beginner) {
+		c.SetError(c.QueryExecTransactioner().Commit())
+		c.Next(ctx)
+	})
+	e.RollbackMW().Append(func(ctx context.Context, c engine_context.Beginner) {
+		c.SetError(c.QueryExecTransactioner().Rollback())
+		c.Next(ctx)
+	})
+	e.PingMW().Append(func(ctx context.Context, c engine_context.Er) {
+		c.SetError(r.each(func(b vsql.SQLer) error {
+			return b.Ping(ctx)
+		}))
+		c.Next(ctx)
+	})
+	e.ConnCloseMW().Append(func(ctx context.Context, c engine_context.Er) {
+		c.SetError(r.each(func(b vsql.SQLer) error {
+			return b.Close()
+		}))
+		c.Next(ctx)
+	})
+}
+
+// each calls f with every backend and returns the first error. f is called for every backend even if one fails.
+func (r *router) each(f func(b vsql.SQLer) error) (err error) {
+	for _, b := range r.backends {
+		if backendErr := f(b); err == nil {
+			err = backendErr
+		}
+	}
+	return err
+}
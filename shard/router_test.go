@@ -0,0 +1,158 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package shard
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/wojnosystems/vsql"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vresult"
+	"github.com/wojnosystems/vsql/vrows"
+	"testing"
+)
+
+func newBackends(n int) (sqlers []vsql.SQLer, mocks []*vsql.SQLerMock) {
+	for i := 0; i < n; i++ {
+		row := &vrows.RowerMock{}
+		rows := &vrows.RowserMock{}
+		rows.On("Next").Return(row).Once()
+		rows.On("Next").Return(nil)
+		rows.On("Close").Return(nil)
+		m := &vsql.SQLerMock{}
+		m.On("Query", mock.Anything, mock.Anything).Return(rows, nil)
+		m.On("Exec", mock.Anything, mock.Anything).Return(&vresult.ResulterMock{}, nil)
+		sqlers = append(sqlers, m)
+		mocks = append(mocks, m)
+	}
+	return
+}
+
+func TestRouter_KeyFromContext(t *testing.T) {
+	backends, mocks := newBackends(3)
+	e, _ := New(backends, Config{Strategy: NewLookup(map[interface{}]int{"acme": 2})})
+	_, err := e.Exec(WithKey(context.Background(), "acme"), vparam.New("DELETE FROM t"))
+	assert.NoError(t, err)
+	mocks[2].AssertNumberOfCalls(t, "Exec", 1)
+	mocks[0].AssertNotCalled(t, "Exec", mock.Anything, mock.Anything)
+
+	_, err = e.Exec(WithKey(context.Background(), "initech"), vparam.New("DELETE FROM t"))
+	assert.Equal(t, &ErrUnknownKey{Key: "initech"}, err)
+
+	_, err = e.Exec(context.Background(), vparam.New("DELETE FROM t"))
+	assert.Equal(t, ErrNoShardKey, err)
+}
+
+func TestRouter_KeyFromParameters(t *testing.T) {
+	backends, mocks := newBackends(2)
+	e, _ := New(backends, Config{Strategy: NewRange(100), KeyParam: "tenant_id"})
+	q := vparam.NewNamedWithData("SELECT * FROM t WHERE tenant_id = :tenant_id AND a = :a", map[string]interface{}{
+		"tenant_id": 150,
+		"a":         1,
+	})
+	_, err := e.Query(context.Background(), q)
+	assert.NoError(t, err)
+	mocks[1].AssertNumberOfCalls(t, "Query", 1)
+
+	// the context takes precedence
+	_, err = e.Query(WithKey(context.Background(), 5), q)
+	assert.NoError(t, err)
+	mocks[0].AssertNumberOfCalls(t, "Query", 1)
+}
+
+func TestRouter_TransactionPinnedToShard(t *testing.T) {
+	backends, mocks := newBackends(2)
+	tx := &vsql.QueryExecTransactionerMock{}
+	tx.On("Exec", mock.Anything, mock.Anything).Return(&vresult.ResulterMock{}, nil)
+	tx.On("Commit").Return(nil)
+	mocks[1].On("Begin", mock.Anything, mock.Anything).Return(tx, nil)
+	e, _ := New(backends, Config{Strategy: NewRange(100)})
+
+	ctx := WithKey(context.Background(), 100)
+	txn, err := e.Begin(ctx, nil)
+	assert.NoError(t, err)
+	_, err = txn.Exec(context.Background(), vparam.New("UPDATE t SET a = 1"))
+	assert.NoError(t, err, "calls without a key use the transaction's shard")
+	_, err = txn.Exec(WithKey(context.Background(), 1), vparam.New("UPDATE t SET a = 1"))
+	assert.Equal(t, &ErrCrossShard{Pinned: 1, Requested: 0}, err)
+	_, err = txn.Exec(WithKey(context.Background(), "tenant"), vparam.New("UPDATE t SET a = 1"))
+	assert.Equal(t, &ErrUnknownKey{Key: "tenant"}, err, "expected keys that place the call on no shard to fail")
+	assert.NoError(t, txn.Commit())
+	tx.AssertNumberOfCalls(t, "Exec", 1)
+	tx.AssertExpectations(t)
+	mocks[0].AssertNotCalled(t, "Exec", mock.Anything, mock.Anything)
+
+	_, err = e.Begin(context.Background(), nil)
+	assert.Equal(t, ErrNoShardKey, err)
+}
+
+func TestEngine_QueryAll(t *testing.T) {
+	backends, mocks := newBackends(3)
+	e, _ := New(backends, Config{})
+	rows, err := e.QueryAll(context.Background(), vparam.New("SELECT * FROM t"))
+	assert.NoError(t, err)
+	count := 0
+	for rows.Next() != nil {
+		count++
+	}
+	assert.NoError(t, rows.Close())
+	assert.Equal(t, 3, count)
+	for _, m := range mocks {
+		m.AssertNumberOfCalls(t, "Query", 1)
+	}
+}
+
+func TestEngine_QueryAllError(t *testing.T) {
+	backends, _ := newBackends(1)
+	failing := &vsql.SQLerMock{}
+	failing.On("Query", mock.Anything, mock.Anything).Return(nil, assert.AnError)
+	backends = append(backends, failing)
+	e, _ := New(backends, Config{})
+	_, err := e.QueryAll(context.Background(), vparam.New("SELECT * FROM t"))
+	assert.Equal(t, assert.AnError, err)
+	backends[0].(*vsql.SQLerMock).AssertNumberOfCalls(t, "Query", 1)
+}
+
+func TestStrategies(t *testing.T) {
+	r := NewRange(200, 100)
+	for key, expected := range map[int64]int{-5: 0, 99: 0, 100: 1, 199: 1, 200: 2, 5000: 2} {
+		index, err := r.Shard(key, 3)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, index, "key %d", key)
+	}
+	_, err := r.Shard("a", 3)
+	assert.Error(t, err)
+
+	h := NewHash()
+	a, _ := h.Shard("tenant-1", 4)
+	b, _ := h.Shard("tenant-1", 4)
+	assert.Equal(t, a, b)
+	assert.True(t, a >= 0 && a < 4)
+	_, err = h.Shard("tenant-1", 0)
+	assert.Equal(t, ErrNoBackends, err)
+
+	l := NewLookup(map[interface{}]int{"acme": 1})
+	_, err = l.Shard([]string{"acme"}, 2)
+	assert.Equal(t, &ErrUnknownKey{Key: []string{"acme"}}, err, "expected keys that cannot be map keys to be refused")
+	_, err = l.Shard(nil, 2)
+	assert.Equal(t, &ErrUnknownKey{Key: nil}, err)
+}
+
+func TestNew_NoBackends(t *testing.T) {
+	_, err := New(nil, Config{})
+	assert.Equal(t, ErrNoBackends, err)
+}
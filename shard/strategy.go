@@ -0,0 +1,125 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package shard
+
+import (
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sort"
+)
+
+// Strategy chooses the shard that holds the data for a shard key. Implementations must be safe for concurrent use.
+type Strategy interface {
+	// Shard returns the index of the shard for key, from 0 to shards-1
+	Shard(key interface{}, shards int) (index int, err error)
+}
+
+// NewHash creates a Strategy that spreads keys evenly over the shards by hashing them. Keys are hashed by their
+// fmt %v representation, so 7 and "7" are on the same shard. Adding shards moves most keys to a different shard.
+func NewHash() Strategy {
+	return hashStrategy{}
+}
+
+type hashStrategy struct{}
+
+func (hashStrategy) Shard(key interface{}, shards int) (int, error) {
+	if shards < 1 {
+		return 0, ErrNoBackends
+	}
+	h := fnv.New64a()
+	_, _ = fmt.Fprint(h, key)
+	return int(h.Sum64() % uint64(shards)), nil
+}
+
+// NewRange creates a Strategy for integer keys. Keys less than upperBounds[0] are on shard 0, keys less than
+// upperBounds[1] are on shard 1, and so on. Keys greater than or equal to the last bound are on the last shard, so
+// there is one more shard than there are bounds. upperBounds need not be sorted.
+func NewRange(upperBounds ...int64) Strategy {
+	bounds := make([]int64, len(upperBounds))
+	copy(bounds, upperBounds)
+	sort.Slice(bounds, func(i, j int) bool {
+		return bounds[i] < bounds[j]
+	})
+	return rangeStrategy{upperBounds: bounds}
+}
+
+type rangeStrategy struct {
+	upperBounds []int64
+}
+
+func (s rangeStrategy) Shard(key interface{}, shards int) (int, error) {
+	k, ok := toInt64(key)
+	if !ok {
+		return 0, &ErrUnknownKey{Key: key}
+	}
+	return sort.Search(len(s.upperBounds), func(i int) bool {
+		return k < s.upperBounds[i]
+	}), nil
+}
+
+// toInt64 converts any signed or unsigned integer to an int64
+func toInt64(key interface{}) (int64, bool) {
+	switch k := key.(type) {
+	case int:
+		return int64(k), true
+	case int8:
+		return int64(k), true
+	case int16:
+		return int64(k), true
+	case int32:
+		return int64(k), true
+	case int64:
+		return k, true
+	case uint:
+		return int64(k), true
+	case uint8:
+		return int64(k), true
+	case uint16:
+		return int64(k), true
+	case uint32:
+		return int64(k), true
+	case uint64:
+		return int64(k), true
+	}
+	return 0, false
+}
+
+// NewLookup creates a Strategy that looks the key up in table, which maps each key to its shard index. table is
+// copied. Keys that are not in the table, including keys of types that cannot be map keys, are refused with
+// ErrUnknownKey.
+func NewLookup(table map[interface{}]int) Strategy {
+	t := make(map[interface{}]int, len(table))
+	for key, index := range table {
+		t[key] = index
+	}
+	return lookupStrategy{table: t}
+}
+
+type lookupStrategy struct {
+	table map[interface{}]int
+}
+
+func (s lookupStrategy) Shard(key interface{}, shards int) (int, error) {
+	if key != nil && !reflect.TypeOf(key).Comparable() {
+		return 0, &ErrUnknownKey{Key: key}
+	}
+	index, ok := s.table[key]
+	if !ok {
+		return 0, &ErrUnknownKey{Key: key}
+	}
+	return index, nil
+}
@@ -0,0 +1,102 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package slice_param
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"github.com/wojnosystems/vsql_engine/sql_lexer"
+	"reflect"
+	"strings"
+)
+
+// DefaultMaxLength is the longest slice a Slicer expands unless configured otherwise
+const DefaultMaxLength = 1000
+
+// ErrEmptySlice is returned when a slice parameter is empty. IN () is not valid SQL and expanding it to NULL would
+// make NOT IN match nothing, so the caller must decide what an empty list means.
+type ErrEmptySlice struct {
+	// Index is the position of the parameter, from 0
+	Index int
+}
+
+func (e ErrEmptySlice) Error() string {
+	return fmt.Sprintf("slice_param: parameter %d is an empty slice", e.Index)
+}
+
+// ErrTooLong is returned when a slice parameter has more values than the Slicer's MaxLength
+type ErrTooLong struct {
+	Index  int
+	Length int
+	Max    int
+}
+
+func (e ErrTooLong) Error() string {
+	return fmt.Sprintf("slice_param: parameter %d has %d values, more than the maximum of %d", e.Index, e.Length, e.Max)
+}
+
+var valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+
+// isSlice is true if value should be expanded into one placeholder per element. []byte and values the driver
+// converts itself, such as Postgres arrays, are single values.
+func isSlice(value interface{}) bool {
+	if value == nil {
+		return false
+	}
+	t := reflect.TypeOf(value)
+	if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+		return false
+	}
+	return t.Elem().Kind() != reflect.Uint8 && !t.Implements(valuerType)
+}
+
+// expand replaces each ? of the positional sqlQuery whose value in params is a slice with one ? per element, and
+// flattens params to match. changed is false if no parameter is a slice.
+func expand(sqlQuery string, params []interface{}, maxLength int) (expanded string, flat []interface{}, changed bool, err error) {
+	tokens := sql_lexer.Lex(sqlQuery)
+	index := 0
+	for i, t := range tokens {
+		if t.Kind != sql_lexer.Placeholder {
+			continue
+		}
+		if index >= len(params) {
+			break
+		}
+		value := params[index]
+		if !isSlice(value) {
+			flat = append(flat, value)
+			index++
+			continue
+		}
+		v := reflect.ValueOf(value)
+		switch {
+		case v.Len() == 0:
+			return "", nil, false, &ErrEmptySlice{Index: index}
+		case maxLength > 0 && v.Len() > maxLength:
+			return "", nil, false, &ErrTooLong{Index: index, Length: v.Len(), Max: maxLength}
+		}
+		for j := 0; j < v.Len(); j++ {
+			flat = append(flat, v.Index(j).Interface())
+		}
+		tokens[i].Text = strings.Repeat(t.Text+", ", v.Len()-1) + t.Text
+		changed = true
+		index++
+	}
+	if !changed {
+		return sqlQuery, params, false, nil
+	}
+	return sql_lexer.Join(tokens), flat, true, nil
+}
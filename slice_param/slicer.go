@@ -0,0 +1,313 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package slice_param
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vstmt"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/query_rewrite"
+	"sync"
+)
+
+// slice_param binds Go slices to IN lists: a placeholder whose value is a slice is expanded into one placeholder per
+// element, such as WHERE id IN (?) with []int{1, 2, 3} becoming WHERE id IN (?, ?, ?).
+
+// Slicer expands slice parameters. Configure the exported fields before calling Install.
+type Slicer struct {
+	// MaxLength is the most values a slice may have. If 0 or less, the length is not limited.
+	MaxLength int
+	// MaxVariants is the most variants kept for each prepared statement. When a statement is used with another
+	// number of values, the variant used least recently is closed. If 0 or less, the variants are not limited.
+	MaxVariants int
+
+	// prepared maps the statements created by the driver to their query, so their variants can be closed with them
+	prepared engine_context.Tracker[*sliceableQuery]
+}
+
+// DefaultMaxVariants is the number of variants a Slicer keeps for each statement unless configured otherwise
+const DefaultMaxVariants = 8
+
+// New creates a Slicer that expands slices of up to DefaultMaxLength values and keeps DefaultMaxVariants variants
+// of each statement
+func New() *Slicer {
+	return &Slicer{MaxLength: DefaultMaxLength, MaxVariants: DefaultMaxVariants}
+}
+
+// preparePerformer and closePerformer run a whole chain. They are implemented by the engine_ware chains.
+type preparePerformer interface {
+	PerformMiddleware(ctx context.Context, c engine_context.Preparer)
+}
+
+type closePerformer interface {
+	PerformMiddleware(ctx context.Context, c engine_context.StatementCloser)
+}
+
+// Install prepends the slicer to the engine. Slices are expanded for Query, Exec and Insert, and for prepared
+// statements. A statement is prepared again for each distinct number of values it is used with; up to MaxVariants
+// of those statements are kept with it and they are closed when it is closed.
+func (s *Slicer) Install(e vsql_engine.SQLQueryer) {
+	e.QueryMW().Prepend(func(ctx context.Context, c engine_context.Queryer) {
+		s.expandQuery(ctx, c)
+	})
+	e.ExecQueryMW().Prepend(func(ctx context.Context, c engine_context.Execer) {
+		s.expandQuery(ctx, c)
+	})
+	e.InsertQueryMW().Prepend(func(ctx context.Context, c engine_context.Inserter) {
+		s.expandQuery(ctx, c)
+	})
+	prepareChain, _ := e.StatementPrepareMW().(preparePerformer)
+	closeChain, _ := e.StatementCloseMW().(closePerformer)
+	e.StatementPrepareMW().Prepend(func(ctx context.Context, c engine_context.Preparer) {
+		if _, ok := c.Query().(*expandedQuery); ok || prepareChain == nil {
+			c.Next(ctx)
+			return
+		}
+		q := &sliceableQuery{
+			Queryer:     c.Query(),
+			maxVariants: s.MaxVariants,
+			variants:    make(map[string]*variant),
+		}
+		q.prepare = func(ctx context.Context, query *expandedQuery) (vstmt.Statementer, error) {
+			// the whole chain is run, as for any statement the caller prepares, in the same transaction
+			pc := engine_context.NewPreparer()
+			pc.(engine_context.WithMiddlewarer).ShallowCopyFrom(c.(engine_context.WithMiddlewarer))
+			pc.SetQueryExecTransactioner(c.QueryExecTransactioner())
+			pc.SetQuery(query)
+			prepareChain.PerformMiddleware(ctx, pc)
+			return pc.Statement(), pc.Error()
+		}
+		q.close = func(ctx context.Context, stmt vstmt.Statementer) error {
+			return closeStatement(ctx, closeChain, c.(engine_context.WithMiddlewarer), stmt)
+		}
+		c.SetQuery(q)
+		c.Next(ctx)
+		if c.Error() == nil {
+			s.prepared.Track(c.Statement(), q)
+		}
+	})
+	e.StatementQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementQueryer) {
+		s.expandStatement(ctx, c)
+	})
+	e.StatementExecQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		s.expandStatement(ctx, c)
+	})
+	e.StatementInsertQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementInsertQueryer) {
+		s.expandStatement(ctx, c)
+	})
+	e.StatementCloseMW().Prepend(func(ctx context.Context, c engine_context.StatementCloser) {
+		q, ok := s.prepared.Forget(c.Statement())
+		c.Next(ctx)
+		if !ok {
+			return
+		}
+		stmts, err := q.takeVariants()
+		for _, stmt := range stmts {
+			if closeErr := closeStatement(ctx, closeChain, c.(engine_context.WithMiddlewarer), stmt); err == nil {
+				err = closeErr
+			}
+		}
+		if c.Error() == nil {
+			c.SetError(err)
+		}
+	})
+}
+
+// closeStatement closes a variant through the whole StatementClose chain, as for any statement the caller closes,
+// with the context of from
+func closeStatement(ctx context.Context, closeChain closePerformer, from engine_context.WithMiddlewarer, stmt vstmt.Statementer) error {
+	if closeChain == nil {
+		return stmt.Close()
+	}
+	cc := engine_context.NewStatementClose()
+	cc.(engine_context.WithMiddlewarer).ShallowCopyFrom(from)
+	cc.SetStatement(stmt)
+	closeChain.PerformMiddleware(ctx, cc)
+	return cc.Error()
+}
+
+func (s *Slicer) expandQuery(ctx context.Context, c engine_context.CommonQueryer) {
+	if c.Query() != nil {
+		sqlQuery, params, err := query_rewrite.Positional(c.Query())
+		var expanded string
+		var flat []interface{}
+		changed := false
+		if err == nil {
+			expanded, flat, changed, err = expand(sqlQuery, params, s.MaxLength)
+		}
+		if err != nil {
+			c.SetError(err)
+			return
+		}
+		if changed {
+			c.SetQuery(query_rewrite.New(expanded, flat))
+		}
+	}
+	c.Next(ctx)
+}
+
+// statementContext is the part of the statement contexts expandStatement needs
+type statementContext interface {
+	engine_context.Er
+	SetStatement(vstmt.Statementer)
+	SetParameterer(vparam.Parameterer)
+	Parameterer() vparam.Parameterer
+	SetQuery(vparam.Queryer)
+	Query() vparam.Queryer
+}
+
+// expandStatement runs a statement used with slices as the variant of the statement prepared for their lengths
+func (s *Slicer) expandStatement(ctx context.Context, c statementContext) {
+	q, ok := c.Query().(*sliceableQuery)
+	if !ok || c.Parameterer() == nil {
+		c.Next(ctx)
+		return
+	}
+	params, err := query_rewrite.PositionalParameters(q.SQLQueryUnInterpolated(), c.Parameterer())
+	var expanded string
+	var flat []interface{}
+	changed := false
+	if err == nil {
+		expanded, flat, changed, err = expand(query_rewrite.PositionalSQL(q.Queryer), params, s.MaxLength)
+	}
+	var v *variant
+	if err == nil && changed {
+		v, err = q.acquire(ctx, expanded)
+	}
+	if err != nil {
+		c.SetError(err)
+		return
+	}
+	if changed {
+		c.SetStatement(v.stmt)
+		c.SetQuery(v.query)
+		c.SetParameterer(query_rewrite.Parameters(flat))
+		defer q.release(ctx, v)
+	}
+	c.Next(ctx)
+}
+
+// sliceableQuery is the query of a prepared statement. It keeps the statements prepared for each distinct number
+// of values it was used with, up to maxVariants.
+type sliceableQuery struct {
+	vparam.Queryer
+	prepare     func(ctx context.Context, query *expandedQuery) (vstmt.Statementer, error)
+	close       func(ctx context.Context, stmt vstmt.Statementer) error
+	maxVariants int
+
+	mu sync.Mutex
+	// variants are keyed by their expanded SQL
+	variants map[string]*variant
+	// recent are the variants from the least to the most recently used
+	recent []*variant
+	// closeErr is the first error closing an evicted variant. It is returned when the statement is closed.
+	closeErr error
+}
+
+// expandedQuery is the positional SQL of a variant. It is prepared as it is.
+type expandedQuery struct {
+	vparam.Queryer
+}
+
+type variant struct {
+	query *expandedQuery
+	stmt  vstmt.Statementer
+	// users is the number of calls using the variant. An evicted variant is closed when its last user is done.
+	users   int
+	evicted bool
+}
+
+// acquire returns the statement prepared with the expanded SQL, preparing it if this is its first use, and evicts
+// the least recently used variants beyond maxVariants. Call release when the variant is no longer used.
+func (q *sliceableQuery) acquire(ctx context.Context, expanded string) (*variant, error) {
+	q.mu.Lock()
+	v, ok := q.variants[expanded]
+	if !ok {
+		query := &expandedQuery{Queryer: query_rewrite.New(expanded, nil)}
+		stmt, err := q.prepare(ctx, query)
+		if err != nil {
+			q.mu.Unlock()
+			return nil, err
+		}
+		v = &variant{query: query, stmt: stmt}
+		q.variants[expanded] = v
+	}
+	v.users++
+	q.use(v)
+	var unused []*variant
+	for q.maxVariants > 0 && len(q.recent) > q.maxVariants {
+		oldest := q.recent[0]
+		q.recent = q.recent[1:]
+		delete(q.variants, oldest.query.SQLQueryUnInterpolated())
+		oldest.evicted = true
+		if oldest.users == 0 {
+			unused = append(unused, oldest)
+		}
+	}
+	q.mu.Unlock()
+	for _, evicted := range unused {
+		q.closeEvicted(ctx, evicted)
+	}
+	return v, nil
+}
+
+// use moves v to the end of recent
+func (q *sliceableQuery) use(v *variant) {
+	for i, r := range q.recent {
+		if r == v {
+			q.recent = append(q.recent[:i], q.recent[i+1:]...)
+			break
+		}
+	}
+	q.recent = append(q.recent, v)
+}
+
+// release is called when a call is done with v. It closes v if it was evicted and this was its last user.
+func (q *sliceableQuery) release(ctx context.Context, v *variant) {
+	q.mu.Lock()
+	v.users--
+	unused := v.evicted && v.users == 0
+	q.mu.Unlock()
+	if unused {
+		q.closeEvicted(ctx, v)
+	}
+}
+
+func (q *sliceableQuery) closeEvicted(ctx context.Context, v *variant) {
+	if err := q.close(ctx, v.stmt); err != nil {
+		q.mu.Lock()
+		if q.closeErr == nil {
+			q.closeErr = err
+		}
+		q.mu.Unlock()
+	}
+}
+
+// takeVariants returns the statements of the variants, and the first error closing an evicted variant, and
+// forgets them
+func (q *sliceableQuery) takeVariants() (stmts []vstmt.Statementer, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, v := range q.variants {
+		stmts = append(stmts, v.stmt)
+	}
+	q.variants = make(map[string]*variant)
+	q.recent = nil
+	err, q.closeErr = q.closeErr, nil
+	return
+}
@@ -0,0 +1,139 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package slice_param
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/internal/testdriver"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	sqlQuery, params, changed, err := expand("SELECT '?' FROM t WHERE id IN (?) AND b = ? AND c IN (?)",
+		[]interface{}{[]int{1, 2, 3}, []byte("raw"), [2]string{"x", "y"}}, 3)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "SELECT '?' FROM t WHERE id IN (?, ?, ?) AND b = ? AND c IN (?, ?)", sqlQuery)
+	assert.Equal(t, []interface{}{1, 2, 3, []byte("raw"), "x", "y"}, params)
+
+	_, _, changed, err = expand("SELECT * FROM t WHERE id = ?", []interface{}{1}, 3)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	_, _, _, err = expand("SELECT * FROM t WHERE a = ? AND id IN (?)", []interface{}{1, []int{}}, 3)
+	assert.Equal(t, &ErrEmptySlice{Index: 1}, err)
+	_, _, _, err = expand("SELECT * FROM t WHERE id IN (?)", []interface{}{[]int{1, 2, 3, 4}}, 3)
+	assert.Equal(t, &ErrTooLong{Index: 0, Length: 4, Max: 3}, err)
+}
+
+func newEngine(s *Slicer) (vsql_engine.SingleTXer, *testdriver.Driver) {
+	e := vsql_engine.NewSingle()
+	d := testdriver.Install(e)
+	s.Install(e)
+	return e, d
+}
+
+// last returns the last call that reached the driver through chain
+func last(d *testdriver.Driver, chain string) testdriver.Call {
+	calls := d.Calls(chain)
+	return calls[len(calls)-1]
+}
+
+// statements returns the SQL of the statements the calls were made with
+func statements(calls []testdriver.Call) (sqlQueries []string) {
+	for _, c := range calls {
+		sqlQueries = append(sqlQueries, c.Statement.SQL)
+	}
+	return
+}
+
+func TestSlicer_Query(t *testing.T) {
+	e, d := newEngine(New())
+	_, err := e.Query(context.Background(), vparam.NewNamedWithData("SELECT * FROM t WHERE id IN (:ids) AND org = :org",
+		map[string]interface{}{"ids": []string{"a", "b"}, "org": 7}))
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM t WHERE id IN (?, ?) AND org = ?", last(d, testdriver.Query).SQL)
+	assert.Equal(t, []interface{}{"a", "b", 7}, last(d, testdriver.Query).Params)
+
+	s := New()
+	s.MaxLength = 1
+	e, _ = newEngine(s)
+	_, err = e.Query(context.Background(), vparam.NewAppendWithData("SELECT * FROM t WHERE id IN (?)", []int{1, 2}))
+	assert.Equal(t, &ErrTooLong{Index: 0, Length: 2, Max: 1}, err)
+}
+
+func TestSlicer_Statements(t *testing.T) {
+	e, d := newEngine(New())
+	ctx := context.Background()
+	const sqlQuery = "DELETE FROM t WHERE id IN (?) AND org = ?"
+
+	stmt, err := e.Prepare(ctx, vparam.New(sqlQuery))
+	assert.NoError(t, err)
+	exec := func(ids []int) *testdriver.Statement {
+		_, err := stmt.Exec(ctx, vparam.NewAppendWithData(sqlQuery, ids, 7))
+		assert.NoError(t, err)
+		return last(d, testdriver.StatementExec).Statement
+	}
+	two := exec([]int{1, 2})
+	assert.Equal(t, "DELETE FROM t WHERE id IN (?, ?) AND org = ?", two.SQL)
+	assert.Equal(t, []interface{}{1, 2, 7}, last(d, testdriver.StatementExec).Params)
+	assert.True(t, two == exec([]int{3, 4}), "variants are prepared once")
+	three := exec([]int{1, 2, 3})
+	assert.False(t, two == three)
+	assert.Equal(t, []string{sqlQuery, two.SQL, three.SQL}, statements(d.Calls(testdriver.Prepare)))
+
+	assert.NoError(t, stmt.Close())
+	assert.ElementsMatch(t, []string{sqlQuery, two.SQL, three.SQL}, statements(d.Calls(testdriver.StatementClose)))
+}
+
+func TestSlicer_MaxVariants(t *testing.T) {
+	s := New()
+	s.MaxVariants = 2
+	e, d := newEngine(s)
+	ctx := context.Background()
+	const sqlQuery = "SELECT * FROM t WHERE id IN (?)"
+
+	stmt, err := e.Prepare(ctx, vparam.New(sqlQuery))
+	assert.NoError(t, err)
+	query := func(ids ...int) *testdriver.Statement {
+		rows, err := stmt.Query(ctx, vparam.NewAppendWithData(sqlQuery, ids))
+		assert.NoError(t, err)
+		assert.NoError(t, rows.Close())
+		return last(d, testdriver.StatementQuery).Statement
+	}
+	one := query(1)
+	two := query(1, 2)
+	assert.True(t, one == query(3), "one is now used more recently than two")
+	assert.Empty(t, d.Calls(testdriver.StatementClose))
+	three := query(1, 2, 3)
+	assert.Equal(t, []string{two.SQL}, statements(d.Calls(testdriver.StatementClose)), "the least recently used variant is closed")
+	again := query(4, 5)
+	assert.False(t, two == again, "evicted variants are prepared again")
+
+	d.Fail = func(call testdriver.Call) error {
+		if call.Chain == testdriver.StatementClose && call.Statement == three {
+			return errors.New("close failed")
+		}
+		return nil
+	}
+	query(1, 2, 3, 4)
+	assert.EqualError(t, stmt.Close(), "close failed", "errors closing evicted variants are returned by Close")
+	assert.Contains(t, statements(d.Calls(testdriver.StatementClose)), again.SQL, "the other variants are closed")
+}
@@ -0,0 +1,214 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package sql_comment
+
+import (
+	"context"
+	"github.com/wojnosystems/vsql/interpolation_strategy"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/engine_ware"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// sql_comment tags SQL with a comment describing where it came from, in the sqlcommenter format:
+// SELECT * FROM users /*route='%2Fusers',service='billing'*/
+// so that queries in the database's logs can be tied back to the service and request that sent them.
+
+// Keys are the names of the tags. Tags with an empty key are not added.
+type Keys struct {
+	Service       string
+	Route         string
+	TraceID       string
+	TransactionID string
+}
+
+// DefaultKeys are the keys a Commenter uses unless configured otherwise
+var DefaultKeys = Keys{
+	Service:       "service",
+	Route:         "route",
+	TraceID:       "trace_id",
+	TransactionID: "tx_id",
+}
+
+// Commenter adds the tags to SQL. Configure the exported fields before calling Install.
+type Commenter struct {
+	// Service is the service tag used when the context does not set one with WithService
+	Service string
+	Keys    Keys
+
+	// transactions maps the transactions created by the driver to their ID
+	transactions engine_context.Tracker[string]
+	lastID       uint64
+}
+
+// New creates a Commenter for the service, with the DefaultKeys
+func New(service string) *Commenter {
+	return &Commenter{
+		Service: service,
+		Keys:    DefaultKeys,
+	}
+}
+
+// Install prepends the commenter to the Query, Exec, Insert and Prepare chains of the engine. Statements are tagged
+// when they are prepared, with the Service alone: a statement outlives the request that prepared it, so that
+// request's route, trace and transaction would mislabel its later executions, and statements prepared with differing
+// SQL would not be shared by the database. Their executions are not tagged, as their SQL was fixed when they were
+// prepared. Transactions are numbered as they begin, for the transaction tag.
+func (m *Commenter) Install(e vsql_engine.SQLQueryer) {
+	e.QueryMW().Prepend(func(ctx context.Context, c engine_context.Queryer) {
+		m.tag(ctx, c)
+	})
+	e.ExecQueryMW().Prepend(func(ctx context.Context, c engine_context.Execer) {
+		m.tag(ctx, c)
+	})
+	e.InsertQueryMW().Prepend(func(ctx context.Context, c engine_context.Inserter) {
+		m.tag(ctx, c)
+	})
+	e.StatementPrepareMW().Prepend(func(ctx context.Context, c engine_context.Preparer) {
+		if c.Query() != nil {
+			if comment := Format(tags(m.Keys.Service, m.Service)); comment != "" {
+				c.SetQuery(&commentedQuery{Queryer: c.Query(), comment: comment})
+			}
+		}
+		c.Next(ctx)
+	})
+	if b, ok := e.(engine_ware.BeginWare); ok {
+		b.BeginMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+			c.Next(ctx)
+			if c.Error() == nil {
+				m.begun(c.QueryExecTransactioner())
+			}
+		})
+	}
+	if b, ok := e.(engine_ware.BeginNestedWare); ok {
+		b.BeginNestedMW().Prepend(func(ctx context.Context, c engine_context.NestedBeginner) {
+			c.Next(ctx)
+			if c.Error() == nil && c.QueryExecNestedTransactioner() != nil {
+				m.begun(c.QueryExecNestedTransactioner())
+			}
+		})
+	}
+	e.CommitMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+		c.Next(ctx)
+		if c.Error() == nil {
+			m.forget(c.QueryExecTransactioner())
+		}
+	})
+	e.RollbackMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+		c.Next(ctx)
+		m.forget(c.QueryExecTransactioner())
+	})
+}
+
+func (m *Commenter) begun(tx interface{}) {
+	if engine_context.Trackable(tx) {
+		m.transactions.Track(tx, strconv.FormatUint(atomic.AddUint64(&m.lastID, 1), 10))
+	}
+}
+
+func (m *Commenter) forget(tx interface{}) {
+	m.transactions.Forget(tx)
+}
+
+func (m *Commenter) tag(ctx context.Context, c engine_context.CommonQueryer) {
+	if c.Query() != nil {
+		if comment := m.comment(ctx, c.QueryExecTransactioner()); comment != "" {
+			c.SetQuery(&commentedQuery{Queryer: c.Query(), comment: comment})
+		}
+	}
+	c.Next(ctx)
+}
+
+// comment returns the comment for a call made with ctx in tx, or "" if it has no tags
+func (m *Commenter) comment(ctx context.Context, tx interface{}) string {
+	service := stringFrom(ctx, serviceKey{})
+	if service == "" {
+		service = m.Service
+	}
+	id, _ := m.transactions.Lookup(tx)
+	return Format(tags(
+		m.Keys.Service, service,
+		m.Keys.Route, stringFrom(ctx, routeKey{}),
+		m.Keys.TraceID, stringFrom(ctx, traceIDKey{}),
+		m.Keys.TransactionID, id,
+	))
+}
+
+// tags returns the tags of the key and value pairs in keysAndValues. Tags with an empty key or value are left out.
+func tags(keysAndValues ...string) (tagged map[string]string) {
+	tagged = make(map[string]string)
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		if keysAndValues[i] != "" && keysAndValues[i+1] != "" {
+			tagged[keysAndValues[i]] = keysAndValues[i+1]
+		}
+	}
+	return
+}
+
+// Format returns the sqlcommenter comment for tags: the tags sorted by key, as key='value' pairs separated by
+// commas. Keys and values are URL encoded, so they cannot end the comment or its quotes.
+func Format(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(tags))
+	for key, value := range tags {
+		pairs = append(pairs, escape(key)+"='"+escape(value)+"'")
+	}
+	sort.Strings(pairs)
+	return "/*" + strings.Join(pairs, ",") + "*/"
+}
+
+func escape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// appendComment adds the comment to the end of sqlQuery, before any final semicolon
+func appendComment(sqlQuery, comment string) string {
+	trimmed := strings.TrimRight(sqlQuery, " \t\r\n")
+	if strings.HasSuffix(trimmed, ";") {
+		return strings.TrimRight(trimmed[:len(trimmed)-1], " \t\r\n") + " " + comment + ";"
+	}
+	return trimmed + " " + comment
+}
+
+// commentedQuery adds the comment to the SQL of a query. The values and placeholders are those of the query.
+type commentedQuery struct {
+	vparam.Queryer
+	comment string
+}
+
+func (q *commentedQuery) SQLQueryUnInterpolated() string {
+	return appendComment(q.Queryer.SQLQueryUnInterpolated(), q.comment)
+}
+
+func (q *commentedQuery) SQLQueryInterpolated(strategy interpolation_strategy.InterpolateStrategy) string {
+	return appendComment(q.Queryer.SQLQueryInterpolated(strategy), q.comment)
+}
+
+func (q *commentedQuery) Interpolate(sqlQuery string, strategy interpolation_strategy.InterpolateStrategy) (string, []interface{}, error) {
+	interpolated, params, err := q.Queryer.Interpolate(q.Queryer.SQLQueryUnInterpolated(), strategy)
+	if err != nil {
+		return interpolated, params, err
+	}
+	return appendComment(interpolated, q.comment), params, nil
+}
@@ -0,0 +1,105 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package sql_comment
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/internal/testdriver"
+	"testing"
+)
+
+func newEngine() (vsql_engine.SingleTXer, *testdriver.Driver) {
+	e := vsql_engine.NewSingle()
+	return e, testdriver.Install(e)
+}
+
+// queries returns the SQL of the calls that reached the driver through the chains given
+func queries(d *testdriver.Driver, chains ...string) (sqlQueries []string) {
+	for _, c := range d.Calls(chains...) {
+		sqlQueries = append(sqlQueries, c.SQL)
+	}
+	return
+}
+
+func TestCommenter_Tags(t *testing.T) {
+	e, d := newEngine()
+	New("billing").Install(e)
+	ctx := WithTraceID(WithRoute(context.Background(), "/users/:id"), "00-4bf9-01")
+
+	_, err := e.Exec(ctx, vparam.NewNamedWithData("DELETE FROM users WHERE id = :id;", map[string]interface{}{"id": 7}))
+	assert.NoError(t, err)
+	assert.Equal(t, "DELETE FROM users WHERE id = ? /*route='%2Fusers%2F%3Aid',service='billing',trace_id='00-4bf9-01'*/;", d.Calls()[0].SQL)
+	assert.Equal(t, []interface{}{7}, d.Calls()[0].Params)
+
+	_, err = e.Exec(WithService(context.Background(), "admin */ DROP TABLE users; /*"), vparam.New("DELETE FROM sessions"))
+	assert.NoError(t, err)
+	assert.Equal(t, "DELETE FROM sessions /*service='admin%20%2A%2F%20DROP%20TABLE%20users%3B%20%2F%2A'*/", d.Calls()[1].SQL)
+}
+
+func TestCommenter_Transactions(t *testing.T) {
+	e, d := newEngine()
+	c := New("")
+	c.Keys.TransactionID = "txn"
+	c.Install(e)
+	ctx := context.Background()
+
+	_, err := e.Exec(ctx, vparam.New("DELETE FROM sessions"))
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		tx, err := e.Begin(ctx, nil)
+		assert.NoError(t, err)
+		_, err = tx.Exec(ctx, vparam.New("DELETE FROM sessions"))
+		assert.NoError(t, err)
+		assert.NoError(t, tx.Commit())
+	}
+	_, err = e.Exec(ctx, vparam.New("DELETE FROM sessions"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"DELETE FROM sessions",
+		"DELETE FROM sessions /*txn='1'*/",
+		"DELETE FROM sessions /*txn='2'*/",
+		"DELETE FROM sessions",
+	}, queries(d, testdriver.Exec))
+}
+
+func TestCommenter_Statements(t *testing.T) {
+	e, d := newEngine()
+	c := New("billing")
+	c.Keys.TransactionID = "txn"
+	c.Install(e)
+	ctx := WithTraceID(WithRoute(context.Background(), "/orders"), "00-4bf9-01")
+
+	tx, err := e.Begin(ctx, nil)
+	assert.NoError(t, err)
+	stmt, err := tx.Prepare(ctx, vparam.NewNamed("UPDATE orders SET state = :state WHERE id = :id"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"UPDATE orders SET state = ? WHERE id = ? /*service='billing'*/"}, queries(d, testdriver.Prepare),
+		"statements are only tagged with the service")
+	_, err = stmt.Exec(WithRoute(context.Background(), "/other"), vparam.NewNamedData(map[string]interface{}{"state": "paid", "id": 7}))
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"paid", 7}, d.Calls(testdriver.StatementExec)[0].Params)
+	assert.Equal(t, "UPDATE orders SET state = ? WHERE id = ? /*service='billing'*/", d.Calls(testdriver.StatementExec)[0].SQL,
+		"statement executions are not tagged")
+
+	e, d = newEngine()
+	New("").Install(e)
+	_, err = e.Prepare(WithService(ctx, "admin"), vparam.New("SELECT 1"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SELECT 1"}, queries(d, testdriver.Prepare), "without a Service, statements are not tagged")
+}
@@ -0,0 +1,166 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package sql_firewall
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/wojnosystems/vsql_engine/fingerprint"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// allowlistHeader starts every allowlist file written by Allowlist.WriteTo
+const allowlistHeader = `# SQL firewall allowlist. Each line is a query fingerprint ID followed by its normalized query.
+# Lines are sorted by query so that changes are easy to review. Remove a line to forbid its query.
+`
+
+var fingerprintID = regexp.MustCompile("^[0-9a-f]{16}$")
+
+// ErrAllowlistSyntax is returned when an allowlist cannot be read
+type ErrAllowlistSyntax struct {
+	Line int
+	Text string
+}
+
+func (e ErrAllowlistSyntax) Error() string {
+	return fmt.Sprintf(`sql_firewall: allowlist line %d is not "<fingerprint ID> <normalized query>": %s`, e.Line, e.Text)
+}
+
+// Allowlist is the set of query fingerprints the firewall allows. It is safe for concurrent use.
+type Allowlist struct {
+	mu sync.RWMutex
+	// queries maps fingerprint IDs to their normalized queries
+	queries map[string]string
+}
+
+// NewAllowlist creates an empty allowlist
+func NewAllowlist() *Allowlist {
+	return &Allowlist{
+		queries: make(map[string]string),
+	}
+}
+
+// ReadAllowlist reads an allowlist in the format written by WriteTo. Blank lines and lines starting with # are ignored.
+func ReadAllowlist(r io.Reader) (*Allowlist, error) {
+	a := NewAllowlist()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.SplitN(text, " ", 2)
+		if len(parts) != 2 || !fingerprintID.MatchString(parts[0]) {
+			return nil, &ErrAllowlistSyntax{Line: line, Text: text}
+		}
+		a.queries[parts[0]] = parts[1]
+	}
+	return a, scanner.Err()
+}
+
+// LoadAllowlist reads the allowlist file at path. A missing file is an empty allowlist.
+func LoadAllowlist(path string) (*Allowlist, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return NewAllowlist(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return ReadAllowlist(f)
+}
+
+// Allows is true if the fingerprint is on the allowlist
+func (a *Allowlist) Allows(fp fingerprint.Fingerprinter) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	_, ok := a.queries[fp.ID()]
+	return ok
+}
+
+// Add puts the fingerprint on the allowlist. It returns true if it was not already on it.
+func (a *Allowlist) Add(fp fingerprint.Fingerprinter) (added bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.queries[fp.ID()]; ok {
+		return false
+	}
+	a.queries[fp.ID()] = fp.Normalized()
+	return true
+}
+
+// Len is the number of fingerprints on the allowlist
+func (a *Allowlist) Len() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.queries)
+}
+
+// WriteTo writes the allowlist, one fingerprint per line, sorted by query
+func (a *Allowlist) WriteTo(w io.Writer) (n int64, err error) {
+	a.mu.RLock()
+	lines := make([]string, 0, len(a.queries))
+	for id, query := range a.queries {
+		lines = append(lines, id+" "+query)
+	}
+	a.mu.RUnlock()
+	sort.Slice(lines, func(i, j int) bool {
+		// the query is the part after the 16 character ID and space
+		if lines[i][17:] != lines[j][17:] {
+			return lines[i][17:] < lines[j][17:]
+		}
+		return lines[i] < lines[j]
+	})
+	bw := bufio.NewWriter(w)
+	written, _ := bw.WriteString(allowlistHeader)
+	n += int64(written)
+	for _, line := range lines {
+		written, _ = bw.WriteString(line + "\n")
+		n += int64(written)
+	}
+	return n, bw.Flush()
+}
+
+// Save writes the allowlist to the file at path, replacing it. The file is replaced atomically so readers never see
+// a partial allowlist.
+func (a *Allowlist) Save(path string) (err error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+	if _, err = a.WriteTo(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,232 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package sql_firewall
+
+import (
+	"context"
+	"fmt"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/fingerprint"
+	"log"
+	"sync"
+	"time"
+)
+
+// sql_firewall only lets queries whose shape has been seen before reach the database. Queries are compared by
+// fingerprint, so a query is allowed regardless of its literal values, but an attacker who changes its structure,
+// for example with SQL injection, is stopped.
+
+// Mode is what the firewall does with queries
+type Mode uint8
+
+const (
+	// Learn allows every query and adds its fingerprint to the allowlist, saving the allowlist when it changes
+	Learn Mode = iota
+	// Enforce refuses queries whose fingerprint is not on the allowlist with ErrNotAllowed
+	Enforce
+)
+
+func (m Mode) String() string {
+	switch m {
+	case Learn:
+		return "learn"
+	case Enforce:
+		return "enforce"
+	}
+	return "unknown"
+}
+
+// ErrNotAllowed is returned in Enforce mode for queries whose fingerprint is not on the allowlist
+type ErrNotAllowed struct {
+	// Fingerprint is the ID of the query's fingerprint
+	Fingerprint string
+	// Query is the normalized query. The literal values are removed, so it is safe to log.
+	Query         string
+	StatementType fingerprint.StatementType
+}
+
+func (e ErrNotAllowed) Error() string {
+	return fmt.Sprintf("sql_firewall: query %s is not on the allowlist: %s", e.Fingerprint, e.Query)
+}
+
+// Event describes a query the firewall refused
+type Event struct {
+	Time          time.Time
+	Mode          Mode
+	Fingerprint   string
+	Query         string
+	StatementType fingerprint.StatementType
+	Tables        []string
+}
+
+// DefaultSaveDelay is how long a firewall in Learn mode waits after learning a fingerprint before it saves the
+// allowlist when Firewall.SaveDelay is 0
+const DefaultSaveDelay = time.Second
+
+// Firewall checks queries against an allowlist of fingerprints
+type Firewall struct {
+	// Mode is what the firewall does with queries
+	Mode Mode
+	// Allowlist is the fingerprints that are allowed
+	Allowlist *Allowlist
+	// Path is the file the allowlist is saved to in Learn mode. If empty, the allowlist is only kept in memory.
+	Path string
+	// SaveDelay is how long the firewall waits after learning a fingerprint before it saves the allowlist, so the
+	// fingerprints learned meanwhile are written in one save instead of one each. If 0, DefaultSaveDelay is used.
+	// Call Flush before the program exits to save fingerprints that are still waiting.
+	SaveDelay time.Duration
+	// Audit receives an Event for every refused query. If nil, refused queries are written to the standard logger.
+	Audit func(event Event)
+	// OnSaveError receives errors saving the allowlist in Learn mode. If nil, they are written to the standard logger.
+	OnSaveError func(err error)
+
+	// saveMu serializes saves of the allowlist file
+	saveMu sync.Mutex
+	// pendingMu protects pending
+	pendingMu sync.Mutex
+	// pending is the timer of the scheduled save, nil if no save is scheduled
+	pending *time.Timer
+}
+
+// New creates a firewall in the given mode using the allowlist file at path, which is read if it exists
+func New(mode Mode, path string) (*Firewall, error) {
+	allowlist, err := LoadAllowlist(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Firewall{
+		Mode:      mode,
+		Allowlist: allowlist,
+		Path:      path,
+	}, nil
+}
+
+// Install prepends the firewall to the chains that run SQL given by the caller: Query, Exec, Insert and Prepare.
+// Statements are checked when they are prepared.
+func (f *Firewall) Install(e vsql_engine.SQLQueryer) {
+	e.QueryMW().Prepend(func(ctx context.Context, c engine_context.Queryer) {
+		f.check(ctx, c)
+	})
+	e.ExecQueryMW().Prepend(func(ctx context.Context, c engine_context.Execer) {
+		f.check(ctx, c)
+	})
+	e.InsertQueryMW().Prepend(func(ctx context.Context, c engine_context.Inserter) {
+		f.check(ctx, c)
+	})
+	e.StatementPrepareMW().Prepend(func(ctx context.Context, c engine_context.Preparer) {
+		f.check(ctx, c)
+	})
+}
+
+func (f *Firewall) check(ctx context.Context, c engine_context.CommonQueryer) {
+	fp := c.Fingerprint()
+	if fp == nil {
+		// there is no query to check, so nothing can reach the database
+		c.Next(ctx)
+		return
+	}
+	switch f.Mode {
+	case Learn:
+		if f.Allowlist.Add(fp) {
+			f.scheduleSave()
+		}
+	case Enforce:
+		if !f.Allowlist.Allows(fp) {
+			f.audit(Event{
+				Time:          time.Now(),
+				Mode:          f.Mode,
+				Fingerprint:   fp.ID(),
+				Query:         fp.Normalized(),
+				StatementType: fp.StatementType(),
+				Tables:        fp.Tables(),
+			})
+			c.SetError(&ErrNotAllowed{
+				Fingerprint:   fp.ID(),
+				Query:         fp.Normalized(),
+				StatementType: fp.StatementType(),
+			})
+			return
+		}
+	}
+	c.Next(ctx)
+}
+
+// Flush saves the allowlist now if fingerprints learned in Learn mode are waiting for SaveDelay to pass, and waits
+// for a save that is already running to finish
+func (f *Firewall) Flush() error {
+	f.pendingMu.Lock()
+	pending := f.pending
+	f.pending = nil
+	f.pendingMu.Unlock()
+	if pending != nil {
+		// if the timer has already fired, its save runs as well, which is harmless
+		pending.Stop()
+		return f.save()
+	}
+	// a save that has already started holds saveMu until it is done
+	f.saveMu.Lock()
+	defer f.saveMu.Unlock()
+	return nil
+}
+
+// scheduleSave saves the allowlist after SaveDelay, unless a save is already scheduled
+func (f *Firewall) scheduleSave() {
+	if f.Path == "" {
+		return
+	}
+	delay := f.SaveDelay
+	if delay == 0 {
+		delay = DefaultSaveDelay
+	}
+	f.pendingMu.Lock()
+	defer f.pendingMu.Unlock()
+	if f.pending != nil {
+		return
+	}
+	f.pending = time.AfterFunc(delay, f.scheduledSave)
+}
+
+func (f *Firewall) scheduledSave() {
+	f.pendingMu.Lock()
+	f.pending = nil
+	// take saveMu before releasing pendingMu so Flush waits for this save
+	f.saveMu.Lock()
+	f.pendingMu.Unlock()
+	err := f.Allowlist.Save(f.Path)
+	f.saveMu.Unlock()
+	if err != nil {
+		if f.OnSaveError != nil {
+			f.OnSaveError(err)
+		} else {
+			log.Println("sql_firewall: unable to save the allowlist:", err)
+		}
+	}
+}
+
+func (f *Firewall) save() error {
+	f.saveMu.Lock()
+	defer f.saveMu.Unlock()
+	return f.Allowlist.Save(f.Path)
+}
+
+func (f *Firewall) audit(event Event) {
+	if f.Audit != nil {
+		f.Audit(event)
+		return
+	}
+	log.Printf("sql_firewall: refused query %s (%s on %v): %s", event.Fingerprint, event.StatementType, event.Tables, event.Query)
+}
@@ -0,0 +1,142 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package sql_firewall
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vresult"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/fingerprint"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestEngine(f *Firewall) (e vsql_engine.SingleTXer, calls *int) {
+	calls = new(int)
+	e = vsql_engine.NewSingle()
+	e.QueryMW().Append(func(ctx context.Context, c engine_context.Queryer) {
+		*calls++
+		c.SetRows(&vrows.RowserMock{})
+		c.Next(ctx)
+	})
+	e.ExecQueryMW().Append(func(ctx context.Context, c engine_context.Execer) {
+		*calls++
+		c.SetResult(&vresult.ResulterMock{})
+		c.Next(ctx)
+	})
+	f.Install(e)
+	return e, calls
+}
+
+func TestFirewall_LearnThenEnforce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sql_firewall")
+	assert.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "allowlist.txt")
+
+	learner, err := New(Learn, path)
+	assert.NoError(t, err)
+	e, _ := newTestEngine(learner)
+	_, err = e.Query(context.Background(), vparam.New("SELECT * FROM users WHERE id = 1"))
+	assert.NoError(t, err)
+	_, err = e.Exec(context.Background(), vparam.New("DELETE FROM sessions WHERE user_id = 2"))
+	assert.NoError(t, err)
+	_, err = e.Query(context.Background(), vparam.New("SELECT * FROM users WHERE id = 3"))
+	assert.NoError(t, err)
+	assert.NoError(t, learner.Flush())
+
+	contents, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	assert.Equal(t, []string{
+		fingerprint.New("DELETE FROM sessions WHERE user_id = 2").ID() + " delete from sessions where user_id = ?",
+		fingerprint.New("SELECT * FROM users WHERE id = 1").ID() + " select * from users where id = ?",
+	}, lines[len(lines)-2:])
+
+	var events []Event
+	enforcer, err := New(Enforce, path)
+	assert.NoError(t, err)
+	enforcer.Audit = func(event Event) {
+		events = append(events, event)
+	}
+	e, calls := newTestEngine(enforcer)
+	_, err = e.Query(context.Background(), vparam.New("SELECT * FROM users WHERE id = 42"))
+	assert.NoError(t, err)
+	_, err = e.Query(context.Background(), vparam.New("SELECT * FROM users WHERE id = 42 OR 1 = 1"))
+	if assert.IsType(t, &ErrNotAllowed{}, err) {
+		assert.Equal(t, "select * from users where id = ? or ? = ?", err.(*ErrNotAllowed).Query)
+	}
+	assert.Equal(t, 1, *calls)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, []string{"users"}, events[0].Tables)
+		assert.Equal(t, Enforce, events[0].Mode)
+	}
+}
+
+func TestFirewall_LearnSavesOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sql_firewall")
+	assert.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "allowlist.txt")
+
+	var saveErrors []error
+	learner, err := New(Learn, path)
+	assert.NoError(t, err)
+	learner.SaveDelay = time.Hour
+	learner.OnSaveError = func(err error) {
+		saveErrors = append(saveErrors, err)
+	}
+	e, _ := newTestEngine(learner)
+	for i := 0; i < 3; i++ {
+		_, err = e.Exec(context.Background(), vparam.New(fmt.Sprintf("UPDATE t%d SET a = 1", i)))
+		assert.NoError(t, err)
+	}
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "the allowlist is not saved before SaveDelay")
+
+	assert.NoError(t, learner.Flush())
+	loaded, err := LoadAllowlist(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, loaded.Len())
+	assert.NoError(t, learner.Flush(), "nothing is waiting to be saved")
+	assert.Empty(t, saveErrors)
+}
+
+func TestFirewall_NilQueryPassesThrough(t *testing.T) {
+	enforcer := &Firewall{Mode: Enforce, Allowlist: NewAllowlist()}
+	e, calls := newTestEngine(enforcer)
+	_, err := e.Query(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, *calls)
+}
+
+func TestReadAllowlist(t *testing.T) {
+	a, err := ReadAllowlist(strings.NewReader("# comment\n\n0123456789abcdef select ?\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, a.Len())
+
+	_, err = ReadAllowlist(strings.NewReader("# comment\nselect ?\n"))
+	assert.Equal(t, &ErrAllowlistSyntax{Line: 2, Text: "select ?"}, err)
+}
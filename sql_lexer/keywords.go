@@ -0,0 +1,44 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package sql_lexer
+
+import "strings"
+
+// keywords are the reserved words common to the SQL dialects supported by vsql drivers. It is intentionally
+// conservative: a word that is missing from this list is treated as an identifier.
+var keywords = map[string]bool{}
+
+func init() {
+	for _, k := range strings.Fields(`
+		add all alter and any as asc between by case cast check column constraint create cross current_date
+		current_time current_timestamp database default delete desc distinct drop else end escape except exists
+		false fetch for foreign from full group having if ignore in index inner insert intersect interval into is
+		join key left like limit lock natural not null offset on or order outer over partition primary
+		references regexp rename replace returning right rlike rows schema select set share table then to top
+		true truncate union unique update using values view when where with`) {
+		keywords[k] = true
+	}
+}
+
+// IsKeyword is true if word is a reserved SQL keyword, ignoring case
+func IsKeyword(word string) bool {
+	return keywords[strings.ToLower(word)]
+}
+
+// IsKeyword is true if the token is a Word that is a reserved SQL keyword
+func (t Token) IsKeyword() bool {
+	return t.Kind == Word && IsKeyword(t.Text)
+}
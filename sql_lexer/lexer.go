@@ -0,0 +1,298 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package sql_lexer
+
+import (
+	"strings"
+)
+
+// sql_lexer splits SQL text into tokens. It understands just enough SQL to tell literals, comments, quoted identifiers
+// and placeholders apart from the rest of the query, which is what middleware that inspects or rewrites
+// vparam.Queryer SQL needs. It is not a parser: it never fails and it does not validate the SQL it is given.
+
+// Kind identifies the type of a Token
+type Kind uint8
+
+const (
+	// Whitespace is any run of spaces, tabs and newlines
+	Whitespace Kind = iota
+	// Comment is a -- line comment, a # line comment or a /* block comment */
+	Comment
+	// Word is a keyword or an unquoted identifier
+	Word
+	// QuotedIdentifier is an identifier wrapped in back-ticks, double quotes or square brackets
+	QuotedIdentifier
+	// String is a single-quoted string literal
+	String
+	// Number is a numeric literal, including hexadecimal (0x1F) and exponent (1.5e3) forms
+	Number
+	// Placeholder is a bound parameter: ?, $1, :name or @name
+	Placeholder
+	// Punctuation is any operator or separator: , ( ) ; . = <> etc.
+	Punctuation
+)
+
+var kindNames = [...]string{
+	Whitespace:       "Whitespace",
+	Comment:          "Comment",
+	Word:             "Word",
+	QuotedIdentifier: "QuotedIdentifier",
+	String:           "String",
+	Number:           "Number",
+	Placeholder:      "Placeholder",
+	Punctuation:      "Punctuation",
+}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "Unknown"
+}
+
+// Token is a single lexical element of a SQL query
+type Token struct {
+	Kind Kind
+	// Text is the token exactly as it appeared in the source, including any quotes
+	Text string
+	// Pos is the byte offset of the token in the source
+	Pos int
+}
+
+// IsSignificant is true for tokens that change the meaning of the query, that is, anything other than whitespace and comments
+func (t Token) IsSignificant() bool {
+	return t.Kind != Whitespace && t.Kind != Comment
+}
+
+// IsLiteral is true for string and numeric literals
+func (t Token) IsLiteral() bool {
+	return t.Kind == String || t.Kind == Number
+}
+
+// IsWord is true if the token is a Word that matches w, ignoring case
+func (t Token) IsWord(w string) bool {
+	return t.Kind == Word && strings.EqualFold(t.Text, w)
+}
+
+// IsPunctuation is true if the token is the punctuation p
+func (t Token) IsPunctuation(p string) bool {
+	return t.Kind == Punctuation && t.Text == p
+}
+
+// Unquoted returns the text of an identifier without its surrounding quotes. Escaped (doubled) quotes are collapsed.
+// Tokens that are not QuotedIdentifiers are returned as-is.
+func (t Token) Unquoted() string {
+	if t.Kind != QuotedIdentifier || len(t.Text) < 2 {
+		return t.Text
+	}
+	closing := t.Text[len(t.Text)-1:]
+	inner := t.Text[1 : len(t.Text)-1]
+	return strings.Replace(inner, closing+closing, closing, -1)
+}
+
+// Lex breaks sqlQuery into tokens. Concatenating the Text of every token returned re-creates sqlQuery exactly.
+// Unterminated strings, identifiers and comments run to the end of the input. A backslash escapes the next character
+// of a string literal, as in MySQL.
+func Lex(sqlQuery string) []Token {
+	return lex(sqlQuery, false)
+}
+
+// LexStandard is Lex for databases with standard conforming strings, such as Postgres. A backslash is an ordinary
+// character in a '...' string and only escapes the next character in an E'...' string.
+func LexStandard(sqlQuery string) []Token {
+	return lex(sqlQuery, true)
+}
+
+func lex(sqlQuery string, standard bool) (tokens []Token) {
+	tokens = make([]Token, 0, len(sqlQuery)/4+1)
+	for pos := 0; pos < len(sqlQuery); {
+		kind, end := scan(sqlQuery, pos, standard)
+		tokens = append(tokens, Token{Kind: kind, Text: sqlQuery[pos:end], Pos: pos})
+		pos = end
+	}
+	return
+}
+
+// Significant returns only the tokens that are not whitespace or comments
+func Significant(tokens []Token) (significant []Token) {
+	significant = make([]Token, 0, len(tokens))
+	for _, t := range tokens {
+		if t.IsSignificant() {
+			significant = append(significant, t)
+		}
+	}
+	return
+}
+
+// Join concatenates the text of the tokens, reversing Lex
+func Join(tokens []Token) string {
+	sb := strings.Builder{}
+	for _, t := range tokens {
+		sb.WriteString(t.Text)
+	}
+	return sb.String()
+}
+
+// scan reads the token that starts at pos and returns its kind and the offset just past its end. If standard is set,
+// backslashes only escape in E'...' strings.
+func scan(s string, pos int, standard bool) (kind Kind, end int) {
+	c := s[pos]
+	switch {
+	case isSpace(c):
+		end = pos + 1
+		for end < len(s) && isSpace(s[end]) {
+			end++
+		}
+		return Whitespace, end
+	case c == '-' && hasPrefixAt(s, pos, "--"), c == '#':
+		return Comment, scanToLineEnd(s, pos)
+	case c == '/' && hasPrefixAt(s, pos, "/*"):
+		if i := strings.Index(s[pos+2:], "*/"); i >= 0 {
+			return Comment, pos + 2 + i + 2
+		}
+		return Comment, len(s)
+	case c == '\'':
+		return String, scanQuoted(s, pos, '\'', !standard)
+	case c == '"':
+		return QuotedIdentifier, scanQuoted(s, pos, '"', false)
+	case c == '`':
+		return QuotedIdentifier, scanQuoted(s, pos, '`', false)
+	case c == '[':
+		return QuotedIdentifier, scanQuoted(s, pos, ']', false)
+	case (c == 'N' || c == 'n' || c == 'E' || c == 'e' || c == 'X' || c == 'x') && hasPrefixAt(s, pos+1, "'"):
+		// prefixed string literals: N'unicode', E'escaped', X'CAFE'
+		return String, scanQuoted(s, pos+1, '\'', c == 'E' || c == 'e')
+	case isDigit(c), c == '.' && pos+1 < len(s) && isDigit(s[pos+1]):
+		return Number, scanNumber(s, pos)
+	case isWordStart(c):
+		end = pos + 1
+		for end < len(s) && isWordPart(s[end]) {
+			end++
+		}
+		return Word, end
+	case c == '?':
+		return Placeholder, pos + 1
+	case c == '$' && pos+1 < len(s) && isDigit(s[pos+1]):
+		end = pos + 1
+		for end < len(s) && isDigit(s[end]) {
+			end++
+		}
+		return Placeholder, end
+	case (c == ':' || c == '@') && pos+1 < len(s) && isWordStart(s[pos+1]) && !(pos > 0 && s[pos-1] == c):
+		// :name and @name. A doubled prefix (Postgres :: casts, MSSQL @@globals) is not a placeholder
+		end = pos + 1
+		for end < len(s) && isWordPart(s[end]) {
+			end++
+		}
+		return Placeholder, end
+	}
+	return Punctuation, scanPunctuation(s, pos)
+}
+
+// scanQuoted reads a quoted token starting at pos. A doubled closing character is an escaped quote.
+// If backslashEscapes is set, a backslash escapes the next character as well.
+func scanQuoted(s string, pos int, closing byte, backslashEscapes bool) int {
+	for i := pos + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if backslashEscapes {
+				i++
+			}
+		case closing:
+			if i+1 < len(s) && s[i+1] == closing {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+func scanToLineEnd(s string, pos int) int {
+	if i := strings.IndexByte(s[pos:], '\n'); i >= 0 {
+		return pos + i
+	}
+	return len(s)
+}
+
+func scanNumber(s string, pos int) (end int) {
+	end = pos
+	if hasPrefixAt(s, pos, "0x") || hasPrefixAt(s, pos, "0X") {
+		end = pos + 2
+		for end < len(s) && isHexDigit(s[end]) {
+			end++
+		}
+		return
+	}
+	for end < len(s) && isDigit(s[end]) {
+		end++
+	}
+	if end < len(s) && s[end] == '.' {
+		end++
+		for end < len(s) && isDigit(s[end]) {
+			end++
+		}
+	}
+	if end+1 < len(s) && (s[end] == 'e' || s[end] == 'E') {
+		exp := end + 1
+		if s[exp] == '+' || s[exp] == '-' {
+			exp++
+		}
+		if exp < len(s) && isDigit(s[exp]) {
+			end = exp
+			for end < len(s) && isDigit(s[end]) {
+				end++
+			}
+		}
+	}
+	return
+}
+
+var multiCharPunctuation = []string{"<=>", "<>", "!=", "<=", ">=", "||", "::", "->>", "->", "<<", ">>", ":="}
+
+func scanPunctuation(s string, pos int) int {
+	for _, p := range multiCharPunctuation {
+		if hasPrefixAt(s, pos, p) {
+			return pos + len(p)
+		}
+	}
+	return pos + 1
+}
+
+func hasPrefixAt(s string, pos int, prefix string) bool {
+	return pos <= len(s) && strings.HasPrefix(s[pos:], prefix)
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isWordStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isWordPart(c byte) bool {
+	return isWordStart(c) || isDigit(c) || c == '$'
+}
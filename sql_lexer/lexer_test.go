@@ -0,0 +1,96 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package sql_lexer
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLex_RoundTrip(t *testing.T) {
+	cases := []string{
+		"SELECT * FROM users WHERE id = ?",
+		"select 'it''s', \"col\"\"x\", `tick`, [bracket] -- trailing\n/* block */ # hash",
+		"INSERT INTO t VALUES ($1, :name, @p1, 0x1F, 1.5e-3, .5)",
+		"SELECT 'unterminated",
+		"",
+	}
+	for _, c := range cases {
+		assert.Equal(t, c, Join(Lex(c)), c)
+	}
+}
+
+func TestLex_Kinds(t *testing.T) {
+	cases := map[string]struct {
+		sql      string
+		expected []Kind
+	}{
+		"select": {
+			sql:      "SELECT a FROM t WHERE b = 'x' AND c = 12",
+			expected: []Kind{Word, Word, Word, Word, Word, Word, Punctuation, String, Word, Word, Punctuation, Number},
+		},
+		"placeholders": {
+			sql:      "? $1 :name @p1",
+			expected: []Kind{Placeholder, Placeholder, Placeholder, Placeholder},
+		},
+		"postgres cast is not a placeholder": {
+			sql:      "a::int",
+			expected: []Kind{Word, Punctuation, Word},
+		},
+		"quoted identifiers": {
+			sql:      "\"a\" `b` [c]",
+			expected: []Kind{QuotedIdentifier, QuotedIdentifier, QuotedIdentifier},
+		},
+		"placeholder inside string is a string": {
+			sql:      "'?' /* ? */",
+			expected: []Kind{String},
+		},
+		"prefixed strings": {
+			sql:      "N'x' E'\\'' X'CAFE'",
+			expected: []Kind{String, String, String},
+		},
+		"identifiers with digits": {
+			sql:      "t1.c2",
+			expected: []Kind{Word, Punctuation, Word},
+		},
+	}
+	for caseName, c := range cases {
+		actual := make([]Kind, 0)
+		for _, tok := range Significant(Lex(c.sql)) {
+			actual = append(actual, tok.Kind)
+		}
+		assert.Equal(t, c.expected, actual, caseName)
+	}
+}
+
+func TestLexStandard_Backslashes(t *testing.T) {
+	sqlQuery := "'C:\\' = ? AND E'\\'?' = ?"
+	texts := func(tokens []Token) (texts []string) {
+		for _, tok := range Significant(tokens) {
+			texts = append(texts, tok.Text)
+		}
+		return
+	}
+	assert.Equal(t, []string{"'C:\\'", "=", "?", "AND", "E'\\'?'", "=", "?"}, texts(LexStandard(sqlQuery)))
+	assert.Equal(t, []string{"'C:\\' = ? AND E'", "\\", "'?'", "=", "?"}, texts(Lex(sqlQuery)))
+}
+
+func TestToken_Unquoted(t *testing.T) {
+	tokens := Lex("\"a\"\"b\"")
+	assert.Equal(t, `a"b`, tokens[0].Unquoted())
+	tokens = Lex("users")
+	assert.Equal(t, "users", tokens[0].Unquoted())
+}
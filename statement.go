@@ -22,53 +22,77 @@ import (
 	"github.com/wojnosystems/vsql/vrows"
 	"github.com/wojnosystems/vsql/vstmt"
 	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/engine_ware"
 )
 
 // mysqlStatement is a representation of a prepared statement that is NOT running in the engine_context of a transaction
 type statement struct {
-	stmt               vstmt.Statementer
+	stmt vstmt.Statementer
+	// query is the query the statement was prepared with, after the StatementPrepare middleware ran
+	query              vparam.Queryer
 	queryEngineFactory *engineQuery
+	// middlewareContext is the context the calls made with the statement copy from. It holds the depth of the transaction it was prepared in.
+	middlewareContext engine_context.WithMiddlewarer
+	// txCtx is the context of the transaction the statement was prepared in, if any. The handlers attached to it apply
+	// to the statement's calls.
+	txCtx context.Context
 }
 
 // Query see github.com/wojnosystems/vsql/vstmt/statements.go#Statementer
 func (m *statement) Query(ctx context.Context, parameterer vparam.Parameterer) (rRows vrows.Rowser, err error) {
-	c := engine_context.NewStatementQuery()
-	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.queryEngineFactory.middlewareContext)
+	ctx = engine_ware.Inherit(ctx, m.txCtx)
+	c := engine_context.AcquireStatementQuery()
+	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
 	c.SetParameterer(parameterer)
 	c.SetStatement(m.stmt)
+	c.SetQuery(m.query)
 	m.queryEngineFactory.statementQueryMW.PerformMiddleware(ctx, c)
 	r := &rows{
+		ctx:                ctx,
 		rows:               c.Rows(),
 		queryEngineFactory: m.queryEngineFactory,
+		middlewareContext:  m.middlewareContext,
 	}
-	return r, c.Error()
+	err = c.Error()
+	engine_context.Release(c)
+	return r, err
 }
 
 // Insert see github.com/wojnosystems/vsql/vstmt/statements.go#Statementer
 func (m *statement) Insert(ctx context.Context, parameterer vparam.Parameterer) (res vresult.InsertResulter, err error) {
-	c := engine_context.NewStatementInsertQuery()
-	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.queryEngineFactory.middlewareContext)
+	ctx = engine_ware.Inherit(ctx, m.txCtx)
+	c := engine_context.AcquireStatementInsertQuery()
+	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
 	c.SetParameterer(parameterer)
 	c.SetStatement(m.stmt)
+	c.SetQuery(m.query)
 	m.queryEngineFactory.statementInsertQueryMW.PerformMiddleware(ctx, c)
-	return c.InsertResult(), c.Error()
+	res, err = c.InsertResult(), c.Error()
+	engine_context.Release(c)
+	return
 }
 
 // Exec see github.com/wojnosystems/vsql/vstmt/statements.go#Statementer
 func (m *statement) Exec(ctx context.Context, parameterer vparam.Parameterer) (res vresult.Resulter, err error) {
-	c := engine_context.NewStatementExecQuery()
-	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.queryEngineFactory.middlewareContext)
+	ctx = engine_ware.Inherit(ctx, m.txCtx)
+	c := engine_context.AcquireStatementExecQuery()
+	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
 	c.SetParameterer(parameterer)
 	c.SetStatement(m.stmt)
+	c.SetQuery(m.query)
 	m.queryEngineFactory.statementExecQueryMW.PerformMiddleware(ctx, c)
-	return c.Result(), c.Error()
+	res, err = c.Result(), c.Error()
+	engine_context.Release(c)
+	return
 }
 
 // Close see github.com/wojnosystems/vsql/vstmt/statements.go#Statementer
 func (m *statement) Close() error {
-	c := engine_context.NewStatementClose()
-	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.queryEngineFactory.middlewareContext)
+	c := engine_context.AcquireStatementClose()
+	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
 	c.SetStatement(m.stmt)
-	m.queryEngineFactory.statementCloseMW.PerformMiddleware(context.Background(), c)
-	return c.Error()
+	m.queryEngineFactory.statementCloseMW.PerformMiddleware(engine_ware.Inherit(context.Background(), m.txCtx), c)
+	err := c.Error()
+	engine_context.Release(c)
+	return err
 }
@@ -0,0 +1,624 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/fingerprint"
+	"github.com/wojnosystems/vsql_engine/query_rewrite"
+	"github.com/wojnosystems/vsql_engine/sql_lexer"
+	"sort"
+	"strings"
+)
+
+// DefaultColumn is the name of the column holding the tenant ID when PredicateInjector.Column is empty
+const DefaultColumn = "tenant_id"
+
+// ErrUnsafeRewrite is returned for queries that PredicateInjector cannot be sure to restrict to the tenant, such as
+// queries with sub-queries of tenant tables. Use WithBypass for such queries, adding the tenant predicates by hand.
+type ErrUnsafeRewrite struct {
+	Query  string
+	Reason string
+}
+
+func (e ErrUnsafeRewrite) Error() string {
+	return fmt.Sprintf("tenant: refusing to run a query that cannot be restricted to the tenant (%s): %s", e.Reason, e.Query)
+}
+
+type bypassKey struct{}
+
+// WithBypass returns a copy of ctx whose calls are not restricted to a tenant by PredicateInjector, for admin code
+// paths that must see every tenant's data. reason is required and should say why, so bypasses can be audited.
+func WithBypass(ctx context.Context, reason string) context.Context {
+	if reason == "" {
+		panic("tenant: WithBypass requires a reason")
+	}
+	return context.WithValue(ctx, bypassKey{}, reason)
+}
+
+// BypassFrom returns the reason given to WithBypass, if ctx bypasses tenant predicates
+func BypassFrom(ctx context.Context) (reason string, ok bool) {
+	reason, ok = ctx.Value(bypassKey{}).(string)
+	return
+}
+
+// PredicateInjector restricts every query to the rows of the tenant in its context, for tables shared by all tenants
+// that have a tenant column. SELECT, UPDATE and DELETE are given a "tenant_id = ?" predicate for each tenant table
+// and INSERT is given the tenant column. Queries the injector cannot safely rewrite, such as those with tenant tables
+// in sub-queries, RIGHT or FULL joins or tables the injector does not recognize, are refused with ErrUnsafeRewrite.
+// Calls made without a tenant in their context are refused with
+// ErrNoTenant, unless the context is from WithBypass.
+type PredicateInjector struct {
+	// Column is the name of the tenant column. If empty, DefaultColumn is used.
+	Column string
+	// Shared are the names of tables without a tenant column, which are not restricted
+	Shared []string
+}
+
+// NewPredicateInjector creates a PredicateInjector for the given tenant column
+func NewPredicateInjector(column string) *PredicateInjector {
+	return &PredicateInjector{
+		Column: column,
+	}
+}
+
+// rewrittenQuery is a query given tenant placeholders. Prepared statements keep it as their query so the tenant's
+// value can be added to their parameters each time they are used.
+type rewrittenQuery struct {
+	vparam.Queryer
+	// original is the SQL of the query before it was rewritten, which the caller's parameters are for
+	original string
+	// tenantParams are the indexes of the tenant's placeholders in the rewritten query, in increasing order
+	tenantParams []int
+}
+
+// Install prepends the injector to every chain of e that runs SQL
+func (p *PredicateInjector) Install(e vsql_engine.SQLQueryer) {
+	e.QueryMW().Prepend(func(ctx context.Context, c engine_context.Queryer) {
+		p.restrict(ctx, c, false)
+	})
+	e.InsertQueryMW().Prepend(func(ctx context.Context, c engine_context.Inserter) {
+		p.restrict(ctx, c, false)
+	})
+	e.ExecQueryMW().Prepend(func(ctx context.Context, c engine_context.Execer) {
+		p.restrict(ctx, c, false)
+	})
+	e.StatementPrepareMW().Prepend(func(ctx context.Context, c engine_context.Preparer) {
+		p.restrict(ctx, c, true)
+	})
+	e.StatementQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementQueryer) {
+		p.bindTenant(ctx, c, c.Query())
+	})
+	e.StatementInsertQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementInsertQueryer) {
+		p.bindTenant(ctx, c, c.Query())
+	})
+	e.StatementExecQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		p.bindTenant(ctx, c, c.Query())
+	})
+}
+
+// restrict rewrites the call's query for the tenant. Queries being prepared have no values yet, so the tenant's
+// value is added when the statement is used instead.
+func (p *PredicateInjector) restrict(ctx context.Context, c engine_context.CommonQueryer, prepare bool) {
+	if _, ok := BypassFrom(ctx); ok {
+		c.Next(ctx)
+		return
+	}
+	tenant, ok := TenantFrom(ctx)
+	if !ok {
+		c.SetError(ErrNoTenant)
+		return
+	}
+	var sqlQuery string
+	var params []interface{}
+	if prepare {
+		sqlQuery = query_rewrite.PositionalSQL(c.Query())
+	} else {
+		var err error
+		sqlQuery, params, err = query_rewrite.Positional(c.Query())
+		if err != nil {
+			c.SetError(err)
+			return
+		}
+	}
+	edits, tenantParams, err := p.plan(sqlQuery)
+	if err == nil && !prepare && len(edits) != 0 && placeholders(sqlQuery) != len(params) {
+		err = &ErrUnsafeRewrite{Query: sqlQuery, Reason: "placeholders do not match the parameters"}
+	}
+	if err != nil {
+		c.SetError(err)
+		return
+	}
+	if len(edits) != 0 {
+		rewritten := query_rewrite.Splice(sqlQuery, edits...)
+		if prepare {
+			c.SetQuery(&rewrittenQuery{
+				Queryer:      query_rewrite.New(rewritten, nil),
+				original:     c.Query().SQLQueryUnInterpolated(),
+				tenantParams: tenantParams,
+			})
+		} else {
+			c.SetQuery(query_rewrite.New(rewritten, insertTenant(params, tenantParams, tenant)))
+		}
+	}
+	c.Next(ctx)
+}
+
+// statementContext is the part of the statement contexts bindTenant needs
+type statementContext interface {
+	engine_context.Er
+	SetParameterer(vparam.Parameterer)
+	Parameterer() vparam.Parameterer
+}
+
+// bindTenant adds the tenant's value to the parameters of a statement prepared with tenant placeholders
+func (p *PredicateInjector) bindTenant(ctx context.Context, c statementContext, query vparam.Queryer) {
+	rewritten, isRewritten := query.(*rewrittenQuery)
+	if _, ok := BypassFrom(ctx); ok && !isRewritten {
+		c.Next(ctx)
+		return
+	}
+	tenant, ok := TenantFrom(ctx)
+	if !ok {
+		c.SetError(ErrNoTenant)
+		return
+	}
+	if isRewritten {
+		params, err := query_rewrite.PositionalParameters(rewritten.original, c.Parameterer())
+		if err == nil && placeholders(query_rewrite.PositionalSQL(rewritten)) != len(params)+len(rewritten.tenantParams) {
+			err = &ErrUnsafeRewrite{Query: rewritten.SQLQueryUnInterpolated(), Reason: "placeholders do not match the parameters"}
+		}
+		if err != nil {
+			c.SetError(err)
+			return
+		}
+		c.SetParameterer(query_rewrite.Parameters(insertTenant(params, rewritten.tenantParams, tenant)))
+	}
+	c.Next(ctx)
+}
+
+// placeholders counts the placeholders in sqlQuery
+func placeholders(sqlQuery string) (n int) {
+	for _, t := range sql_lexer.Lex(sqlQuery) {
+		if t.Kind == sql_lexer.Placeholder {
+			n++
+		}
+	}
+	return n
+}
+
+// insertTenant returns params with the tenant inserted at each of the increasing indexes
+func insertTenant(params []interface{}, indexes []int, tenant string) []interface{} {
+	out := make([]interface{}, 0, len(params)+len(indexes))
+	next := 0
+	for _, index := range indexes {
+		for len(out) < index {
+			out = append(out, params[next])
+			next++
+		}
+		out = append(out, tenant)
+	}
+	return append(out, params[next:]...)
+}
+
+func (p *PredicateInjector) column() string {
+	if p.Column == "" {
+		return DefaultColumn
+	}
+	return p.Column
+}
+
+func (p *PredicateInjector) isShared(table string) bool {
+	for _, shared := range p.Shared {
+		if strings.EqualFold(shared, table) {
+			return true
+		}
+	}
+	return false
+}
+
+// plan works out the edits that restrict sqlQuery, which must use ? placeholders, to the tenant, and the indexes
+// the tenant's placeholders will have in the rewritten query
+func (p *PredicateInjector) plan(sqlQuery string) (edits []query_rewrite.Edit, tenantParams []int, err error) {
+	s := newStatement(sqlQuery, p)
+	unsafe := func(reason string) error {
+		return &ErrUnsafeRewrite{Query: sqlQuery, Reason: reason}
+	}
+	if len(s.refs) == 0 && !s.isTrivial() {
+		// the tables may be written in a way the fingerprint does not recognize, such as TABLE orders
+		return nil, nil, unsafe("no tables found")
+	}
+	if s.hasUnrecognizedTable() {
+		return nil, nil, unsafe("unrecognized table")
+	}
+	if len(s.tenantTables) == 0 {
+		return nil, nil, nil
+	}
+	if s.tokens[0].IsWord("with") {
+		return nil, nil, unsafe("common table expression")
+	}
+	for i, t := range s.tokens {
+		if s.depth[i] != 0 {
+			continue
+		}
+		if t.IsPunctuation(";") && i != len(s.tokens)-1 {
+			return nil, nil, unsafe("multiple statements")
+		}
+		if t.IsWord("union") || t.IsWord("intersect") || t.IsWord("except") {
+			return nil, nil, unsafe("compound query")
+		}
+	}
+	for _, ref := range s.tenantTables {
+		if s.depth[s.index(ref)] != 0 {
+			return nil, nil, unsafe("tenant table in a sub-query")
+		}
+	}
+	switch {
+	case s.tokens[0].IsWord("select"):
+		edits, err = s.planSelect()
+	case s.tokens[0].IsWord("update"):
+		edits, err = s.planUpdate()
+	case s.tokens[0].IsWord("delete"):
+		edits, err = s.planDelete()
+	case s.tokens[0].IsWord("insert"):
+		edits, err = s.planInsert()
+	default:
+		err = unsafe("unsupported statement")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return edits, s.tenantParamIndexes(edits), nil
+}
+
+// statement is a query being planned
+type statement struct {
+	sql    string
+	tokens []sql_lexer.Token
+	// depth is the parenthesis depth of each token. Parentheses are at the depth they enclose.
+	depth        []int
+	refs         []fingerprint.TableReference
+	tenantTables []fingerprint.TableReference
+	column       string
+}
+
+func newStatement(sqlQuery string, p *PredicateInjector) *statement {
+	s := &statement{
+		sql:    sqlQuery,
+		tokens: sql_lexer.Significant(sql_lexer.Lex(sqlQuery)),
+		refs:   fingerprint.TableReferences(sqlQuery),
+		column: p.column(),
+	}
+	s.depth = make([]int, len(s.tokens))
+	d := 0
+	for i, t := range s.tokens {
+		if t.IsPunctuation(")") {
+			d--
+		}
+		s.depth[i] = d
+		if t.IsPunctuation("(") {
+			d++
+		}
+	}
+	for _, ref := range s.refs {
+		if !p.isShared(ref.Name) {
+			s.tenantTables = append(s.tenantTables, ref)
+		}
+	}
+	return s
+}
+
+// isTrivial is true for statements that cannot read or write a table: SELECT without FROM, such as SELECT 1
+func (s *statement) isTrivial() bool {
+	if len(s.tokens) == 0 {
+		return true
+	}
+	if !s.tokens[0].IsWord("select") {
+		return false
+	}
+	for _, t := range s.tokens {
+		if t.IsWord("from") || t.IsWord("into") {
+			return false
+		}
+	}
+	return true
+}
+
+// hasUnrecognizedTable is true if a top-level FROM or JOIN is not followed by a table the fingerprint recognized or
+// a sub-query, such as a table named by a keyword
+func (s *statement) hasUnrecognizedTable() bool {
+	for i, t := range s.tokens {
+		if s.depth[i] != 0 || !(t.IsWord("from") || t.IsWord("join")) {
+			continue
+		}
+		j := i + 1
+		for j < len(s.tokens) && (s.tokens[j].IsWord("only") || s.tokens[j].IsWord("lateral")) {
+			j++
+		}
+		if j == len(s.tokens) || s.tokens[j].IsPunctuation("(") {
+			continue
+		}
+		recognized := false
+		for _, ref := range s.refs {
+			recognized = recognized || ref.Pos == s.tokens[j].Pos
+		}
+		if !recognized {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *statement) unsafe(reason string) error {
+	return &ErrUnsafeRewrite{Query: s.sql, Reason: reason}
+}
+
+// topLevelTables is the number of tables referenced outside of sub-queries
+func (s *statement) topLevelTables() (n int) {
+	for _, ref := range s.refs {
+		if s.depth[s.index(ref)] == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// index returns the index of the token at which ref starts
+func (s *statement) index(ref fingerprint.TableReference) int {
+	for i, t := range s.tokens {
+		if t.Pos == ref.Pos {
+			return i
+		}
+	}
+	return -1
+}
+
+// end returns the byte offset just past the token at i
+func (s *statement) end(i int) int {
+	return s.tokens[i].Pos + len(s.tokens[i].Text)
+}
+
+// next returns the index of the first top-level token after i that is one of words, or a semicolon. If there is none,
+// len(tokens) is returned.
+func (s *statement) next(i int, words ...string) int {
+	for i++; i < len(s.tokens); i++ {
+		if s.depth[i] != 0 {
+			continue
+		}
+		if s.tokens[i].IsPunctuation(";") {
+			return i
+		}
+		for _, w := range words {
+			if s.tokens[i].IsWord(w) {
+				return i
+			}
+		}
+	}
+	return i
+}
+
+// predicate is the condition restricting ref to the tenant
+func (s *statement) predicate(ref fingerprint.TableReference) string {
+	qualifier := ref.Alias
+	if qualifier == "" {
+		qualifier = s.sql[ref.Pos:ref.End]
+	}
+	return qualifier + "." + s.column + " = ?"
+}
+
+// whereEnd are the words that end a WHERE clause or, if there is no WHERE clause, the FROM clause
+var whereEnd = []string{"group", "having", "window", "order", "limit", "offset", "fetch", "for", "lock", "returning"}
+
+// joinStart are the words that start a join, and so end the ON clause of the previous one
+var joinStart = []string{"join", "inner", "left", "right", "full", "cross", "natural", "straight_join"}
+
+// restrictWhere adds predicates to the WHERE clause that follows token from, creating it if there is none
+func (s *statement) restrictWhere(from int, predicates []string) (edits []query_rewrite.Edit, err error) {
+	if len(predicates) == 0 {
+		return nil, nil
+	}
+	condition := strings.Join(predicates, " AND ")
+	where := s.next(from, append([]string{"where"}, whereEnd...)...)
+	if where < len(s.tokens) && s.tokens[where].IsWord("where") {
+		end := s.next(where, whereEnd...)
+		if end == where+1 {
+			return nil, s.unsafe("empty WHERE clause")
+		}
+		return []query_rewrite.Edit{
+			{Pos: s.tokens[where+1].Pos, End: s.tokens[where+1].Pos, Text: "("},
+			{Pos: s.end(end - 1), End: s.end(end - 1), Text: ") AND " + condition},
+		}, nil
+	}
+	return []query_rewrite.Edit{
+		{Pos: s.end(where - 1), End: s.end(where - 1), Text: " WHERE " + condition},
+	}, nil
+}
+
+func (s *statement) planSelect() (edits []query_rewrite.Edit, err error) {
+	if s.hasRightOrFullJoin() {
+		// the rows of the preserved side would be returned for every tenant if it were restricted in the ON clause,
+		// and restricting the null-supplying side in the WHERE clause would drop the rows it is outer joined to
+		return nil, s.unsafe("RIGHT or FULL JOIN")
+	}
+	from := s.next(0, "from")
+	where := make([]string, 0, len(s.tenantTables))
+	for _, ref := range s.tenantTables {
+		i := s.index(ref)
+		if !s.isLeftJoined(i) {
+			where = append(where, s.predicate(ref))
+			continue
+		}
+		// restricting the joined table in the WHERE clause would drop the rows it is outer joined to, so the
+		// restriction goes in its ON clause
+		on := i + 1
+		for on < len(s.tokens) && !s.tokens[on].IsWord("on") && !s.tokens[on].IsPunctuation(";") {
+			if s.depth[on] == 0 && (s.tokens[on].IsWord("using") || s.tokens[on].IsWord("where") || s.tokens[on].IsPunctuation(",")) {
+				break
+			}
+			on++
+		}
+		if on+1 >= len(s.tokens) || !s.tokens[on].IsWord("on") {
+			return nil, s.unsafe("outer join without ON")
+		}
+		end := s.next(on, append(append([]string{"where"}, joinStart...), whereEnd...)...)
+		edits = append(edits,
+			query_rewrite.Edit{Pos: s.tokens[on+1].Pos, End: s.tokens[on+1].Pos, Text: "("},
+			query_rewrite.Edit{Pos: s.end(end - 1), End: s.end(end - 1), Text: ") AND " + s.predicate(ref)},
+		)
+	}
+	whereEdits, err := s.restrictWhere(from, where)
+	return append(edits, whereEdits...), err
+}
+
+// hasRightOrFullJoin is true if the statement has a top-level RIGHT or FULL join
+func (s *statement) hasRightOrFullJoin() bool {
+	for i, t := range s.tokens {
+		if s.depth[i] != 0 || !(t.IsWord("right") || t.IsWord("full")) {
+			continue
+		}
+		j := i + 1
+		if j < len(s.tokens) && s.tokens[j].IsWord("outer") {
+			j++
+		}
+		if j < len(s.tokens) && s.tokens[j].IsWord("join") {
+			return true
+		}
+	}
+	return false
+}
+
+// isLeftJoined is true if the table starting at token i is the null-supplying side of a LEFT join
+func (s *statement) isLeftJoined(i int) bool {
+	j := i - 1
+	for j >= 0 && (s.tokens[j].IsWord("only") || s.tokens[j].IsWord("lateral")) {
+		j--
+	}
+	if j < 0 || !s.tokens[j].IsWord("join") {
+		return false
+	}
+	j--
+	for j >= 0 && (s.tokens[j].IsWord("outer") || s.tokens[j].IsWord("natural")) {
+		j--
+	}
+	return j >= 0 && s.tokens[j].IsWord("left")
+}
+
+// assignsColumn is true if a top-level assignment between tokens from and to sets the tenant column
+func (s *statement) assignsColumn(from, to int) bool {
+	for i := from; i < to && i+1 < len(s.tokens); i++ {
+		t := s.tokens[i]
+		if s.depth[i] == 0 && (t.Kind == sql_lexer.Word || t.Kind == sql_lexer.QuotedIdentifier) &&
+			strings.EqualFold(t.Unquoted(), s.column) && s.tokens[i+1].IsPunctuation("=") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *statement) planUpdate() ([]query_rewrite.Edit, error) {
+	set := s.next(0, "set")
+	if s.topLevelTables() != 1 || set == len(s.tokens) {
+		return nil, s.unsafe("multiple-table UPDATE")
+	}
+	if s.assignsColumn(set+1, s.next(set, append([]string{"where"}, whereEnd...)...)) {
+		return nil, s.unsafe("UPDATE of the tenant column")
+	}
+	return s.restrictWhere(set, []string{s.predicate(s.tenantTables[0])})
+}
+
+func (s *statement) planDelete() ([]query_rewrite.Edit, error) {
+	if len(s.tokens) < 2 || !s.tokens[1].IsWord("from") || s.topLevelTables() != 1 || s.next(0, "using") != len(s.tokens) {
+		return nil, s.unsafe("multiple-table DELETE")
+	}
+	return s.restrictWhere(1, []string{s.predicate(s.tenantTables[0])})
+}
+
+func (s *statement) planInsert() (edits []query_rewrite.Edit, err error) {
+	if len(s.refs) != 1 {
+		return nil, s.unsafe("INSERT from a query")
+	}
+	i := s.index(s.tenantTables[0])
+	for i < len(s.tokens) && !s.tokens[i].IsPunctuation("(") && !s.tokens[i].IsWord("values") &&
+		!s.tokens[i].IsWord("select") && !s.tokens[i].IsWord("set") {
+		i++
+	}
+	if i == len(s.tokens) || !s.tokens[i].IsPunctuation("(") {
+		return nil, s.unsafe("INSERT without a column list")
+	}
+	closing := i + 1
+	for closing < len(s.tokens) && s.depth[closing] != 0 {
+		closing++
+	}
+	if closing == len(s.tokens) {
+		return nil, s.unsafe("unbalanced parentheses")
+	}
+	for _, t := range s.tokens[i+1 : closing] {
+		if (t.Kind == sql_lexer.Word || t.Kind == sql_lexer.QuotedIdentifier) && strings.EqualFold(t.Unquoted(), s.column) {
+			return nil, s.unsafe("INSERT of the tenant column")
+		}
+	}
+	edits = append(edits, query_rewrite.Edit{Pos: s.tokens[closing].Pos, End: s.tokens[closing].Pos, Text: ", " + s.column})
+	i = closing + 1
+	if i == len(s.tokens) || !(s.tokens[i].IsWord("values") || s.tokens[i].IsWord("value")) {
+		return nil, s.unsafe("INSERT from a query")
+	}
+	// add the tenant to each row
+	for i++; i < len(s.tokens) && s.tokens[i].IsPunctuation("("); {
+		closing := i + 1
+		for closing < len(s.tokens) && s.depth[closing] != 0 {
+			closing++
+		}
+		if closing == len(s.tokens) {
+			return nil, s.unsafe("unbalanced parentheses")
+		}
+		edits = append(edits, query_rewrite.Edit{Pos: s.tokens[closing].Pos, End: s.tokens[closing].Pos, Text: ", ?"})
+		i = closing + 1
+		if i < len(s.tokens) && s.tokens[i].IsPunctuation(",") {
+			i++
+		}
+	}
+	// ON DUPLICATE KEY UPDATE and ON CONFLICT DO UPDATE must not move the row to another tenant
+	if s.assignsColumn(i, len(s.tokens)) {
+		return nil, s.unsafe("UPDATE of the tenant column")
+	}
+	return edits, nil
+}
+
+// tenantParamIndexes returns the indexes the ? placeholders inserted by edits will have in the rewritten query
+func (s *statement) tenantParamIndexes(edits []query_rewrite.Edit) (indexes []int) {
+	// the rewritten query is built from the edits in order of position
+	positions := make([]int, 0, len(edits))
+	for _, e := range edits {
+		for n := strings.Count(e.Text, "?"); n > 0; n-- {
+			positions = append(positions, e.Pos)
+		}
+	}
+	sort.Ints(positions)
+	for inserted, pos := range positions {
+		before := 0
+		for _, t := range s.tokens {
+			if t.Kind == sql_lexer.Placeholder && t.Pos < pos {
+				before++
+			}
+		}
+		indexes = append(indexes, before+inserted)
+	}
+	return indexes
+}
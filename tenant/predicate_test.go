@@ -0,0 +1,141 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package tenant
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vresult"
+	"github.com/wojnosystems/vsql/vstmt"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/query_rewrite"
+	"testing"
+)
+
+func TestPredicateInjector_Rewrites(t *testing.T) {
+	p := &PredicateInjector{Shared: []string{"plans"}}
+	cases := map[string]string{
+		"SELECT * FROM orders": "SELECT * FROM orders WHERE orders.tenant_id = ?",
+		"SELECT * FROM orders o WHERE o.id = ? OR o.id = ? ORDER BY o.id LIMIT 5":                                  "SELECT * FROM orders o WHERE (o.id = ? OR o.id = ?) AND o.tenant_id = ? ORDER BY o.id LIMIT 5",
+		"SELECT * FROM orders o JOIN items i ON i.order_id = o.id JOIN plans p ON p.id = o.plan_id WHERE o.id = ?": "SELECT * FROM orders o JOIN items i ON i.order_id = o.id JOIN plans p ON p.id = o.plan_id WHERE (o.id = ?) AND o.tenant_id = ? AND i.tenant_id = ?",
+		"SELECT * FROM orders o LEFT JOIN items AS i ON i.order_id = o.id OR i.x = 1 WHERE o.id = ?":               "SELECT * FROM orders o LEFT JOIN items AS i ON (i.order_id = o.id OR i.x = 1) AND i.tenant_id = ? WHERE (o.id = ?) AND o.tenant_id = ?",
+		"SELECT * FROM `orders` FOR UPDATE":                           "SELECT * FROM `orders` WHERE `orders`.tenant_id = ? FOR UPDATE",
+		"SELECT * FROM plans WHERE id IN (SELECT plan_id FROM plans)": "SELECT * FROM plans WHERE id IN (SELECT plan_id FROM plans)",
+		"UPDATE orders SET total = ? WHERE id = ?":                    "UPDATE orders SET total = ? WHERE (id = ?) AND orders.tenant_id = ?",
+		"DELETE FROM orders":                                          "DELETE FROM orders WHERE orders.tenant_id = ?",
+		"INSERT INTO orders (id, total) VALUES (?, ?), (?, ?)":        "INSERT INTO orders (id, total, tenant_id) VALUES (?, ?, ?), (?, ?, ?)",
+		"SELECT 1": "SELECT 1",
+	}
+	for sqlQuery, expected := range cases {
+		edits, _, err := p.plan(sqlQuery)
+		if assert.NoError(t, err, sqlQuery) {
+			assert.Equal(t, expected, query_rewrite.Splice(sqlQuery, edits...))
+		}
+	}
+}
+
+func TestPredicateInjector_FailsClosed(t *testing.T) {
+	p := NewPredicateInjector("")
+	for _, sqlQuery := range []string{
+		"SELECT * FROM plans WHERE id IN (SELECT plan_id FROM orders)",
+		"WITH o AS (SELECT * FROM orders) SELECT * FROM o",
+		"SELECT id FROM orders UNION SELECT id FROM items",
+		"SELECT * FROM orders; DELETE FROM orders",
+		"UPDATE orders SET tenant_id = ?",
+		"UPDATE orders o JOIN items i ON i.order_id = o.id SET o.total = 1",
+		"DELETE FROM orders USING orders, items",
+		"INSERT INTO orders VALUES (?, ?)",
+		"INSERT INTO orders (id, tenant_id) VALUES (?, ?)",
+		"INSERT INTO orders (id) SELECT id FROM items",
+		"INSERT INTO orders (id) VALUES (?) ON DUPLICATE KEY UPDATE tenant_id = ?",
+		"SELECT * FROM orders o LEFT JOIN items i USING (order_id)",
+		"TRUNCATE orders",
+		"SELECT * FROM plans p RIGHT JOIN orders o ON o.plan_id = p.id",
+		"SELECT * FROM orders o RIGHT OUTER JOIN plans p ON o.plan_id = p.id",
+		"SELECT * FROM plans p FULL JOIN orders o ON o.plan_id = p.id",
+		"SELECT * FROM orders o FULL OUTER JOIN items i ON i.order_id = o.id",
+		"TABLE orders",
+		"SELECT * FROM key",
+		"SELECT * FROM orders o JOIN key k ON k.order_id = o.id",
+		"SHOW TABLES",
+	} {
+		_, _, err := p.plan(sqlQuery)
+		assert.IsType(t, &ErrUnsafeRewrite{}, err, sqlQuery)
+	}
+}
+
+func TestPredicateInjector_Parameters(t *testing.T) {
+	e, r := newRecordingEngine()
+	NewPredicateInjector("").Install(e)
+	ctx := WithTenant(context.Background(), "acme")
+	q := vparam.NewNamedWithData("SELECT * FROM orders o LEFT JOIN items i ON i.order_id = o.id WHERE o.id = :id",
+		map[string]interface{}{"id": 7})
+	_, err := e.Query(ctx, q)
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM orders o LEFT JOIN items i ON (i.order_id = o.id) AND i.tenant_id = ? WHERE (o.id = ?) AND o.tenant_id = ?", r.queries[0])
+	assert.Equal(t, []interface{}{"acme", 7, "acme"}, r.params[0])
+}
+
+func TestPredicateInjector_RefusesWithoutTenant(t *testing.T) {
+	e, r := newRecordingEngine()
+	NewPredicateInjector("").Install(e)
+	_, err := e.Exec(context.Background(), vparam.New("DELETE FROM orders"))
+	assert.Equal(t, ErrNoTenant, err)
+	_, err = e.Exec(WithTenant(context.Background(), "acme"), vparam.New("DELETE FROM orders WHERE id IN (SELECT order_id FROM items)"))
+	assert.IsType(t, &ErrUnsafeRewrite{}, err)
+	assert.Empty(t, r.queries)
+}
+
+func TestPredicateInjector_Bypass(t *testing.T) {
+	e, r := newRecordingEngine()
+	NewPredicateInjector("").Install(e)
+	_, err := e.Exec(WithBypass(context.Background(), "purge job"), vparam.New("DELETE FROM orders WHERE id IN (SELECT order_id FROM items)"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"DELETE FROM orders WHERE id IN (SELECT order_id FROM items)"}, r.queries)
+	assert.Panics(t, func() {
+		WithBypass(context.Background(), "")
+	})
+}
+
+func TestPredicateInjector_Statements(t *testing.T) {
+	e := vsql_engine.NewSingle()
+	var prepared string
+	var executed []interface{}
+	e.StatementPrepareMW().Append(func(ctx context.Context, c engine_context.Preparer) {
+		prepared = query_rewrite.PositionalSQL(c.Query())
+		c.SetStatement(&vstmt.StatementerMock{})
+		c.Next(ctx)
+	})
+	e.StatementExecQueryMW().Append(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		executed, _ = query_rewrite.PositionalParameters(prepared, c.Parameterer())
+		c.SetResult(&vresult.ResulterMock{})
+		c.Next(ctx)
+	})
+	NewPredicateInjector("").Install(e)
+
+	stmt, err := e.Prepare(WithTenant(context.Background(), "acme"), vparam.NewNamed("UPDATE orders SET total = :total WHERE id = :id"))
+	assert.NoError(t, err)
+	assert.Equal(t, "UPDATE orders SET total = ? WHERE (id = ?) AND orders.tenant_id = ?", prepared)
+
+	_, err = stmt.Exec(WithTenant(context.Background(), "initech"), vparam.NewNamedData(map[string]interface{}{"total": 10, "id": 3}))
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{10, 3, "initech"}, executed)
+
+	_, err = stmt.Exec(context.Background(), vparam.NewNamedData(map[string]interface{}{"total": 10, "id": 3}))
+	assert.Equal(t, ErrNoTenant, err)
+}
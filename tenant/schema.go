@@ -0,0 +1,319 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"github.com/wojnosystems/vsql"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/engine_ware"
+	"github.com/wojnosystems/vsql_engine/fingerprint"
+	"github.com/wojnosystems/vsql_engine/query_rewrite"
+	"regexp"
+	"strings"
+)
+
+// Mode is how SchemaRouter selects the tenant's schema
+type Mode uint8
+
+const (
+	// QualifyTables prefixes every unqualified table name in the SQL with the tenant's schema: "FROM orders" becomes
+	// "FROM tenant_a.orders". This works inside and outside of transactions.
+	QualifyTables Mode = iota
+	// SessionSetup runs a statement that selects the tenant's schema, such as SET LOCAL search_path, at the start of
+	// each transaction. Calls outside of a transaction are refused with ErrNoTransaction as they may run on any
+	// pooled connection.
+	SessionSetup
+)
+
+// ErrNoTransaction is returned in SessionSetup mode for calls made outside of a transaction
+type ErrNoTransaction struct {
+	Tenant string
+}
+
+func (e ErrNoTransaction) Error() string {
+	return fmt.Sprintf(`tenant: calls for tenant "%s" must be made in a transaction to select its schema`, e.Tenant)
+}
+
+// ErrInvalidSchema is returned when a tenant's schema name is not a plain identifier. Schema names are written into
+// the SQL, so only letters, digits and underscores are allowed.
+type ErrInvalidSchema struct {
+	Tenant string
+	Schema string
+}
+
+func (e ErrInvalidSchema) Error() string {
+	return fmt.Sprintf(`tenant: schema "%s" of tenant "%s" is not a valid identifier`, e.Schema, e.Tenant)
+}
+
+// ErrTenantMismatch is returned for calls in a transaction that are made for a different tenant than the one that
+// began it
+type ErrTenantMismatch struct {
+	// Transaction is the tenant that began the transaction
+	Transaction string
+	// Requested is the tenant in the call's context
+	Requested string
+}
+
+func (e ErrTenantMismatch) Error() string {
+	return fmt.Sprintf(`tenant: the transaction is for tenant "%s" and cannot be used by tenant "%s"`, e.Transaction, e.Requested)
+}
+
+var validSchema = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+
+// SchemaRouter points every call at the schema of the tenant in its context, for the schema-per-tenant model. Calls
+// made with a context without a tenant are refused with ErrNoTenant.
+type SchemaRouter struct {
+	// Mode is how the schema is selected
+	Mode Mode
+	// Schema returns the schema of a tenant. If nil, the tenant's ID is its schema.
+	Schema func(tenant string) string
+	// Setup returns the statement run at the start of each transaction in SessionSetup mode. If nil,
+	// SET LOCAL search_path TO <schema> is used.
+	Setup func(schema string) vparam.Queryer
+	// Shared are the names of tables that are shared by all tenants and are not qualified in QualifyTables mode
+	Shared []string
+
+	// transactions maps the transactions begun by the driver to the tenant they were begun for
+	transactions engine_context.Tracker[string]
+}
+
+// NewSchemaRouter creates a SchemaRouter for the given mode
+func NewSchemaRouter(mode Mode) *SchemaRouter {
+	return &SchemaRouter{
+		Mode: mode,
+	}
+}
+
+// Install prepends the router to every chain of e that runs SQL. Begin is covered for both SingleTXer and MultiTXer
+// engines.
+func (r *SchemaRouter) Install(e vsql_engine.SQLQueryer) {
+	e.QueryMW().Prepend(func(ctx context.Context, c engine_context.Queryer) {
+		r.route(ctx, c, false)
+	})
+	e.InsertQueryMW().Prepend(func(ctx context.Context, c engine_context.Inserter) {
+		r.route(ctx, c, false)
+	})
+	e.ExecQueryMW().Prepend(func(ctx context.Context, c engine_context.Execer) {
+		r.route(ctx, c, false)
+	})
+	e.StatementPrepareMW().Prepend(func(ctx context.Context, c engine_context.Preparer) {
+		r.route(ctx, c, true)
+	})
+	// statements were routed when they were prepared, but must still be used on behalf of a tenant
+	e.StatementQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementQueryer) {
+		rebind(ctx, c, c.Query())
+	})
+	e.StatementInsertQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementInsertQueryer) {
+		rebind(ctx, c, c.Query())
+	})
+	e.StatementExecQueryMW().Prepend(func(ctx context.Context, c engine_context.StatementExecQueryer) {
+		rebind(ctx, c, c.Query())
+	})
+	e.CommitMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+		r.forget(c.QueryExecTransactioner())
+		c.Next(ctx)
+	})
+	e.RollbackMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+		r.forget(c.QueryExecTransactioner())
+		c.Next(ctx)
+	})
+	if b, ok := e.(engine_ware.BeginWare); ok {
+		b.BeginMW().Prepend(func(ctx context.Context, c engine_context.Beginner) {
+			tenant, ok := TenantFrom(ctx)
+			if !ok {
+				c.SetError(ErrNoTenant)
+				return
+			}
+			c.Next(ctx)
+			if c.Error() == nil && c.QueryExecTransactioner() != nil {
+				r.begun(ctx, c, c.QueryExecTransactioner(), tenant, true)
+			}
+		})
+	}
+	if b, ok := e.(engine_ware.BeginNestedWare); ok {
+		b.BeginNestedMW().Prepend(func(ctx context.Context, c engine_context.NestedBeginner) {
+			tenant, ok := TenantFrom(ctx)
+			if !ok {
+				c.SetError(ErrNoTenant)
+				return
+			}
+			parent := c.QueryExecNestedTransactioner()
+			if parent != nil {
+				if err := r.checkTransaction(parent, tenant); err != nil {
+					c.SetError(err)
+					return
+				}
+			}
+			c.Next(ctx)
+			if c.Error() == nil && c.QueryExecNestedTransactioner() != nil {
+				// nested transactions share the session of the outermost transaction, which is already set up
+				r.begun(ctx, c, c.QueryExecNestedTransactioner(), tenant, parent == nil)
+			}
+		})
+	}
+}
+
+// route points the call's SQL at the tenant's schema. prepare is set for the statements being prepared.
+func (r *SchemaRouter) route(ctx context.Context, c engine_context.CommonQueryer, prepare bool) {
+	tenant, ok := TenantFrom(ctx)
+	if !ok {
+		c.SetError(ErrNoTenant)
+		return
+	}
+	tx := c.QueryExecTransactioner()
+	if tx != nil {
+		if err := r.checkTransaction(tx, tenant); err != nil {
+			c.SetError(err)
+			return
+		}
+	}
+	switch r.Mode {
+	case SessionSetup:
+		if tx == nil {
+			c.SetError(&ErrNoTransaction{Tenant: tenant})
+			return
+		}
+	case QualifyTables:
+		schema, err := r.schemaOf(tenant)
+		if err != nil {
+			c.SetError(err)
+			return
+		}
+		query, err := r.qualify(c.Query(), schema, prepare)
+		if err != nil {
+			c.SetError(err)
+			return
+		}
+		c.SetQuery(query)
+	}
+	c.Next(ctx)
+}
+
+// qualifiedQuery is a query prepared with its tables qualified. Its SQL is positional, so the parameters of the
+// statement are made positional for it when the statement is used, see rebind.
+type qualifiedQuery struct {
+	vparam.Queryer
+	// original is the SQL of the query before it was qualified, which the caller's parameters are for
+	original string
+}
+
+// qualify prefixes the unqualified, non-shared tables of query with schema. Queries being prepared have no values,
+// so only their SQL is made positional.
+func (r *SchemaRouter) qualify(query vparam.Queryer, schema string, prepare bool) (vparam.Queryer, error) {
+	var sqlQuery string
+	var params []interface{}
+	if prepare {
+		sqlQuery = query_rewrite.PositionalSQL(query)
+	} else {
+		var err error
+		if sqlQuery, params, err = query_rewrite.Positional(query); err != nil {
+			return nil, err
+		}
+	}
+	edits := make([]query_rewrite.Edit, 0, 2)
+	for _, ref := range fingerprint.TableReferences(sqlQuery) {
+		if ref.Qualified || r.isShared(ref.Name) {
+			continue
+		}
+		edits = append(edits, query_rewrite.Edit{Pos: ref.Pos, End: ref.Pos, Text: schema + "."})
+	}
+	if len(edits) == 0 {
+		return query, nil
+	}
+	qualified := query_rewrite.New(query_rewrite.Splice(sqlQuery, edits...), params)
+	if prepare {
+		return &qualifiedQuery{Queryer: qualified, original: query.SQLQueryUnInterpolated()}, nil
+	}
+	return qualified, nil
+}
+
+func (r *SchemaRouter) isShared(table string) bool {
+	for _, shared := range r.Shared {
+		if strings.EqualFold(shared, table) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *SchemaRouter) schemaOf(tenant string) (string, error) {
+	schema := tenant
+	if r.Schema != nil {
+		schema = r.Schema(tenant)
+	}
+	if !validSchema.MatchString(schema) {
+		return "", &ErrInvalidSchema{Tenant: tenant, Schema: schema}
+	}
+	return schema, nil
+}
+
+// begun records the tenant of a new transaction and, if setup is set in SessionSetup mode, selects the tenant's
+// schema in it. If the schema cannot be selected, the transaction is rolled back.
+func (r *SchemaRouter) begun(ctx context.Context, c engine_context.Er, tx vsql.QueryExecTransactioner, tenant string, setup bool) {
+	if setup && r.Mode == SessionSetup {
+		schema, err := r.schemaOf(tenant)
+		if err == nil {
+			_, err = tx.Exec(ctx, r.setupQuery(schema))
+		}
+		if err != nil {
+			_ = tx.Rollback()
+			c.SetError(err)
+			return
+		}
+	}
+	r.transactions.Track(tx, tenant)
+}
+
+func (r *SchemaRouter) setupQuery(schema string) vparam.Queryer {
+	if r.Setup != nil {
+		return r.Setup(schema)
+	}
+	return vparam.New("SET LOCAL search_path TO " + schema)
+}
+
+// checkTransaction refuses to use a transaction begun for a different tenant
+func (r *SchemaRouter) checkTransaction(tx vsql.QueryExecTransactioner, tenant string) error {
+	if began, ok := r.transactions.Lookup(tx); ok && began != tenant {
+		return &ErrTenantMismatch{Transaction: began, Requested: tenant}
+	}
+	return nil
+}
+
+func (r *SchemaRouter) forget(tx vsql.QueryExecTransactioner) {
+	r.transactions.Forget(tx)
+}
+
+// rebind refuses statement calls made without a tenant and makes the parameters of statements prepared with qualified
+// tables positional
+func rebind(ctx context.Context, c statementContext, query vparam.Queryer) {
+	if _, ok := TenantFrom(ctx); !ok {
+		c.SetError(ErrNoTenant)
+		return
+	}
+	if qualified, ok := query.(*qualifiedQuery); ok {
+		params, err := query_rewrite.PositionalParameters(qualified.original, c.Parameterer())
+		if err != nil {
+			c.SetError(err)
+			return
+		}
+		c.SetParameterer(query_rewrite.Parameters(params))
+	}
+	c.Next(ctx)
+}
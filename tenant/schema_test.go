@@ -0,0 +1,159 @@
+//Copyright 2019 Chris Wojno
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy of this software and associated
+// documentation files (the "Software"), to deal in the Software without restriction, including without limitation
+// the rights to use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all copies or substantial portions of the
+// Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE
+// WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS
+// OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR
+// OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package tenant
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/wojnosystems/vsql"
+	"github.com/wojnosystems/vsql/vparam"
+	"github.com/wojnosystems/vsql/vresult"
+	"github.com/wojnosystems/vsql/vrows"
+	"github.com/wojnosystems/vsql_engine"
+	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/internal/testdriver"
+	"github.com/wojnosystems/vsql_engine/query_rewrite"
+	"testing"
+)
+
+// recorder is a fake driver that records the SQL and parameters it receives
+type recorder struct {
+	queries []string
+	params  [][]interface{}
+	tx      *vsql.QueryExecTransactionerMock
+}
+
+func (r *recorder) record(q vparam.Queryer) {
+	sqlQuery, params, _ := query_rewrite.Positional(q)
+	r.queries = append(r.queries, sqlQuery)
+	r.params = append(r.params, params)
+}
+
+func newRecordingEngine() (vsql_engine.SingleTXer, *recorder) {
+	r := &recorder{tx: &vsql.QueryExecTransactionerMock{}}
+	r.tx.On("Exec", mock.Anything, mock.Anything).Return(&vresult.ResulterMock{}, nil).Run(func(args mock.Arguments) {
+		r.record(args.Get(1).(vparam.Queryer))
+	})
+	r.tx.On("Commit").Return(nil)
+	r.tx.On("Rollback").Return(nil)
+	e := vsql_engine.NewSingle()
+	e.QueryMW().Append(func(ctx context.Context, c engine_context.Queryer) {
+		r.record(c.Query())
+		c.SetRows(&vrows.RowserMock{})
+		c.Next(ctx)
+	})
+	e.ExecQueryMW().Append(func(ctx context.Context, c engine_context.Execer) {
+		r.record(c.Query())
+		c.SetResult(&vresult.ResulterMock{})
+		c.Next(ctx)
+	})
+	e.BeginMW().Append(func(ctx context.Context, c engine_context.Beginner) {
+		c.SetQueryExecTransactioner(r.tx)
+		c.Next(ctx)
+	})
+	e.CommitMW().Append(func(ctx context.Context, c engine_context.Beginner) {
+		c.SetError(c.QueryExecTransactioner().Commit())
+		c.Next(ctx)
+	})
+	return e, r
+}
+
+func TestSchemaRouter_QualifyTables(t *testing.T) {
+	e, r := newRecordingEngine()
+	router := NewSchemaRouter(QualifyTables)
+	router.Shared = []string{"plans"}
+	router.Install(e)
+
+	q := vparam.NewNamedWithData("SELECT o.id FROM orders o JOIN plans p ON p.id = o.plan_id JOIN public.users u ON u.id = o.user_id WHERE o.id = :id",
+		map[string]interface{}{"id": 7})
+	_, err := e.Query(WithTenant(context.Background(), "acme"), q)
+	assert.NoError(t, err)
+	_, err = e.Query(WithTenant(context.Background(), "initech"), q)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"SELECT o.id FROM acme.orders o JOIN plans p ON p.id = o.plan_id JOIN public.users u ON u.id = o.user_id WHERE o.id = ?",
+		"SELECT o.id FROM initech.orders o JOIN plans p ON p.id = o.plan_id JOIN public.users u ON u.id = o.user_id WHERE o.id = ?",
+	}, r.queries)
+	assert.Equal(t, []interface{}{7}, r.params[0])
+}
+
+func TestSchemaRouter_Prepare(t *testing.T) {
+	e := vsql_engine.NewSingle()
+	d := testdriver.Install(e)
+	router := NewSchemaRouter(QualifyTables)
+	router.Shared = []string{"PLANS"}
+	router.Install(e)
+	acme := WithTenant(context.Background(), "acme")
+
+	positional, err := e.Prepare(acme, vparam.New("SELECT * FROM orders WHERE id = ?"))
+	assert.NoError(t, err)
+	_, err = positional.Query(acme, query_rewrite.Parameters([]interface{}{7}))
+	assert.NoError(t, err)
+	named, err := e.Prepare(acme, vparam.NewNamed("SELECT * FROM orders JOIN plans ON plans.id = orders.plan_id WHERE orders.id = :id"))
+	assert.NoError(t, err)
+	_, err = named.Query(acme, vparam.NewNamedData(map[string]interface{}{"id": 8}))
+	assert.NoError(t, err)
+	_, err = named.Query(context.Background(), vparam.NewNamedData(map[string]interface{}{"id": 8}))
+	assert.Equal(t, ErrNoTenant, err)
+
+	prepared := d.Calls(testdriver.Prepare)
+	if assert.Len(t, prepared, 2) {
+		assert.Equal(t, "SELECT * FROM acme.orders WHERE id = ?", prepared[0].SQL)
+		assert.Equal(t, "SELECT * FROM acme.orders JOIN plans ON plans.id = orders.plan_id WHERE orders.id = ?", prepared[1].SQL)
+	}
+	queried := d.Calls(testdriver.StatementQuery)
+	if assert.Len(t, queried, 2) {
+		assert.Equal(t, []interface{}{7}, queried[0].Params)
+		assert.Equal(t, []interface{}{8}, queried[1].Params)
+	}
+}
+
+func TestSchemaRouter_RefusesWithoutTenant(t *testing.T) {
+	e, r := newRecordingEngine()
+	NewSchemaRouter(QualifyTables).Install(e)
+	_, err := e.Query(context.Background(), vparam.New("SELECT * FROM orders"))
+	assert.Equal(t, ErrNoTenant, err)
+	_, err = e.Begin(context.Background(), nil)
+	assert.Equal(t, ErrNoTenant, err)
+	assert.Empty(t, r.queries)
+}
+
+func TestSchemaRouter_InvalidSchema(t *testing.T) {
+	e, r := newRecordingEngine()
+	NewSchemaRouter(QualifyTables).Install(e)
+	_, err := e.Exec(WithTenant(context.Background(), "acme; DROP TABLE orders"), vparam.New("DELETE FROM orders"))
+	assert.IsType(t, &ErrInvalidSchema{}, err)
+	assert.Empty(t, r.queries)
+}
+
+func TestSchemaRouter_SessionSetup(t *testing.T) {
+	e, r := newRecordingEngine()
+	NewSchemaRouter(SessionSetup).Install(e)
+	acme := WithTenant(context.Background(), "acme")
+
+	_, err := e.Exec(acme, vparam.New("DELETE FROM orders"))
+	assert.Equal(t, &ErrNoTransaction{Tenant: "acme"}, err)
+
+	tx, err := e.Begin(acme, nil)
+	assert.NoError(t, err)
+	_, err = tx.Exec(acme, vparam.New("DELETE FROM orders"))
+	assert.NoError(t, err)
+	_, err = tx.Exec(WithTenant(context.Background(), "initech"), vparam.New("DELETE FROM orders"))
+	assert.Equal(t, &ErrTenantMismatch{Transaction: "acme", Requested: "initech"}, err)
+	assert.NoError(t, tx.Commit())
+	assert.Equal(t, []string{"SET LOCAL search_path TO acme", "DELETE FROM orders"}, r.queries)
+}
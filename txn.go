@@ -22,18 +22,25 @@ import (
 	"github.com/wojnosystems/vsql/vrows"
 	"github.com/wojnosystems/vsql/vstmt"
 	"github.com/wojnosystems/vsql_engine/engine_context"
+	"github.com/wojnosystems/vsql_engine/engine_ware"
 )
 
 type nonNestedTx struct {
+	// ctx is the context the transaction was started with. Commit and Rollback do not take a context, so their
+	// middleware receive this one.
+	ctx                context.Context
 	beginnerContext    engine_context.Beginner
 	queryEngineFactory *engineQuery
+	// middlewareContext is the context the calls made with the transaction copy from. It holds the depth of the transaction.
+	middlewareContext engine_context.WithMiddlewarer
 }
 
 // Commit see github.com/wojnosystems/vsql/transactions.go#Transactioner
 func (m *nonNestedTx) Commit() error {
 	c := engine_context.NewBeginner()
 	c.SetQueryExecTransactioner(m.beginnerContext.QueryExecTransactioner())
-	m.queryEngineFactory.commitMW.PerformMiddleware(nil, c)
+	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
+	m.queryEngineFactory.commitMW.PerformMiddleware(m.ctx, c)
 	return c.Error()
 }
 
@@ -41,54 +48,70 @@ func (m *nonNestedTx) Commit() error {
 func (m *nonNestedTx) Rollback() error {
 	c := engine_context.NewBeginner()
 	c.SetQueryExecTransactioner(m.beginnerContext.QueryExecTransactioner())
-	m.queryEngineFactory.rollbackMW.PerformMiddleware(nil, c)
+	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
+	m.queryEngineFactory.rollbackMW.PerformMiddleware(m.ctx, c)
 	return c.Error()
 }
 
 // Query nonNestedTx github.com/wojnosystems/vsql/strategy.go#QueryExecer
 func (m *nonNestedTx) Query(ctx context.Context, query vparam.Queryer) (rRows vrows.Rowser, err error) {
-	c := engine_context.NewQuery()
+	ctx = engine_ware.Inherit(ctx, m.ctx)
+	c := engine_context.AcquireQuery()
 	c.SetQueryExecTransactioner(m.beginnerContext.QueryExecTransactioner())
-	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.queryEngineFactory.middlewareContext)
+	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
 	c.SetQuery(query)
 	m.queryEngineFactory.queryMW.PerformMiddleware(ctx, c)
 	r := &rows{
+		ctx:                ctx,
 		rows:               c.Rows(),
 		queryEngineFactory: m.queryEngineFactory,
+		middlewareContext:  m.middlewareContext,
 	}
-	return r, c.Error()
+	err = c.Error()
+	engine_context.Release(c)
+	return r, err
 }
 
 // Insert see github.com/wojnosystems/vsql/strategy.go#QueryExecer
 func (m *nonNestedTx) Insert(ctx context.Context, query vparam.Queryer) (res vresult.InsertResulter, err error) {
-	c := engine_context.NewInsertQuery()
+	ctx = engine_ware.Inherit(ctx, m.ctx)
+	c := engine_context.AcquireInsertQuery()
 	c.SetQueryExecTransactioner(m.beginnerContext.QueryExecTransactioner())
-	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.queryEngineFactory.middlewareContext)
+	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
 	c.SetQuery(query)
 	m.queryEngineFactory.insertQueryMW.PerformMiddleware(ctx, c)
-	return c.InsertResult(), c.Error()
+	res, err = c.InsertResult(), c.Error()
+	engine_context.Release(c)
+	return
 }
 
 // Exec see github.com/wojnosystems/vsql/strategy.go#QueryExecer
 func (m *nonNestedTx) Exec(ctx context.Context, query vparam.Queryer) (res vresult.Resulter, err error) {
-	c := engine_context.NewExecQuery()
+	ctx = engine_ware.Inherit(ctx, m.ctx)
+	c := engine_context.AcquireExecQuery()
 	c.SetQueryExecTransactioner(m.beginnerContext.QueryExecTransactioner())
-	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.queryEngineFactory.middlewareContext)
+	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
 	c.SetQuery(query)
 	m.queryEngineFactory.execQueryMW.PerformMiddleware(ctx, c)
-	return c.Result(), c.Error()
+	res, err = c.Result(), c.Error()
+	engine_context.Release(c)
+	return
 }
 
 // Prepare see github.com/wojnosystems/vsql/vstmt/statements.go#Preparer
 func (m *nonNestedTx) Prepare(ctx context.Context, query vparam.Queryer) (stmtr vstmt.Statementer, err error) {
+	ctx = engine_ware.Inherit(ctx, m.ctx)
 	c := engine_context.NewPreparer()
 	c.SetQueryExecTransactioner(m.beginnerContext.QueryExecTransactioner())
-	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.queryEngineFactory.middlewareContext)
+	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
 	c.SetQuery(query)
 	m.queryEngineFactory.statementPrepareMW.PerformMiddleware(ctx, c)
 	s := &statement{
 		stmt:               c.Statement(),
+		query:              c.Query(),
 		queryEngineFactory: m.queryEngineFactory,
+		txCtx:              m.ctx,
+		middlewareContext:  m.middlewareContext,
 	}
 	return s, c.Error()
 }
@@ -29,22 +29,36 @@ import (
 
 //vsql.QueryExecNestedTransactioner
 type nestedTx struct {
+	// ctx is the context the transaction was started with. Commit and Rollback do not take a context, so their
+	// middleware receive this one.
+	ctx                   context.Context
 	beginnerNestedContext engine_context.NestedBeginner
 	queryEngineFactory    *engineNest
 	beginNestedMW         *engine_ware.BeginNestedMW
+	// middlewareContext is the context the calls made with the transaction copy from. It holds the depth of the transaction.
+	middlewareContext engine_context.WithMiddlewarer
 }
 
 // Begin see github.com/wojnosystems/vsql/transactions.go#TransactionStarter
 func (m *nestedTx) Begin(ctx context.Context, txOp vtxn.TxOptioner) (n vsql.QueryExecNestedTransactioner, err error) {
+	ctx = engine_ware.Inherit(ctx, m.ctx)
 	c := engine_context.NewNestedBeginner()
-	c.SetQueryExecNestedTransactioner(m)
-	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.queryEngineFactory.middlewareContext)
+	// The middleware receive the parent's transaction, as created by the driver, so the driver can nest within it.
+	// The driver replaces it with the new, nested transaction.
+	c.SetQueryExecNestedTransactioner(m.beginnerNestedContext.QueryExecNestedTransactioner())
+	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
+	if txOp == nil {
+		txOp = &vtxn.TxOption{}
+	}
 	c.SetTxOptions(txOp)
 	m.beginNestedMW.PerformMiddleware(ctx, c)
 	s := &nestedTx{
+		ctx:                   ctx,
 		beginnerNestedContext: c,
 		queryEngineFactory:    m.queryEngineFactory,
 		beginNestedMW:         m.beginNestedMW,
+		middlewareContext:     engine_context.ForTransaction(m.middlewareContext, engine_context.TxDepth(m.middlewareContext)+1,
+			c.QueryExecNestedTransactioner()),
 	}
 	return s, c.Error()
 }
@@ -53,8 +67,8 @@ func (m *nestedTx) Begin(ctx context.Context, txOp vtxn.TxOptioner) (n vsql.Quer
 func (m *nestedTx) Commit() error {
 	c := engine_context.NewBeginner()
 	c.SetQueryExecTransactioner(m.beginnerNestedContext.QueryExecNestedTransactioner())
-	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.queryEngineFactory.middlewareContext)
-	m.queryEngineFactory.commitMW.PerformMiddleware(nil, c)
+	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
+	m.queryEngineFactory.commitMW.PerformMiddleware(m.ctx, c)
 	return c.Error()
 }
 
@@ -62,56 +76,70 @@ func (m *nestedTx) Commit() error {
 func (m *nestedTx) Rollback() error {
 	c := engine_context.NewBeginner()
 	c.SetQueryExecTransactioner(m.beginnerNestedContext.QueryExecNestedTransactioner())
-	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.queryEngineFactory.middlewareContext)
-	m.queryEngineFactory.rollbackMW.PerformMiddleware(nil, c)
+	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
+	m.queryEngineFactory.rollbackMW.PerformMiddleware(m.ctx, c)
 	return c.Error()
 }
 
 // Query nonNestedTx github.com/wojnosystems/vsql/strategy.go#QueryExecer
 func (m *nestedTx) Query(ctx context.Context, query vparam.Queryer) (rRows vrows.Rowser, err error) {
-	c := engine_context.NewQuery()
+	ctx = engine_ware.Inherit(ctx, m.ctx)
+	c := engine_context.AcquireQuery()
 	c.SetQueryExecTransactioner(m.beginnerNestedContext.QueryExecNestedTransactioner())
-	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.queryEngineFactory.middlewareContext)
+	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
 	c.SetQuery(query)
 	m.queryEngineFactory.queryMW.PerformMiddleware(ctx, c)
 	r := &rows{
+		ctx:                ctx,
 		rows:               c.Rows(),
 		queryEngineFactory: m.queryEngineFactory.engineQuery,
+		middlewareContext:  m.middlewareContext,
 	}
-	return r, c.Error()
+	err = c.Error()
+	engine_context.Release(c)
+	return r, err
 }
 
 // Insert see github.com/wojnosystems/vsql/strategy.go#QueryExecer
 func (m *nestedTx) Insert(ctx context.Context, query vparam.Queryer) (res vresult.InsertResulter, err error) {
-	c := engine_context.NewInsertQuery()
+	ctx = engine_ware.Inherit(ctx, m.ctx)
+	c := engine_context.AcquireInsertQuery()
 	c.SetQueryExecTransactioner(m.beginnerNestedContext.QueryExecNestedTransactioner())
-	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.queryEngineFactory.middlewareContext)
+	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
 	c.SetQuery(query)
 	m.queryEngineFactory.insertQueryMW.PerformMiddleware(ctx, c)
-	return c.InsertResult(), c.Error()
+	res, err = c.InsertResult(), c.Error()
+	engine_context.Release(c)
+	return
 }
 
 // Exec see github.com/wojnosystems/vsql/strategy.go#QueryExecer
 func (m *nestedTx) Exec(ctx context.Context, query vparam.Queryer) (res vresult.Resulter, err error) {
-	c := engine_context.NewExecQuery()
+	ctx = engine_ware.Inherit(ctx, m.ctx)
+	c := engine_context.AcquireExecQuery()
 	c.SetQueryExecTransactioner(m.beginnerNestedContext.QueryExecNestedTransactioner())
-	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.queryEngineFactory.middlewareContext)
+	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
 	c.SetQuery(query)
 	m.queryEngineFactory.execQueryMW.PerformMiddleware(ctx, c)
-	return c.Result(), c.Error()
+	res, err = c.Result(), c.Error()
+	engine_context.Release(c)
+	return
 }
 
 // Prepare see github.com/wojnosystems/vsql/vstmt/statements.go#Preparer
 func (m *nestedTx) Prepare(ctx context.Context, query vparam.Queryer) (stmtr vstmt.Statementer, err error) {
+	ctx = engine_ware.Inherit(ctx, m.ctx)
 	c := engine_context.NewPreparer()
 	c.SetQueryExecTransactioner(m.beginnerNestedContext.QueryExecNestedTransactioner())
-	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.queryEngineFactory.middlewareContext)
+	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
 	c.SetQuery(query)
 	m.queryEngineFactory.statementPrepareMW.PerformMiddleware(ctx, c)
 	s := &txStatement{
 		preparer:           c,
 		queryEngineFactory: m.queryEngineFactory,
 		beginNestedMW:      m.beginNestedMW,
+		txCtx:              m.ctx,
+		middlewareContext:  m.middlewareContext,
 	}
 	return s, c.Error()
 }
@@ -31,47 +31,68 @@ type txStatement struct {
 	preparer           engine_context.Preparer
 	queryEngineFactory *engineNest
 	beginNestedMW      *engine_ware.BeginNestedMW
+	// middlewareContext is the context the calls made with the statement copy from. It holds the depth of the transaction it was prepared in.
+	middlewareContext engine_context.WithMiddlewarer
+	// txCtx is the context of the transaction the statement was prepared in, if any. The handlers attached to it apply
+	// to the statement's calls.
+	txCtx context.Context
 }
 
 // Query see github.com/wojnosystems/vsql/vstmt/statements.go#Statementer
 func (m *txStatement) Query(ctx context.Context, parameterer vparam.Parameterer) (rRows vrows.Rowser, err error) {
-	c := engine_context.NewStatementQuery()
+	ctx = engine_ware.Inherit(ctx, m.txCtx)
+	c := engine_context.AcquireStatementQuery()
 	c.SetStatement(m.preparer.Statement())
+	c.SetQuery(m.preparer.Query())
 	c.SetParameterer(parameterer)
-	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.queryEngineFactory.middlewareContext)
+	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
 	m.queryEngineFactory.statementQueryMW.PerformMiddleware(ctx, c)
 	r := &rows{
+		ctx:                ctx,
 		rows:               c.Rows(),
 		queryEngineFactory: m.queryEngineFactory.engineQuery,
+		middlewareContext:  m.middlewareContext,
 	}
-	return r, c.Error()
+	err = c.Error()
+	engine_context.Release(c)
+	return r, err
 }
 
 // Insert see github.com/wojnosystems/vsql/vstmt/statements.go#Statementer
 func (m *txStatement) Insert(ctx context.Context, parameterer vparam.Parameterer) (res vresult.InsertResulter, err error) {
-	c := engine_context.NewStatementInsertQuery()
+	ctx = engine_ware.Inherit(ctx, m.txCtx)
+	c := engine_context.AcquireStatementInsertQuery()
 	c.SetStatement(m.preparer.Statement())
+	c.SetQuery(m.preparer.Query())
 	c.SetParameterer(parameterer)
-	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.queryEngineFactory.middlewareContext)
+	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
 	m.queryEngineFactory.statementInsertQueryMW.PerformMiddleware(ctx, c)
-	return c.InsertResult(), c.Error()
+	res, err = c.InsertResult(), c.Error()
+	engine_context.Release(c)
+	return
 }
 
 // Exec see github.com/wojnosystems/vsql/vstmt/statements.go#Statementer
 func (m *txStatement) Exec(ctx context.Context, parameterer vparam.Parameterer) (res vresult.Resulter, err error) {
-	c := engine_context.NewStatementExecQuery()
+	ctx = engine_ware.Inherit(ctx, m.txCtx)
+	c := engine_context.AcquireStatementExecQuery()
 	c.SetStatement(m.preparer.Statement())
+	c.SetQuery(m.preparer.Query())
 	c.SetParameterer(parameterer)
-	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.queryEngineFactory.middlewareContext)
+	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
 	m.queryEngineFactory.statementExecQueryMW.PerformMiddleware(ctx, c)
-	return c.Result(), c.Error()
+	res, err = c.Result(), c.Error()
+	engine_context.Release(c)
+	return
 }
 
 // Close see github.com/wojnosystems/vsql/vstmt/statements.go#Statementer
 func (m *txStatement) Close() error {
-	c := engine_context.NewStatementClose()
+	c := engine_context.AcquireStatementClose()
 	c.SetStatement(m.preparer.Statement())
-	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.queryEngineFactory.middlewareContext)
-	m.queryEngineFactory.statementCloseMW.PerformMiddleware(nil, c)
-	return c.Error()
+	c.(engine_context.WithMiddlewarer).ShallowCopyFrom(m.middlewareContext)
+	m.queryEngineFactory.statementCloseMW.PerformMiddleware(engine_ware.Inherit(nil, m.txCtx), c)
+	err := c.Error()
+	engine_context.Release(c)
+	return err
 }